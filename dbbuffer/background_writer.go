@@ -0,0 +1,59 @@
+package dbbuffer
+
+import (
+	"log/slog"
+	"time"
+)
+
+// 一定間隔でunpinされた変更済みbufferを少しずつディスクに書き出す
+// 置き換えで選ばれたbufferが変更済みだとpinが書き出しを待つので, 先に書き出しておいて同期的な書き出しを減らす
+// 書き出しはBuffer.flushで行うので, pageを最後に変更したlog recordまで先にflushされる
+type BackgroundWriter struct {
+	bufferManager *BufferManager
+	interval      time.Duration
+	// 1回に書き出す最大buffer数
+	maxBuffers int
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+}
+
+func NewBackgroundWriter(bm *BufferManager, interval time.Duration, maxBuffers int) *BackgroundWriter {
+	return &BackgroundWriter{
+		bufferManager: bm,
+		interval:      interval,
+		maxBuffers:    maxBuffers,
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+}
+
+func (w *BackgroundWriter) Start() {
+	go w.run()
+}
+
+// 実行中の書き出しが終わるのを待って止める
+func (w *BackgroundWriter) Stop() {
+	close(w.stopCh)
+	<-w.doneCh
+}
+
+func (w *BackgroundWriter) run() {
+	defer close(w.doneCh)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			n, err := w.bufferManager.WriteDirtyUnpinned(w.maxBuffers)
+			if err != nil {
+				slog.Error("background write failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Debug("background write", slog.Int("written", n), slog.Int("dirty", w.bufferManager.DirtyCount()))
+			}
+		}
+	}
+}
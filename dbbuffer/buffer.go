@@ -2,6 +2,7 @@ package dbbuffer
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dblog"
@@ -11,15 +12,18 @@ type Buffer struct {
 	ID          int
 	fileManager *dbfile.FileManager
 	logManager  *dblog.LogManager
-	state       bufferState
+	// pageの変更とflushを排他する. 他のgoroutineがpin中のbufferをflushする時に変更途中の内容を書き出さないようにする
+	latch sync.Mutex
+	state bufferState
 }
 
 type bufferState struct {
-	contents  *dbfile.Page
-	blk       dbfile.BlockID
-	pins      int
-	txNum     uint64 // contentsをメモリ上で変更してdisk writeされてないtransaction number
-	lsn       int
+	contents *dbfile.Page
+	blk      dbfile.BlockID
+	pins     int
+	txNum    uint64 // contentsをメモリ上で変更してdisk writeされてないtransaction number
+	// logに残していない変更がある. redoで復元できないのでcommit前に書き出す必要がある
+	unlogged  bool
 	permanent bool
 }
 
@@ -33,7 +37,6 @@ func NewBuffer(id int, fm *dbfile.FileManager, lm *dblog.LogManager) Buffer {
 			blk:      dbfile.BlockID{},
 			pins:     0,
 			txNum:    0,
-			lsn:      -1,
 		},
 	}
 }
@@ -46,10 +49,23 @@ func (b *Buffer) BlockID() dbfile.BlockID {
 	return b.state.blk
 }
 
+// pageを変更する間はlatchを取る
+func (b *Buffer) Latch() {
+	b.latch.Lock()
+}
+
+func (b *Buffer) Unlatch() {
+	b.latch.Unlock()
+}
+
+// lsnが正の場合はpageのLSNを更新する. 負の場合はlogに残していない変更として扱う
+// latch前提
 func (b *Buffer) SetModified(txnum uint64, lsn int) {
 	b.state.txNum = txnum
 	if lsn > 0 {
-		b.state.lsn = lsn
+		b.state.contents.SetLSN(lsn)
+	} else {
+		b.state.unlogged = true
 	}
 }
 
@@ -58,15 +74,16 @@ func (b *Buffer) IsPinned() bool {
 }
 
 func (b *Buffer) ModifyingTx() uint64 {
+	b.latch.Lock()
+	defer b.latch.Unlock()
 	return b.state.txNum
 }
 
-// bufferとblockとの対応関係を変更する
-func (b *Buffer) assignToBlock(blockID dbfile.BlockID) error {
-	if err := b.flush(); err != nil {
-		return fmt.Errorf("flush buffer %d before assigning to block %s: %w", b.ID, blockID, err)
-	}
+// evict済みのbufferにblockを読み込む
+func (b *Buffer) load(blockID dbfile.BlockID) error {
 	if err := b.fileManager.Read(blockID, b.state.contents); err != nil {
+		// 途中まで読んだ内容を元のblockとして使わない
+		b.reset()
 		return fmt.Errorf("read block %s to buffer %d: %w", blockID, b.ID, err)
 	}
 	b.state.blk = blockID
@@ -74,21 +91,52 @@ func (b *Buffer) assignToBlock(blockID dbfile.BlockID) error {
 	return nil
 }
 
-// 変更をディスクに書き出す
-// WALの原則に従い、先にlogをflushする。flush()が呼ばれる前にlogにはappendされてないといけない
-func (b *Buffer) flush() error {
-	if b.state.txNum == 0 {
-		return nil
+// 変更を書き出してblockとの対応関係を外す. 別のblockを読み込む前に使う. 書き出した場合はtrue
+// 書き出しに失敗した場合は元のblockのまま残る
+func (b *Buffer) evict() (bool, error) {
+	flushed, err := b.flush()
+	if err != nil {
+		return false, fmt.Errorf("flush buffer %d: %w", b.ID, err)
+	}
+	b.reset()
+	return flushed, nil
+}
+
+// contentsに読み込み済みのblockを割り当てる
+func (b *Buffer) setBlock(blockID dbfile.BlockID) {
+	b.state.blk = blockID
+	b.state.pins = 0
+}
+
+// blockとの対応関係と未書き出しの変更を破棄する
+func (b *Buffer) reset() {
+	b.state.blk = dbfile.BlockID{}
+	b.state.txNum = 0
+	b.state.unlogged = false
+}
+
+// 変更をディスクに書き出す. 書き出した場合はtrue
+// WALの原則に従い、pageを最後に変更したlog recordまで先にflushする。flush()が呼ばれる前にlogにはappendされてないといけない
+func (b *Buffer) flush() (bool, error) {
+	return b.flushIf(func(s *bufferState) bool { return true })
+}
+
+// condを満たす場合のみflushする
+func (b *Buffer) flushIf(cond func(s *bufferState) bool) (bool, error) {
+	b.latch.Lock()
+	defer b.latch.Unlock()
+	if b.state.txNum == 0 || !cond(&b.state) {
+		return false, nil
 	}
-	// log managerにappendしてくれる上位コンポーネントがあるはず. それがflushしてもいい？
-	if err := b.logManager.Flush(); err != nil {
-		return err
+	if err := b.logManager.FlushWithLSN(b.state.contents.LSN()); err != nil {
+		return false, err
 	}
 	if err := b.fileManager.Write(b.state.blk, b.state.contents); err != nil {
-		return fmt.Errorf("write buffer %d to block %s: %w", b.ID, b.state.blk, err)
+		return false, fmt.Errorf("write buffer %d to block %s: %w", b.ID, b.state.blk, err)
 	}
 	b.state.txNum = 0
-	return nil
+	b.state.unlogged = false
+	return true, nil
 }
 
 func (b *Buffer) pin() {
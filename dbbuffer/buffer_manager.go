@@ -1,7 +1,6 @@
 package dbbuffer
 
 import (
-	"container/list"
 	"context"
 	"fmt"
 	"sync"
@@ -16,30 +15,70 @@ const MaxWaitTime = 10000 * time.Millisecond
 
 type BufferManager struct {
 	mu                       sync.Mutex
+	fileManager              *dbfile.FileManager
 	availabilityNotification chan struct{}
 	bufferPool               []Buffer
-	// unpinされた時間を見たdequeue unpinされると左からenq, replaceは右からdequeされていく
-	lru          *list.List
+	// blockを割り当て済みのbuffer. pin, unpin関係なく持つ
+	blocks       map[dbfile.BlockID]int
+	replacer     replacer
 	numAvailable int
+	// ファイルごとの統計
+	stats map[string]*BufferStats
+	// background writerが次に見るbuffer
+	writerHand int
+	// snapshot isolationで読むblockの古いversion
+	versions *VersionStore
+	// このdatabaseの全てのtransactionで共有するblockのlock
+	lockTable *LockTable
 }
 
-func NewBufferManager(fm *dbfile.FileManager, lm *dblog.LogManager, numBuffers int) *BufferManager {
+type bufferConfig struct {
+	policy ReplacementPolicy
+}
+
+type BufferOption func(*bufferConfig)
+
+// デフォルトはLRU
+func WithReplacementPolicy(policy ReplacementPolicy) BufferOption {
+	return func(c *bufferConfig) {
+		c.policy = policy
+	}
+}
+
+func NewBufferManager(fm *dbfile.FileManager, lm *dblog.LogManager, numBuffers int, opts ...BufferOption) *BufferManager {
+	cfg := bufferConfig{policy: PolicyLRU}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	bufs := make([]Buffer, numBuffers)
-	lru := list.New()
+	r := newReplacer(cfg.policy, numBuffers)
 	for i := range numBuffers {
 		bufs[i] = NewBuffer(i, fm, lm)
-		lru.PushFront(i)
+		r.unpinned(i)
 	}
 	bm := &BufferManager{
+		fileManager:              fm,
 		bufferPool:               bufs,
+		blocks:                   make(map[dbfile.BlockID]int, numBuffers),
 		numAvailable:             numBuffers,
 		availabilityNotification: make(chan struct{}),
-		lru:                      lru,
+		replacer:                 r,
+		stats:                    make(map[string]*BufferStats),
+		versions:                 NewVersionStore(),
+		lockTable:                NewLockTable(),
 	}
 
 	return bm
 }
 
+func (bm *BufferManager) Versions() *VersionStore {
+	return bm.versions
+}
+
+func (bm *BufferManager) LockTable() *LockTable {
+	return bm.lockTable
+}
+
 func (bm *BufferManager) Available() int {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
@@ -48,16 +87,69 @@ func (bm *BufferManager) Available() int {
 
 // txNumによって変更されたbufferをflushする
 func (bm *BufferManager) FlushAll(txNum uint64) error {
+	return bm.flushIf(func(s *bufferState) bool { return s.txNum == txNum })
+}
+
+// txNumがlogに残さずに変更したbufferをflushする
+// commitはlogしかflushしないので, redoで復元できない変更はcommit前にディスクに乗せる
+func (bm *BufferManager) FlushUnlogged(txNum uint64) error {
+	return bm.flushIf(func(s *bufferState) bool { return s.txNum == txNum && s.unlogged })
+}
+
+// 全てのtransactionの変更をflushする. checkpointで使う
+// pin中のbufferは変更が終わるのを待って書き出す
+// 置き換えやbackground writerが以前に書き出したファイルもfsyncするので, 返った後はそれまでの変更が全てディスクに乗っている
+func (bm *BufferManager) FlushModified() error {
+	if err := bm.flushIf(func(s *bufferState) bool { return true }); err != nil {
+		return err
+	}
+	if err := bm.fileManager.SyncAll(); err != nil {
+		return fmt.Errorf("sync files: %w", err)
+	}
+	return nil
+}
+
+// 書き出したファイルはfsyncしてから返る. 置き換えやbackground writerの書き出しと違い, 呼び出し側はディスクに乗ったことを前提にする
+func (bm *BufferManager) flushIf(cond func(s *bufferState) bool) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	flushedFiles := make(map[string]struct{})
+	for i := range bm.bufferPool {
+		buf := &bm.bufferPool[i]
+		flushed, err := buf.flushIf(cond)
+		if err != nil {
+			return fmt.Errorf("flush buffer %d: %w", i, err)
+		}
+		if flushed {
+			bm.fileStatsLocked(buf.BlockID().FileName()).DirtyFlushes++
+			flushedFiles[buf.BlockID().FileName()] = struct{}{}
+		}
+	}
+	for fileName := range flushedFiles {
+		if err := bm.fileManager.Sync(fileName); err != nil {
+			return fmt.Errorf("sync %q: %w", fileName, err)
+		}
+	}
+	return nil
+}
+
+// fileNameのblockに割り当てられたbufferを未割り当てに戻す. 変更は書き出さずに破棄する
+// 削除されたファイルのblockが後から読まれたり書き戻されたりしないようにする. 統計も消す
+func (bm *BufferManager) DiscardFile(fileName string) error {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 	for i := range bm.bufferPool {
-		if bm.bufferPool[i].state.txNum != txNum {
+		buf := &bm.bufferPool[i]
+		if buf.BlockID().FileName() != fileName {
 			continue
 		}
-		if err := bm.bufferPool[i].flush(); err != nil {
-			return fmt.Errorf("flush buffer %d for transaction %d: %w", i, txNum, err)
+		if buf.IsPinned() {
+			return fmt.Errorf("buffer %d for block %s is still pinned", buf.ID, buf.BlockID())
 		}
+		delete(bm.blocks, buf.BlockID())
+		buf.reset()
 	}
+	delete(bm.stats, fileName)
 	return nil
 }
 
@@ -70,7 +162,7 @@ func (bm *BufferManager) Unpin(buffer *Buffer) {
 	buffer.unpin()
 	if !buffer.IsPinned() {
 		bm.numAvailable++
-		bm.lru.PushFront(buffer.ID)
+		bm.replacer.unpinned(buffer.ID)
 		if bm.availabilityNotification != nil {
 			close(bm.availabilityNotification)
 			bm.availabilityNotification = nil
@@ -91,6 +183,11 @@ func (bm *BufferManager) PinPermanent(ctx context.Context, blk dbfile.BlockID) (
 
 // blockを紐付けたbufferを返す。ファイルがない場合は作成
 func (bm *BufferManager) Pin(ctx context.Context, blk dbfile.BlockID) (*Buffer, error) {
+	return bm.PinWithStrategy(ctx, blk, nil)
+}
+
+// Pinと同じだが, blockを読み込むbufferをsに従って選ぶ. sがnilならPinと同じ
+func (bm *BufferManager) PinWithStrategy(ctx context.Context, blk dbfile.BlockID, s *AccessStrategy) (*Buffer, error) {
 	// 最大max_timeまつ
 	// tryToPinが失敗したら1つunpinされるのを待つ
 	ctx, cancel := context.WithTimeout(ctx, MaxWaitTime)
@@ -104,7 +201,11 @@ func (bm *BufferManager) Pin(ctx context.Context, blk dbfile.BlockID) (*Buffer,
 				bm.availabilityNotification = make(chan struct{})
 			}
 			waitCh = bm.availabilityNotification
-			return bm.tryToPinLocked(blk)
+			buf, err := bm.tryToPinLocked(blk, s)
+			if buf == nil && err == nil {
+				bm.fileStatsLocked(blk.FileName()).PinWaits++
+			}
+			return buf, err
 		}()
 
 		if err != nil {
@@ -123,55 +224,155 @@ func (bm *BufferManager) Pin(ctx context.Context, blk dbfile.BlockID) (*Buffer,
 }
 
 // goroutineセーフではないので事前にlockが必要
-func (bm *BufferManager) tryToPinLocked(blk dbfile.BlockID) (*Buffer, error) {
+func (bm *BufferManager) tryToPinLocked(blk dbfile.BlockID, s *AccessStrategy) (*Buffer, error) {
 	// 不要なreplaceを防ぐためpin, unpin関係なくblkにひもづくbufferを探す
-	buf := bm.findExistingBuffer(blk)
-	if buf == nil {
-		// unpinされたbufferから最適なものを探す
-		buf = bm.chooseUnpinnedBuffer()
-		if buf == nil {
+	var buf *Buffer
+	stats := bm.fileStatsLocked(blk.FileName())
+	if id, ok := bm.blocks[blk]; ok {
+		stats.Hits++
+		buf = &bm.bufferPool[id]
+	} else {
+		// unpinされたbufferから最適なものを選ぶ
+		id, ok := bm.victimLocked(s, blk)
+		if !ok {
 			return nil, nil
 		}
-		if err := buf.assignToBlock(blk); err != nil {
+		stats.Misses++
+		buf = &bm.bufferPool[id]
+		if err := bm.evictLocked(buf); err != nil {
+			bm.replacer.unpinned(id)
+			return nil, fmt.Errorf("assign buffer to block %s: %w", blk, err)
+		}
+		if err := buf.load(blk); err != nil {
+			bm.replacer.unpinned(id)
 			return nil, fmt.Errorf("assign buffer to block %s: %w", blk, err)
 		}
+		bm.blocks[blk] = id
 	}
 	if !buf.IsPinned() {
 		bm.numAvailable--
 	}
+	bm.replacer.pinned(buf.ID)
 	buf.pin()
 	return buf, nil
 }
 
-// ターゲットのblkに現在pin済み、あるいは過去にpinされてunpinされたbufferを探す。
-// TODO: 計算量の改善
-func (bm *BufferManager) findExistingBuffer(blk dbfile.BlockID) *Buffer {
-	for i := range bm.bufferPool {
-		if bm.bufferPool[i].BlockID().Equals(blk) {
-			// lruに見つかれば除去（unpin済みのbufferとblkが一致した場合）
-			for e := bm.lru.Front(); e != nil; {
-				id, _ := e.Value.(int)
-				if id == bm.bufferPool[i].ID {
-					bm.lru.Remove(e)
-					break
-				}
-				e = e.Next()
+// blkから続くn個のblockのうちbuffer poolにないものを, 連続する範囲ごとにまとめて読んでおく. pinはしない
+// 読み込みに使えるbufferがなくなればそこで止め, unpinされるのは待たない
+func (bm *BufferManager) Prefetch(blk dbfile.BlockID, n int, s *AccessStrategy) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	fileName := blk.FileName()
+	first := blk.BlockNum()
+	// firstから続くblockを読むbuffer
+	var ids []int
+	for blkNum := blk.BlockNum(); blkNum < blk.BlockNum()+n; blkNum++ {
+		b := dbfile.NewBlockID(fileName, blkNum)
+		if _, ok := bm.blocks[b]; ok {
+			if err := bm.loadLocked(fileName, first, ids); err != nil {
+				return err
 			}
-			return &bm.bufferPool[i]
+			ids, first = nil, blkNum+1
+			continue
 		}
+		id, ok := bm.victimLocked(s, b)
+		if !ok {
+			break
+		}
+		if err := bm.evictLocked(&bm.bufferPool[id]); err != nil {
+			bm.replacer.unpinned(id)
+			for _, id := range ids {
+				bm.replacer.unpinned(id)
+			}
+			return fmt.Errorf("prefetch block %s: %w", b, err)
+		}
+		ids = append(ids, id)
 	}
-	return nil
+	return bm.loadLocked(fileName, first, ids)
 }
 
-func (bm *BufferManager) chooseUnpinnedBuffer() *Buffer {
-	back := bm.lru.Back()
-	if back == nil {
+// idsのbufferにfileNameのfirstから続くblockを読み, 置き換えの候補に戻す. lock前提
+// 読み込みに失敗した場合, bufferはどのblockにも割り当てない
+func (bm *BufferManager) loadLocked(fileName string, first int, ids []int) error {
+	if len(ids) == 0 {
 		return nil
 	}
-	id, ok := back.Value.(int)
-	if !ok {
+	bm.fileStatsLocked(fileName).Prefetches += int64(len(ids))
+	pages := make([]*dbfile.Page, len(ids))
+	for i, id := range ids {
+		pages[i] = bm.bufferPool[id].Contents()
+	}
+	err := bm.fileManager.ReadBlocks(fileName, first, pages)
+	for i, id := range ids {
+		if err == nil {
+			blk := dbfile.NewBlockID(fileName, first+i)
+			bm.bufferPool[id].setBlock(blk)
+			bm.blocks[blk] = id
+		}
+		bm.replacer.unpinned(id)
+	}
+	if err != nil {
+		return fmt.Errorf("prefetch blocks %d-%d of %q: %w", first, first+len(ids)-1, fileName, err)
+	}
+	return nil
+}
+
+// 前回の続きからbuffer poolを一周するまで見て, unpinされた変更済みbufferを最大maxBuffers個書き出す
+// 置き換えを待つ他のpinを長く止めないよう, 1つ書き出すごとにlockを外す
+func (bm *BufferManager) WriteDirtyUnpinned(maxBuffers int) (int, error) {
+	written := 0
+	for range len(bm.bufferPool) {
+		if written >= maxBuffers {
+			break
+		}
+		ok, err := bm.writeNextDirtyUnpinned()
+		if err != nil {
+			return written, err
+		}
+		if ok {
+			written++
+		}
+	}
+	return written, nil
+}
+
+// writerHandのbufferがunpinされていて変更があれば書き出す
+func (bm *BufferManager) writeNextDirtyUnpinned() (bool, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	buf := &bm.bufferPool[bm.writerHand]
+	bm.writerHand = (bm.writerHand + 1) % len(bm.bufferPool)
+	if buf.IsPinned() || buf.ModifyingTx() == 0 {
+		return false, nil
+	}
+	flushed, err := buf.flush()
+	if err != nil {
+		return false, fmt.Errorf("write buffer %d: %w", buf.ID, err)
+	}
+	if flushed {
+		stats := bm.fileStatsLocked(buf.BlockID().FileName())
+		stats.DirtyFlushes++
+		stats.BackgroundWrites++
+	}
+	return flushed, nil
+}
+
+// 置き換えで選んだbufferの変更を書き出し, 元のblockとの対応関係を外す. lock前提
+// 書き出しに失敗した場合は元のblockのまま残る
+func (bm *BufferManager) evictLocked(buf *Buffer) error {
+	old := buf.BlockID()
+	flushed, err := buf.evict()
+	if err != nil {
+		return err
+	}
+	if old == (dbfile.BlockID{}) {
 		return nil
 	}
-	bm.lru.Remove(back)
-	return &bm.bufferPool[id]
+	delete(bm.blocks, old)
+	stats := bm.fileStatsLocked(old.FileName())
+	stats.Evictions++
+	if flushed {
+		stats.DirtyFlushes++
+	}
+	return nil
 }
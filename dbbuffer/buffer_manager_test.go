@@ -12,7 +12,7 @@ import (
 	"github.com/teru01/simpledb-go/dblog"
 )
 
-func setupTestBufferManager(t *testing.T, numBuffers int) (*dbbuffer.BufferManager, *dbfile.FileManager, func()) {
+func setupTestBufferManager(t *testing.T, numBuffers int, opts ...dbbuffer.BufferOption) (*dbbuffer.BufferManager, *dbfile.FileManager, func()) {
 	t.Helper()
 	dir, err := os.MkdirTemp("", "buffermanager_test")
 	if err != nil {
@@ -33,7 +33,7 @@ func setupTestBufferManager(t *testing.T, numBuffers int) (*dbbuffer.BufferManag
 		t.Fatalf("failed to create log manager: %v", err)
 	}
 
-	bm := dbbuffer.NewBufferManager(fm, lm, numBuffers)
+	bm := dbbuffer.NewBufferManager(fm, lm, numBuffers, opts...)
 
 	cleanup := func() {
 		dirFile.Close()
@@ -437,3 +437,252 @@ func TestBufferManagerPinTimeout(t *testing.T) {
 		bm.Unpin(buf1)
 	})
 }
+
+func TestBufferManagerReplacementPolicies(t *testing.T) {
+	// 3つのbufferにblock 0, 1, 2を読み, block 0だけもう一度pinしてからblock 3を読む
+	tests := []struct {
+		policy dbbuffer.ReplacementPolicy
+		// block 3に置き換えられるblock
+		evicted int
+	}{
+		// 最後のunpinが最も古い
+		{dbbuffer.PolicyLRU, 1},
+		// 全ての参照bitを下ろして一周し, 針の位置に戻る
+		{dbbuffer.PolicyClock, 0},
+		// 2回アクセスしたblock 0は残り, 1回のうち最も古いもの
+		{dbbuffer.PolicyLRUK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			bm, fm, cleanup := setupTestBufferManager(t, 3, dbbuffer.WithReplacementPolicy(tt.policy))
+			defer cleanup()
+			ctx := context.Background()
+			for i := range 4 {
+				if _, err := fm.Append("testfile"); err != nil {
+					t.Fatalf("failed to append block %d: %v", i, err)
+				}
+			}
+			pinUnpin := func(blkNum int) {
+				t.Helper()
+				buf, err := bm.Pin(ctx, dbfile.NewBlockID("testfile", blkNum))
+				if err != nil {
+					t.Fatalf("failed to pin block %d: %v", blkNum, err)
+				}
+				bm.Unpin(buf)
+			}
+			for _, blkNum := range []int{0, 1, 2, 0, 3} {
+				pinUnpin(blkNum)
+			}
+			if got := bm.Stats(); got.Hits != 1 || got.Misses != 4 {
+				t.Fatalf("expected 1 hit and 4 misses, got %+v", got)
+			}
+
+			for blkNum := range 4 {
+				bm.ResetStats()
+				pinUnpin(blkNum)
+				if blkNum == tt.evicted {
+					// 読み直しで別のblockが追い出されるので, 以降は見ない
+					if got := bm.Stats().Misses; got != 1 {
+						t.Errorf("expected block %d to be evicted", blkNum)
+					}
+					return
+				}
+				if got := bm.Stats().Hits; got != 1 {
+					t.Errorf("expected block %d to stay in the pool", blkNum)
+				}
+			}
+		})
+	}
+}
+
+func TestBufferManagerPrefetch(t *testing.T) {
+	bm, fm, cleanup := setupTestBufferManager(t, 8)
+	defer cleanup()
+	ctx := context.Background()
+	for i := range 6 {
+		if _, err := fm.Append("testfile"); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	// block 2は先にbuffer poolにある
+	buf, err := bm.Pin(ctx, dbfile.NewBlockID("testfile", 2))
+	if err != nil {
+		t.Fatalf("failed to pin block 2: %v", err)
+	}
+	bm.Unpin(buf)
+	fm.ResetCounts()
+	bm.ResetStats()
+
+	if err := bm.Prefetch(dbfile.NewBlockID("testfile", 0), 6, nil); err != nil {
+		t.Fatalf("failed to prefetch: %v", err)
+	}
+	if got := fm.ReadCount(); got != 5 {
+		t.Errorf("expected 5 blocks to be read, got %d", got)
+	}
+	if got := bm.Available(); got != 8 {
+		t.Errorf("expected prefetched buffers to stay unpinned, got %d available", got)
+	}
+	for i := range 6 {
+		buf, err := bm.Pin(ctx, dbfile.NewBlockID("testfile", i))
+		if err != nil {
+			t.Fatalf("failed to pin block %d: %v", i, err)
+		}
+		bm.Unpin(buf)
+	}
+	if got := bm.Stats(); got.Hits != 6 || got.Misses != 0 {
+		t.Errorf("expected all prefetched blocks to hit, got %+v", got)
+	}
+	if got := fm.ReadCount(); got != 5 {
+		t.Errorf("expected no more reads after prefetch, got %d", got)
+	}
+}
+
+func TestBufferManagerSequentialStrategy(t *testing.T) {
+	bm, fm, cleanup := setupTestBufferManager(t, 40)
+	defer cleanup()
+	ctx := context.Background()
+	for i := range 10 {
+		if _, err := fm.Append("hot"); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	for i := range 100 {
+		if _, err := fm.Append("large"); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	pinUnpin := func(blk dbfile.BlockID, s *dbbuffer.AccessStrategy) {
+		t.Helper()
+		buf, err := bm.PinWithStrategy(ctx, blk, s)
+		if err != nil {
+			t.Fatalf("failed to pin block %s: %v", blk, err)
+		}
+		bm.Unpin(buf)
+	}
+	for i := range 10 {
+		pinUnpin(dbfile.NewBlockID("hot", i), nil)
+	}
+
+	// 先読みしながらbuffer poolより大きいファイルを順に読む
+	s := bm.SequentialStrategy(100)
+	if s.ReadAhead() < 2 {
+		t.Fatalf("expected read ahead, got %d", s.ReadAhead())
+	}
+	for i := range 100 {
+		blk := dbfile.NewBlockID("large", i)
+		if i%s.ReadAhead() == 0 {
+			if err := bm.Prefetch(blk, min(s.ReadAhead(), 100-i), s); err != nil {
+				t.Fatalf("failed to prefetch block %s: %v", blk, err)
+			}
+		}
+		pinUnpin(blk, s)
+	}
+
+	bm.ResetStats()
+	for i := range 10 {
+		pinUnpin(dbfile.NewBlockID("hot", i), nil)
+	}
+	if got := bm.Stats(); got.Misses != 0 {
+		t.Errorf("expected hot blocks to survive the scan, got %+v", got)
+	}
+}
+
+func TestBufferManagerWriteDirtyUnpinned(t *testing.T) {
+	bm, fm, cleanup := setupTestBufferManager(t, 4)
+	defer cleanup()
+	ctx := context.Background()
+	for i := range 3 {
+		if _, err := fm.Append("testfile"); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	var bufs []*dbbuffer.Buffer
+	for i := range 3 {
+		buf, err := bm.Pin(ctx, dbfile.NewBlockID("testfile", i))
+		if err != nil {
+			t.Fatalf("failed to pin block %d: %v", i, err)
+		}
+		buf.SetModified(uint64(i+1), 0)
+		bufs = append(bufs, buf)
+	}
+	// block 2はpinしたまま
+	bm.Unpin(bufs[0])
+	bm.Unpin(bufs[1])
+	if got := bm.DirtyCount(); got != 3 {
+		t.Fatalf("expected 3 dirty buffers, got %d", got)
+	}
+
+	fm.ResetCounts()
+	n, err := bm.WriteDirtyUnpinned(1)
+	if err != nil {
+		t.Fatalf("failed to write dirty buffers: %v", err)
+	}
+	if n != 1 || bm.DirtyCount() != 2 {
+		t.Errorf("expected 1 buffer to be written, got %d written and %d dirty", n, bm.DirtyCount())
+	}
+	// 前回の続きから見る
+	n, err = bm.WriteDirtyUnpinned(10)
+	if err != nil {
+		t.Fatalf("failed to write dirty buffers: %v", err)
+	}
+	if n != 1 || bm.DirtyCount() != 1 {
+		t.Errorf("expected 1 buffer to be written, got %d written and %d dirty", n, bm.DirtyCount())
+	}
+	if bufs[2].ModifyingTx() != 3 {
+		t.Errorf("expected pinned buffer not to be written")
+	}
+	if got := fm.WriteCount(); got != 2 {
+		t.Errorf("expected 2 writes, got %d", got)
+	}
+	if got := bm.Stats().BackgroundWrites; got != 2 {
+		t.Errorf("expected 2 background writes, got %d", got)
+	}
+	bm.Unpin(bufs[2])
+}
+
+func TestBufferManagerFileStats(t *testing.T) {
+	bm, fm, cleanup := setupTestBufferManager(t, 1)
+	defer cleanup()
+	ctx := context.Background()
+	for _, fileName := range []string{"file1", "file2"} {
+		if _, err := fm.Append(fileName); err != nil {
+			t.Fatalf("failed to append block to %s: %v", fileName, err)
+		}
+	}
+	buf, err := bm.Pin(ctx, dbfile.NewBlockID("file1", 0))
+	if err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+	buf.SetModified(1, 0)
+	frames := bm.Frames()
+	if len(frames) != 1 || !frames[0].Block.Equals(dbfile.NewBlockID("file1", 0)) || frames[0].Pins != 1 || frames[0].ModifyingTx != 1 {
+		t.Errorf("unexpected frames %+v", frames)
+	}
+
+	// 空いているbufferがないので待ってから諦める
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := bm.Pin(canceled, dbfile.NewBlockID("file2", 0)); err == nil {
+		t.Fatal("expected error when pinning with no available buffers")
+	}
+	bm.Unpin(buf)
+	buf, err = bm.Pin(ctx, dbfile.NewBlockID("file2", 0))
+	if err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+	bm.Unpin(buf)
+
+	stats := bm.FileStats()
+	if got := stats["file1"]; got.Misses != 1 || got.Evictions != 1 || got.DirtyFlushes != 1 {
+		t.Errorf("unexpected stats for file1: %+v", got)
+	}
+	if got := stats["file2"]; got.Misses != 1 || got.PinWaits != 1 {
+		t.Errorf("unexpected stats for file2: %+v", got)
+	}
+	if got := bm.Stats(); got.Misses != 2 || got.Evictions != 1 {
+		t.Errorf("unexpected total stats: %+v", got)
+	}
+	if frames := bm.Frames(); frames[0].Dirty() || frames[0].Pins != 0 {
+		t.Errorf("unexpected frames %+v", frames)
+	}
+}
@@ -0,0 +1,242 @@
+package dbbuffer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/teru01/simpledb-go/dberr"
+	"github.com/teru01/simpledb-go/dbfile"
+)
+
+const MaxWaitTimeSecond = 10
+
+type LockTable struct {
+	mu               sync.Mutex
+	lockWaitChannels map[dbfile.BlockID]chan struct{}
+	locks            map[dbfile.BlockID]*lockEntry
+	// lock待ちのtransaction. waits-forグラフの辺はここから辿る
+	waits map[uint64]lockRequest
+	// deadlockの犠牲に選ばれ、まだ待機から戻っていないtransaction
+	victims map[uint64]struct{}
+}
+
+type lockEntry struct {
+	holders   map[uint64]struct{}
+	exclusive bool
+}
+
+type lockRequest struct {
+	blk       dbfile.BlockID
+	exclusive bool
+}
+
+func NewLockTable() *LockTable {
+	return &LockTable{
+		mu:               sync.Mutex{},
+		lockWaitChannels: make(map[dbfile.BlockID]chan struct{}),
+		locks:            make(map[dbfile.BlockID]*lockEntry),
+		waits:            make(map[uint64]lockRequest),
+		victims:          make(map[uint64]struct{}),
+	}
+}
+
+// shared lockを取る
+// 他のtransactionがxlockを取っている場合は待機
+func (l *LockTable) SLock(ctx context.Context, txNum uint64, blk dbfile.BlockID) error {
+	return l.lock(ctx, txNum, lockRequest{blk: blk, exclusive: false})
+}
+
+// XLockをとる
+// 他のtransactionがx, slockを取っている場合は待機
+// XLockを取る前にSLockが取られている必要がある
+func (l *LockTable) XLock(ctx context.Context, txNum uint64, blk dbfile.BlockID) error {
+	return l.lock(ctx, txNum, lockRequest{blk: blk, exclusive: true})
+}
+
+func (l *LockTable) lock(ctx context.Context, txNum uint64, req lockRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, MaxWaitTimeSecond*time.Second)
+	defer cancel()
+
+	for {
+		waitCh, err := l.tryLock(txNum, req)
+		if err != nil {
+			return err
+		}
+		if waitCh == nil {
+			return nil
+		}
+		select {
+		case <-waitCh: // lockが外れた時, またはdeadlockの犠牲に選ばれた時
+		case <-ctx.Done():
+			l.mu.Lock()
+			delete(l.waits, txNum)
+			delete(l.victims, txNum)
+			l.mu.Unlock()
+			return dberr.New(dberr.CodeTransactionLockWaitAbort, fmt.Sprintf("timeout. It took too long to get %s for block %s", req.mode(), req.blk), nil)
+		}
+	}
+}
+
+// lockを取れた場合はnilを返す. 取れなかった場合は待機用のchannelを返す
+func (l *LockTable) tryLock(txNum uint64, req lockRequest) (chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.victims[txNum]; ok {
+		delete(l.victims, txNum)
+		delete(l.waits, txNum)
+		return nil, l.deadlockError(txNum, req)
+	}
+
+	if l.grantableLocked(txNum, req) {
+		delete(l.waits, txNum)
+		entry := l.locks[req.blk]
+		if entry == nil {
+			entry = &lockEntry{holders: make(map[uint64]struct{})}
+			l.locks[req.blk] = entry
+		}
+		entry.holders[txNum] = struct{}{}
+		if req.exclusive {
+			entry.exclusive = true
+		}
+		return nil, nil
+	}
+
+	l.waits[txNum] = req
+	if cycle := l.findCycleLocked(txNum); cycle != nil {
+		// 最も新しい(txNumが最大の)transactionを犠牲にする
+		victim := txNum
+		for _, t := range cycle {
+			victim = max(victim, t)
+		}
+		if victim == txNum {
+			delete(l.waits, txNum)
+			return nil, l.deadlockError(txNum, req)
+		}
+		l.victims[victim] = struct{}{}
+		l.notifyLocked(l.waits[victim].blk)
+	}
+
+	if l.lockWaitChannels[req.blk] == nil {
+		l.lockWaitChannels[req.blk] = make(chan struct{})
+	}
+	return l.lockWaitChannels[req.blk], nil // lockの外で使うため
+}
+
+// ロックを外す
+func (l *LockTable) UnLock(txNum uint64, blk dbfile.BlockID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.locks[blk]
+	if !ok {
+		return
+	}
+	if _, ok := entry.holders[txNum]; !ok {
+		return
+	}
+	delete(entry.holders, txNum)
+	entry.exclusive = false
+	if len(entry.holders) == 0 {
+		delete(l.locks, blk)
+	}
+	// slockが1つ減っただけでもupgrade待ちのtransactionが取れる可能性がある
+	l.notifyLocked(blk)
+}
+
+func (l *LockTable) grantableLocked(txNum uint64, req lockRequest) bool {
+	return len(l.blockersLocked(txNum, req)) == 0
+}
+
+// reqを待たせている他のtransaction
+func (l *LockTable) blockersLocked(txNum uint64, req lockRequest) []uint64 {
+	entry, ok := l.locks[req.blk]
+	if !ok || (!req.exclusive && !entry.exclusive) {
+		return nil
+	}
+	var blockers []uint64
+	for holder := range entry.holders {
+		if holder != txNum {
+			blockers = append(blockers, holder)
+		}
+	}
+	return blockers
+}
+
+// waits-forグラフを辿ってtxNumに戻る閉路を探す. 見つかった場合は閉路上のtransactionを返す
+func (l *LockTable) findCycleLocked(txNum uint64) []uint64 {
+	visited := make(map[uint64]struct{})
+	var path []uint64
+	var visit func(t uint64) bool
+	visit = func(t uint64) bool {
+		req, waiting := l.waits[t]
+		if !waiting {
+			return false
+		}
+		if _, ok := l.victims[t]; ok {
+			// 既に犠牲として中断されることが決まっている
+			return false
+		}
+		path = append(path, t)
+		for _, next := range l.blockersLocked(t, req) {
+			if next == txNum {
+				return true
+			}
+			if _, ok := visited[next]; ok {
+				continue
+			}
+			visited[next] = struct{}{}
+			if visit(next) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(txNum) {
+		return path
+	}
+	return nil
+}
+
+// blkを待っている全てのtransactionを起こす
+func (l *LockTable) notifyLocked(blk dbfile.BlockID) {
+	if ch, exists := l.lockWaitChannels[blk]; exists {
+		close(ch)
+		delete(l.lockWaitChannels, blk)
+	}
+}
+
+func (l *LockTable) deadlockError(txNum uint64, req lockRequest) error {
+	return dberr.New(dberr.CodeTransactionDeadlockAbort, fmt.Sprintf("deadlock detected. transaction %d was aborted while waiting for %s on block %s", txNum, req.mode(), req.blk), nil)
+}
+
+func (r lockRequest) mode() string {
+	if r.exclusive {
+		return "xlock"
+	}
+	return "slock"
+}
+
+func (l *LockTable) HasXLockLocked(blk dbfile.BlockID) bool {
+	entry, ok := l.locks[blk]
+	return ok && entry.exclusive
+}
+
+func (l *LockTable) HasOtherSLocksLocked(blk dbfile.BlockID) bool {
+	entry, ok := l.locks[blk]
+	return ok && !entry.exclusive && len(entry.holders) > 1
+}
+
+// xlockの場合は-1, slockの場合は保持しているtransactionの数を返す
+func (l *LockTable) GetLockValLocked(blk dbfile.BlockID) int {
+	entry, ok := l.locks[blk]
+	if !ok {
+		return 0
+	}
+	if entry.exclusive {
+		return -1
+	}
+	return len(entry.holders)
+}
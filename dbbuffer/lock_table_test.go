@@ -1,21 +1,24 @@
-package dbtx_test
+package dbbuffer_test
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 	"testing/synctest"
+	"time"
 
+	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dberr"
 	"github.com/teru01/simpledb-go/dbfile"
-	"github.com/teru01/simpledb-go/dbtx"
 )
 
 func TestLockTableSLock(t *testing.T) {
-	lt := dbtx.NewLockTable()
+	lt := dbbuffer.NewLockTable()
 	blk := dbfile.NewBlockID("testfile", 0)
 	ctx := context.Background()
 
-	err := lt.SLock(ctx, blk)
+	err := lt.SLock(ctx, 1, blk)
 	if err != nil {
 		t.Fatalf("failed to acquire SLock: %v", err)
 	}
@@ -26,13 +29,13 @@ func TestLockTableSLock(t *testing.T) {
 }
 
 func TestLockTableMultipleSLocks(t *testing.T) {
-	lt := dbtx.NewLockTable()
+	lt := dbbuffer.NewLockTable()
 	blk := dbfile.NewBlockID("testfile", 0)
 	ctx := context.Background()
 
 	// Acquire multiple SLocks on the same block
 	for i := 0; i < 3; i++ {
-		if err := lt.SLock(ctx, blk); err != nil {
+		if err := lt.SLock(ctx, uint64(i+1), blk); err != nil {
 			t.Fatalf("failed to acquire SLock %d: %v", i, err)
 		}
 	}
@@ -42,15 +45,15 @@ func TestLockTableMultipleSLocks(t *testing.T) {
 	}
 
 	// Unlock twice (should still have 1 SLock)
-	lt.UnLock(blk)
-	lt.UnLock(blk)
+	lt.UnLock(1, blk)
+	lt.UnLock(2, blk)
 
 	if got := lt.GetLockValLocked(blk); got != 1 {
 		t.Errorf("expected lock count 1 after 2 unlocks, got %d", got)
 	}
 
 	// Final unlock
-	lt.UnLock(blk)
+	lt.UnLock(3, blk)
 
 	if got := lt.GetLockValLocked(blk); got != 0 {
 		t.Errorf("expected lock count 0 after final unlock, got %d", got)
@@ -58,12 +61,12 @@ func TestLockTableMultipleSLocks(t *testing.T) {
 }
 
 func TestLockTableXLock(t *testing.T) {
-	lt := dbtx.NewLockTable()
+	lt := dbbuffer.NewLockTable()
 	blk := dbfile.NewBlockID("testfile", 0)
 	ctx := context.Background()
 
 	// Must acquire SLock first
-	if err := lt.SLock(ctx, blk); err != nil {
+	if err := lt.SLock(ctx, 1, blk); err != nil {
 		t.Fatalf("failed to acquire SLock: %v", err)
 	}
 
@@ -72,7 +75,7 @@ func TestLockTableXLock(t *testing.T) {
 	}
 
 	// Upgrade to XLock
-	if err := lt.XLock(ctx, blk); err != nil {
+	if err := lt.XLock(ctx, 1, blk); err != nil {
 		t.Fatalf("failed to acquire XLock: %v", err)
 	}
 
@@ -81,7 +84,7 @@ func TestLockTableXLock(t *testing.T) {
 	}
 
 	// Unlock XLock
-	lt.UnLock(blk)
+	lt.UnLock(1, blk)
 
 	if got := lt.GetLockValLocked(blk); got != 0 {
 		t.Errorf("expected lock count 0 after unlock, got %d", got)
@@ -90,27 +93,27 @@ func TestLockTableXLock(t *testing.T) {
 
 func TestLockTableSLockBlockedByXLock(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
-		lt := dbtx.NewLockTable()
+		lt := dbbuffer.NewLockTable()
 		blk := dbfile.NewBlockID("testfile", 0)
 		ctx := context.Background()
 
 		// Goroutine 1: Acquire SLock and upgrade to XLock
-		if err := lt.SLock(ctx, blk); err != nil {
+		if err := lt.SLock(ctx, 1, blk); err != nil {
 			t.Fatalf("failed to acquire SLock: %v", err)
 		}
-		if err := lt.XLock(ctx, blk); err != nil {
+		if err := lt.XLock(ctx, 1, blk); err != nil {
 			t.Fatalf("failed to acquire XLock: %v", err)
 		}
 
 		// Goroutine 2: Try to acquire SLock (should block)
 		var slockAcquired bool
 		go func() {
-			if err := lt.SLock(ctx, blk); err != nil {
+			if err := lt.SLock(ctx, 2, blk); err != nil {
 				t.Errorf("failed to acquire SLock in goroutine: %v", err)
 				return
 			}
 			slockAcquired = true
-			lt.UnLock(blk)
+			lt.UnLock(2, blk)
 		}()
 
 		// Wait for goroutine to block
@@ -122,7 +125,7 @@ func TestLockTableSLockBlockedByXLock(t *testing.T) {
 		}
 
 		// Release XLock
-		lt.UnLock(blk)
+		lt.UnLock(1, blk)
 
 		// Wait for goroutine to acquire SLock
 		// goroutineの終了を待っている
@@ -137,15 +140,15 @@ func TestLockTableSLockBlockedByXLock(t *testing.T) {
 
 func TestLockTableXLockBlockedByMultipleSLocks(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
-		lt := dbtx.NewLockTable()
+		lt := dbbuffer.NewLockTable()
 		blk := dbfile.NewBlockID("testfile", 0)
 		ctx := context.Background()
 
-		// Acquire 2 SLocks
-		if err := lt.SLock(ctx, blk); err != nil {
+		// Acquire 2 SLocks by other transactions
+		if err := lt.SLock(ctx, 2, blk); err != nil {
 			t.Fatalf("failed to acquire first SLock: %v", err)
 		}
-		if err := lt.SLock(ctx, blk); err != nil {
+		if err := lt.SLock(ctx, 3, blk); err != nil {
 			t.Fatalf("failed to acquire second SLock: %v", err)
 		}
 
@@ -153,10 +156,10 @@ func TestLockTableXLockBlockedByMultipleSLocks(t *testing.T) {
 			t.Errorf("expected lock count 2, got %d", got)
 		}
 
-		// Try to upgrade to XLock (should block because there are 2 SLocks)
+		// Try to acquire XLock (should block because there are 2 SLocks)
 		var xlockAcquired bool
 		go func() {
-			if err := lt.XLock(ctx, blk); err != nil {
+			if err := lt.XLock(ctx, 1, blk); err != nil {
 				t.Errorf("failed to acquire XLock in goroutine: %v", err)
 				return
 			}
@@ -176,7 +179,7 @@ func TestLockTableXLockBlockedByMultipleSLocks(t *testing.T) {
 		}
 
 		// Release one SLock (still 1 SLock remaining)
-		lt.UnLock(blk)
+		lt.UnLock(2, blk)
 		synctest.Wait()
 
 		// XLock should still not be acquired
@@ -189,7 +192,7 @@ func TestLockTableXLockBlockedByMultipleSLocks(t *testing.T) {
 		}
 
 		// Release the last SLock
-		lt.UnLock(blk)
+		lt.UnLock(3, blk)
 		synctest.Wait()
 
 		// XLock should now be acquired
@@ -202,62 +205,62 @@ func TestLockTableXLockBlockedByMultipleSLocks(t *testing.T) {
 		}
 
 		// Clean up
-		lt.UnLock(blk)
+		lt.UnLock(1, blk)
 	})
 }
 
 func TestLockTableSLockTimeout(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
-		lt := dbtx.NewLockTable()
+		lt := dbbuffer.NewLockTable()
 		blk := dbfile.NewBlockID("testfile", 0)
 		ctx := context.Background()
 
 		// Acquire XLock
-		if err := lt.SLock(ctx, blk); err != nil {
+		if err := lt.SLock(ctx, 1, blk); err != nil {
 			t.Fatalf("failed to acquire SLock: %v", err)
 		}
-		if err := lt.XLock(ctx, blk); err != nil {
+		if err := lt.XLock(ctx, 1, blk); err != nil {
 			t.Fatalf("failed to acquire XLock: %v", err)
 		}
 
 		// Try to acquire SLock from another goroutine (should timeout)
-		err := lt.SLock(ctx, blk)
+		err := lt.SLock(ctx, 2, blk)
 		if err == nil {
 			t.Error("expected timeout error when acquiring SLock blocked by XLock")
 		}
 
-		lt.UnLock(blk)
+		lt.UnLock(1, blk)
 	})
 }
 
 func TestLockTableXLockTimeout(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
-		lt := dbtx.NewLockTable()
+		lt := dbbuffer.NewLockTable()
 		blk := dbfile.NewBlockID("testfile", 0)
 		ctx := context.Background()
 
 		// Acquire 2 SLocks
-		if err := lt.SLock(ctx, blk); err != nil {
+		if err := lt.SLock(ctx, 1, blk); err != nil {
 			t.Fatalf("failed to acquire first SLock: %v", err)
 		}
-		if err := lt.SLock(ctx, blk); err != nil {
+		if err := lt.SLock(ctx, 2, blk); err != nil {
 			t.Fatalf("failed to acquire second SLock: %v", err)
 		}
 
 		// Try to acquire XLock (should timeout because there are 2 SLocks)
-		err := lt.XLock(ctx, blk)
+		err := lt.XLock(ctx, 1, blk)
 		if err == nil {
 			t.Error("expected timeout error when acquiring XLock blocked by multiple SLocks")
 		}
 
-		lt.UnLock(blk)
-		lt.UnLock(blk)
+		lt.UnLock(1, blk)
+		lt.UnLock(2, blk)
 	})
 }
 
 func TestLockTableConcurrentAccess(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
-		lt := dbtx.NewLockTable()
+		lt := dbbuffer.NewLockTable()
 		blk := dbfile.NewBlockID("testfile", 0)
 		ctx := context.Background()
 
@@ -269,11 +272,11 @@ func TestLockTableConcurrentAccess(t *testing.T) {
 			wg.Add(1)
 			go func(id int) {
 				defer wg.Done()
-				if err := lt.SLock(ctx, blk); err != nil {
+				if err := lt.SLock(ctx, uint64(id+1), blk); err != nil {
 					t.Errorf("goroutine %d: failed to acquire SLock: %v", id, err)
 					return
 				}
-				lt.UnLock(blk)
+				lt.UnLock(uint64(id+1), blk)
 			}(i)
 		}
 
@@ -284,32 +287,122 @@ func TestLockTableConcurrentAccess(t *testing.T) {
 }
 
 func TestLockTableUnlockNonExistentLock(t *testing.T) {
-	lt := dbtx.NewLockTable()
+	lt := dbbuffer.NewLockTable()
 	blk := dbfile.NewBlockID("testfile", 0)
 
 	// Unlock without acquiring lock (should not panic)
-	lt.UnLock(blk)
+	lt.UnLock(1, blk)
 
 	// No error should occur
 }
 
 func TestLockTableMultipleBlocks(t *testing.T) {
-	lt := dbtx.NewLockTable()
+	lt := dbbuffer.NewLockTable()
 	blk1 := dbfile.NewBlockID("testfile", 0)
 	blk2 := dbfile.NewBlockID("testfile", 1)
 	ctx := context.Background()
 
 	// Acquire locks on different blocks
-	if err := lt.SLock(ctx, blk1); err != nil {
+	if err := lt.SLock(ctx, 1, blk1); err != nil {
 		t.Fatalf("failed to acquire SLock on blk1: %v", err)
 	}
-	if err := lt.SLock(ctx, blk2); err != nil {
+	if err := lt.SLock(ctx, 1, blk2); err != nil {
 		t.Fatalf("failed to acquire SLock on blk2: %v", err)
 	}
 
 	// Unlock blk1
-	lt.UnLock(blk1)
+	lt.UnLock(1, blk1)
 
 	// Unlock blk2
-	lt.UnLock(blk2)
+	lt.UnLock(1, blk2)
+}
+
+func TestLockTableDeadlockAbortsYoungestCaller(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lt := dbbuffer.NewLockTable()
+		blk1 := dbfile.NewBlockID("testfile", 0)
+		blk2 := dbfile.NewBlockID("testfile", 1)
+		ctx := context.Background()
+
+		if err := lt.SLock(ctx, 1, blk1); err != nil {
+			t.Fatalf("failed to acquire SLock: %v", err)
+		}
+		if err := lt.SLock(ctx, 2, blk2); err != nil {
+			t.Fatalf("failed to acquire SLock: %v", err)
+		}
+
+		// tx1 waits for tx2
+		var olderErr error
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			olderErr = lt.XLock(ctx, 1, blk2)
+		}()
+		synctest.Wait()
+
+		// tx2 closes the cycle and is the youngest, so it is aborted without waiting
+		start := time.Now()
+		err := lt.XLock(ctx, 2, blk1)
+		assertDeadlock(t, err)
+		if elapsed := time.Since(start); elapsed != 0 {
+			t.Errorf("deadlock should be detected immediately, took %v", elapsed)
+		}
+
+		// tx2 rolls back and releases its locks
+		lt.UnLock(2, blk2)
+		<-done
+		if olderErr != nil {
+			t.Fatalf("older transaction should acquire XLock after victim released: %v", olderErr)
+		}
+		if got := lt.GetLockValLocked(blk2); got != -1 {
+			t.Errorf("expected lock count -1, got %d", got)
+		}
+	})
+}
+
+func TestLockTableDeadlockAbortsYoungestWaiter(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lt := dbbuffer.NewLockTable()
+		blk := dbfile.NewBlockID("testfile", 0)
+		ctx := context.Background()
+
+		// both transactions hold SLock and try to upgrade
+		if err := lt.SLock(ctx, 1, blk); err != nil {
+			t.Fatalf("failed to acquire SLock: %v", err)
+		}
+		if err := lt.SLock(ctx, 2, blk); err != nil {
+			t.Fatalf("failed to acquire SLock: %v", err)
+		}
+
+		var youngerErr error
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			youngerErr = lt.XLock(ctx, 2, blk)
+			lt.UnLock(2, blk)
+		}()
+		synctest.Wait()
+
+		// tx1 closes the cycle. tx2 is younger, so the waiting tx2 is aborted instead
+		start := time.Now()
+		if err := lt.XLock(ctx, 1, blk); err != nil {
+			t.Fatalf("older transaction should acquire XLock: %v", err)
+		}
+		<-done
+		assertDeadlock(t, youngerErr)
+		if elapsed := time.Since(start); elapsed != 0 {
+			t.Errorf("deadlock should be detected immediately, took %v", elapsed)
+		}
+		if got := lt.GetLockValLocked(blk); got != -1 {
+			t.Errorf("expected lock count -1, got %d", got)
+		}
+	})
+}
+
+func assertDeadlock(t *testing.T, err error) {
+	t.Helper()
+	var dbErr *dberr.DBError
+	if !errors.As(err, &dbErr) || dbErr.Code != dberr.CodeTransactionDeadlockAbort {
+		t.Fatalf("expected deadlock error, got %v", err)
+	}
 }
@@ -0,0 +1,205 @@
+package dbbuffer
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// unpinされたbufferのうち, どれを別のblockに割り当て直すかの方針
+type ReplacementPolicy string
+
+const (
+	// 最後にunpinされてから最も時間が経ったbufferを選ぶ
+	PolicyLRU ReplacementPolicy = "lru"
+	// 参照bitを見ながら針を回し, 一周する間に参照されなかったbufferを選ぶ. LRUの近似
+	PolicyClock ReplacementPolicy = "clock"
+	// 最近K回のアクセスのうち最も古いものが最も古いbufferを選ぶ. 1回しか読まれないscanでよく使うblockが追い出されにくい
+	PolicyLRUK ReplacementPolicy = "lru-k"
+)
+
+// LRU-KのK
+const lruK = 2
+
+func ParseReplacementPolicy(s string) (ReplacementPolicy, error) {
+	switch p := ReplacementPolicy(s); p {
+	case PolicyLRU, PolicyClock, PolicyLRUK:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown buffer replacement policy %q", s)
+}
+
+// 置き換えの候補(unpinされたbuffer)を管理する. BufferManagerのlock内で呼ぶ
+type replacer interface {
+	// bufferがpinされた. 候補なら候補から外す
+	pinned(id int)
+	// bufferのpinが全て外れて候補になった
+	unpinned(id int)
+	// 候補から置き換えるbufferを選んで候補から外す. 候補がなければfalse
+	victim() (int, bool)
+	// アクセスとして数えずに候補から外す. ringで使い回すbufferに使う
+	remove(id int)
+}
+
+func newReplacer(policy ReplacementPolicy, numBuffers int) replacer {
+	switch policy {
+	case PolicyClock:
+		return newClockReplacer(numBuffers)
+	case PolicyLRUK:
+		return newLRUKReplacer(numBuffers)
+	default:
+		return newLRUReplacer(numBuffers)
+	}
+}
+
+// unpinされると先頭に入れ, 末尾から選ぶ
+type lruReplacer struct {
+	list     *list.List
+	elements []*list.Element
+}
+
+func newLRUReplacer(numBuffers int) *lruReplacer {
+	return &lruReplacer{list: list.New(), elements: make([]*list.Element, numBuffers)}
+}
+
+func (r *lruReplacer) pinned(id int) {
+	if e := r.elements[id]; e != nil {
+		r.list.Remove(e)
+		r.elements[id] = nil
+	}
+}
+
+func (r *lruReplacer) unpinned(id int) {
+	if r.elements[id] == nil {
+		r.elements[id] = r.list.PushFront(id)
+	}
+}
+
+func (r *lruReplacer) remove(id int) {
+	r.pinned(id)
+}
+
+func (r *lruReplacer) victim() (int, bool) {
+	back := r.list.Back()
+	if back == nil {
+		return 0, false
+	}
+	id := back.Value.(int)
+	r.list.Remove(back)
+	r.elements[id] = nil
+	return id, true
+}
+
+// pinされると参照bitを立てる. 針の位置の候補の参照bitが立っていれば下ろして進み, 下りていれば選ぶ
+type clockReplacer struct {
+	candidate     []bool
+	referenced    []bool
+	numCandidates int
+	hand          int
+}
+
+func newClockReplacer(numBuffers int) *clockReplacer {
+	return &clockReplacer{candidate: make([]bool, numBuffers), referenced: make([]bool, numBuffers)}
+}
+
+func (r *clockReplacer) pinned(id int) {
+	r.referenced[id] = true
+	if r.candidate[id] {
+		r.candidate[id] = false
+		r.numCandidates--
+	}
+}
+
+func (r *clockReplacer) unpinned(id int) {
+	if !r.candidate[id] {
+		r.candidate[id] = true
+		r.numCandidates++
+	}
+}
+
+func (r *clockReplacer) remove(id int) {
+	if r.candidate[id] {
+		r.candidate[id] = false
+		r.numCandidates--
+	}
+}
+
+func (r *clockReplacer) victim() (int, bool) {
+	if r.numCandidates == 0 {
+		return 0, false
+	}
+	// 2周すれば全ての参照bitが下りる
+	for {
+		id := r.hand
+		r.hand = (r.hand + 1) % len(r.candidate)
+		if !r.candidate[id] {
+			continue
+		}
+		if r.referenced[id] {
+			r.referenced[id] = false
+			continue
+		}
+		r.candidate[id] = false
+		r.numCandidates--
+		return id, true
+	}
+}
+
+// bufferごとに最近K回のアクセス時刻を持ち, K回前のアクセスが最も古いものを選ぶ
+// アクセスがK回に満たないbufferを優先し, その中では最後のアクセスが最も古いものを選ぶ
+// 選ぶ時は候補を全て見るので, 置き換えはbuffer数に比例する
+type lrukReplacer struct {
+	candidate []bool
+	// history[id][0]が最後のアクセス. 0はアクセスなし
+	history [][lruK]uint64
+	now     uint64
+}
+
+func newLRUKReplacer(numBuffers int) *lrukReplacer {
+	return &lrukReplacer{candidate: make([]bool, numBuffers), history: make([][lruK]uint64, numBuffers)}
+}
+
+func (r *lrukReplacer) pinned(id int) {
+	r.now++
+	h := &r.history[id]
+	copy(h[1:], h[:lruK-1])
+	h[0] = r.now
+	r.candidate[id] = false
+}
+
+func (r *lrukReplacer) unpinned(id int) {
+	r.candidate[id] = true
+}
+
+func (r *lrukReplacer) remove(id int) {
+	r.candidate[id] = false
+}
+
+func (r *lrukReplacer) victim() (int, bool) {
+	best := -1
+	for id, ok := range r.candidate {
+		if ok && (best < 0 || r.older(id, best)) {
+			best = id
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	r.candidate[best] = false
+	// 別のblockになるので履歴を捨てる
+	r.history[best] = [lruK]uint64{}
+	return best, true
+}
+
+// aの方がbより先に置き換えるべきか
+func (r *lrukReplacer) older(a, b int) bool {
+	ha, hb := r.history[a], r.history[b]
+	fullA, fullB := ha[lruK-1] != 0, hb[lruK-1] != 0
+	switch {
+	case fullA != fullB:
+		return !fullA
+	case !fullA:
+		return ha[0] < hb[0]
+	default:
+		return ha[lruK-1] < hb[lruK-1]
+	}
+}
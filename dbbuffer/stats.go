@@ -0,0 +1,113 @@
+package dbbuffer
+
+import "github.com/teru01/simpledb-go/dbfile"
+
+// buffer poolの利用状況. ファイルごとに数える
+type BufferStats struct {
+	// pinでblockがbuffer poolにあった
+	Hits int64
+	// pinでblockをディスクから読んだ
+	Misses int64
+	// 別のblockを読むためにbufferから追い出された
+	Evictions int64
+	// 空いているbufferがなくpinが待った
+	PinWaits int64
+	// 変更済みのbufferを書き出した. 置き換え, commit, checkpoint, background writerの全てを含む
+	DirtyFlushes int64
+	// DirtyFlushesのうちbackground writerが書き出した
+	BackgroundWrites int64
+	// 先読みでディスクから読んだ
+	Prefetches int64
+}
+
+func (s BufferStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s *BufferStats) add(other BufferStats) {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Evictions += other.Evictions
+	s.PinWaits += other.PinWaits
+	s.DirtyFlushes += other.DirtyFlushes
+	s.BackgroundWrites += other.BackgroundWrites
+	s.Prefetches += other.Prefetches
+}
+
+// buffer pool全体の統計
+func (bm *BufferManager) Stats() BufferStats {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	var total BufferStats
+	for _, s := range bm.stats {
+		total.add(*s)
+	}
+	return total
+}
+
+// ファイル名ごとの統計
+func (bm *BufferManager) FileStats() map[string]BufferStats {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	m := make(map[string]BufferStats, len(bm.stats))
+	for fileName, s := range bm.stats {
+		m[fileName] = *s
+	}
+	return m
+}
+
+func (bm *BufferManager) ResetStats() {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	clear(bm.stats)
+}
+
+// lock前提
+func (bm *BufferManager) fileStatsLocked(fileName string) *BufferStats {
+	s, ok := bm.stats[fileName]
+	if !ok {
+		s = &BufferStats{}
+		bm.stats[fileName] = s
+	}
+	return s
+}
+
+// buffer poolの1つのbufferの現在の状態
+type Frame struct {
+	ID int
+	// 未割り当てならゼロ値
+	Block dbfile.BlockID
+	Pins  int
+	// ディスクに書き出していない変更をしたtransaction. 変更がなければ0
+	ModifyingTx uint64
+}
+
+func (f Frame) Dirty() bool {
+	return f.ModifyingTx != 0
+}
+
+// 全てのbufferの現在の状態
+func (bm *BufferManager) Frames() []Frame {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	frames := make([]Frame, len(bm.bufferPool))
+	for i := range bm.bufferPool {
+		buf := &bm.bufferPool[i]
+		frames[i] = Frame{ID: buf.ID, Block: buf.BlockID(), Pins: buf.state.pins, ModifyingTx: buf.ModifyingTx()}
+	}
+	return frames
+}
+
+// ディスクに書き出していない変更があるbufferの数
+func (bm *BufferManager) DirtyCount() int {
+	n := 0
+	for _, f := range bm.Frames() {
+		if f.Dirty() {
+			n++
+		}
+	}
+	return n
+}
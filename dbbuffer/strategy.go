@@ -0,0 +1,67 @@
+package dbbuffer
+
+import "github.com/teru01/simpledb-go/dbfile"
+
+const (
+	// 大きなファイルのscanで使い回すbufferの最大数
+	maxScanRingSize = 32
+	// 1回の先読みで読む最大block数
+	maxReadAhead = 8
+)
+
+// ファイルを先頭から順に読むscanのbufferの使い方. scanごとに作る
+// ringがある場合, scanが読み込みに使うbufferをringの分だけに限り, 使い終わったbufferを次のblockに使い回す
+// 1度しか読まれないblockでbuffer pool全体が置き換わり, 他のtransactionがよく使うblockが追い出されるのを防ぐ
+type AccessStrategy struct {
+	ring      []ringSlot
+	next      int
+	readAhead int
+}
+
+// ringの各位置で最後に読み込みに使ったbuffer
+type ringSlot struct {
+	assigned bool
+	id       int
+	blk      dbfile.BlockID
+}
+
+// numBlocksのファイルを順に読む時のstrategy
+// buffer poolの1/4より大きいファイルはringを使って読む
+func (bm *BufferManager) SequentialStrategy(numBlocks int) *AccessStrategy {
+	numBuffers := len(bm.bufferPool)
+	s := &AccessStrategy{readAhead: min(maxReadAhead, numBuffers/4)}
+	// 先読みしたblockを読む前にringで使い回さないよう, ringは先読みの範囲とpin中のblockより大きくする
+	if ringSize := min(maxScanRingSize, numBuffers/4); numBlocks > numBuffers/4 && ringSize > s.readAhead+1 {
+		s.ring = make([]ringSlot, ringSize)
+	}
+	return s
+}
+
+// 1回の先読みで読むblock数
+func (s *AccessStrategy) ReadAhead() int {
+	return s.readAhead
+}
+
+// blkを読み込むbufferを選んで置き換えの候補から外す. lock前提
+// ringの次の位置のbufferがunpinされていて, まだringで読んだblockのままなら使い回す. そうでなければreplacerから選ぶ
+func (bm *BufferManager) victimLocked(s *AccessStrategy, blk dbfile.BlockID) (int, bool) {
+	if s == nil || s.ring == nil {
+		return bm.replacer.victim()
+	}
+	slot := &s.ring[s.next]
+	s.next = (s.next + 1) % len(s.ring)
+	if slot.assigned {
+		buf := &bm.bufferPool[slot.id]
+		if !buf.IsPinned() && buf.BlockID().Equals(slot.blk) {
+			bm.replacer.remove(slot.id)
+			slot.blk = blk
+			return slot.id, true
+		}
+	}
+	id, ok := bm.replacer.victim()
+	if !ok {
+		return 0, false
+	}
+	*slot = ringSlot{assigned: true, id: id, blk: blk}
+	return id, true
+}
@@ -0,0 +1,187 @@
+package dbbuffer
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/teru01/simpledb-go/dberr"
+	"github.com/teru01/simpledb-go/dbfile"
+)
+
+// まだcommitされていないversionのseq. どのsnapshotからも見えない
+const uncommittedSeq = math.MaxUint64
+
+// snapshot isolationのためにblockの古いversionをメモリ上に保持する. BufferManagerごとに1つ持ち, そのdatabaseの全てのtransactionで共有する
+// 書き込むtransactionはblockを最初に変更する前に変更前の内容を退避し、
+// snapshotで読むtransactionは自分から見える最新のversionを読む
+// versionはblock単位なので, 同じblockの別々のrecordを書き換えたtransactionどうしも競合として扱う
+type VersionStore struct {
+	mu sync.Mutex
+	// 最後にcommitしたtransactionの通し番号
+	commitSeq uint64
+	chains    map[dbfile.BlockID]*versionChain
+	// transactionごとの書き込んだblock
+	written map[uint64][]dbfile.BlockID
+	// 実行中のsnapshot. 古いversionを捨ててよいかの判定に使う
+	snapshots map[uint64]uint64
+}
+
+// bufferの内容と, それより古いversion
+type versionChain struct {
+	// bufferの内容を書き込んだtransaction
+	writer uint64
+	seq    uint64
+	// 古い順
+	olders []blockVersion
+}
+
+type blockVersion struct {
+	page   *dbfile.Page
+	writer uint64
+	seq    uint64
+}
+
+// transaction開始時点でcommit済みのversionのみが見える
+type Snapshot struct {
+	txNum uint64
+	seq   uint64
+}
+
+func (s Snapshot) sees(writer, seq uint64) bool {
+	return writer == s.txNum || seq <= s.seq
+}
+
+func NewVersionStore() *VersionStore {
+	return &VersionStore{
+		chains:    make(map[dbfile.BlockID]*versionChain),
+		written:   make(map[uint64][]dbfile.BlockID),
+		snapshots: make(map[uint64]uint64),
+	}
+}
+
+func (v *VersionStore) Begin(txNum uint64) Snapshot {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.snapshots[txNum] = v.commitSeq
+	return Snapshot{txNum: txNum, seq: v.commitSeq}
+}
+
+// snapから見えるblkの内容をreadに渡す
+// bufferの内容を読んでいる間に他のtransactionが書き込みを始めないようにlock内で呼ぶ
+func (v *VersionStore) Read(snap Snapshot, blk dbfile.BlockID, current *dbfile.Page, read func(p *dbfile.Page)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	chain, ok := v.chains[blk]
+	if !ok || snap.sees(chain.writer, chain.seq) {
+		read(current)
+		return
+	}
+	for _, version := range slices.Backward(chain.olders) {
+		if snap.sees(version.writer, version.seq) {
+			read(version.page)
+			return
+		}
+	}
+	// 見えるversionは捨てていないはずだが、念のため最も古いものを読む
+	read(chain.olders[0].page)
+}
+
+// txNumがblkを変更する前に呼ぶ. 初めて変更するblockなら変更前の内容を退避する
+// snapがnilでない場合, snapshot以降に他のtransactionがcommitしたblockへの書き込みはエラーにする(first-committer-wins)
+// 競合はblock単位で判定するので, 書き換えるrecordが別でも同じblockなら競合になる
+func (v *VersionStore) BeforeWrite(txNum uint64, snap *Snapshot, blk dbfile.BlockID, current *dbfile.Page) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	chain, ok := v.chains[blk]
+	if !ok {
+		chain = &versionChain{}
+		v.chains[blk] = chain
+	}
+	if chain.writer == txNum {
+		return nil
+	}
+	if snap != nil && !snap.sees(chain.writer, chain.seq) {
+		return dberr.New(dberr.CodeTransactionWriteConflictAbort, fmt.Sprintf("could not serialize access due to concurrent update. block %s was changed by transaction %d after the snapshot of transaction %d", blk, chain.writer, txNum), nil)
+	}
+	chain.olders = append(chain.olders, blockVersion{page: current.Clone(), writer: chain.writer, seq: chain.seq})
+	chain.writer = txNum
+	chain.seq = uncommittedSeq
+	v.written[txNum] = append(v.written[txNum], blk)
+	return nil
+}
+
+// txNumが追加したblock. 追加前は空のblockだったものとして扱う
+func (v *VersionStore) Appended(txNum uint64, blk dbfile.BlockID, blockSize int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.chains[blk] = &versionChain{
+		writer: txNum,
+		seq:    uncommittedSeq,
+		olders: []blockVersion{{page: dbfile.NewPage(blockSize)}},
+	}
+	v.written[txNum] = append(v.written[txNum], blk)
+}
+
+// txNumが書き込んだversionを以降に開始するsnapshotから見えるようにする
+func (v *VersionStore) Commit(txNum uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.written[txNum]) == 0 {
+		return
+	}
+	v.commitSeq++
+	for _, blk := range v.written[txNum] {
+		if chain, ok := v.chains[blk]; ok && chain.writer == txNum {
+			chain.seq = v.commitSeq
+		}
+	}
+}
+
+// rollbackでbufferの内容が戻ったので, 退避していたversionを取り除く
+func (v *VersionStore) Rollback(txNum uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, blk := range slices.Backward(v.written[txNum]) {
+		chain, ok := v.chains[blk]
+		if !ok || chain.writer != txNum || len(chain.olders) == 0 {
+			continue
+		}
+		last := chain.olders[len(chain.olders)-1]
+		chain.olders = chain.olders[:len(chain.olders)-1]
+		chain.writer = last.writer
+		chain.seq = last.seq
+	}
+}
+
+// transaction終了時に呼ぶ. どのsnapshotからも参照されなくなったversionを捨てる
+func (v *VersionStore) Finish(txNum uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.written, txNum)
+	delete(v.snapshots, txNum)
+
+	oldest := v.commitSeq
+	for _, seq := range v.snapshots {
+		oldest = min(oldest, seq)
+	}
+	for blk, chain := range v.chains {
+		// olders[i]はそれより新しいversionがすべてのsnapshotから見えるなら不要
+		keep := len(chain.olders)
+		for i := len(chain.olders) - 1; i >= 0; i-- {
+			newerSeq := chain.seq
+			if i+1 < len(chain.olders) {
+				newerSeq = chain.olders[i+1].seq
+			}
+			if newerSeq <= oldest {
+				keep = len(chain.olders) - i - 1
+				break
+			}
+		}
+		chain.olders = chain.olders[len(chain.olders)-keep:]
+		if len(chain.olders) == 0 && chain.seq != uncommittedSeq {
+			delete(v.chains, blk)
+		}
+	}
+}
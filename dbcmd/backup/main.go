@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/teru01/simpledb-go/dbexecutor"
+	"github.com/teru01/simpledb-go/dbparse"
+	"github.com/teru01/simpledb-go/dbserver"
+)
+
+// protocol version 3.0
+const protocolVersion = 196608
+
+// 動いているserverにBACKUP TOを送ってbackupを取る. -restoreではbackupを写してrecoveryする
+//
+//	SERVER_ADDR=localhost:5432 BACKUP_DIR=/backup/2026-10-16 go run ./dbcmd/backup
+//	BACKUP_DIR=/backup/2026-10-16 BASE_DIR=/data go run ./dbcmd/backup -restore
+func main() {
+	restore := flag.Bool("restore", false, "copy BACKUP_DIR to BASE_DIR and run recovery on the copy")
+	flag.Parse()
+
+	backupDir := os.Getenv("BACKUP_DIR")
+	if backupDir == "" {
+		slog.Error("BACKUP_DIR is required")
+		os.Exit(1)
+	}
+
+	if *restore {
+		if err := restoreBackup(backupDir); err != nil {
+			slog.Error("failed to restore backup", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	addr := getEnvOrDefault("SERVER_ADDR", "localhost:5432")
+	tag, err := runQuery(addr, fmt.Sprintf("BACKUP TO %s", strconv.Quote(backupDir)))
+	if err != nil {
+		slog.Error("failed to take backup", "addr", addr, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("backup completed", "dir", backupDir, "tag", tag)
+}
+
+// BACKUP_DIRをBASE_DIRに写し, backup labelのcheckpointからrecoveryする
+// RECOVER_TOを指定した場合は, ARCHIVE_DIRのlogをそこまで反映する
+func restoreBackup(backupDir string) error {
+	baseDir := os.Getenv("BASE_DIR")
+	if baseDir == "" {
+		return fmt.Errorf("BASE_DIR is required for restore")
+	}
+	if err := os.CopyFS(baseDir, os.DirFS(backupDir)); err != nil {
+		return fmt.Errorf("copy %s to %s: %w", backupDir, baseDir, err)
+	}
+
+	db, cleanup, err := dbexecutor.NewSimpleDB(baseDir, getEnvIntOrDefault("BLOCK_SIZE", 4000), getEnvIntOrDefault("BUFFER_SIZE", 100))
+	if err != nil {
+		return fmt.Errorf("create simpledb: %w", err)
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	if recoverTo := os.Getenv("RECOVER_TO"); recoverTo != "" {
+		archiveDir := os.Getenv("ARCHIVE_DIR")
+		if archiveDir == "" {
+			return fmt.Errorf("ARCHIVE_DIR is required for RECOVER_TO")
+		}
+		data, err := dbparse.NewParser("RECOVER TO " + recoverTo).RecoverTo()
+		if err != nil {
+			return fmt.Errorf("parse RECOVER_TO %q: %w", recoverTo, err)
+		}
+		if err := db.InitFromArchive(ctx, archiveDir, data.Target()); err != nil {
+			return fmt.Errorf("recover to %s: %w", data.Target(), err)
+		}
+	} else if err := db.Init(ctx); err != nil {
+		return fmt.Errorf("recover: %w", err)
+	}
+	slog.Info("restore completed", "dir", baseDir)
+	return nil
+}
+
+// simple query protocolでqueryを1つ実行し, command tagを返す
+func runQuery(addr, query string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	// StartupMessage. serverはparameterを見ない
+	startup := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	startup = append(startup, "user\x00simpledb\x00\x00"...)
+	if _, err := conn.Write(binary.BigEndian.AppendUint32(nil, uint32(len(startup)+4))); err != nil {
+		return "", fmt.Errorf("write startup length: %w", err)
+	}
+	if _, err := conn.Write(startup); err != nil {
+		return "", fmt.Errorf("write startup message: %w", err)
+	}
+	if _, err := readUntilReady(conn); err != nil {
+		return "", fmt.Errorf("startup: %w", err)
+	}
+
+	msg := dbserver.NewMessage(dbserver.Query, append([]byte(query), 0))
+	if _, err := conn.Write(msg.ToByte()); err != nil {
+		return "", fmt.Errorf("write query: %w", err)
+	}
+	tag, err := readUntilReady(conn)
+	if err != nil {
+		return "", err
+	}
+	terminate := dbserver.NewMessage('X', nil)
+	if _, err := conn.Write(terminate.ToByte()); err != nil {
+		return "", fmt.Errorf("write terminate: %w", err)
+	}
+	return tag, nil
+}
+
+// ReadyForQueryまで読み, CommandCompleteのtagを返す. ErrorResponseの場合はそのmessageをerrorにする
+func readUntilReady(r io.Reader) (string, error) {
+	var tag string
+	var queryErr error
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return "", fmt.Errorf("read message header: %w", err)
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return "", fmt.Errorf("read message payload: %w", err)
+		}
+		switch dbserver.MessageIdentifier(header[0]) {
+		case dbserver.CommandComplete:
+			tag = string(bytes.TrimRight(payload, "\x00"))
+		case dbserver.ErrorResponse:
+			queryErr = fmt.Errorf("server error: %s", errorMessage(payload))
+		case dbserver.ReadyForQuery:
+			return tag, queryErr
+		}
+	}
+}
+
+// ErrorResponseのMフィールド
+func errorMessage(payload []byte) string {
+	for _, field := range bytes.Split(payload, []byte{0}) {
+		if len(field) > 0 && field[0] == 'M' {
+			return string(field[1:])
+		}
+	}
+	return "unknown error"
+}
+
+func getEnvOrDefault(key, defaultVal string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+func getEnvIntOrDefault(key string, defaultVal int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("invalid env value", "key", key, "value", v, "error", err)
+		os.Exit(1)
+	}
+	return n
+}
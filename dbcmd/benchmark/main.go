@@ -5,10 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math/rand/v2"
 	"os"
 	"runtime/pprof"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/teru01/simpledb-go/dbbuffer"
@@ -25,12 +28,21 @@ type benchConfig struct {
 	records    int
 	iterations int
 	dataDir    string
+	// group commitの計測用
+	commitWorkers    int
+	commitsPerWorker int
+	groupCommitDelay time.Duration
+	// buffer poolの置き換え方針の計測用
+	bufferBlocks int
+	bufferOps    int
 }
 
 func main() {
 	prepare := flag.Bool("prepare", false, "prepare data only (create table and insert records into a fresh database)")
 	index := flag.Bool("index", false, "create index only (on existing data)")
 	sel := flag.Bool("select", false, "run 100 SELECT queries by id on existing data")
+	commit := flag.Bool("commit", false, "run concurrent small transactions and report group commit batch sizes")
+	buffer := flag.Bool("buffer", false, "compare hit ratio and pin latency of buffer replacement policies")
 	flag.Parse()
 
 	cfg := benchConfig{
@@ -39,6 +51,13 @@ func main() {
 		records:    getEnvIntOrDefault("RECORDS", 10000),
 		iterations: getEnvIntOrDefault("ITERATIONS", 100),
 		dataDir:    os.Getenv("DATA_DIR"),
+
+		commitWorkers:    getEnvIntOrDefault("COMMIT_WORKERS", 8),
+		commitsPerWorker: getEnvIntOrDefault("COMMITS_PER_WORKER", 100),
+		groupCommitDelay: getEnvDurationOrDefault("GROUP_COMMIT_DELAY", 0),
+
+		bufferBlocks: getEnvIntOrDefault("BUFFER_BLOCKS", 0),
+		bufferOps:    getEnvIntOrDefault("BUFFER_OPS", 100000),
 	}
 
 	mode := "all"
@@ -48,6 +67,10 @@ func main() {
 		mode = "index"
 	} else if *sel {
 		mode = "select"
+	} else if *commit {
+		mode = "commit"
+	} else if *buffer {
+		mode = "buffer"
 	}
 
 	dirName := cfg.dataDir
@@ -77,7 +100,7 @@ func main() {
 			slog.Error("DATA_DIR is required for -index")
 			os.Exit(1)
 		}
-	case "all":
+	case "all", "commit", "buffer":
 		if dirName == "" {
 			var err error
 			dirName, err = os.MkdirTemp("", "simpledb-bench-*")
@@ -104,6 +127,7 @@ func main() {
 		slog.Error("failed to init db", "error", err)
 		os.Exit(1)
 	}
+	defer lm.Close()
 
 	ctx := context.Background()
 	planner, err := setupPlanner(ctx, fm, lm, bm)
@@ -151,6 +175,18 @@ func main() {
 		benchSelectByID(ctx, fm, lm, bm, planner, cfg.records, cfg.iterations, "without index")
 		benchCreateIndex(ctx, fm, lm, bm, planner)
 		benchSelectByID(ctx, fm, lm, bm, planner, cfg.records, cfg.iterations, "with index")
+
+		fmt.Printf("\n--- Group commit ---\n\n")
+
+		benchGroupCommit(ctx, fm, lm, bm, cfg)
+
+		fmt.Printf("\n--- Buffer replacement ---\n\n")
+
+		benchBufferPolicies(ctx, fm, lm, cfg)
+	case "commit":
+		benchGroupCommit(ctx, fm, lm, bm, cfg)
+	case "buffer":
+		benchBufferPolicies(ctx, fm, lm, cfg)
 	}
 }
 
@@ -163,7 +199,7 @@ func initDB(dirName string, cfg benchConfig) (*dbfile.FileManager, *dblog.LogMan
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	lm, err := dblog.NewLogManager(fm, "log.log")
+	lm, err := dblog.NewLogManager(fm, "log.log", dblog.WithGroupCommit(cfg.groupCommitDelay))
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -334,6 +370,112 @@ func benchSelectByID(ctx context.Context, fm *dbfile.FileManager, lm *dblog.LogM
 	}
 }
 
+// workerごとに別のblockへ書き込む小さなtransactionを並行にcommitし, group commitでまとまったflushの大きさを見る
+func benchGroupCommit(ctx context.Context, fm *dbfile.FileManager, lm *dblog.LogManager, bm *dbbuffer.BufferManager, cfg benchConfig) {
+	const fileName = "groupcommit.bench"
+	blocks := make([]dbfile.BlockID, cfg.commitWorkers)
+	if err := execBench(ctx, fm, lm, bm, func(ctx context.Context, tx *dbtx.Transaction) error {
+		for i := range blocks {
+			blk, err := tx.Append(ctx, fileName)
+			if err != nil {
+				return err
+			}
+			blocks[i] = blk
+		}
+		return nil
+	}); err != nil {
+		slog.Error("failed to prepare group commit blocks", "error", err)
+		return
+	}
+
+	lm.ResetGroupCommitStats()
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, cfg.commitWorkers)
+	for _, blk := range blocks {
+		wg.Go(func() {
+			for i := range cfg.commitsPerWorker {
+				if err := execBench(ctx, fm, lm, bm, func(ctx context.Context, tx *dbtx.Transaction) error {
+					if err := tx.Pin(ctx, blk); err != nil {
+						return err
+					}
+					return tx.SetInt(ctx, blk, 0, i, true)
+				}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		slog.Error("commit failed", "error", err)
+		return
+	}
+	elapsed := time.Since(start)
+
+	total := cfg.commitWorkers * cfg.commitsPerWorker
+	printResult(fmt.Sprintf("COMMIT (%d workers, delay=%s)", cfg.commitWorkers, cfg.groupCommitDelay), total, elapsed)
+	stats := lm.GroupCommitStats()
+	fmt.Printf("  group commit: flushes=%d  commits=%d  avg batch=%.2f  max batch=%d\n", stats.Flushes, stats.Commits, stats.AvgBatchSize(), stats.MaxBatchSize)
+	for _, size := range slices.Sorted(maps.Keys(stats.BatchSizes)) {
+		fmt.Printf("    batch size %d: %d flushes\n", size, stats.BatchSizes[size])
+	}
+}
+
+// 同じアクセス列を置き換え方針ごとに新しいbuffer poolで読み, hit率とpinの時間を比べる
+// アクセス列は8割が先頭2割のblockに偏り, 時々buffer pool全体の大きさのscanが混ざる
+func benchBufferPolicies(ctx context.Context, fm *dbfile.FileManager, lm *dblog.LogManager, cfg benchConfig) {
+	const fileName = "buffer.bench"
+	numBlocks := cfg.bufferBlocks
+	if numBlocks == 0 {
+		numBlocks = 4 * cfg.bufferSize
+	}
+	for range numBlocks {
+		if _, err := fm.Append(fileName); err != nil {
+			slog.Error("failed to prepare blocks", "error", err)
+			return
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	hot := max(numBlocks/5, 1)
+	accesses := make([]int, 0, cfg.bufferOps)
+	for len(accesses) < cfg.bufferOps {
+		if len(accesses)%1000 == 999 {
+			start := rng.IntN(numBlocks)
+			for i := range min(cfg.bufferSize, cfg.bufferOps-len(accesses)) {
+				accesses = append(accesses, (start+i)%numBlocks)
+			}
+			continue
+		}
+		if rng.IntN(10) < 8 {
+			accesses = append(accesses, rng.IntN(hot))
+		} else {
+			accesses = append(accesses, rng.IntN(numBlocks))
+		}
+	}
+
+	fmt.Printf("blocks=%d  accesses=%d\n", numBlocks, len(accesses))
+	for _, policy := range []dbbuffer.ReplacementPolicy{dbbuffer.PolicyLRU, dbbuffer.PolicyClock, dbbuffer.PolicyLRUK} {
+		bm := dbbuffer.NewBufferManager(fm, lm, cfg.bufferSize, dbbuffer.WithReplacementPolicy(policy))
+		start := time.Now()
+		for _, blkNum := range accesses {
+			buf, err := bm.Pin(ctx, dbfile.NewBlockID(fileName, blkNum))
+			if err != nil {
+				slog.Error("failed to pin", "error", err, "policy", policy)
+				return
+			}
+			bm.Unpin(buf)
+		}
+		elapsed := time.Since(start)
+		stats := bm.Stats()
+		printResult(fmt.Sprintf("PIN %s", policy), len(accesses), elapsed)
+		fmt.Printf("  hit ratio=%.3f  hits=%d  misses=%d  avg pin=%s\n", stats.HitRatio(), stats.Hits, stats.Misses, elapsed/time.Duration(len(accesses)))
+	}
+}
+
 func scanQuery(ctx context.Context, planner *dbplan.Planner, tx *dbtx.Transaction, sql string, count *int) error {
 	plan, err := planner.CreateQueryPlan(ctx, sql, tx)
 	if err != nil {
@@ -379,3 +521,16 @@ func getEnvIntOrDefault(key string, defaultVal int) int {
 	}
 	return n
 }
+
+func getEnvDurationOrDefault(key string, defaultVal time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Error("invalid env value", "key", key, "value", v, "error", err)
+		os.Exit(1)
+	}
+	return d
+}
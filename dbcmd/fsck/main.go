@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/teru01/simpledb-go/dbexecutor"
+)
+
+// BASE_DIRの全てのtableについて, レコードとindexが食い違っていないか, B-treeが壊れていないかを確かめる
+// 問題があれば終了コード1で終わる. -repairでは問題のあったindexをレコードから作り直す. serverを止めてから実行する
+func main() {
+	repair := flag.Bool("repair", false, "rebuild indexes that have problems")
+	flag.Parse()
+
+	dirName := getEnvOrDefault("BASE_DIR", filepath.Join(os.Getenv("PWD"), ".dbdata"))
+	db, cleanup, err := dbexecutor.NewSimpleDB(dirName, getEnvIntOrDefault("BLOCK_SIZE", 4000), getEnvIntOrDefault("BUFFER_SIZE", 100))
+	if err != nil {
+		slog.Error("failed to create simpledb", "error", err)
+		os.Exit(1)
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := db.Init(ctx); err != nil {
+		slog.Error("failed to init simpledb", "error", err)
+		os.Exit(1)
+	}
+	results, err := db.CheckTables(ctx, *repair)
+	if err != nil {
+		slog.Error("failed to check tables", "error", err)
+		os.Exit(1)
+	}
+
+	numIndexes, numBroken := 0, 0
+	for _, table := range slices.Sorted(maps.Keys(results)) {
+		for _, c := range results[table] {
+			numIndexes++
+			if len(c.Problems) == 0 {
+				continue
+			}
+			numBroken++
+			for _, p := range c.Problems {
+				fmt.Printf("%s.%s (%s): %s\n", table, c.FieldName, c.IndexName, p)
+			}
+			if c.Repaired {
+				fmt.Printf("%s.%s (%s): repaired\n", table, c.FieldName, c.IndexName)
+			}
+		}
+	}
+	fmt.Printf("checked %d tables and %d indexes: %d broken\n", len(results), numIndexes, numBroken)
+	if numBroken > 0 && !*repair {
+		cleanup()
+		os.Exit(1)
+	}
+}
+
+func getEnvOrDefault(key, defaultVal string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+func getEnvIntOrDefault(key string, defaultVal int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("invalid env value", "key", key, "value", v, "error", err)
+		os.Exit(1)
+	}
+	return n
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbindex"
+	"github.com/teru01/simpledb-go/dblog"
+	"github.com/teru01/simpledb-go/dbmetadata"
+	"github.com/teru01/simpledb-go/dbname"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbsize"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// 止めたdatabaseのファイルを読んで中身を表示する. transactionを使わないので, logにrecordを書かずに読める
+// ディスク上のpageをそのまま読むので, logにしか残っていない変更は起動してrecoveryするまで表示されない
+//
+//	inspect -tables                              tableとindexの一覧
+//	inspect -table students -block 0             tableのblockのslot
+//	inspect -index students_id -node leaf -block 0  B-treeのleaf/dirのpage
+//	inspect -log [-tx 12] [-from 100] [-to 200]  log record
+func main() {
+	tables := flag.Bool("tables", false, "list tables and indexes in the catalog")
+	table := flag.String("table", "", "dump slots of a block of the table")
+	index := flag.String("index", "", "dump a B-tree page of the index")
+	node := flag.String("node", "leaf", "B-tree page type for -index: leaf or dir")
+	block := flag.Int("block", 0, "block number for -table and -index")
+	showLog := flag.Bool("log", false, "decode log records")
+	txNum := flag.Int("tx", -1, "show only log records of the transaction")
+	fromLSN := flag.Int("from", 0, "show only log records with LSN >= from")
+	toLSN := flag.Int("to", math.MaxInt, "show only log records with LSN <= to")
+	flag.Parse()
+
+	dirName := getEnvOrDefault("BASE_DIR", filepath.Join(os.Getenv("PWD"), ".dbdata"))
+	blockSize := getEnvIntOrDefault("BLOCK_SIZE", 4000)
+	f, err := os.Open(dirName)
+	if err != nil {
+		slog.Error("failed to open dir", "dir", dirName, "error", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	fm, err := dbfile.NewFileManager(f, blockSize)
+	if err != nil {
+		slog.Error("failed to create file manager", "error", err)
+		os.Exit(1)
+	}
+	i := &inspector{fm: fm}
+
+	switch {
+	case *tables:
+		err = i.printTables()
+	case *table != "":
+		err = i.printTableBlock(*table, *block)
+	case *index != "":
+		err = i.printBTreePage(*index, *node, *block)
+	case *showLog:
+		err = printLog(fm, *txNum, *fromLSN, *toLSN)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("failed to inspect", "error", err)
+		os.Exit(1)
+	}
+}
+
+type inspector struct {
+	fm *dbfile.FileManager
+}
+
+// tableの1行. NULLはnil
+type row map[string]any
+
+// fileNameのファイルの使用中のslotを全て読む
+func (i *inspector) scanTable(tableName string, layout *dbrecord.Layout) ([]row, error) {
+	fileName := dbrecord.TableFileName(tableName)
+	n, err := i.fm.FileBlockLength(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("get block length of %q: %w", fileName, err)
+	}
+	var rows []row
+	for blkNum := range n {
+		p, err := i.readPage(dbfile.NewBlockID(fileName, blkNum))
+		if err != nil {
+			return nil, err
+		}
+		for slot := range i.fm.BlockSize() / layout.SlotSize() {
+			pos := slot * layout.SlotSize()
+			if dbrecord.SlotStatus(p.GetInt(pos)) != dbrecord.SlotUsed {
+				continue
+			}
+			rows = append(rows, readRow(p, pos, layout))
+		}
+	}
+	return rows, nil
+}
+
+func (i *inspector) readPage(blk dbfile.BlockID) (*dbfile.Page, error) {
+	p := dbfile.NewPage(i.fm.BlockSize())
+	if err := i.fm.Read(blk, p); err != nil {
+		return nil, fmt.Errorf("read block %s: %w", blk, err)
+	}
+	return p, nil
+}
+
+// posから始まるslotの値を読む
+func readRow(p *dbfile.Page, pos int, layout *dbrecord.Layout) row {
+	r := make(row)
+	for _, field := range layout.Schema().Fields() {
+		offset, mask := layout.NullBit(field)
+		if p.GetInt(pos+offset)&mask != 0 {
+			r[field] = nil
+			continue
+		}
+		if layout.Schema().FieldType(field) == dbrecord.FieldTypeInt {
+			r[field] = p.GetInt(pos + layout.Offset(field))
+		} else {
+			r[field] = p.GetString(pos + layout.Offset(field))
+		}
+	}
+	return r
+}
+
+func formatRow(r row, fields []string) string {
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		switch v := r[field].(type) {
+		case nil:
+			values = append(values, field+"=NULL")
+		case string:
+			values = append(values, fmt.Sprintf("%s=%q", field, v))
+		default:
+			values = append(values, fmt.Sprintf("%s=%v", field, v))
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// catalogからtableの配置を組み立てる
+func (i *inspector) layout(tableName string) (*dbrecord.Layout, error) {
+	tables, err := i.scanTable(dbmetadata.TableCatalogTableName, dbmetadata.TableCatalogLayout())
+	if err != nil {
+		return nil, err
+	}
+	slotSize := -1
+	for _, t := range tables {
+		if t["tablename"] == tableName {
+			slotSize = t["slotsize"].(int)
+		}
+	}
+	if slotSize == -1 {
+		return nil, fmt.Errorf("table %q not found in catalog", tableName)
+	}
+	fields, err := i.scanTable(dbmetadata.FieldCatalogTableName, dbmetadata.FieldCatalogLayout())
+	if err != nil {
+		return nil, err
+	}
+	schema := dbrecord.NewSchema()
+	offsets := make(map[string]int)
+	for _, f := range fields {
+		if f["tablename"] != tableName {
+			continue
+		}
+		name := f["fieldname"].(string)
+		schema.AddField(name, f["type"].(int), f["length"].(int))
+		offsets[name] = f["offset"].(int)
+	}
+	return dbrecord.NewLayoutFromOffsets(schema, offsets, slotSize), nil
+}
+
+func (i *inspector) printTables() error {
+	tables, err := i.scanTable(dbmetadata.TableCatalogTableName, dbmetadata.TableCatalogLayout())
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		name := t["tablename"].(string)
+		layout, err := i.layout(name)
+		if err != nil {
+			return err
+		}
+		n, err := i.fm.FileBlockLength(dbrecord.TableFileName(name))
+		if err != nil {
+			return fmt.Errorf("get block length of %q: %w", name, err)
+		}
+		fmt.Printf("%s (slot size %d, %d blocks)\n", name, layout.SlotSize(), n)
+		for _, field := range layout.Schema().Fields() {
+			typ := "INT"
+			if layout.Schema().FieldType(field) == dbrecord.FieldTypeString {
+				typ = fmt.Sprintf("VARCHAR(%d)", layout.Schema().Length(field))
+			}
+			fmt.Printf("  %-16s %-12s offset %d\n", field, typ, layout.Offset(field))
+		}
+	}
+
+	indexLayout, err := i.layout(dbmetadata.IndexCatalogTableName)
+	if err != nil {
+		// indexを一度も作っていない
+		return nil
+	}
+	indexes, err := i.scanTable(dbmetadata.IndexCatalogTableName, indexLayout)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		fmt.Printf("index %s on %s (%s)\n", idx["indexname"], idx["tablename"], idx["fieldname"])
+	}
+	return nil
+}
+
+func (i *inspector) printTableBlock(tableName string, blkNum int) error {
+	layout, err := i.layout(tableName)
+	if err != nil {
+		return err
+	}
+	blk := dbfile.NewBlockID(dbrecord.TableFileName(tableName), blkNum)
+	p, err := i.readPage(blk)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s LSN %d\n", blk, p.LSN())
+	for slot := range i.fm.BlockSize() / layout.SlotSize() {
+		pos := slot * layout.SlotSize()
+		if dbrecord.SlotStatus(p.GetInt(pos)) != dbrecord.SlotUsed {
+			fmt.Printf("  slot %d: empty\n", slot)
+			continue
+		}
+		fmt.Printf("  slot %d: %s\n", slot, formatRow(readRow(p, pos, layout), layout.Schema().Fields()))
+	}
+	return nil
+}
+
+// indexの配置はIndexInfoと同じように, 対象のフィールドの型から組み立てる
+func (i *inspector) indexLayouts(indexName string) (leaf, dir *dbrecord.Layout, err error) {
+	indexLayout, err := i.layout(dbmetadata.IndexCatalogTableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes, err := i.scanTable(dbmetadata.IndexCatalogTableName, indexLayout)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, idx := range indexes {
+		if idx["indexname"] != indexName {
+			continue
+		}
+		tableLayout, err := i.layout(idx["tablename"].(string))
+		if err != nil {
+			return nil, nil, err
+		}
+		field := idx["fieldname"].(string)
+		leafSchema := dbrecord.NewSchema()
+		leafSchema.AddIntField(dbname.IndexFieldBlock)
+		leafSchema.AddIntField(dbname.IndexFieldID)
+		leafSchema.AddField(dbname.IndexFieldDataValue, tableLayout.Schema().FieldType(field), tableLayout.Schema().Length(field))
+		dirSchema := dbrecord.NewSchema()
+		dirSchema.Add(dbname.IndexFieldBlock, leafSchema)
+		dirSchema.Add(dbname.IndexFieldDataValue, leafSchema)
+		return dbrecord.NewLayout(leafSchema), dbrecord.NewLayout(dirSchema), nil
+	}
+	return nil, nil, fmt.Errorf("index %q not found in catalog", indexName)
+}
+
+// B-treeのpageは [flag][record数][record...] の順に並ぶ
+// leafのflagはoverflow blockの番号(なければ-1), dirのflagは木の中での高さ(leafの直上が0)
+func (i *inspector) printBTreePage(indexName, node string, blkNum int) error {
+	leaf, dir, err := i.indexLayouts(indexName)
+	if err != nil {
+		return err
+	}
+	fileNames := dbindex.BTreeIndexFileNames(indexName)
+	var fileName string
+	var layout *dbrecord.Layout
+	switch node {
+	case "leaf":
+		fileName, layout = fileNames[0], leaf
+	case "dir":
+		fileName, layout = fileNames[1], dir
+	default:
+		return fmt.Errorf("unknown B-tree node type %q. use leaf or dir", node)
+	}
+	blk := dbfile.NewBlockID(fileName, blkNum)
+	p, err := i.readPage(blk)
+	if err != nil {
+		return err
+	}
+	flag, numRecs := p.GetInt(0), p.GetInt(dbsize.IntSize)
+	fmt.Printf("%s LSN %d flag %d records %d\n", blk, p.LSN(), flag, numRecs)
+	// dataValueの後にblock, idの順で表示する
+	fields := append([]string{dbname.IndexFieldDataValue}, dbname.IndexFieldBlock)
+	if node == "leaf" {
+		fields = append(fields, dbname.IndexFieldID)
+	}
+	for slot := range numRecs {
+		pos := 2*dbsize.IntSize + slot*layout.SlotSize()
+		if pos+layout.SlotSize() > i.fm.BlockSize() {
+			return fmt.Errorf("record count %d exceeds the capacity of block %s", numRecs, blk)
+		}
+		fmt.Printf("  slot %d: %s\n", slot, formatRow(readRow(p, pos, layout), fields))
+	}
+	return nil
+}
+
+func printLog(fm *dbfile.FileManager, txNum, fromLSN, toLSN int) error {
+	lm, err := dblog.NewLogManager(fm, "log.log")
+	if err != nil {
+		return fmt.Errorf("create log manager: %w", err)
+	}
+	it, err := lm.ForwardIterator(fromLSN)
+	if err != nil {
+		return fmt.Errorf("get forward log iterator: %w", err)
+	}
+	for rec, err := range it {
+		if err != nil {
+			return fmt.Errorf("get next log record: %w", err)
+		}
+		if rec.LSN > toLSN {
+			break
+		}
+		logRecord := dbtx.NewLogRecord(rec.Data)
+		if logRecord == nil {
+			fmt.Printf("%d: unknown log record (%d bytes)\n", rec.LSN, len(rec.Data))
+			continue
+		}
+		if txNum >= 0 && dbtx.LogRecordTxNumber(logRecord) != uint64(txNum) {
+			continue
+		}
+		fmt.Printf("%d: %v\n", rec.LSN, logRecord)
+	}
+	return nil
+}
+
+func getEnvOrDefault(key, defaultVal string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+func getEnvIntOrDefault(key string, defaultVal int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("invalid env value", "key", key, "value", v, "error", err)
+		os.Exit(1)
+	}
+	return n
+}
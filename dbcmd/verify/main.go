@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// BASE_DIRの全てのファイルの全てのblockのchecksumを確かめ, 壊れたblockを表示する
+// 壊れたblockがあれば終了コード1で終わる. serverを止めてから実行する
+func main() {
+	dirName := getEnvOrDefault("BASE_DIR", filepath.Join(os.Getenv("PWD"), ".dbdata"))
+	blockSize := getEnvIntOrDefault("BLOCK_SIZE", 4000)
+
+	f, err := os.Open(dirName)
+	if err != nil {
+		slog.Error("failed to open dir", "dir", dirName, "error", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	fm, err := dbfile.NewFileManager(f, blockSize)
+	if err != nil {
+		slog.Error("failed to create file manager", "error", err)
+		os.Exit(1)
+	}
+	files, err := fm.Files()
+	if err != nil {
+		slog.Error("failed to list files", "error", err)
+		os.Exit(1)
+	}
+
+	numFiles, numCorrupt := 0, 0
+	for _, file := range files {
+		// blockに分かれていないファイル
+		if file == dbtx.BackupLabelFileName || strings.HasSuffix(file, ".tmp") {
+			continue
+		}
+		corrupt, err := fm.VerifyFile(file)
+		if err != nil {
+			slog.Error("failed to verify file", "file", file, "error", err)
+			os.Exit(1)
+		}
+		for _, c := range corrupt {
+			fmt.Println(c.Err)
+		}
+		numFiles++
+		numCorrupt += len(corrupt)
+	}
+	fmt.Printf("verified %d files: %d corrupt blocks\n", numFiles, numCorrupt)
+	if numCorrupt > 0 {
+		os.Exit(1)
+	}
+}
+
+func getEnvOrDefault(key, defaultVal string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+func getEnvIntOrDefault(key string, defaultVal int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("invalid env value", "key", key, "value", v, "error", err)
+		os.Exit(1)
+	}
+	return n
+}
@@ -1,6 +1,7 @@
 package dbconstant
 
 import (
+	"cmp"
 	"hash/fnv"
 	"strconv"
 	"strings"
@@ -30,12 +31,14 @@ func (c *IntConstant) String() string {
 	return strconv.Itoa(c.value)
 }
 
+// NULLはどの値よりも大きいとして並べる
 func (c *IntConstant) Compare(other Constant) int {
 	otherInt, ok := other.AsRaw().(int)
 	if !ok {
 		return -1
 	}
-	return c.value - otherInt
+	// 差を返すとB-treeの番兵のmath.MinIntとの比較で桁あふれする
+	return cmp.Compare(c.value, otherInt)
 }
 
 func (c *IntConstant) Equals(other Constant) bool {
@@ -65,6 +68,9 @@ func (c *StringConstant) String() string {
 }
 
 func (c *StringConstant) Compare(other Constant) int {
+	if IsNull(other) {
+		return -1
+	}
 	otherString, ok := other.AsRaw().(string)
 	if !ok {
 		return 1
@@ -81,3 +87,42 @@ func (c *StringConstant) HashCode() int {
 	h.Write([]byte(c.String()))
 	return int(h.Sum64())
 }
+
+// NullConstant represents SQL NULL.
+// Compare/Equalsは並べ替えやグループ化のためNULL同士を等しいとする.
+// 比較演算子の三値論理はdbqueryで扱う
+type NullConstant struct{}
+
+var null = &NullConstant{}
+
+func NewNullConstant() *NullConstant {
+	return null
+}
+
+func IsNull(c Constant) bool {
+	_, ok := c.(*NullConstant)
+	return ok
+}
+
+func (c *NullConstant) AsRaw() any {
+	return nil
+}
+
+func (c *NullConstant) String() string {
+	return "NULL"
+}
+
+func (c *NullConstant) Compare(other Constant) int {
+	if IsNull(other) {
+		return 0
+	}
+	return 1
+}
+
+func (c *NullConstant) Equals(other Constant) bool {
+	return IsNull(other)
+}
+
+func (c *NullConstant) HashCode() int {
+	return 0
+}
@@ -10,8 +10,18 @@ type Code string
 
 const (
 	CodeTransactionLockWaitAbort Code = "TRANSACTION_LOCK_WAIT_ABORT"
-	CodeBufferWaitAbort          Code = "BUFFER_WAIT_ABORT"
-	CodeSyntaxError              Code = "SYNTAX_ERROR"
+	// waits-forグラフに閉路が見つかり犠牲に選ばれた. すぐに再試行してよい
+	CodeTransactionDeadlockAbort Code = "TRANSACTION_DEADLOCK_ABORT"
+	// snapshot以降に他のtransactionがcommitしたblockに書き込もうとした
+	CodeTransactionWriteConflictAbort Code = "TRANSACTION_WRITE_CONFLICT_ABORT"
+	CodeBufferWaitAbort               Code = "BUFFER_WAIT_ABORT"
+	CodeSyntaxError                   Code = "SYNTAX_ERROR"
+	// 構文は正しいが, 存在しないfieldを参照するなどqueryの意味が正しくない
+	CodeSemanticError Code = "SEMANTIC_ERROR"
+	// blockのchecksumが内容と一致しない. 書き込みの途中でcrashしたか, ディスク上でデータが壊れた
+	CodeChecksumMismatch Code = "CHECKSUM_MISMATCH"
+	// databaseのファイルの形式がこのversionで読める形式と異なる
+	CodeUnsupportedFormat Code = "UNSUPPORTED_FORMAT"
 )
 
 type DBError struct {
@@ -35,6 +45,12 @@ func New(code Code, message string, err error) error {
 	return &DBError{Code: code, Message: message, Err: err}
 }
 
+// errがcodeのDBErrorを含むかどうか
+func IsCode(err error, code Code) bool {
+	var dbErr *DBError
+	return errors.As(err, &dbErr) && dbErr.Code == code
+}
+
 func HandleErrorLog(logger *slog.Logger, err error) {
 	var dbErr *DBError
 	if errors.As(err, &dbErr) {
@@ -0,0 +1,196 @@
+package dbexecutor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teru01/simpledb-go/dbparse"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// TxStatus is the transaction status reported in ReadyForQuery.
+type TxStatus byte
+
+const (
+	TxStatusIdle   TxStatus = 'I' // not in a transaction block
+	TxStatusInTx   TxStatus = 'T' // in a transaction block
+	TxStatusFailed TxStatus = 'E' // in a failed transaction block
+)
+
+// Session holds the transaction state of a single client connection.
+// Sessionはgoroutineセーフではない. 1接続につき1つ作成する
+type Session struct {
+	db    *SimpleDB
+	state sessionState
+}
+
+type sessionState struct {
+	// START TRANSACTIONで開始した明示的なtransaction
+	explicitTx *dbtx.Transaction
+	// 明示的なtransaction内でエラーが発生し、COMMIT/ROLLBACKを待っている
+	failed bool
+	// SET TRANSACTIONで指定された, 次に開始するtransactionのisolation level
+	nextIsolationLevel *dbtx.IsolationLevel
+}
+
+func (s *SimpleDB) NewSession() *Session {
+	return &Session{db: s}
+}
+
+func (s *Session) TxStatus() TxStatus {
+	switch {
+	case s.state.failed:
+		return TxStatusFailed
+	case s.state.explicitTx != nil:
+		return TxStatusInTx
+	default:
+		return TxStatusIdle
+	}
+}
+
+// 接続終了時に未完了のtransactionをrollbackする
+func (s *Session) Close(ctx context.Context) error {
+	s.state.failed = false
+	if s.state.explicitTx == nil {
+		return nil
+	}
+	tx := s.state.explicitTx
+	s.state.explicitTx = nil
+	if err := tx.Rollback(ctx); err != nil {
+		return fmt.Errorf("rollback transaction %d on session close: %w", tx.TxNum(), err)
+	}
+	return nil
+}
+
+func (s *Session) Execute(ctx context.Context, sql string) (*ExecuteResult, error) {
+	if matchStartTx(sql) {
+		if s.TxStatus() != TxStatusIdle {
+			return nil, fmt.Errorf("there is already a transaction in progress")
+		}
+		data, err := dbparse.NewParser(sql).StartTransaction()
+		if err != nil {
+			return nil, fmt.Errorf("parse start transaction: %w", err)
+		}
+		var level *dbtx.IsolationLevel
+		if l, ok := data.IsolationLevel(); ok {
+			level = &l
+		}
+		tx, err := s.newTx(level)
+		if err != nil {
+			return nil, fmt.Errorf("create transaction: %w", err)
+		}
+		s.state.explicitTx = tx
+		return &ExecuteResult{Tag: "START TRANSACTION"}, nil
+	} else if matchSetTransaction(sql) {
+		if s.TxStatus() != TxStatusIdle {
+			return nil, fmt.Errorf("transaction characteristics can't be changed while a transaction is in progress")
+		}
+		data, err := dbparse.NewParser(sql).SetTransaction()
+		if err != nil {
+			return nil, fmt.Errorf("parse set transaction: %w", err)
+		}
+		level := data.IsolationLevel()
+		s.state.nextIsolationLevel = &level
+		return &ExecuteResult{Tag: "SET"}, nil
+	} else if matchBackup(sql) {
+		if s.TxStatus() != TxStatusIdle {
+			return nil, fmt.Errorf("BACKUP cannot run inside a transaction block")
+		}
+		data, err := dbparse.NewParser(sql).Backup()
+		if err != nil {
+			return nil, fmt.Errorf("parse backup: %w", err)
+		}
+		if _, err := s.db.Backup(data.Dir()); err != nil {
+			return nil, fmt.Errorf("backup: %w", err)
+		}
+		return &ExecuteResult{Tag: "BACKUP"}, nil
+	} else if matchCommit(sql) {
+		if s.state.failed {
+			// 失敗したtransactionはrollback済み
+			s.state.failed = false
+			return &ExecuteResult{Tag: "ROLLBACK"}, nil
+		}
+		if s.state.explicitTx == nil {
+			return nil, fmt.Errorf("no transactions yet.")
+		}
+		tx := s.state.explicitTx
+		s.state.explicitTx = nil
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit: %w", err)
+		}
+		return &ExecuteResult{Tag: "COMMIT"}, nil
+	} else if matchRollback(sql) {
+		if s.state.failed {
+			s.state.failed = false
+			return &ExecuteResult{Tag: "ROLLBACK"}, nil
+		}
+		if s.state.explicitTx == nil {
+			return nil, fmt.Errorf("no transactions yet.")
+		}
+		tx := s.state.explicitTx
+		s.state.explicitTx = nil
+		if err := tx.Rollback(ctx); err != nil {
+			return nil, fmt.Errorf("rollback: %w", err)
+		}
+		return &ExecuteResult{Tag: "ROLLBACK"}, nil
+	}
+
+	if s.state.failed {
+		return nil, fmt.Errorf("current transaction is aborted, commands ignored until end of transaction block")
+	}
+
+	var (
+		tx  *dbtx.Transaction
+		err error
+	)
+	if s.state.explicitTx != nil {
+		tx = s.state.explicitTx
+	} else {
+		tx, err = s.newTx(nil)
+		if err != nil {
+			return nil, fmt.Errorf("create transaction: %w", err)
+		}
+	}
+
+	var result *ExecuteResult
+	if matchSelect(sql) {
+		result, err = s.db.execQuery(ctx, tx, sql)
+	} else if matchCheckTable(sql) {
+		result, err = s.db.checkTable(ctx, tx, sql)
+	} else {
+		var n int
+		n, err = s.db.planner.ExecuteUpdate(ctx, sql, tx)
+		if err == nil {
+			result = &ExecuteResult{Tag: updateTag(sql, n)}
+		}
+	}
+	if err != nil {
+		if s.state.explicitTx != nil {
+			s.state.explicitTx = nil
+			s.state.failed = true
+		}
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return nil, rbErr
+		}
+		return nil, err
+	}
+
+	if s.state.explicitTx != nil {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// levelがnilの場合はSET TRANSACTIONで指定されたisolation levelを使う
+// SET TRANSACTIONの指定は次のtransaction1つにのみ適用する
+func (s *Session) newTx(level *dbtx.IsolationLevel) (*dbtx.Transaction, error) {
+	if level == nil {
+		level = s.state.nextIsolationLevel
+	}
+	s.state.nextIsolationLevel = nil
+	var opts []dbtx.TxOption
+	if level != nil {
+		opts = append(opts, dbtx.WithIsolationLevel(*level))
+	}
+	return s.db.newTx(opts...)
+}
@@ -2,18 +2,21 @@ package dbexecutor
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
 	"strings"
 
 	"os"
 	"time"
 
 	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dblog"
 	"github.com/teru01/simpledb-go/dbmetadata"
+	"github.com/teru01/simpledb-go/dbparse"
 	"github.com/teru01/simpledb-go/dbplan"
 	"github.com/teru01/simpledb-go/dbraft"
 	"github.com/teru01/simpledb-go/dbrecord"
@@ -22,6 +25,32 @@ import (
 
 const followerInitRetryInterval = 200 * time.Millisecond
 
+const checkpointInterval = 30 * time.Second
+
+const (
+	defaultBackgroundWriterInterval   = 200 * time.Millisecond
+	defaultBackgroundWriterMaxBuffers = 100
+)
+
+type simpleDBConfig struct {
+	groupCommitDelay time.Duration
+	archiveDir       string
+	bufferPolicy     dbbuffer.ReplacementPolicy
+	writerInterval   time.Duration
+	writerMaxBuffers int
+	syncOnCommit     bool
+	storage          dbfile.Storage
+}
+
+type SimpleDBOption func(*simpleDBConfig)
+
+// group commitでflusherが他のcommitを待つ最大時間. デフォルトは0で, flush中に溜まったcommitだけをまとめる
+func WithGroupCommitDelay(d time.Duration) SimpleDBOption {
+	return func(c *simpleDBConfig) {
+		c.groupCommitDelay = d
+	}
+}
+
 type ExecuteResult struct {
 	// Tag is the command tag (e.g. "SELECT 3", "INSERT 0 1", "CREATE TABLE", "BEGIN", "COMMIT").
 	Tag string
@@ -29,36 +58,121 @@ type ExecuteResult struct {
 	Fields []string
 	// FieldTypes holds column types (dbrecord.FieldTypeInt or dbrecord.FieldTypeString) for SELECT results.
 	FieldTypes []int
-	// Rows holds the result rows as string values for SELECT results.
-	Rows [][]string
+	// Rows holds the result rows as string values for SELECT results. NULL values have Valid set to false.
+	Rows [][]sql.NullString
 }
 
 type SimpleDB struct {
+	// storageを指定した場合は空
+	dirName         string
 	fileManager     *dbfile.FileManager
 	logManager      *dblog.LogManager
 	bufferManager   *dbbuffer.BufferManager
 	metadataManager *dbmetadata.MetadataManager
 	planner         *dbplan.Planner
 	raftNode        *dbraft.RaftNode
-	explicitTx      *dbtx.Transaction
+	checkpointer    *dbtx.Checkpointer
+	writer          *dbbuffer.BackgroundWriter
+	// 0ならbackground writerを動かさない
+	writerInterval   time.Duration
+	writerMaxBuffers int
 }
 
-func NewSimpleDB(dirName string, blockSize, bufferSize int) (*SimpleDB, func(), error) {
-	f, err := os.Open(dirName)
-	if err != nil {
-		return nil, nil, fmt.Errorf("open %q: %w", dirName, err)
+// 書き終えたlogのsegmentをdirに写す. point-in-time recoveryで使う
+func WithArchiveDir(dir string) SimpleDBOption {
+	return func(c *simpleDBConfig) {
+		c.archiveDir = dir
+	}
+}
+
+// buffer poolの置き換え方針. デフォルトはLRU
+func WithBufferReplacementPolicy(policy dbbuffer.ReplacementPolicy) SimpleDBOption {
+	return func(c *simpleDBConfig) {
+		c.bufferPolicy = policy
+	}
+}
+
+// background writerがintervalごとに書き出す変更済みbufferを最大maxBuffers個にする. intervalが0なら動かさない
+// デフォルトは200msごとに100個
+func WithBackgroundWriter(interval time.Duration, maxBuffers int) SimpleDBOption {
+	return func(c *simpleDBConfig) {
+		c.writerInterval = interval
+		c.writerMaxBuffers = maxBuffers
+	}
+}
+
+// 書き込みごとにO_SYNCで待たず, commitやcheckpointでfsyncする
+func WithSyncOnCommit() SimpleDBOption {
+	return func(c *simpleDBConfig) {
+		c.syncOnCommit = true
+	}
+}
+
+// dirNameのディレクトリの代わりにstorageにファイルを置く. dbfile.NewMemoryStorageで一時的なdatabaseを作れる
+// 同じstorageでNewSimpleDBを作り直すと, 前のSimpleDBが書いたlogからrecoveryする
+// backup labelはディレクトリに置くので, storageを指定したdatabaseはbackupからの起動に使えない
+func WithStorage(storage dbfile.Storage) SimpleDBOption {
+	return func(c *simpleDBConfig) {
+		c.storage = storage
+	}
+}
+
+// WithStorageを指定した場合はdirNameを使わない
+func NewSimpleDB(dirName string, blockSize, bufferSize int, opts ...SimpleDBOption) (*SimpleDB, func(), error) {
+	cfg := simpleDBConfig{
+		writerInterval:   defaultBackgroundWriterInterval,
+		writerMaxBuffers: defaultBackgroundWriterMaxBuffers,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var fileOpts []dbfile.FileOption
+	if cfg.syncOnCommit {
+		fileOpts = append(fileOpts, dbfile.WithSyncOnCommit())
+	}
+	var (
+		fm  *dbfile.FileManager
+		f   *os.File
+		err error
+	)
+	if cfg.storage != nil {
+		dirName = ""
+		fm, err = dbfile.NewFileManagerWithStorage(cfg.storage, blockSize, fileOpts...)
+	} else {
+		f, err = os.Open(dirName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open %q: %w", dirName, err)
+		}
+		fm, err = dbfile.NewFileManager(f, blockSize, fileOpts...)
 	}
-	fm, err := dbfile.NewFileManager(f, blockSize)
 	if err != nil {
 		return nil, nil, fmt.Errorf("create file manager: %w", err)
 	}
-	lm, err := dblog.NewLogManager(fm, "log.log")
+	logOpts := []dblog.LogOption{dblog.WithGroupCommit(cfg.groupCommitDelay)}
+	if cfg.archiveDir != "" {
+		logOpts = append(logOpts, dblog.WithArchiveDir(cfg.archiveDir))
+	}
+	lm, err := dblog.NewLogManager(fm, "log.log", logOpts...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("create log manager: %w", err)
 	}
-	bm := dbbuffer.NewBufferManager(fm, lm, bufferSize)
-	return &SimpleDB{fileManager: fm, logManager: lm, bufferManager: bm}, func() {
-		f.Close()
+	var bufOpts []dbbuffer.BufferOption
+	if cfg.bufferPolicy != "" {
+		bufOpts = append(bufOpts, dbbuffer.WithReplacementPolicy(cfg.bufferPolicy))
+	}
+	bm := dbbuffer.NewBufferManager(fm, lm, bufferSize, bufOpts...)
+	db := &SimpleDB{dirName: dirName, fileManager: fm, logManager: lm, bufferManager: bm, writerInterval: cfg.writerInterval, writerMaxBuffers: cfg.writerMaxBuffers}
+	return db, func() {
+		if db.checkpointer != nil {
+			db.checkpointer.Stop()
+		}
+		if db.writer != nil {
+			db.writer.Stop()
+		}
+		lm.Close()
+		if f != nil {
+			f.Close()
+		}
 	}, nil
 }
 
@@ -81,8 +195,7 @@ func (s *SimpleDB) LeaderAddr() string {
 	return s.raftNode.LeaderID()
 }
 
-func (s *SimpleDB) newTx() (*dbtx.Transaction, error) {
-	var opts []dbtx.TxOption
+func (s *SimpleDB) newTx(opts ...dbtx.TxOption) (*dbtx.Transaction, error) {
 	if s.raftNode != nil {
 		opts = append(opts, dbtx.WithRaftNode(s.raftNode))
 	}
@@ -93,7 +206,73 @@ func (s *SimpleDB) newLocalTx() (*dbtx.Transaction, error) {
 	return dbtx.NewTransaction(s.fileManager, s.logManager, s.bufferManager)
 }
 
+// BACKUP TOで取ったbackupのディレクトリで起動した場合は, backup labelのcheckpointからrecoveryする
 func (s *SimpleDB) Init(ctx context.Context) error {
+	label, ok, err := s.readBackupLabel()
+	if err != nil {
+		return err
+	}
+	if ok {
+		return s.initFromBackup(ctx, func(tx *dbtx.Transaction) error {
+			slog.Info("recovering database from backup", "checkpointLSN", label.CheckpointLSN, "endLSN", label.EndLSN, "startTime", label.StartTime)
+			return tx.RecoverFromBackup(ctx, label.CheckpointLSN)
+		})
+	}
+	return s.init(ctx, func(tx *dbtx.Transaction) error {
+		slog.Info("recovering database")
+		return tx.Recover(ctx)
+	})
+}
+
+// base backupのディレクトリで起動し, archiveDirのlogをtargetまで反映してから初期化する(point-in-time recovery)
+func (s *SimpleDB) InitFromArchive(ctx context.Context, archiveDir string, target dbtx.RecoveryTarget) error {
+	label, ok, err := s.readBackupLabel()
+	if err != nil {
+		return err
+	}
+	checkpointLSN := label.CheckpointLSN
+	if !ok {
+		// 停止中にファイルを写したbase backup
+		checkpointLSN, err = dbtx.LastCheckpointLSN(s.logManager)
+		if err != nil {
+			return fmt.Errorf("find checkpoint of base backup: %w", err)
+		}
+	}
+	lsn, err := dbtx.RestoreArchivedLog(s.logManager, archiveDir, target)
+	if err != nil {
+		return fmt.Errorf("restore archived log: %w", err)
+	}
+	return s.initFromBackup(ctx, func(tx *dbtx.Transaction) error {
+		slog.Info("recovering database to target", "target", target.String(), "lsn", lsn, "checkpointLSN", checkpointLSN)
+		return tx.RecoverFromBackup(ctx, checkpointLSN)
+	})
+}
+
+// 復元を終えたらbackup labelを消す
+func (s *SimpleDB) initFromBackup(ctx context.Context, recover func(tx *dbtx.Transaction) error) error {
+	if err := s.init(ctx, recover); err != nil {
+		return err
+	}
+	if s.dirName == "" {
+		return nil
+	}
+	return dbtx.RemoveBackupLabel(s.dirName)
+}
+
+// storageを指定したdatabaseにはbackup labelがない
+func (s *SimpleDB) readBackupLabel() (dbtx.BackupLabel, bool, error) {
+	if s.dirName == "" {
+		return dbtx.BackupLabel{}, false, nil
+	}
+	return dbtx.ReadBackupLabel(s.dirName)
+}
+
+// 動いているdatabaseをdirに写す. dirで起動するとbackupを取った時点の状態に復元される
+func (s *SimpleDB) Backup(dir string) (dbtx.BackupLabel, error) {
+	return dbtx.Backup(s.fileManager, s.logManager, s.bufferManager, dir)
+}
+
+func (s *SimpleDB) init(ctx context.Context, recover func(tx *dbtx.Transaction) error) error {
 	tx, err := s.newTx()
 	if err != nil {
 		return fmt.Errorf("create transaction: %w", err)
@@ -102,8 +281,7 @@ func (s *SimpleDB) Init(ctx context.Context) error {
 	if isNew {
 		slog.Info("initializing new database")
 	} else {
-		slog.Info("recovering database")
-		if err := tx.Recover(ctx); err != nil {
+		if err := recover(tx); err != nil {
 			return fmt.Errorf("recover database: %w", err)
 		}
 	}
@@ -114,13 +292,20 @@ func (s *SimpleDB) Init(ctx context.Context) error {
 	}
 	s.metadataManager = m
 
-	qp := dbplan.NewQueryPlanner(s.metadataManager)
+	qp := s.newQueryPlanner()
 	up := dbplan.NewIndexUpdatePlanner(s.metadataManager)
 	s.planner = dbplan.NewPlanner(qp, up)
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit transaction: %w", err)
 	}
+
+	s.checkpointer = dbtx.NewCheckpointer(s.logManager, s.bufferManager, checkpointInterval)
+	s.checkpointer.Start()
+	if s.writerInterval > 0 && s.writerMaxBuffers > 0 {
+		s.writer = dbbuffer.NewBackgroundWriter(s.bufferManager, s.writerInterval, s.writerMaxBuffers)
+		s.writer.Start()
+	}
 	return nil
 }
 
@@ -155,7 +340,7 @@ func (s *SimpleDB) InitFollower(ctx context.Context) error {
 			continue
 		}
 		s.metadataManager = m
-		qp := dbplan.NewQueryPlanner(s.metadataManager)
+		qp := s.newQueryPlanner()
 		up := dbplan.NewIndexUpdatePlanner(s.metadataManager)
 		s.planner = dbplan.NewPlanner(qp, up)
 		if err := tx.Commit(); err != nil {
@@ -166,77 +351,8 @@ func (s *SimpleDB) InitFollower(ctx context.Context) error {
 	}
 }
 
-func (s *SimpleDB) Execute(ctx context.Context, sql string) (*ExecuteResult, error) {
-	if matchStartTx(sql) {
-		tx, err := s.newTx()
-		if err != nil {
-			return nil, fmt.Errorf("create transaction: %w", err)
-		}
-		s.explicitTx = tx
-		return &ExecuteResult{Tag: "START TRANSACTION"}, nil
-	} else if matchCommit(sql) {
-		if s.explicitTx == nil {
-			return nil, fmt.Errorf("no transactions yet.")
-		}
-		if err := s.explicitTx.Commit(); err != nil {
-			return nil, fmt.Errorf("commit: %w", err)
-		}
-		s.explicitTx = nil
-		return &ExecuteResult{Tag: "COMMIT"}, nil
-	} else if matchRollback(sql) {
-		if s.explicitTx == nil {
-			return nil, fmt.Errorf("no transactions yet.")
-		}
-		if err := s.explicitTx.Rollback(ctx); err != nil {
-			return nil, fmt.Errorf("rollback: %w", err)
-		}
-		s.explicitTx = nil
-		return &ExecuteResult{Tag: "ROLLBACK"}, nil
-	}
-
-	var (
-		tx  *dbtx.Transaction
-		err error
-	)
-	if s.explicitTx != nil {
-		tx = s.explicitTx
-	} else {
-		tx, err = s.newTx()
-		if err != nil {
-			return nil, fmt.Errorf("create transaction: %w", err)
-		}
-	}
-
-	var result *ExecuteResult
-	if matchSelect(sql) {
-		result, err = s.execQuery(ctx, tx, sql)
-		if err != nil {
-			s.explicitTx = nil
-			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				return nil, rbErr
-			}
-			return nil, err
-		}
-	} else {
-		n, err := s.planner.ExecuteUpdate(ctx, sql, tx)
-		if err != nil {
-			s.explicitTx = nil
-			if rbErr := tx.Rollback(ctx); rbErr != nil {
-				return nil, rbErr
-			}
-			return nil, err
-		}
-		result = &ExecuteResult{Tag: updateTag(sql, n)}
-	}
-
-	if s.explicitTx != nil {
-		return result, nil
-	}
-	return result, tx.Commit()
-}
-
-func (s *SimpleDB) execQuery(ctx context.Context, tx *dbtx.Transaction, sql string) (*ExecuteResult, error) {
-	plan, err := s.planner.CreateQueryPlan(ctx, sql, tx)
+func (s *SimpleDB) execQuery(ctx context.Context, tx *dbtx.Transaction, query string) (*ExecuteResult, error) {
+	plan, err := s.planner.CreateQueryPlan(ctx, query, tx)
 	if err != nil {
 		return nil, err
 	}
@@ -254,7 +370,7 @@ func (s *SimpleDB) execQuery(ctx context.Context, tx *dbtx.Transaction, sql stri
 		fieldTypes[i] = schema.FieldType(f)
 	}
 
-	var rows [][]string
+	var rows [][]sql.NullString
 	for {
 		ok, err := scan.Next(ctx)
 		if err != nil {
@@ -263,22 +379,17 @@ func (s *SimpleDB) execQuery(ctx context.Context, tx *dbtx.Transaction, sql stri
 		if !ok {
 			break
 		}
-		row := make([]string, 0, len(fields))
-		for i, f := range fields {
-			switch fieldTypes[i] {
-			case dbrecord.FieldTypeInt:
-				v, err := scan.GetInt(ctx, f)
-				if err != nil {
-					return nil, err
-				}
-				row = append(row, strconv.Itoa(v))
-			case dbrecord.FieldTypeString:
-				v, err := scan.GetString(ctx, f)
-				if err != nil {
-					return nil, err
-				}
-				row = append(row, v)
+		row := make([]sql.NullString, 0, len(fields))
+		for _, f := range fields {
+			v, err := scan.GetValue(ctx, f)
+			if err != nil {
+				return nil, err
+			}
+			if dbconstant.IsNull(v) {
+				row = append(row, sql.NullString{})
+				continue
 			}
+			row = append(row, sql.NullString{String: v.String(), Valid: true})
 		}
 		rows = append(rows, row)
 	}
@@ -291,6 +402,88 @@ func (s *SimpleDB) execQuery(ctx context.Context, tx *dbtx.Transaction, sql stri
 	}, nil
 }
 
+// CHECK TABLEの結果をMySQLと同じくindexごとのmsg_type, msg_textの行で返す
+func (s *SimpleDB) checkTable(ctx context.Context, tx *dbtx.Transaction, sqlStr string) (*ExecuteResult, error) {
+	data, err := dbparse.NewParser(sqlStr).CheckTable()
+	if err != nil {
+		return nil, err
+	}
+	checks, err := s.metadataManager.CheckTable(ctx, data.TableName(), data.Repair(), tx)
+	if err != nil {
+		return nil, err
+	}
+	row := func(index, msgType, msgText string) []sql.NullString {
+		return []sql.NullString{
+			{String: data.TableName(), Valid: true},
+			{String: index, Valid: index != ""},
+			{String: msgType, Valid: true},
+			{String: msgText, Valid: true},
+		}
+	}
+	var rows [][]sql.NullString
+	for _, c := range checks {
+		for _, p := range c.Problems {
+			rows = append(rows, row(c.IndexName, "error", p))
+		}
+		rows = append(rows, row(c.IndexName, "status", checkStatus(c)))
+	}
+	if len(checks) == 0 {
+		rows = append(rows, row("", "status", "OK"))
+	}
+	return &ExecuteResult{
+		Tag:        "CHECK TABLE",
+		Fields:     []string{"table", "index", "msg_type", "msg_text"},
+		FieldTypes: []int{dbrecord.FieldTypeString, dbrecord.FieldTypeString, dbrecord.FieldTypeString, dbrecord.FieldTypeString},
+		Rows:       rows,
+	}, nil
+}
+
+func checkStatus(c dbmetadata.IndexCheck) string {
+	switch {
+	case c.Repaired:
+		return "repaired"
+	case len(c.Problems) > 0:
+		return "corrupt"
+	default:
+		return "OK"
+	}
+}
+
+// 全てのtableのindexを検査する. 結果はtable名をキーにする
+func (s *SimpleDB) CheckTables(ctx context.Context, repair bool) (map[string][]dbmetadata.IndexCheck, error) {
+	tx, err := s.newTx()
+	if err != nil {
+		return nil, fmt.Errorf("create transaction: %w", err)
+	}
+	results, err := s.checkTables(ctx, repair, tx)
+	if err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return nil, errors.Join(err, rbErr)
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return results, nil
+}
+
+func (s *SimpleDB) checkTables(ctx context.Context, repair bool, tx *dbtx.Transaction) (map[string][]dbmetadata.IndexCheck, error) {
+	tables, err := s.metadataManager.TableNames(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	results := make(map[string][]dbmetadata.IndexCheck, len(tables))
+	for _, table := range tables {
+		checks, err := s.metadataManager.CheckTable(ctx, table, repair, tx)
+		if err != nil {
+			return nil, fmt.Errorf("check table %q: %w", table, err)
+		}
+		results[table] = checks
+	}
+	return results, nil
+}
+
 func updateTag(sql string, n int) string {
 	lower := strings.ToLower(sql)
 	switch {
@@ -306,6 +499,14 @@ func updateTag(sql string, n int) string {
 		return "CREATE VIEW"
 	case strings.HasPrefix(lower, "create index"):
 		return "CREATE INDEX"
+	case strings.HasPrefix(lower, "drop table"):
+		return "DROP TABLE"
+	case strings.HasPrefix(lower, "drop view"):
+		return "DROP VIEW"
+	case strings.HasPrefix(lower, "drop index"):
+		return "DROP INDEX"
+	case strings.HasPrefix(lower, "alter table"):
+		return "ALTER TABLE"
 	default:
 		return fmt.Sprintf("UPDATE %d", n)
 	}
@@ -319,6 +520,20 @@ func matchStartTx(sql string) bool {
 	return strings.HasPrefix(strings.ToLower(sql), "start transaction")
 }
 
+func matchSetTransaction(sql string) bool {
+	fields := strings.Fields(strings.ToLower(sql))
+	return len(fields) >= 2 && fields[0] == "set" && fields[1] == "transaction"
+}
+
+func matchBackup(sql string) bool {
+	return strings.HasPrefix(strings.ToLower(sql), "backup")
+}
+
+func matchCheckTable(sql string) bool {
+	fields := strings.Fields(strings.ToLower(sql))
+	return len(fields) >= 2 && fields[0] == "check" && fields[1] == "table"
+}
+
 func matchCommit(sql string) bool {
 	return strings.HasPrefix(strings.ToLower(sql), "commit")
 }
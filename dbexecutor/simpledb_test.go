@@ -2,48 +2,51 @@ package dbexecutor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dberr"
+	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbraft"
 	"github.com/teru01/simpledb-go/dbrecord"
 	"github.com/teru01/simpledb-go/dbtx"
 )
 
-func setupTestDB(t *testing.T) (*SimpleDB, context.Context, func()) {
+func setupTestDB(t *testing.T) (*Session, context.Context, func()) {
 	t.Helper()
-	dir, err := os.MkdirTemp("", "simpledb_integration_test")
+	db, cleanup, err := NewSimpleDB("", 4000, 100, WithStorage(dbfile.NewMemoryStorage()))
 	if err != nil {
-		t.Fatalf("failed to create temp dir: %v", err)
-	}
-
-	db, cleanup, err := NewSimpleDB(dir, 4000, 100)
-	if err != nil {
-		os.RemoveAll(dir)
 		t.Fatalf("failed to create simpledb: %v", err)
 	}
 
 	ctx := context.Background()
 	if err := db.Init(ctx); err != nil {
 		cleanup()
-		os.RemoveAll(dir)
 		t.Fatalf("failed to init simpledb: %v", err)
 	}
 
-	return db, ctx, func() {
+	session := db.NewSession()
+	return session, ctx, func() {
+		session.Close(ctx)
 		cleanup()
-		os.RemoveAll(dir)
 	}
 }
 
 // queryRows executes a SELECT and returns rows as [][]string.
-func queryRows(t *testing.T, db *SimpleDB, ctx context.Context, sql string) [][]string {
+func queryRows(t *testing.T, session *Session, ctx context.Context, sql string) [][]string {
 	t.Helper()
 
+	db := session.db
 	var tx *dbtx.Transaction
 	ownTx := false
-	if db.explicitTx != nil {
-		tx = db.explicitTx
+	if session.state.explicitTx != nil {
+		tx = session.state.explicitTx
 	} else {
 		var err error
 		tx, err = dbtx.NewTransaction(db.fileManager, db.logManager, db.bufferManager)
@@ -61,7 +64,6 @@ func queryRows(t *testing.T, db *SimpleDB, ctx context.Context, sql string) [][]
 	if err != nil {
 		t.Fatalf("failed to open plan: %v", err)
 	}
-	defer scan.Close(ctx)
 
 	schema := plan.Schema()
 	fields := schema.Fields()
@@ -77,23 +79,19 @@ func queryRows(t *testing.T, db *SimpleDB, ctx context.Context, sql string) [][]
 		}
 		row := make([]string, 0, len(fields))
 		for _, f := range fields {
-			switch schema.FieldType(f) {
-			case dbrecord.FieldTypeInt:
-				v, err := scan.GetInt(ctx, f)
-				if err != nil {
-					t.Fatalf("failed to get int %q: %v", f, err)
-				}
-				row = append(row, strconv.Itoa(v))
-			case dbrecord.FieldTypeString:
-				v, err := scan.GetString(ctx, f)
-				if err != nil {
-					t.Fatalf("failed to get string %q: %v", f, err)
-				}
-				row = append(row, v)
+			// NULLは"NULL"として比較する
+			v, err := scan.GetValue(ctx, f)
+			if err != nil {
+				t.Fatalf("failed to get value %q: %v", f, err)
 			}
+			row = append(row, v.String())
 		}
 		rows = append(rows, row)
 	}
+	// commitの前に閉じて, scanが使ったtemp tableを削除させる
+	if err := scan.Close(ctx); err != nil {
+		t.Fatalf("failed to close scan: %v", err)
+	}
 	if ownTx {
 		if err := tx.Commit(); err != nil {
 			t.Fatalf("failed to commit: %v", err)
@@ -102,9 +100,9 @@ func queryRows(t *testing.T, db *SimpleDB, ctx context.Context, sql string) [][]
 	return rows
 }
 
-func execUpdate(t *testing.T, db *SimpleDB, ctx context.Context, sql string) {
+func execUpdate(t *testing.T, session *Session, ctx context.Context, sql string) {
 	t.Helper()
-	if _, err := db.Execute(ctx, sql); err != nil {
+	if _, err := session.Execute(ctx, sql); err != nil {
 		t.Fatalf("failed to execute %q: %v", sql, err)
 	}
 }
@@ -158,16 +156,16 @@ func assertRows(t *testing.T, got [][]string, want [][]string) {
 }
 
 func TestInsertAndSelect(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
 	assertRows(t, rows, [][]string{
 		{"1", "sheep", "A"},
 		{"2", "goat", "B"},
@@ -177,16 +175,16 @@ func TestInsertAndSelect(t *testing.T) {
 }
 
 func TestSelectWithWhere(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "B"`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "B"`)
 	assertRows(t, rows, [][]string{
 		{"2", "goat", "B"},
 		{"3", "cow", "B"},
@@ -194,40 +192,201 @@ func TestSelectWithWhere(t *testing.T) {
 }
 
 func TestJoin(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
-	execUpdate(t, db, ctx, `CREATE TABLE results (student_id INT, score INT)`)
-	execUpdate(t, db, ctx, `INSERT INTO results (student_id, score) VALUES (1, 100)`)
-	execUpdate(t, db, ctx, `INSERT INTO results (student_id, score) VALUES (2, 70)`)
-	execUpdate(t, db, ctx, `INSERT INTO results (student_id, score) VALUES (3, 80)`)
+	execUpdate(t, session, ctx, `CREATE TABLE results (student_id INT, score INT)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, score) VALUES (1, 100)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, score) VALUES (2, 70)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, score) VALUES (3, 80)`)
 
-	rows := queryRows(t, db, ctx, `SELECT id, name, score FROM students, results WHERE id = student_id AND score > 70`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, score FROM students, results WHERE id = student_id AND score > 70`)
 	assertRows(t, rows, [][]string{
 		{"1", "sheep", "100"},
 		{"3", "cow", "80"},
 	})
 }
 
+func TestOrderBy(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+
+	rows := queryRows(t, session, ctx, `SELECT id, name FROM students ORDER BY class DESC, name`)
+	assertRows(t, rows, [][]string{
+		{"4", "cat"},
+		{"3", "cow"},
+		{"2", "goat"},
+		{"1", "sheep"},
+	})
+
+	execUpdate(t, session, ctx, `CREATE VIEW sorted AS SELECT id, name FROM students ORDER BY name`)
+	rows = queryRows(t, session, ctx, `SELECT id FROM sorted`)
+	assertRows(t, rows, [][]string{{"4"}, {"3"}, {"2"}, {"1"}})
+}
+
+func TestGroupBy(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE results (student_id INT, class VARCHAR(1), score INT)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (1, "A", 100)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (2, "B", 70)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (3, "B", 80)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (4, "C", 55)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (5, "B", 91)`)
+
+	rows := queryRows(t, session, ctx, `SELECT class, COUNT(*), SUM(score), MIN(score), MAX(score), AVG(score) FROM results GROUP BY class ORDER BY class`)
+	assertRows(t, rows, [][]string{
+		{"A", "1", "100", "100", "100", "100"},
+		{"B", "3", "241", "70", "91", "80"},
+		{"C", "1", "55", "55", "55", "55"},
+	})
+
+	rows = queryRows(t, session, ctx, `SELECT class, COUNT(student_id) FROM results WHERE score > 60 GROUP BY class ORDER BY COUNT(student_id) DESC, class`)
+	assertRows(t, rows, [][]string{
+		{"B", "3"},
+		{"A", "1"},
+	})
+
+	rows = queryRows(t, session, ctx, `SELECT COUNT(*), MAX(class) FROM results`)
+	assertRows(t, rows, [][]string{{"5", "C"}})
+
+	rows = queryRows(t, session, ctx, `SELECT COUNT(*) FROM results WHERE score > 100`)
+	assertRows(t, rows, [][]string{{"0"}})
+
+	for _, sql := range []string{
+		`SELECT student_id, COUNT(*) FROM results GROUP BY class`,
+		`SELECT SUM(class) FROM results`,
+		`SELECT COUNT(*) FROM results GROUP BY unknown`,
+	} {
+		if _, err := session.Execute(ctx, sql); err == nil {
+			t.Errorf("expected error for %q", sql)
+		}
+	}
+}
+
+func TestComparisonOperators(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE results (student_id INT, class VARCHAR(1), score INT)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (1, "A", 100)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (2, "B", 70)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (3, "B", 80)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (4, "C", 55)`)
+
+	tests := []struct {
+		where string
+		want  [][]string
+	}{
+		{`score <= 70`, [][]string{{"2"}, {"4"}}},
+		{`score >= 80`, [][]string{{"1"}, {"3"}}},
+		{`class <> "B"`, [][]string{{"1"}, {"4"}}},
+		{`class != "B"`, [][]string{{"1"}, {"4"}}},
+		{`class >= "B" AND score < 80`, [][]string{{"2"}, {"4"}}},
+	}
+	for _, tt := range tests {
+		rows := queryRows(t, session, ctx, `SELECT student_id FROM results WHERE `+tt.where+` ORDER BY student_id`)
+		assertRows(t, rows, tt.want)
+	}
+
+	// view定義は文字列化して保存されるので、演算子と文字列定数が保たれる必要がある
+	execUpdate(t, session, ctx, `CREATE VIEW passed AS SELECT student_id FROM results WHERE score >= 70 AND class <> "A"`)
+	rows := queryRows(t, session, ctx, `SELECT student_id FROM passed`)
+	assertRowsUnordered(t, rows, [][]string{{"2"}, {"3"}})
+}
+
+func TestNull(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE results (student_id INT, class VARCHAR(1), score INT)`)
+	execUpdate(t, session, ctx, `CREATE INDEX results_score ON results (score)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (1, "A", 100)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class) VALUES (2, "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (3, "B", 80)`)
+	execUpdate(t, session, ctx, `INSERT INTO results (student_id, class, score) VALUES (4, NULL, 55)`)
+
+	rows := queryRows(t, session, ctx, `SELECT student_id, class, score FROM results ORDER BY score`)
+	assertRows(t, rows, [][]string{
+		{"4", "NULL", "55"},
+		{"3", "B", "80"},
+		{"1", "A", "100"},
+		{"2", "B", "NULL"},
+	})
+
+	tests := []struct {
+		where string
+		want  [][]string
+	}{
+		{`score IS NULL`, [][]string{{"2"}}},
+		{`score IS NOT NULL`, [][]string{{"1"}, {"3"}, {"4"}}},
+		{`class IS NULL OR score IS NULL`, [][]string{{"2"}, {"4"}}},
+		// NULLとの比較はUNKNOWNなのでどの行も満たさない
+		{`score = NULL`, nil},
+		{`score <> 80`, [][]string{{"1"}, {"4"}}},
+		{`NOT score > 60`, [][]string{{"4"}}},
+		{`score > 60 OR class = "B"`, [][]string{{"1"}, {"2"}, {"3"}}},
+	}
+	for _, tt := range tests {
+		rows := queryRows(t, session, ctx, `SELECT student_id FROM results WHERE `+tt.where+` ORDER BY student_id`)
+		assertRows(t, rows, tt.want)
+	}
+
+	// 集約関数はNULLを無視する
+	rows = queryRows(t, session, ctx, `SELECT class, COUNT(*), COUNT(score), SUM(score) FROM results GROUP BY class ORDER BY class`)
+	assertRows(t, rows, [][]string{
+		{"A", "1", "1", "100"},
+		{"B", "2", "1", "80"},
+		{"NULL", "1", "1", "55"},
+	})
+	rows = queryRows(t, session, ctx, `SELECT MAX(score) FROM results WHERE score IS NULL`)
+	assertRows(t, rows, [][]string{{"NULL"}})
+
+	// NULLはindexに登録されないが、値を設定すると検索できる
+	execUpdate(t, session, ctx, `UPDATE results SET score = 70 WHERE student_id = 2`)
+	execUpdate(t, session, ctx, `UPDATE results SET score = NULL WHERE student_id = 3`)
+	rows = queryRows(t, session, ctx, `SELECT student_id FROM results WHERE score = 70`)
+	assertRows(t, rows, [][]string{{"2"}})
+	rows = queryRows(t, session, ctx, `SELECT student_id FROM results WHERE score = 80`)
+	assertRows(t, rows, nil)
+	rows = queryRows(t, session, ctx, `SELECT student_id FROM results WHERE score IS NULL`)
+	assertRows(t, rows, [][]string{{"3"}})
+
+	result, err := session.Execute(ctx, `SELECT student_id, class FROM results WHERE student_id = 4`)
+	if err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0][1].Valid {
+		t.Errorf("expected NULL class, got %v", result.Rows)
+	}
+}
+
 func TestUpdate(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
-	execUpdate(t, db, ctx, `UPDATE students SET class = "F" WHERE id = 4`)
+	execUpdate(t, session, ctx, `UPDATE students SET class = "F" WHERE id = 4`)
 
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
 	assertRows(t, rows, [][]string{
 		{"1", "sheep", "A"},
 		{"2", "goat", "B"},
@@ -237,18 +396,18 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
-	execUpdate(t, db, ctx, `DELETE FROM students WHERE class = "B"`)
+	execUpdate(t, session, ctx, `DELETE FROM students WHERE class = "B"`)
 
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
 	assertRows(t, rows, [][]string{
 		{"1", "sheep", "A"},
 		{"4", "cat", "C"},
@@ -256,30 +415,30 @@ func TestDelete(t *testing.T) {
 }
 
 func TestTransaction(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
 	// start transaction, insert, then rollback
-	execUpdate(t, db, ctx, `START TRANSACTION`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (5, "gorilla", "D")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (6, "monkey", "E")`)
+	execUpdate(t, session, ctx, `START TRANSACTION`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (5, "gorilla", "D")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (6, "monkey", "E")`)
 
 	// within transaction, should see 6 rows
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
 	if len(rows) != 6 {
 		t.Fatalf("expected 6 rows within transaction, got %d", len(rows))
 	}
 
-	execUpdate(t, db, ctx, `ROLLBACK`)
+	execUpdate(t, session, ctx, `ROLLBACK`)
 
 	// after rollback, should see only 4 rows
-	rows = queryRows(t, db, ctx, `SELECT id, name, class FROM students`)
+	rows = queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
 	assertRows(t, rows, [][]string{
 		{"1", "sheep", "A"},
 		{"2", "goat", "B"},
@@ -288,84 +447,944 @@ func TestTransaction(t *testing.T) {
 	})
 }
 
+func TestDrop(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	execUpdate(t, session, ctx, `CREATE INDEX students_id ON students (id)`)
+	execUpdate(t, session, ctx, `CREATE VIEW names AS SELECT name FROM students`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name) VALUES (1, "sheep")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name) VALUES (2, "goat")`)
+
+	// rollbackすればテーブルもindexも元に戻る
+	execUpdate(t, session, ctx, `START TRANSACTION`)
+	execUpdate(t, session, ctx, `DROP TABLE students`)
+	execUpdate(t, session, ctx, `ROLLBACK`)
+	rows := queryRows(t, session, ctx, `SELECT name FROM students WHERE id = 2`)
+	assertRows(t, rows, [][]string{{"goat"}})
+
+	execUpdate(t, session, ctx, `DROP VIEW names`)
+	if _, err := session.Execute(ctx, `SELECT name FROM names`); err == nil {
+		t.Errorf("expected error when selecting from dropped view")
+	}
+
+	execUpdate(t, session, ctx, `DROP INDEX students_id`)
+	rows = queryRows(t, session, ctx, `SELECT name FROM students WHERE id = 1`)
+	assertRows(t, rows, [][]string{{"sheep"}})
+
+	execUpdate(t, session, ctx, `CREATE INDEX students_name ON students (name)`)
+	result, err := session.Execute(ctx, `DROP TABLE students`)
+	if err != nil {
+		t.Fatalf("failed to drop table: %v", err)
+	}
+	if result.Tag != "DROP TABLE" {
+		t.Errorf("expected tag DROP TABLE, got %q", result.Tag)
+	}
+	if _, err := session.Execute(ctx, `SELECT name FROM students`); err == nil {
+		t.Errorf("expected error when selecting from dropped table")
+	}
+	tx, err := session.db.newTx()
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	indexes, err := session.db.metadataManager.GetIndexInfo(ctx, "students", tx)
+	if err != nil {
+		t.Fatalf("failed to get index info: %v", err)
+	}
+	if len(indexes) != 0 {
+		t.Errorf("expected indexes on dropped table to be dropped, got %v", indexes)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// 同じ名前で作り直しても以前のデータは見えない
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	rows = queryRows(t, session, ctx, `SELECT id, name FROM students`)
+	assertRows(t, rows, nil)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name) VALUES (3, "cow")`)
+	rows = queryRows(t, session, ctx, `SELECT id, name FROM students`)
+	assertRows(t, rows, [][]string{{"3", "cow"}})
+
+	for _, sql := range []string{`DROP TABLE unknown`, `DROP INDEX unknown`, `DROP VIEW unknown`, `DROP TABLE table_catalog`} {
+		if _, err := session.Execute(ctx, sql); err == nil {
+			t.Errorf("expected error for %q", sql)
+		}
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	execUpdate(t, session, ctx, `CREATE INDEX students_id ON students (id)`)
+	for i := range 30 {
+		execUpdate(t, session, ctx, fmt.Sprintf(`INSERT INTO students (id, name) VALUES (%d, "s%d")`, i, i))
+	}
+	execUpdate(t, session, ctx, `DELETE FROM students WHERE id < 10`)
+
+	// slot sizeが変わってもレコードとindexは保たれる
+	execUpdate(t, session, ctx, `ALTER TABLE students ADD COLUMN class VARCHAR(1) DEFAULT "A"`)
+	execUpdate(t, session, ctx, `ALTER TABLE students ADD score INT`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class, score FROM students WHERE id = 15`)
+	assertRows(t, rows, [][]string{{"15", "s15", "A", "NULL"}})
+	rows = queryRows(t, session, ctx, `SELECT COUNT(*) FROM students`)
+	assertRows(t, rows, [][]string{{"20"}})
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class, score) VALUES (100, "new", "B", 80)`)
+	rows = queryRows(t, session, ctx, `SELECT name, class, score FROM students WHERE id = 100`)
+	assertRows(t, rows, [][]string{{"new", "B", "80"}})
+
+	execUpdate(t, session, ctx, `ALTER TABLE students RENAME COLUMN name TO nickname`)
+	rows = queryRows(t, session, ctx, `SELECT nickname FROM students WHERE id = 12`)
+	assertRows(t, rows, [][]string{{"s12"}})
+	if _, err := session.Execute(ctx, `SELECT name FROM students`); err == nil {
+		t.Errorf("expected error when selecting renamed column")
+	}
+
+	// indexが張られたcolumnを削除するとindexも消える
+	execUpdate(t, session, ctx, `ALTER TABLE students RENAME id TO sid`)
+	execUpdate(t, session, ctx, `ALTER TABLE students DROP COLUMN class`)
+	rows = queryRows(t, session, ctx, `SELECT sid, nickname, score FROM students WHERE sid = 100`)
+	assertRows(t, rows, [][]string{{"100", "new", "80"}})
+	execUpdate(t, session, ctx, `ALTER TABLE students DROP sid`)
+	rows = queryRows(t, session, ctx, `SELECT nickname FROM students WHERE score = 80`)
+	assertRows(t, rows, [][]string{{"new"}})
+
+	// rollbackで元に戻る
+	execUpdate(t, session, ctx, `CREATE INDEX students_nickname ON students (nickname)`)
+	execUpdate(t, session, ctx, `START TRANSACTION`)
+	execUpdate(t, session, ctx, `ALTER TABLE students RENAME TO pupils`)
+	rows = queryRows(t, session, ctx, `SELECT score FROM pupils WHERE nickname = "new"`)
+	assertRows(t, rows, [][]string{{"80"}})
+	execUpdate(t, session, ctx, `ROLLBACK`)
+	if _, err := session.Execute(ctx, `SELECT nickname FROM pupils`); err == nil {
+		t.Errorf("expected error when selecting from rolled back table name")
+	}
+	rows = queryRows(t, session, ctx, `SELECT score FROM students WHERE nickname = "new"`)
+	assertRows(t, rows, [][]string{{"80"}})
+
+	execUpdate(t, session, ctx, `ALTER TABLE students RENAME TO pupils`)
+	rows = queryRows(t, session, ctx, `SELECT score FROM pupils WHERE nickname = "new"`)
+	assertRows(t, rows, [][]string{{"80"}})
+	rows = queryRows(t, session, ctx, `SELECT COUNT(nickname) FROM pupils`)
+	assertRows(t, rows, [][]string{{"21"}})
+
+	for _, sql := range []string{
+		`ALTER TABLE pupils ADD score INT`,
+		`ALTER TABLE pupils ADD grade INT DEFAULT "x"`,
+		`ALTER TABLE pupils DROP COLUMN unknown`,
+		`ALTER TABLE pupils RENAME COLUMN nickname TO score`,
+		`ALTER TABLE unknown ADD a INT`,
+		`ALTER TABLE table_catalog ADD a INT`,
+	} {
+		if _, err := session.Execute(ctx, sql); err == nil {
+			t.Errorf("expected error for %q", sql)
+		}
+	}
+}
+
 func TestCreateIndexAndSelect(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `CREATE INDEX idx_class ON students (class)`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `CREATE INDEX idx_class ON students (class)`)
 
 	// insert after index creation so index entries are created
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
 	// select using the indexed field
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "B"`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "B"`)
 	assertRowsUnordered(t, rows, [][]string{
 		{"2", "goat", "B"},
 		{"3", "cow", "B"},
 	})
 
 	// select with a different value
-	rows = queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "A"`)
+	rows = queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "A"`)
 	assertRowsUnordered(t, rows, [][]string{
 		{"1", "sheep", "A"},
 	})
 
 	// select with no match
-	rows = queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "Z"`)
+	rows = queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "Z"`)
 	assertRows(t, rows, [][]string{})
 }
 
+func TestSelectWithOrNot(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `CREATE INDEX idx_class ON students (class)`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+
+	// ORを跨いでindexを使うとclass = "B"以外の行が落ちる
+	rows := queryRows(t, session, ctx, `SELECT id FROM students WHERE class = "B" OR id = 4`)
+	assertRowsUnordered(t, rows, [][]string{{"2"}, {"3"}, {"4"}})
+
+	rows = queryRows(t, session, ctx, `SELECT id FROM students WHERE class = "B" AND NOT (id = 2 OR name = "sheep")`)
+	assertRows(t, rows, [][]string{{"3"}})
+
+	rows = queryRows(t, session, ctx, `SELECT id FROM students WHERE NOT class = "B" ORDER BY id DESC`)
+	assertRows(t, rows, [][]string{{"4"}, {"1"}})
+
+	execUpdate(t, session, ctx, `DELETE FROM students WHERE id = 1 OR class = "C"`)
+	rows = queryRows(t, session, ctx, `SELECT id FROM students`)
+	assertRowsUnordered(t, rows, [][]string{{"2"}, {"3"}})
+}
+
 func TestCreateIndexOnExistingData(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
 
 	// insert data before creating index
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (4, "cat", "C")`)
 
 	// create index on existing data
-	execUpdate(t, db, ctx, `CREATE INDEX idx_class ON students (class)`)
+	execUpdate(t, session, ctx, `CREATE INDEX idx_class ON students (class)`)
 
 	// existing data should be searchable via index
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "B"`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "B"`)
 	assertRowsUnordered(t, rows, [][]string{
 		{"2", "goat", "B"},
 		{"3", "cow", "B"},
 	})
 
-	rows = queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "A"`)
+	rows = queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "A"`)
 	assertRowsUnordered(t, rows, [][]string{
 		{"1", "sheep", "A"},
 	})
 
-	rows = queryRows(t, db, ctx, `SELECT id, name, class FROM students WHERE class = "Z"`)
+	rows = queryRows(t, session, ctx, `SELECT id, name, class FROM students WHERE class = "Z"`)
 	assertRows(t, rows, [][]string{})
 }
 
 func TestCreateIndexAndSelectAll(t *testing.T) {
-	db, ctx, cleanup := setupTestDB(t)
+	session, ctx, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	execUpdate(t, db, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
-	execUpdate(t, db, ctx, `CREATE INDEX idx_class ON students (class)`)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `CREATE INDEX idx_class ON students (class)`)
 
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
-	execUpdate(t, db, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (3, "cow", "B")`)
 
 	// select all (no WHERE on indexed field) should still work via full scan
-	rows := queryRows(t, db, ctx, `SELECT id, name, class FROM students`)
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
 	assertRowsUnordered(t, rows, [][]string{
 		{"1", "sheep", "A"},
 		{"2", "goat", "B"},
 		{"3", "cow", "B"},
 	})
 }
+
+func TestSessionIsolation(t *testing.T) {
+	session1, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+	session2 := session1.db.NewSession()
+	defer session2.Close(ctx)
+
+	execUpdate(t, session1, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	execUpdate(t, session1, ctx, `CREATE TABLE results (student_id INT, score INT)`)
+	execUpdate(t, session2, ctx, `INSERT INTO results (student_id, score) VALUES (1, 100)`)
+
+	execUpdate(t, session1, ctx, `START TRANSACTION`)
+	execUpdate(t, session1, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+
+	// session2 runs in its own autocommit transaction, not in session1's transaction
+	if got := session2.TxStatus(); got != TxStatusIdle {
+		t.Fatalf("expected session2 status %q, got %q", TxStatusIdle, got)
+	}
+	execUpdate(t, session2, ctx, `INSERT INTO results (student_id, score) VALUES (2, 70)`)
+
+	execUpdate(t, session1, ctx, `ROLLBACK`)
+
+	rows := queryRows(t, session1, ctx, `SELECT id, name, class FROM students`)
+	assertRows(t, rows, [][]string{})
+	rows = queryRows(t, session2, ctx, `SELECT student_id, score FROM results`)
+	assertRows(t, rows, [][]string{
+		{"1", "100"},
+		{"2", "70"},
+	})
+}
+
+func TestSessionTxStatus(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10), class VARCHAR(1))`)
+	if got := session.TxStatus(); got != TxStatusIdle {
+		t.Fatalf("expected status %q, got %q", TxStatusIdle, got)
+	}
+
+	execUpdate(t, session, ctx, `START TRANSACTION`)
+	if got := session.TxStatus(); got != TxStatusInTx {
+		t.Fatalf("expected status %q after START TRANSACTION, got %q", TxStatusInTx, got)
+	}
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name, class) VALUES (1, "sheep", "A")`)
+
+	// an error inside the transaction block aborts the transaction
+	if _, err := session.Execute(ctx, `INSERT INTO unknown_table (id) VALUES (1)`); err == nil {
+		t.Fatalf("expected error for unknown table")
+	}
+	if got := session.TxStatus(); got != TxStatusFailed {
+		t.Fatalf("expected status %q after error, got %q", TxStatusFailed, got)
+	}
+	if _, err := session.Execute(ctx, `INSERT INTO students (id, name, class) VALUES (2, "goat", "B")`); err == nil {
+		t.Fatalf("expected error while transaction is aborted")
+	}
+
+	result, err := session.Execute(ctx, `COMMIT`)
+	if err != nil {
+		t.Fatalf("failed to end aborted transaction: %v", err)
+	}
+	if result.Tag != "ROLLBACK" {
+		t.Errorf("expected tag ROLLBACK for aborted transaction, got %q", result.Tag)
+	}
+	if got := session.TxStatus(); got != TxStatusIdle {
+		t.Fatalf("expected status %q after COMMIT, got %q", TxStatusIdle, got)
+	}
+
+	rows := queryRows(t, session, ctx, `SELECT id, name, class FROM students`)
+	assertRows(t, rows, [][]string{})
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	session1, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+	session2 := session1.db.NewSession()
+	defer session2.Close(ctx)
+	session3 := session1.db.NewSession()
+	defer session3.Close(ctx)
+
+	execUpdate(t, session1, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	execUpdate(t, session1, ctx, `INSERT INTO students (id, name) VALUES (1, "sheep")`)
+
+	execUpdate(t, session1, ctx, `START TRANSACTION WITH CONSISTENT SNAPSHOT`)
+	assertRows(t, queryRows(t, session1, ctx, `SELECT id, name FROM students`), [][]string{{"1", "sheep"}})
+
+	// committed changes after the snapshot are not visible
+	execUpdate(t, session2, ctx, `UPDATE students SET name = "goat" WHERE id = 1`)
+	execUpdate(t, session2, ctx, `INSERT INTO students (id, name) VALUES (2, "cow")`)
+	assertRows(t, queryRows(t, session1, ctx, `SELECT id, name FROM students`), [][]string{{"1", "sheep"}})
+
+	// readers are not blocked by an uncommitted 2PL writer
+	execUpdate(t, session3, ctx, `START TRANSACTION`)
+	execUpdate(t, session3, ctx, `DELETE FROM students WHERE id = 2`)
+	execUpdate(t, session2, ctx, `START TRANSACTION WITH CONSISTENT SNAPSHOT`)
+	assertRowsUnordered(t, queryRows(t, session2, ctx, `SELECT id, name FROM students`), [][]string{{"1", "goat"}, {"2", "cow"}})
+	execUpdate(t, session3, ctx, `ROLLBACK`)
+	execUpdate(t, session2, ctx, `COMMIT`)
+
+	// first-committer-wins: session1 cannot update rows changed after its snapshot
+	_, err := session1.Execute(ctx, `UPDATE students SET name = "pig" WHERE id = 1`)
+	var dbErr *dberr.DBError
+	if !errors.As(err, &dbErr) || dbErr.Code != dberr.CodeTransactionWriteConflictAbort {
+		t.Fatalf("expected write conflict, got %v", err)
+	}
+	execUpdate(t, session1, ctx, `ROLLBACK`)
+
+	assertRowsUnordered(t, queryRows(t, session1, ctx, `SELECT id, name FROM students`), [][]string{{"1", "goat"}, {"2", "cow"}})
+}
+
+func TestIsolationLevelAnomalies(t *testing.T) {
+	// tryQuery gives up quickly when the statement has to wait for a lock
+	tryQuery := func(session *Session, ctx context.Context, sql string) ([][]string, error) {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		result, err := session.Execute(ctx, sql)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([][]string, 0, len(result.Rows))
+		for _, row := range result.Rows {
+			var values []string
+			for _, v := range row {
+				values = append(values, v.String)
+			}
+			rows = append(rows, values)
+		}
+		return rows, nil
+	}
+	mustQuery := func(t *testing.T, session *Session, ctx context.Context, sql string) [][]string {
+		t.Helper()
+		rows, err := tryQuery(session, ctx, sql)
+		if err != nil {
+			t.Fatalf("failed to execute %q: %v", sql, err)
+		}
+		return rows
+	}
+
+	anomalies := []struct {
+		name string
+		// true if the anomaly was observed
+		run func(t *testing.T, ctx context.Context, reader, writer *Session) bool
+		// isolation levels which allow the anomaly
+		allowedIn []string
+	}{
+		{
+			name: "dirty read",
+			run: func(t *testing.T, ctx context.Context, reader, writer *Session) bool {
+				execUpdate(t, writer, ctx, `START TRANSACTION`)
+				execUpdate(t, writer, ctx, `UPDATE animals SET name = "goat" WHERE id = 1`)
+				rows, err := tryQuery(reader, ctx, `SELECT name FROM animals WHERE id = 1`)
+				execUpdate(t, writer, ctx, `ROLLBACK`)
+				return err == nil && rows[0][0] == "goat"
+			},
+			allowedIn: []string{"READ UNCOMMITTED"},
+		},
+		{
+			name: "non-repeatable read",
+			run: func(t *testing.T, ctx context.Context, reader, writer *Session) bool {
+				first := mustQuery(t, reader, ctx, `SELECT name FROM animals WHERE id = 1`)
+				_, _ = tryQuery(writer, ctx, `UPDATE animals SET name = "goat" WHERE id = 1`)
+				second := mustQuery(t, reader, ctx, `SELECT name FROM animals WHERE id = 1`)
+				return first[0][0] != second[0][0]
+			},
+			allowedIn: []string{"READ UNCOMMITTED", "READ COMMITTED"},
+		},
+		{
+			name: "phantom",
+			run: func(t *testing.T, ctx context.Context, reader, writer *Session) bool {
+				// the first block is full, so the new row is appended to a new block
+				first := mustQuery(t, reader, ctx, `SELECT id FROM animals`)
+				_, _ = tryQuery(writer, ctx, `INSERT INTO animals (id, name) VALUES (3, "cat")`)
+				second := mustQuery(t, reader, ctx, `SELECT id FROM animals`)
+				return len(first) != len(second)
+			},
+			allowedIn: []string{"READ UNCOMMITTED", "READ COMMITTED", "REPEATABLE READ"},
+		},
+	}
+	levels := []string{"READ UNCOMMITTED", "READ COMMITTED", "REPEATABLE READ", "SERIALIZABLE", "SNAPSHOT"}
+
+	for _, anomaly := range anomalies {
+		for _, level := range levels {
+			t.Run(anomaly.name+"/"+level, func(t *testing.T) {
+				reader, ctx, cleanup := setupTestDB(t)
+				defer cleanup()
+				writer := reader.db.NewSession()
+				defer writer.Close(ctx)
+
+				// two records fill a block
+				execUpdate(t, writer, ctx, `CREATE TABLE animals (id INT, name VARCHAR(400))`)
+				execUpdate(t, writer, ctx, `INSERT INTO animals (id, name) VALUES (1, "sheep")`)
+				execUpdate(t, writer, ctx, `INSERT INTO animals (id, name) VALUES (2, "cow")`)
+
+				execUpdate(t, reader, ctx, `SET TRANSACTION ISOLATION LEVEL `+level)
+				execUpdate(t, reader, ctx, `START TRANSACTION`)
+				if got := reader.state.explicitTx.IsolationLevel().String(); got != level {
+					t.Fatalf("expected isolation level %s, got %s", level, got)
+				}
+				got := anomaly.run(t, ctx, reader, writer)
+				execUpdate(t, reader, ctx, `ROLLBACK`)
+
+				if want := slices.Contains(anomaly.allowedIn, level); got != want {
+					t.Errorf("%s under %s: expected anomaly=%v, got %v", anomaly.name, level, want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+	inFlight := session.db.NewSession()
+	defer inFlight.Close(ctx)
+	writer := session.db.NewSession()
+	defer writer.Close(ctx)
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	execUpdate(t, session, ctx, `CREATE INDEX students_id ON students (id)`)
+	execUpdate(t, session, ctx, `CREATE TABLE events (seq INT)`)
+	// 一時ファイルと似た名前のtableも写す
+	execUpdate(t, session, ctx, `CREATE TABLE temperatures (city VARCHAR(10), celsius INT)`)
+	execUpdate(t, session, ctx, `INSERT INTO temperatures (city, celsius) VALUES ("tokyo", 21)`)
+	for i := range 300 {
+		execUpdate(t, session, ctx, fmt.Sprintf(`INSERT INTO students (id, name) VALUES (%d, "s%d")`, i, i))
+	}
+	// backupの時点で完了していないtransactionは復元されない
+	execUpdate(t, inFlight, ctx, `START TRANSACTION`)
+	execUpdate(t, inFlight, ctx, `INSERT INTO students (id, name) VALUES (1000, "ghost")`)
+
+	// backup中も書き込みを続ける
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		for seq := 0; ; seq++ {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			if _, err := writer.Execute(ctx, fmt.Sprintf(`INSERT INTO events (seq) VALUES (%d)`, seq)); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	backupDir := t.TempDir() + "/backup"
+	execUpdate(t, session, ctx, fmt.Sprintf(`BACKUP TO "%s"`, backupDir))
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("failed to write during backup: %v", err)
+	}
+	if _, err := session.Execute(ctx, fmt.Sprintf(`BACKUP TO "%s"`, backupDir)); err == nil {
+		t.Errorf("expected error for backup to a non-empty dir")
+	}
+	execUpdate(t, inFlight, ctx, `ROLLBACK`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name) VALUES (2000, "late")`)
+
+	db, cleanupBackup, err := NewSimpleDB(backupDir, 4000, 100)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer cleanupBackup()
+	if err := db.Init(ctx); err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+	if _, err := os.Stat(backupDir + "/" + dbtx.BackupLabelFileName); !os.IsNotExist(err) {
+		t.Errorf("expected backup label to be removed after restore, got %v", err)
+	}
+	restored := db.NewSession()
+	defer restored.Close(ctx)
+
+	if rows := queryRows(t, restored, ctx, `SELECT id FROM students`); len(rows) != 300 {
+		t.Errorf("expected 300 students, got %d", len(rows))
+	}
+	assertRows(t, queryRows(t, restored, ctx, `SELECT name FROM students WHERE id = 299`), [][]string{{"s299"}})
+	assertRows(t, queryRows(t, restored, ctx, `SELECT name FROM students WHERE id = 1000`), [][]string{})
+	assertRows(t, queryRows(t, restored, ctx, `SELECT city, celsius FROM temperatures`), [][]string{{"tokyo", "21"}})
+	// 書き込みは順にcommitしたので, 復元されるのは最初から連続した範囲
+	events := queryRows(t, restored, ctx, `SELECT seq FROM events`)
+	seen := make(map[string]bool)
+	for _, row := range events {
+		seen[row[0]] = true
+	}
+	for i := range len(events) {
+		if !seen[fmt.Sprint(i)] {
+			t.Fatalf("expected events 0..%d to be restored, missing %d", len(events)-1, i)
+		}
+	}
+}
+
+func TestCheckTable(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+	db := session.db
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, class VARCHAR(1))`)
+	execUpdate(t, session, ctx, `CREATE INDEX idx_id ON students (id)`)
+	execUpdate(t, session, ctx, `CREATE INDEX idx_class ON students (class)`)
+	// leafの分割とoverflowが起きる数を入れる
+	for i := range 600 {
+		execUpdate(t, session, ctx, fmt.Sprintf(`INSERT INTO students (id, class) VALUES (%d, "%c")`, i, 'A'+i%2))
+	}
+	execUpdate(t, session, ctx, `INSERT INTO students (id) VALUES (1000)`)
+
+	checkTable := func(sql string) [][]string {
+		t.Helper()
+		result, err := session.Execute(ctx, sql)
+		if err != nil {
+			t.Fatalf("failed to execute %q: %v", sql, err)
+		}
+		var rows [][]string
+		for _, r := range result.Rows {
+			row := make([]string, len(r))
+			for i, v := range r {
+				row[i] = v.String
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+	healthy := [][]string{
+		{"students", "idx_class", "status", "OK"},
+		{"students", "idx_id", "status", "OK"},
+	}
+	assertRows(t, checkTable(`CHECK TABLE students`), healthy)
+
+	// id=5のentryを消し, 存在しないレコードを指すentryを入れる
+	tx, err := db.newTx()
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	infos, err := db.metadataManager.GetIndexInfo(ctx, "students", tx)
+	if err != nil {
+		t.Fatalf("failed to get index info: %v", err)
+	}
+	idx, err := infos["id"].Open(ctx)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	if err := idx.BeforeFirst(ctx, dbconstant.NewIntConstant(5)); err != nil {
+		t.Fatalf("failed to search index: %v", err)
+	}
+	if ok, err := idx.Next(ctx); err != nil || !ok {
+		t.Fatalf("expected entry for id=5: %v", err)
+	}
+	rid, err := idx.GetDataRID(ctx)
+	if err != nil {
+		t.Fatalf("failed to get rid: %v", err)
+	}
+	if err := idx.Delete(ctx, dbconstant.NewIntConstant(5), *rid); err != nil {
+		t.Fatalf("failed to delete entry: %v", err)
+	}
+	if err := idx.Insert(ctx, dbconstant.NewIntConstant(9999), *dbrecord.NewRID(0, 999)); err != nil {
+		t.Fatalf("failed to insert entry: %v", err)
+	}
+	if err := idx.Close(ctx); err != nil {
+		t.Fatalf("failed to close index: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	assertRows(t, checkTable(`CHECK TABLE students`), [][]string{
+		{"students", "idx_class", "status", "OK"},
+		{"students", "idx_id", "error", "entry 9999 points to [block 0, slot 999], which is not a live record"},
+		{"students", "idx_id", "error", fmt.Sprintf("record %s with value 5 has no entry", rid)},
+		{"students", "idx_id", "status", "corrupt"},
+	})
+	results, err := db.CheckTables(ctx, false)
+	if err != nil {
+		t.Fatalf("failed to check tables: %v", err)
+	}
+	if got := len(results["students"][1].Problems); got != 2 {
+		t.Errorf("expected 2 problems in idx_id, got %d", got)
+	}
+
+	rows := checkTable(`CHECK TABLE students REPAIR`)
+	if last := rows[len(rows)-1]; last[3] != "repaired" {
+		t.Errorf("expected idx_id to be repaired, got %v", rows)
+	}
+	assertRows(t, checkTable(`CHECK TABLE students`), healthy)
+	assertRows(t, queryRows(t, session, ctx, `SELECT id, class FROM students WHERE id = 5`), [][]string{{"5", "B"}})
+	assertRows(t, queryRows(t, session, ctx, `SELECT id FROM students WHERE id = 9999`), [][]string{})
+}
+
+func TestBufferSystemTables(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	execUpdate(t, session, ctx, `INSERT INTO students (id, name) VALUES (1, "sheep")`)
+	queryRows(t, session, ctx, `SELECT id, name FROM students`)
+
+	rows := queryRows(t, session, ctx, `SELECT filename, blocknum, pins FROM sys_buffercache WHERE filename = "students.tbl"`)
+	assertRows(t, rows, [][]string{{"students.tbl", "0", "0"}})
+
+	// 書き出していない変更があれば変更したtransactionが見える
+	rows = queryRows(t, session, ctx, `SELECT dirty FROM sys_buffercache WHERE filename = "students.tbl" AND txnum IS NOT NULL`)
+	for _, row := range rows {
+		if row[0] != "1" {
+			t.Errorf("expected buffer with txnum to be dirty, got %v", row)
+		}
+	}
+
+	rows = queryRows(t, session, ctx, `SELECT filename, hits, misses FROM sys_bufferstats WHERE filename = "students.tbl"`)
+	if len(rows) != 1 {
+		t.Fatalf("expected stats for students.tbl, got %v", rows)
+	}
+	if rows[0][1] == "0" {
+		t.Errorf("expected hits on students.tbl, got %v", rows[0])
+	}
+
+	// ORDER BYでtemp tableに書き出せる
+	rows = queryRows(t, session, ctx, `SELECT filename FROM sys_bufferstats ORDER BY filename`)
+	if !slices.ContainsFunc(rows, func(row []string) bool { return row[0] == "students.tbl" }) {
+		t.Errorf("expected students.tbl in sorted stats, got %v", rows)
+	}
+
+	// system tableと重なりうる名前のtableやviewは作れない
+	for _, sql := range []string{
+		`CREATE TABLE sys_buffercache (id INT)`,
+		`CREATE TABLE sys_other (id INT)`,
+		`CREATE VIEW sys_students AS SELECT id FROM students`,
+		`ALTER TABLE students RENAME TO sys_students`,
+	} {
+		if _, err := session.Execute(ctx, sql); err == nil {
+			t.Errorf("expected %q to fail", sql)
+		}
+	}
+	assertRows(t, queryRows(t, session, ctx, `SELECT id, name FROM students`), [][]string{{"1", "sheep"}})
+}
+
+func TestMemoryStorageRecovery(t *testing.T) {
+	ctx := context.Background()
+	storage := dbfile.NewMemoryStorage()
+	// commitした変更をbufferに残したままcrashさせる
+	db, cleanup, err := NewSimpleDB("", 4000, 100, WithStorage(storage), WithBackgroundWriter(0, 0))
+	if err != nil {
+		t.Fatalf("failed to create simpledb: %v", err)
+	}
+	if err := db.Init(ctx); err != nil {
+		cleanup()
+		t.Fatalf("failed to init simpledb: %v", err)
+	}
+	session := db.NewSession()
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	for i := range 50 {
+		execUpdate(t, session, ctx, fmt.Sprintf(`INSERT INTO students (id, name) VALUES (%d, "s%d")`, i, i))
+	}
+	if n := db.BufferManager().DirtyCount(); n == 0 {
+		t.Fatalf("expected committed changes to remain in buffers")
+	}
+	cleanup()
+
+	files, err := storage.Files()
+	if err != nil || len(files) == 0 {
+		t.Fatalf("expected files to remain in storage, got %v (err=%v)", files, err)
+	}
+	recovered, cleanupRecovered, err := NewSimpleDB("", 4000, 100, WithStorage(storage))
+	if err != nil {
+		t.Fatalf("failed to reopen simpledb: %v", err)
+	}
+	defer cleanupRecovered()
+	if err := recovered.Init(ctx); err != nil {
+		t.Fatalf("failed to recover simpledb: %v", err)
+	}
+	s := recovered.NewSession()
+	defer s.Close(ctx)
+	if rows := queryRows(t, s, ctx, `SELECT id FROM students`); len(rows) != 50 {
+		t.Errorf("expected 50 students, got %d", len(rows))
+	}
+	assertRows(t, queryRows(t, s, ctx, `SELECT name FROM students WHERE id = 49`), [][]string{{"s49"}})
+}
+
+// 同じプロセスで開いた複数のdatabaseは, lockやtransactionの状態を共有しない
+func TestMemoryStorageMultipleInstances(t *testing.T) {
+	ctx := context.Background()
+	open := func(storage dbfile.Storage) (*SimpleDB, func()) {
+		t.Helper()
+		db, cleanup, err := NewSimpleDB("", 4000, 100, WithStorage(storage))
+		if err != nil {
+			t.Fatalf("failed to create simpledb: %v", err)
+		}
+		if err := db.Init(ctx); err != nil {
+			cleanup()
+			t.Fatalf("failed to init simpledb: %v", err)
+		}
+		return db, cleanup
+	}
+	storageA := dbfile.NewMemoryStorage()
+	dbA, cleanupA := open(storageA)
+	dbB, cleanupB := open(dbfile.NewMemoryStorage())
+	defer cleanupB()
+	sessionA, sessionB := dbA.NewSession(), dbB.NewSession()
+	defer sessionB.Close(ctx)
+	for _, s := range []*Session{sessionA, sessionB} {
+		execUpdate(t, s, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+		execUpdate(t, s, ctx, `INSERT INTO students (id, name) VALUES (1, "committed")`)
+	}
+
+	// Aで実行中のtransactionのlockは, Bの同じ名前のtableへの書き込みを待たせない
+	execUpdate(t, sessionA, ctx, `START TRANSACTION`)
+	execUpdate(t, sessionA, ctx, `INSERT INTO students (id, name) VALUES (2, "in flight")`)
+	execUpdate(t, sessionB, ctx, `INSERT INTO students (id, name) VALUES (2, "other db")`)
+	assertRowsUnordered(t, queryRows(t, sessionB, ctx, `SELECT id, name FROM students`), [][]string{{"1", "committed"}, {"2", "other db"}})
+
+	// transactionの途中でAをcrashさせて開き直すと, 実行中だった変更は取り消され, lockも残らない
+	cleanupA()
+	recovered, cleanupRecovered := open(storageA)
+	defer cleanupRecovered()
+	s := recovered.NewSession()
+	defer s.Close(ctx)
+	assertRows(t, queryRows(t, s, ctx, `SELECT id, name FROM students`), [][]string{{"1", "committed"}})
+	execUpdate(t, s, ctx, `INSERT INTO students (id, name) VALUES (3, "recovered")`)
+	assertRowsUnordered(t, queryRows(t, s, ctx, `SELECT id FROM students`), [][]string{{"1"}, {"3"}})
+	assertRowsUnordered(t, queryRows(t, sessionB, ctx, `SELECT id FROM students`), [][]string{{"1"}, {"2"}})
+}
+
+// DROP TABLEは, isolation levelに関わらずtableを読んでいるtransactionの終了を待つ
+func TestDropTableWaitsForReaders(t *testing.T) {
+	for _, level := range []string{"READ UNCOMMITTED", "READ COMMITTED", "REPEATABLE READ", "SERIALIZABLE", "SNAPSHOT"} {
+		t.Run(level, func(t *testing.T) {
+			reader, ctx, cleanup := setupTestDB(t)
+			defer cleanup()
+			dropper := reader.db.NewSession()
+			defer dropper.Close(ctx)
+
+			execUpdate(t, dropper, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+			execUpdate(t, dropper, ctx, `INSERT INTO students (id, name) VALUES (1, "sheep")`)
+			execUpdate(t, reader, ctx, `SET TRANSACTION ISOLATION LEVEL `+level)
+			execUpdate(t, reader, ctx, `START TRANSACTION`)
+			assertRows(t, queryRows(t, reader, ctx, `SELECT id FROM students`), [][]string{{"1"}})
+
+			done := make(chan error)
+			go func() {
+				_, err := dropper.Execute(ctx, `DROP TABLE students`)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				t.Fatalf("expected DROP TABLE to wait for the reader, got %v", err)
+			case <-time.After(100 * time.Millisecond):
+			}
+			assertRows(t, queryRows(t, reader, ctx, `SELECT id FROM students`), [][]string{{"1"}})
+			execUpdate(t, reader, ctx, `COMMIT`)
+			if err := <-done; err != nil {
+				t.Fatalf("failed to drop table: %v", err)
+			}
+		})
+	}
+}
+
+// 存在しないテーブルのDROPは, そのファイルのlockを待たずにエラーになる
+func TestDropUnknownTableDoesNotLock(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	holder, err := session.db.newTx()
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if err := holder.DropFile(ctx, dbrecord.TableFileName("unknown")); err != nil {
+		t.Fatalf("failed to lock file: %v", err)
+	}
+	defer holder.Rollback(ctx)
+
+	done := make(chan error)
+	go func() {
+		_, err := session.Execute(ctx, `DROP TABLE unknown`)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("expected not found error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected DROP TABLE unknown to fail without waiting for the lock")
+	}
+}
+
+// ORDER BYで書き出したrunのファイルと統計は, transactionの終了後に残らない
+func TestOrderByRemovesRuns(t *testing.T) {
+	ctx := context.Background()
+	// bufferを少なくして複数のrunをマージさせる
+	db, cleanup, err := NewSimpleDB("", 1000, 12, WithStorage(dbfile.NewMemoryStorage()))
+	if err != nil {
+		t.Fatalf("failed to create simpledb: %v", err)
+	}
+	defer cleanup()
+	if err := db.Init(ctx); err != nil {
+		t.Fatalf("failed to init simpledb: %v", err)
+	}
+	session := db.NewSession()
+	defer session.Close(ctx)
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	const n = 1500
+	for i := range n {
+		execUpdate(t, session, ctx, fmt.Sprintf(`INSERT INTO students (id, name) VALUES (%d, "s%d")`, (i*7)%n, i))
+	}
+	// scanを閉じてからcommitする
+	result, err := session.Execute(ctx, `SELECT id FROM students ORDER BY id`)
+	if err != nil {
+		t.Fatalf("failed to execute query: %v", err)
+	}
+	rows := result.Rows
+	if len(rows) != n || rows[0][0].String != "0" || rows[n-1][0].String != fmt.Sprint(n-1) {
+		t.Fatalf("expected %d sorted rows, got %d", n, len(rows))
+	}
+
+	files, err := db.fileManager.Files()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file, dbfile.TempFilePrefix) {
+			t.Errorf("expected temp file %q to be removed", file)
+		}
+	}
+	for file := range db.BufferManager().FileStats() {
+		if strings.HasPrefix(file, dbfile.TempFilePrefix) {
+			t.Errorf("expected stats for %q to be removed", file)
+		}
+	}
+}
+
+// 他のnodeに届かないtransport. leaderを選べないのでnodeはfollowerのままになる
+type unreachableTransport struct{}
+
+func (unreachableTransport) RequestVote(target string, req *dbraft.RequestVoteRequest) (*dbraft.RequestVoteResponse, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (unreachableTransport) AppendEntries(target string, req *dbraft.AppendEntriesRequest) (*dbraft.AppendEntriesResponse, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (unreachableTransport) Start(addr string, handler dbraft.RPCHandler) error { return nil }
+func (unreachableTransport) Close() error                                       { return nil }
+
+// followerでも一時ファイルを使うORDER BYは実行でき, rollbackしても一時ファイルは残らない
+func TestOrderByOnFollower(t *testing.T) {
+	session, ctx, cleanup := setupTestDB(t)
+	defer cleanup()
+	execUpdate(t, session, ctx, `CREATE TABLE students (id INT, name VARCHAR(10))`)
+	for _, id := range []int{3, 1, 2} {
+		execUpdate(t, session, ctx, fmt.Sprintf(`INSERT INTO students (id, name) VALUES (%d, "s%d")`, id, id))
+	}
+	// 空のcatalogを読むとblockを追加するので, followerにする前に書いておく
+	execUpdate(t, session, ctx, `CREATE VIEW names AS SELECT name FROM students`)
+
+	db := session.db
+	node, err := dbraft.NewRaftNode(dbraft.Config{
+		ID:        "follower",
+		Peers:     []string{"unreachable"},
+		DataDir:   t.TempDir(),
+		FSM:       dbraft.NewFSM(db.bufferManager, db.fileManager),
+		Transport: unreachableTransport{},
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft node: %v", err)
+	}
+	defer node.Stop()
+	db.SetRaftNode(node)
+
+	follower := db.NewSession()
+	defer follower.Close(ctx)
+	execUpdate(t, follower, ctx, `START TRANSACTION`)
+	result, err := follower.Execute(ctx, `SELECT id FROM students ORDER BY id DESC`)
+	if err != nil {
+		t.Fatalf("failed to execute ORDER BY on follower: %v", err)
+	}
+	var ids []string
+	for _, row := range result.Rows {
+		ids = append(ids, row[0].String)
+	}
+	if !slices.Equal(ids, []string{"3", "2", "1"}) {
+		t.Errorf("expected [3 2 1], got %v", ids)
+	}
+	if _, err := follower.Execute(ctx, `INSERT INTO students (id, name) VALUES (4, "s4")`); !errors.Is(err, dbtx.ErrNotLeader) {
+		t.Errorf("expected ErrNotLeader for INSERT on follower, got %v", err)
+	}
+	execUpdate(t, follower, ctx, `ROLLBACK`)
+
+	files, err := db.fileManager.Files()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file, dbfile.TempFilePrefix) {
+			t.Errorf("expected temp file %q to be removed after rollback", file)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package dbexecutor
+
+import (
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbmetadata"
+	"github.com/teru01/simpledb-go/dbplan"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+const (
+	// buffer poolの各bufferの現在の状態. pg_buffercacheに相当する
+	BufferCacheTableName = dbmetadata.SystemTablePrefix + "buffercache"
+	// ファイルごとのbuffer poolの統計
+	BufferStatsTableName = dbmetadata.SystemTablePrefix + "bufferstats"
+)
+
+// ORDER BYでtemp tableに書き出せるよう, table名やindex名から作られるファイル名が収まる長さにする
+const systemFileNameLength = 64
+
+func (s *SimpleDB) newQueryPlanner() *dbplan.BasicQueryPlanner {
+	qp := dbplan.NewQueryPlanner(s.metadataManager)
+	qp.AddSystemTable(BufferCacheTableName, bufferCacheTable(s.bufferManager))
+	qp.AddSystemTable(BufferStatsTableName, bufferStatsTable(s.bufferManager))
+	return qp
+}
+
+// 割り当てられていないbufferのfilenameとblocknum, 変更のないbufferのtxnumはNULL
+func bufferCacheTable(bm *dbbuffer.BufferManager) *dbplan.SystemTable {
+	schema := dbrecord.NewSchema()
+	schema.AddIntField("bufferid")
+	schema.AddStringField("filename", systemFileNameLength)
+	schema.AddIntField("blocknum")
+	schema.AddIntField("pins")
+	schema.AddIntField("dirty")
+	schema.AddIntField("txnum")
+	return dbplan.NewSystemTable(schema, func() [][]dbconstant.Constant {
+		var rows [][]dbconstant.Constant
+		for _, f := range bm.Frames() {
+			var fileName, blockNum, txNum dbconstant.Constant = dbconstant.NewNullConstant(), dbconstant.NewNullConstant(), dbconstant.NewNullConstant()
+			if f.Block.FileName() != "" {
+				fileName = dbconstant.NewStringConstant(f.Block.FileName())
+				blockNum = dbconstant.NewIntConstant(f.Block.BlockNum())
+			}
+			dirty := 0
+			if f.Dirty() {
+				dirty = 1
+				txNum = dbconstant.NewIntConstant(int(f.ModifyingTx))
+			}
+			rows = append(rows, []dbconstant.Constant{
+				dbconstant.NewIntConstant(f.ID), fileName, blockNum,
+				dbconstant.NewIntConstant(f.Pins), dbconstant.NewIntConstant(dirty), txNum,
+			})
+		}
+		return rows
+	})
+}
+
+func bufferStatsTable(bm *dbbuffer.BufferManager) *dbplan.SystemTable {
+	schema := dbrecord.NewSchema()
+	schema.AddStringField("filename", systemFileNameLength)
+	for _, fieldName := range []string{"hits", "misses", "evictions", "pinwaits", "dirtyflushes", "bgwrites", "prefetches"} {
+		schema.AddIntField(fieldName)
+	}
+	return dbplan.NewSystemTable(schema, func() [][]dbconstant.Constant {
+		stats := bm.FileStats()
+		fileNames := make([]string, 0, len(stats))
+		for fileName := range stats {
+			fileNames = append(fileNames, fileName)
+		}
+		slices.Sort(fileNames)
+		var rows [][]dbconstant.Constant
+		for _, fileName := range fileNames {
+			st := stats[fileName]
+			rows = append(rows, []dbconstant.Constant{
+				dbconstant.NewStringConstant(fileName),
+				dbconstant.NewIntConstant(int(st.Hits)),
+				dbconstant.NewIntConstant(int(st.Misses)),
+				dbconstant.NewIntConstant(int(st.Evictions)),
+				dbconstant.NewIntConstant(int(st.PinWaits)),
+				dbconstant.NewIntConstant(int(st.DirtyFlushes)),
+				dbconstant.NewIntConstant(int(st.BackgroundWrites)),
+				dbconstant.NewIntConstant(int(st.Prefetches)),
+			})
+		}
+		return rows
+	})
+}
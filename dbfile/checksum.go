@@ -0,0 +1,74 @@
+package dbfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/teru01/simpledb-go/dberr"
+)
+
+// headerの中でchecksumを置く位置. LSNの後ろ
+const checksumOffset = 8
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// headerのLSNとpageの内容のCRC32C. checksum自体は含めない
+func blockChecksum(b []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, b[:checksumOffset])
+	return crc32.Update(crc, castagnoli, b[PageHeaderSize:])
+}
+
+// headerを含むblockの内容bにchecksumを書く
+func putChecksum(b []byte) {
+	binary.BigEndian.PutUint32(b[checksumOffset:], blockChecksum(b))
+}
+
+// 書き込みの途中でcrashした場合(torn page)やbit rotで内容が変わった場合はCodeChecksumMismatchを返す
+func verifyChecksum(blk BlockID, b []byte) error {
+	stored := binary.BigEndian.Uint32(b[checksumOffset:])
+	if computed := blockChecksum(b); stored != computed {
+		return dberr.New(dberr.CodeChecksumMismatch, fmt.Sprintf("checksum mismatch in block %s: stored %08x, computed %08x", blk, stored, computed), nil)
+	}
+	return nil
+}
+
+// 壊れたblock
+type CorruptBlock struct {
+	Block BlockID
+	Err   error
+}
+
+// fileNameの全てのblockのchecksumを確かめ, 壊れたblockを返す
+// ファイルの末尾にblockの大きさに満たない書きかけの部分があれば, それも壊れたblockとして返す
+func (fm *FileManager) VerifyFile(fileName string) ([]CorruptBlock, error) {
+	f, err := fm.lockFile(fileName, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("get file handle for %q: %w", fileName, err)
+	}
+	size, err := f.file.Size()
+	f.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("get file size for %q: %w", fileName, err)
+	}
+
+	blockLen := int64(PageHeaderSize + fm.blockSize)
+	var corrupt []CorruptBlock
+	b := make([]byte, blockLen)
+	n := int(size / blockLen)
+	for i := range n {
+		if err := fm.readRawBlock(fileName, i, b); err != nil {
+			return corrupt, err
+		}
+		blk := NewBlockID(fileName, i)
+		if err := verifyChecksum(blk, b); err != nil {
+			corrupt = append(corrupt, CorruptBlock{Block: blk, Err: err})
+		}
+	}
+	if rest := size % blockLen; rest != 0 {
+		blk := NewBlockID(fileName, n)
+		err := dberr.New(dberr.CodeChecksumMismatch, fmt.Sprintf("partially written block %s: %d of %d bytes", blk, rest, blockLen), nil)
+		corrupt = append(corrupt, CorruptBlock{Block: blk, Err: err})
+	}
+	return corrupt, nil
+}
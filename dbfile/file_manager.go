@@ -1,28 +1,58 @@
 package dbfile
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
-	"strings"
+	"slices"
 	"sync"
 )
 
 const defaultDirectory = "/tmp/simpledb"
 
+// ディスク上の各blockの先頭に置くheaderのサイズ. pageのLSN(8byte)とblockのchecksum(4byte)を保存する
+// headerはpageの内容に含めないので, 1 blockはディスク上でPageHeaderSize+blockSizeの大きさになる
+// headerのないblockを書いた古いdatabaseはFormatVersionで見分けて開かない
+const PageHeaderSize = 12
+
 type FileManager struct {
-	mu              sync.Mutex
-	dbDirectory     *os.File
-	blockSize       int
-	isNew           bool
-	openFiles       map[string]*os.File
+	// openFilesとread/writeの回数を守る. ファイルの読み書きの間は持たない
+	mu        sync.Mutex
+	storage   Storage
+	blockSize int
+	isNew     bool
+	// trueならO_SYNCで開かず, Syncが呼ばれた時にfsyncする
+	syncOnCommit    bool
+	openFiles       map[string]*openFile
 	readCount       int64
 	writeCount      int64
 	readCountByFile map[string]int64
 }
 
-func NewFileManager(dbDirectory *os.File, blockSize int) (*FileManager, error) {
+// 開いているファイル. 読み込みは並行に行い, 書き込みとファイルの伸長は他の読み書きと排他する
+type openFile struct {
+	mu   sync.RWMutex
+	file StorageFile
+	// Remove, Renameなどで閉じた. 閉じたファイルを取った場合は開き直す
+	closed bool
+	// fsyncしていない書き込みがある. syncOnCommitの場合のみ使う
+	unsynced bool
+}
+
+type FileOption func(*FileManager)
+
+// 書き込みごとにO_SYNCで待たず, Syncが呼ばれた時にまとめてfsyncする
+// logはcommitやWALのflushでSyncするので, commit済みの変更とWALの順序は保たれる
+func WithSyncOnCommit() FileOption {
+	return func(fm *FileManager) {
+		fm.syncOnCommit = true
+	}
+}
+
+func NewFileManager(dbDirectory *os.File, blockSize int, opts ...FileOption) (*FileManager, error) {
 	var isNew bool
 
 	if dbDirectory == nil {
@@ -36,106 +66,342 @@ func NewFileManager(dbDirectory *os.File, blockSize int) (*FileManager, error) {
 		if err != nil {
 			return nil, fmt.Errorf("open database directory %s: %w", defaultDirectory, err)
 		}
-	} else {
-		files, err := dbDirectory.ReadDir(0)
-		if err != nil {
-			return nil, fmt.Errorf("read directory %s: %w", dbDirectory.Name(), err)
-		}
-		for _, file := range files {
-			if strings.HasPrefix(file.Name(), "temp") {
-				if err := os.Remove(filepath.Join(dbDirectory.Name(), file.Name())); err != nil {
-					return nil, fmt.Errorf("remove temporary file %s: %w", file.Name(), err)
-				}
-			}
-		}
 	}
 
-	return &FileManager{
-		dbDirectory:     dbDirectory,
+	fm := newFileManager(blockSize, isNew, opts)
+	fm.storage = &dirStorage{dir: dbDirectory.Name(), syncWrites: !fm.syncOnCommit}
+	if err := removeTempFiles(fm.storage); err != nil {
+		return nil, err
+	}
+	if err := checkFormat(fm.storage); err != nil {
+		return nil, err
+	}
+	return fm, nil
+}
+
+// storageにblockを置くFileManager. storageにファイルがなければ新しいdatabaseとして扱う
+func NewFileManagerWithStorage(storage Storage, blockSize int, opts ...FileOption) (*FileManager, error) {
+	if err := removeTempFiles(storage); err != nil {
+		return nil, err
+	}
+	files, err := storage.Files()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFormat(storage); err != nil {
+		return nil, err
+	}
+	fm := newFileManager(blockSize, len(files) == 0, opts)
+	fm.storage = storage
+	return fm, nil
+}
+
+func newFileManager(blockSize int, isNew bool, opts []FileOption) *FileManager {
+	fm := &FileManager{
 		blockSize:       blockSize,
 		isNew:           isNew,
-		openFiles:       make(map[string]*os.File),
+		openFiles:       make(map[string]*openFile),
 		readCountByFile: make(map[string]int64),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(fm)
+	}
+	return fm
 }
 
 func (fm *FileManager) Read(blockID BlockID, p *Page) error {
-	fm.mu.Lock()
-	defer fm.mu.Unlock()
-	fm.readCount++
-	fm.readCountByFile[blockID.FileName()]++
-	file, err := fm.getFile(blockID.FileName())
+	return fm.read(blockID, p, true)
+}
+
+// checksumを確かめずに読む. 書きかけのlogの末尾のblockから壊れていないlog recordを拾うのに使う
+func (fm *FileManager) ReadUnverified(blockID BlockID, p *Page) error {
+	return fm.read(blockID, p, false)
+}
+
+func (fm *FileManager) read(blockID BlockID, p *Page, verify bool) error {
+	fm.countRead(blockID.FileName(), 1)
+	f, err := fm.lockFile(blockID.FileName(), false, true)
 	if err != nil {
 		return fmt.Errorf("get file handle for %q: %w", blockID.FileName(), err)
 	}
-	_, err = file.Seek(int64(blockID.BlockNum()*fm.blockSize), io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("seek to block %d in file %q: %w", blockID.BlockNum(), blockID.FileName(), err)
+	defer f.mu.RUnlock()
+	b := make([]byte, PageHeaderSize+fm.blockSize)
+	if _, err := f.file.ReadAt(b, fm.blockOffset(blockID.BlockNum())); err != nil {
+		return fmt.Errorf("read block %d from file %q: %w", blockID.BlockNum(), blockID.FileName(), err)
 	}
-	_, err = file.Read(p.pageBuffer().buffer)
+	if verify {
+		if err := verifyChecksum(blockID, b); err != nil {
+			return err
+		}
+	}
+	copy(p.pageBuffer().buffer, b[PageHeaderSize:])
+	p.SetLSN(int(binary.BigEndian.Uint64(b)))
+	return nil
+}
+
+// fileNameのstartから続くlen(pages)個のblockを1回の読み込みでpagesに読む. 順に読むscanの先読みで使う
+func (fm *FileManager) ReadBlocks(fileName string, start int, pages []*Page) error {
+	fm.countRead(fileName, len(pages))
+	f, err := fm.lockFile(fileName, false, true)
 	if err != nil {
-		return fmt.Errorf("read block %d from file %q: %w", blockID.BlockNum(), blockID.FileName(), err)
+		return fmt.Errorf("get file handle for %q: %w", fileName, err)
+	}
+	defer f.mu.RUnlock()
+	blockLen := PageHeaderSize + fm.blockSize
+	b := make([]byte, len(pages)*blockLen)
+	if _, err := f.file.ReadAt(b, fm.blockOffset(start)); err != nil {
+		return fmt.Errorf("read blocks %d-%d from file %q: %w", start, start+len(pages)-1, fileName, err)
+	}
+	for i, p := range pages {
+		block := b[i*blockLen : (i+1)*blockLen]
+		if err := verifyChecksum(NewBlockID(fileName, start+i), block); err != nil {
+			return err
+		}
+		copy(p.pageBuffer().buffer, block[PageHeaderSize:])
+		p.SetLSN(int(binary.BigEndian.Uint64(block)))
 	}
 	return nil
 }
 
 func (fm *FileManager) Write(blockID BlockID, p *Page) error {
 	fm.mu.Lock()
-	defer fm.mu.Unlock()
 	fm.writeCount++
-	file, err := fm.getFile(blockID.FileName())
+	fm.mu.Unlock()
+	f, err := fm.lockFile(blockID.FileName(), true, true)
 	if err != nil {
 		return fmt.Errorf("get file handle for %q: %w", blockID.FileName(), err)
 	}
-	_, err = file.Seek(int64(blockID.BlockNum()*fm.blockSize), io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("seek to block %d in file %q: %w", blockID.BlockNum(), blockID.FileName(), err)
-	}
-	_, err = file.Write(p.pageBuffer().buffer)
-	if err != nil {
+	defer f.mu.Unlock()
+	// headerとpageが別々にディスクに乗らないように1回で書き込む
+	b := make([]byte, PageHeaderSize+fm.blockSize)
+	binary.BigEndian.PutUint64(b, uint64(p.LSN()))
+	copy(b[PageHeaderSize:], p.pageBuffer().buffer)
+	putChecksum(b)
+	if _, err := f.file.WriteAt(b, fm.blockOffset(blockID.BlockNum())); err != nil {
 		return fmt.Errorf("write block %d to file %q: %w", blockID.BlockNum(), blockID.FileName(), err)
 	}
+	f.unsynced = fm.syncOnCommit
 	return nil
 }
 
 // fileNameのファイルを1ブロック伸ばす
+// syncOnCommitでも伸ばしたファイルの大きさはすぐにfsyncする. crash後のredoが存在しないblockを読まないようにする
 func (fm *FileManager) Append(fileName string) (BlockID, error) {
-	fm.mu.Lock()
-	defer fm.mu.Unlock()
+	f, err := fm.lockFile(fileName, true, true)
+	if err != nil {
+		return BlockID{}, fmt.Errorf("get file handle for %q: %w", fileName, err)
+	}
+	defer f.mu.Unlock()
 
-	blockNum, err := fm.FileBlockLength(fileName)
+	blockNum, err := fm.blockLength(f.file, fileName)
 	if err != nil {
 		return BlockID{}, fmt.Errorf("get file block length for %q: %w", fileName, err)
 	}
 	newBlockID := NewBlockID(fileName, blockNum)
 
-	b := make([]byte, fm.blockSize)
-	f, err := fm.getFile(fileName)
+	b := make([]byte, PageHeaderSize+fm.blockSize)
+	putChecksum(b)
+	if _, err := f.file.WriteAt(b, fm.blockOffset(newBlockID.blockNum)); err != nil {
+		return BlockID{}, fmt.Errorf("write new block %d to file %q: %w", newBlockID.blockNum, fileName, err)
+	}
+	if fm.syncOnCommit {
+		if err := f.file.Sync(); err != nil {
+			return BlockID{}, fmt.Errorf("sync file %q: %w", fileName, err)
+		}
+		f.unsynced = false
+	}
+	return newBlockID, nil
+}
+
+// fileNameへの書き込みをfsyncする. O_SYNCで開いている場合や開いていないファイルは何もしない
+func (fm *FileManager) Sync(fileName string) error {
+	if !fm.syncOnCommit {
+		return nil
+	}
+	f, err := fm.lockFile(fileName, true, false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		return BlockID{}, fmt.Errorf("get file handle for %q: %w", fileName, err)
+		return fmt.Errorf("get file handle for %q: %w", fileName, err)
+	}
+	defer f.mu.Unlock()
+	if !f.unsynced {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("sync file %q: %w", fileName, err)
+	}
+	f.unsynced = false
+	return nil
+}
+
+// fsyncしていない書き込みがある全てのファイルをfsyncする
+// 置き換えやbackground writerの書き出しはfsyncしないので, logを捨てる前のcheckpointで使う
+func (fm *FileManager) SyncAll() error {
+	for _, fileName := range fm.UnsyncedFiles() {
+		if err := fm.Sync(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsyncしていない書き込みがあるファイル名
+func (fm *FileManager) UnsyncedFiles() []string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	var names []string
+	for name, f := range fm.openFiles {
+		f.mu.RLock()
+		if f.unsynced {
+			names = append(names, name)
+		}
+		f.mu.RUnlock()
+	}
+	slices.Sort(names)
+	return names
+}
+
+// fileNameのファイルを削除する. 存在しない場合は何もしない
+func (fm *FileManager) Remove(fileName string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if err := fm.closeLocked(fileName); err != nil {
+		return err
+	}
+	delete(fm.readCountByFile, fileName)
+	if err := fm.storage.Remove(fileName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove file %q: %w", fileName, err)
+	}
+	return nil
+}
+
+// oldNameのファイルでnewNameのファイルを置き換える
+func (fm *FileManager) Rename(oldName, newName string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	for _, fileName := range []string{oldName, newName} {
+		if err := fm.closeLocked(fileName); err != nil {
+			return err
+		}
 	}
-	_, err = f.Seek(int64(newBlockID.blockNum*fm.blockSize), io.SeekStart)
+	delete(fm.readCountByFile, oldName)
+	if err := fm.storage.Rename(oldName, newName); err != nil {
+		return fmt.Errorf("rename file %q to %q: %w", oldName, newName, err)
+	}
+	return nil
+}
+
+// database内のblockに分かれたファイルの名前. REPLの履歴などの隠しファイルと形式のファイルは含めない
+func (fm *FileManager) Files() ([]string, error) {
+	files, err := fm.storage.Files()
 	if err != nil {
-		return BlockID{}, fmt.Errorf("seek to block %d in file %q: %w", newBlockID.blockNum, fileName, err)
+		return nil, err
 	}
-	_, err = f.Write(b)
+	return slices.DeleteFunc(files, func(name string) bool { return name == FormatFileName }), nil
+}
+
+// fileNameのファイルをdstPathに写す
+// blockごとにlockを取るので, 写している間も他のファイルの読み書きは止めない. 各blockは書き込みの途中の状態では写らない
+// 写している間にファイルが削除された場合はfs.ErrNotExistを返す
+func (fm *FileManager) CopyFile(fileName, dstPath string) error {
+	n, err := fm.existingFileBlockLength(fileName)
 	if err != nil {
-		return BlockID{}, fmt.Errorf("write new block %d to file %q: %w", newBlockID.blockNum, fileName, err)
+		return fmt.Errorf("get file block length for %q: %w", fileName, err)
 	}
-	return newBlockID, nil
+	return writeFileAtomically(dstPath, func(dst *os.File) error {
+		b := make([]byte, PageHeaderSize+fm.blockSize)
+		for i := range n {
+			if err := fm.readRawBlock(fileName, i, b); err != nil {
+				return err
+			}
+			if _, err := dst.Write(b); err != nil {
+				return fmt.Errorf("write block %d to %s: %w", i, dstPath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// fileNameのファイルとpathのファイルの内容が同じかどうか
+func (fm *FileManager) EqualFile(fileName, path string) (bool, error) {
+	other, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("read %s: %w", path, err)
+	}
+	n, err := fm.FileBlockLength(fileName)
+	if err != nil {
+		return false, fmt.Errorf("get file block length for %q: %w", fileName, err)
+	}
+	blockLen := PageHeaderSize + fm.blockSize
+	if len(other) != n*blockLen {
+		return false, nil
+	}
+	b := make([]byte, blockLen)
+	for i := range n {
+		if err := fm.readRawBlock(fileName, i, b); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(b, other[i*blockLen:(i+1)*blockLen]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// headerを含むblockの内容をそのまま読む
+func (fm *FileManager) readRawBlock(fileName string, blockNum int, b []byte) error {
+	f, err := fm.lockFile(fileName, false, false)
+	if err != nil {
+		return fmt.Errorf("get file handle for %q: %w", fileName, err)
+	}
+	defer f.mu.RUnlock()
+	if _, err := f.file.ReadAt(b, fm.blockOffset(blockNum)); err != nil {
+		return fmt.Errorf("read block %d from file %q: %w", blockNum, fileName, err)
+	}
+	return nil
+}
+
+// srcPathのファイルでfileNameのファイルを置き換える
+func (fm *FileManager) ImportFile(srcPath, fileName string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", srcPath, err)
+	}
+	defer src.Close()
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if err := fm.closeLocked(fileName); err != nil {
+		return err
+	}
+	if err := fm.storage.Replace(fileName, src); err != nil {
+		return fmt.Errorf("import %s: %w", srcPath, err)
+	}
+	return nil
 }
 
 // fileNameのファイルのブロック数を取得.ブロック単位で書き込まれるので切り捨てても問題ない
 func (fm *FileManager) FileBlockLength(fileName string) (int, error) {
-	file, err := fm.getFile(fileName)
+	f, err := fm.lockFile(fileName, false, true)
 	if err != nil {
 		return 0, fmt.Errorf("get file handle for %q: %w", fileName, err)
 	}
-	info, err := file.Stat()
+	defer f.mu.RUnlock()
+	return fm.blockLength(f.file, fileName)
+}
+
+func (fm *FileManager) blockLength(file StorageFile, fileName string) (int, error) {
+	size, err := file.Size()
 	if err != nil {
-		return 0, fmt.Errorf("get file info for %q: %w", fileName, err)
+		return 0, fmt.Errorf("get file size for %q: %w", fileName, err)
 	}
-	return int(info.Size() / int64(fm.blockSize)), nil
+	return int(size / int64(PageHeaderSize+fm.blockSize)), nil
+}
+
+// ディスク上でのblockの開始位置
+func (fm *FileManager) blockOffset(blockNum int) int64 {
+	return int64(blockNum) * int64(PageHeaderSize+fm.blockSize)
 }
 
 func (fm *FileManager) IsNew() bool {
@@ -177,15 +443,81 @@ func (fm *FileManager) ResetCounts() {
 	fm.readCountByFile = make(map[string]int64)
 }
 
-func (fm *FileManager) getFile(fileName string) (*os.File, error) {
-	file, ok := fm.openFiles[fileName]
-	if !ok {
-		f, err := os.OpenFile(filepath.Join(fm.dbDirectory.Name(), fileName), os.O_RDWR|os.O_CREATE|os.O_SYNC, 0644)
+func (fm *FileManager) countRead(fileName string, n int) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.readCount += int64(n)
+	fm.readCountByFile[fileName] += int64(n)
+}
+
+func (fm *FileManager) existingFileBlockLength(fileName string) (int, error) {
+	f, err := fm.lockFile(fileName, false, false)
+	if err != nil {
+		return 0, fmt.Errorf("get file handle for %q: %w", fileName, err)
+	}
+	defer f.mu.RUnlock()
+	return fm.blockLength(f.file, fileName)
+}
+
+// fileNameのファイルを開いてlockを取る. writeなら排他lock, そうでなければ共有lock
+// createがfalseの場合, ファイルがなければ作らずにfs.ErrNotExistを返す
+func (fm *FileManager) lockFile(fileName string, write, create bool) (*openFile, error) {
+	for {
+		fm.mu.Lock()
+		f, err := fm.getFileLocked(fileName, create)
+		fm.mu.Unlock()
 		if err != nil {
 			return nil, err
 		}
-		fm.openFiles[fileName] = f
+		if write {
+			f.mu.Lock()
+		} else {
+			f.mu.RLock()
+		}
+		if !f.closed {
+			return f, nil
+		}
+		// lockを待つ間にRemoveなどで閉じられた
+		if write {
+			f.mu.Unlock()
+		} else {
+			f.mu.RUnlock()
+		}
+	}
+}
+
+func (fm *FileManager) getFileLocked(fileName string, create bool) (*openFile, error) {
+	if f, ok := fm.openFiles[fileName]; ok {
 		return f, nil
 	}
-	return file, nil
+	file, err := fm.storage.Open(fileName, create)
+	if err != nil {
+		return nil, err
+	}
+	f := &openFile{file: file}
+	fm.openFiles[fileName] = f
+	return f, nil
+}
+
+// fileNameのファイルを閉じる. 読み書き中なら終わるのを待つ. lock前提
+// renameされたファイルに書き込みが残らないよう, fsyncしていない書き込みがあればfsyncしてから閉じる
+func (fm *FileManager) closeLocked(fileName string) error {
+	f, ok := fm.openFiles[fileName]
+	if !ok {
+		return nil
+	}
+	delete(fm.openFiles, fileName)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.unsynced {
+		if err := f.file.Sync(); err != nil {
+			f.file.Close()
+			return fmt.Errorf("sync file %q: %w", fileName, err)
+		}
+	}
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close file %q: %w", fileName, err)
+	}
+	return nil
 }
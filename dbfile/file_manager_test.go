@@ -0,0 +1,271 @@
+package dbfile_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/teru01/simpledb-go/dberr"
+	"github.com/teru01/simpledb-go/dbfile"
+)
+
+func TestFileManagerChecksum(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open dir: %v", err)
+	}
+	defer f.Close()
+	blockSize := 400
+	fm, err := dbfile.NewFileManager(f, blockSize)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+
+	p := dbfile.NewPage(blockSize)
+	for i := range 3 {
+		blk, err := fm.Append("test.tbl")
+		if err != nil {
+			t.Fatalf("failed to append block: %v", err)
+		}
+		if err := p.SetInt(0, i); err != nil {
+			t.Fatalf("failed to set int: %v", err)
+		}
+		p.SetLSN(i + 1)
+		if err := fm.Write(blk, p); err != nil {
+			t.Fatalf("failed to write block: %v", err)
+		}
+	}
+	if corrupt, err := fm.VerifyFile("test.tbl"); err != nil || len(corrupt) != 0 {
+		t.Fatalf("expected no corrupt blocks, got %v (err=%v)", corrupt, err)
+	}
+
+	// block 1の内容を1byteだけ書き換える
+	path := filepath.Join(dir, "test.tbl")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	raw[dbfile.PageHeaderSize+blockSize+dbfile.PageHeaderSize+100] ^= 0xff
+	// 書きかけのblockを末尾に足す
+	raw = append(raw, make([]byte, 10)...)
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if err := fm.Read(dbfile.NewBlockID("test.tbl", 0), p); err != nil {
+		t.Errorf("expected block 0 to be readable, got %v", err)
+	}
+	err = fm.Read(dbfile.NewBlockID("test.tbl", 1), p)
+	var dbErr *dberr.DBError
+	if !errors.As(err, &dbErr) || dbErr.Code != dberr.CodeChecksumMismatch {
+		t.Fatalf("expected checksum mismatch for block 1, got %v", err)
+	}
+
+	corrupt, err := fm.VerifyFile("test.tbl")
+	if err != nil {
+		t.Fatalf("failed to verify file: %v", err)
+	}
+	if len(corrupt) != 2 || corrupt[0].Block.BlockNum() != 1 || corrupt[1].Block.BlockNum() != 3 {
+		t.Errorf("expected blocks 1 and 3 to be corrupt, got %v", corrupt)
+	}
+}
+
+func TestFileManagerConcurrentReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open dir: %v", err)
+	}
+	defer f.Close()
+	blockSize := 400
+	fm, err := dbfile.NewFileManager(f, blockSize, dbfile.WithSyncOnCommit())
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+
+	numBlocks := 8
+	p := dbfile.NewPage(blockSize)
+	for i := range numBlocks {
+		blk, err := fm.Append("test.tbl")
+		if err != nil {
+			t.Fatalf("failed to append block: %v", err)
+		}
+		if err := p.SetInt(0, i); err != nil {
+			t.Fatalf("failed to set int: %v", err)
+		}
+		if err := fm.Write(blk, p); err != nil {
+			t.Fatalf("failed to write block: %v", err)
+		}
+	}
+	if err := fm.Sync("test.tbl"); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	// 別のblockへの読み書きが並行しても互いの内容を壊さない
+	var wg sync.WaitGroup
+	errCh := make(chan error, numBlocks*2)
+	for i := range numBlocks {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p := dbfile.NewPage(blockSize)
+			for range 50 {
+				if err := fm.Read(dbfile.NewBlockID("test.tbl", i), p); err != nil {
+					errCh <- err
+					return
+				}
+				if v := p.GetInt(0); v != i {
+					errCh <- fmt.Errorf("block %d: expected %d, got %d", i, i, v)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			p := dbfile.NewPage(blockSize)
+			if err := p.SetInt(0, i); err != nil {
+				errCh <- err
+				return
+			}
+			for range 50 {
+				if err := fm.Write(dbfile.NewBlockID("test.tbl", i), p); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+	if err := fm.Sync("test.tbl"); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if err := fm.Sync("missing.tbl"); err != nil {
+		t.Errorf("expected sync of missing file to be no-op, got %v", err)
+	}
+}
+
+func TestFileManagerMemoryStorage(t *testing.T) {
+	storage := dbfile.NewMemoryStorage()
+	blockSize := 400
+	fm, err := dbfile.NewFileManagerWithStorage(storage, blockSize)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	if !fm.IsNew() {
+		t.Errorf("expected empty storage to be new")
+	}
+
+	p := dbfile.NewPage(blockSize)
+	for i := range 3 {
+		blk, err := fm.Append("test.tbl")
+		if err != nil {
+			t.Fatalf("failed to append block: %v", err)
+		}
+		if err := p.SetInt(0, i); err != nil {
+			t.Fatalf("failed to set int: %v", err)
+		}
+		p.SetLSN(i + 1)
+		if err := fm.Write(blk, p); err != nil {
+			t.Fatalf("failed to write block: %v", err)
+		}
+	}
+	if _, err := fm.Append(dbfile.TempFilePrefix + "1.tbl"); err != nil {
+		t.Fatalf("failed to append block: %v", err)
+	}
+	if err := fm.Rename("test.tbl", "renamed.tbl"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+
+	// 同じstorageで作り直したFileManagerは内容を引き継ぎ, 一時ファイルは消す
+	fm, err = dbfile.NewFileManagerWithStorage(storage, blockSize)
+	if err != nil {
+		t.Fatalf("failed to recreate file manager: %v", err)
+	}
+	if fm.IsNew() {
+		t.Errorf("expected non-empty storage not to be new")
+	}
+	files, err := fm.Files()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(files) != 1 || files[0] != "renamed.tbl" {
+		t.Errorf("expected [renamed.tbl], got %v", files)
+	}
+	if n, err := fm.FileBlockLength("renamed.tbl"); err != nil || n != 3 {
+		t.Errorf("expected 3 blocks, got %d (err=%v)", n, err)
+	}
+	if err := fm.Read(dbfile.NewBlockID("renamed.tbl", 2), p); err != nil {
+		t.Fatalf("failed to read block: %v", err)
+	}
+	if v := p.GetInt(0); v != 2 || p.LSN() != 3 {
+		t.Errorf("expected value 2 with LSN 3, got %d with LSN %d", v, p.LSN())
+	}
+	if corrupt, err := fm.VerifyFile("renamed.tbl"); err != nil || len(corrupt) != 0 {
+		t.Errorf("expected no corrupt blocks, got %v (err=%v)", corrupt, err)
+	}
+	if err := fm.Read(dbfile.NewBlockID("renamed.tbl", 3), p); err == nil {
+		t.Errorf("expected error for reading past the end of file")
+	}
+
+	if err := fm.Remove("renamed.tbl"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	if _, err := fm.VerifyFile("renamed.tbl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for removed file, got %v", err)
+	}
+}
+
+func TestFileManagerFormatVersion(t *testing.T) {
+	open := func(dir string) (*dbfile.FileManager, error) {
+		t.Helper()
+		f, err := os.Open(dir)
+		if err != nil {
+			t.Fatalf("failed to open dir: %v", err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return dbfile.NewFileManager(f, 400)
+	}
+
+	// 新しいdatabaseには形式を記録し, 開き直せる
+	dir := t.TempDir()
+	fm, err := open(dir)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	if _, err := fm.Append("test.tbl"); err != nil {
+		t.Fatalf("failed to append block: %v", err)
+	}
+	if files, err := fm.Files(); err != nil || len(files) != 1 || files[0] != "test.tbl" {
+		t.Errorf("expected [test.tbl], got %v (err=%v)", files, err)
+	}
+	if _, err := open(dir); err != nil {
+		t.Fatalf("failed to reopen file manager: %v", err)
+	}
+
+	// 異なる形式のdatabaseは開かない. 1つ前の形式はnull bitmapのないslotを持つ
+	for _, version := range []int{dbfile.FormatVersion - 1, dbfile.FormatVersion + 1} {
+		if err := os.WriteFile(filepath.Join(dir, dbfile.FormatFileName), []byte(fmt.Sprintf("%d\n", version)), 0644); err != nil {
+			t.Fatalf("failed to write format file: %v", err)
+		}
+		if _, err := open(dir); !dberr.IsCode(err, dberr.CodeUnsupportedFormat) {
+			t.Errorf("expected unsupported format for version %d, got %v", version, err)
+		}
+	}
+
+	// 形式を記録する前に作られたdatabaseは開かない
+	if err := os.Remove(filepath.Join(dir, dbfile.FormatFileName)); err != nil {
+		t.Fatalf("failed to remove format file: %v", err)
+	}
+	if _, err := open(dir); !dberr.IsCode(err, dberr.CodeUnsupportedFormat) {
+		t.Errorf("expected unsupported format for a database without format file, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package dbfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/teru01/simpledb-go/dberr"
+)
+
+// databaseのファイルの形式を記録するファイル. blockに分かれていないのでFilesには含めない
+const FormatFileName = "simpledb.format"
+
+// blockやlog recordの形式を変えたら上げる. 形式の異なるdatabaseは開かない
+//   - 1: blockの前にLSNとchecksumのheader(PageHeaderSize)を置き, log recordにもchecksumを置く
+//   - 2: record slotの使用中flagの後にnull bitmapを置く. 以前のslotとはfieldのoffsetが異なる
+const FormatVersion = 2
+
+// 新しいdatabaseなら形式を記録し, 既存のdatabaseなら同じ形式か確かめる
+// 形式を記録する前に作られたdatabaseはblockの大きさが異なるので開かない
+func checkFormat(storage Storage) error {
+	f, err := storage.Open(FormatFileName, false)
+	if errors.Is(err, fs.ErrNotExist) {
+		files, err := storage.Files()
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return dberr.New(dberr.CodeUnsupportedFormat, fmt.Sprintf("database has %d files but no %s. it was created by an older version and must be recreated", len(files), FormatFileName), nil)
+		}
+		if err := storage.Replace(FormatFileName, strings.NewReader(formatFileContent())); err != nil {
+			return fmt.Errorf("write %s: %w", FormatFileName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open %s: %w", FormatFileName, err)
+	}
+	defer f.Close()
+	size, err := f.Size()
+	if err != nil {
+		return fmt.Errorf("get size of %s: %w", FormatFileName, err)
+	}
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read %s: %w", FormatFileName, err)
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return dberr.New(dberr.CodeUnsupportedFormat, fmt.Sprintf("parse format version in %s", FormatFileName), err)
+	}
+	if version != FormatVersion {
+		return dberr.New(dberr.CodeUnsupportedFormat, fmt.Sprintf("database format version %d is not supported. expected %d", version, FormatVersion), nil)
+	}
+	return nil
+}
+
+// dirに現在の形式を記録する. backupのディレクトリを開けるようにするのに使う
+func WriteFormatFile(dir string) error {
+	path := filepath.Join(dir, FormatFileName)
+	if err := os.WriteFile(path, []byte(formatFileContent()), 0644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func formatFileContent() string {
+	return strconv.Itoa(FormatVersion) + "\n"
+}
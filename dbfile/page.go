@@ -1,9 +1,14 @@
 package dbfile
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 type Page struct {
 	buffer *ByteBuffer
+	// 最後にこのpageを変更したlog recordのLSN. ディスク上ではblockのheaderに置く
+	lsn int
 }
 
 // IO Bufferを使わないのでパフォーマンスどうか？
@@ -57,6 +62,33 @@ func MaxStringLengthOnPage(strLen int) int {
 	return intSize + strLen*4
 }
 
+// 内容をコピーした新しいPageを返す
+func (p *Page) Clone() *Page {
+	return &Page{buffer: NewByteBufferFromBytes(bytes.Clone(p.buffer.buffer)), lsn: p.lsn}
+}
+
+// offsetからn byteをlengthなしでそのまま返す. full page imageをlogに残すのに使う
+func (p *Page) RawBytes(offset, n int) []byte {
+	return bytes.Clone(p.buffer.buffer[offset : offset+n])
+}
+
+// offsetにbをlengthなしでそのまま書く
+func (p *Page) SetRawBytes(offset int, b []byte) error {
+	if offset < 0 || offset+len(b) > p.buffer.Size() {
+		return fmt.Errorf("set %d raw bytes at offset %d: exceeds page size %d", len(b), offset, p.buffer.Size())
+	}
+	copy(p.buffer.buffer[offset:], b)
+	return nil
+}
+
+func (p *Page) LSN() int {
+	return p.lsn
+}
+
+func (p *Page) SetLSN(lsn int) {
+	p.lsn = lsn
+}
+
 func (p *Page) pageBuffer() *ByteBuffer {
 	return p.buffer
 }
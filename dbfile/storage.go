@@ -0,0 +1,148 @@
+package dbfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileManagerがblockを読み書きするファイルの置き場所
+// FileManagerはblockの読み書き(Read, Write, Append, FileBlockLength)をStorageのファイルへの位置指定の読み書きで行う
+type Storage interface {
+	// nameのファイルを開く. createがfalseの場合, ファイルがなければfs.ErrNotExistを返す
+	Open(name string, create bool) (StorageFile, error)
+	// nameのファイルを削除する. 存在しない場合はfs.ErrNotExistを返す
+	Remove(name string) error
+	// oldNameのファイルでnewNameのファイルを置き換える
+	Rename(oldName, newName string) error
+	// ファイル名の一覧. 隠しファイルは含めない
+	Files() ([]string, error)
+	// nameのファイルの内容をrで置き換える. 途中で失敗した場合は元の内容のまま残る
+	Replace(name string, r io.Reader) error
+}
+
+// Storageで開いたファイル
+type StorageFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() (int64, error)
+	Sync() error
+	Close() error
+}
+
+// ディレクトリのファイルに置くStorage
+type dirStorage struct {
+	dir string
+	// trueならO_SYNCで開き, 書き込みごとにディスクに乗るのを待つ
+	syncWrites bool
+}
+
+func (s *dirStorage) Open(name string, create bool) (StorageFile, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	if s.syncWrites {
+		flag |= os.O_SYNC
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, name), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+func (s *dirStorage) Remove(name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+func (s *dirStorage) Rename(oldName, newName string) error {
+	return os.Rename(filepath.Join(s.dir, oldName), filepath.Join(s.dir, newName))
+}
+
+// REPLの履歴などの隠しファイルは含めない
+func (s *dirStorage) Files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory %s: %w", s.dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *dirStorage) Replace(name string, r io.Reader) error {
+	return writeFileAtomically(filepath.Join(s.dir, name), func(dst *os.File) error {
+		if _, err := io.Copy(dst, r); err != nil {
+			return fmt.Errorf("copy to %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// 一時ファイルに書いてからrenameするので, 途中でcrashしてもpathには書き終えた内容しか置かれない
+func writeFileAtomically(path string, write func(f *os.File) error) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmpPath, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("sync %s: %w", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("close %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename %s to %s: %w", tmpPath, path, err)
+	}
+	return nil
+}
+
+// 一時ファイルの名前のprefix. SQLの識別子に使えない文字で始め, tableやindexのファイルと重ならないようにする
+// 一時ファイルはlogから復元せず, backupにも含めず, 起動時に消す
+const TempFilePrefix = "#temp"
+
+// storageのファイルのうち, 前回の実行で残った一時ファイルを消す
+func removeTempFiles(storage Storage) error {
+	names, err := storage.Files()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, TempFilePrefix) {
+			if err := storage.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("remove temporary file %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
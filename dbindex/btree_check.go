@@ -0,0 +1,249 @@
+package dbindex
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+// leafに入っているentry
+type IndexEntry struct {
+	Value dbconstant.Constant
+	RID   dbrecord.RID
+}
+
+// rootから木を辿って構造を検査し, leafの全entryと見つかった問題を返す
+//   - dirのkeyは昇順で, 親から見たkeyの範囲[lo, hi)に収まる
+//   - dirの子のlevelは親のlevel-1で, leafは1箇所からだけ指される
+//   - leafのkeyは昇順で範囲に収まり, overflow blockは先頭のleafと同じkeyだけを持つ
+//
+// どこからも指されないblockは問題にしない. Resetで作り直した後に残るため
+func (b *BTreeIndex) Check(ctx context.Context) ([]IndexEntry, []string, error) {
+	if err := b.Close(ctx); err != nil {
+		return nil, nil, fmt.Errorf("close: %w", err)
+	}
+	c := &btreeChecker{
+		index:      b,
+		seenDirs:   make(map[int]bool),
+		seenLeaves: make(map[int]bool),
+	}
+	if err := c.checkDir(ctx, b.rootBlock.BlockNum(), nil, nil, -1); err != nil {
+		return nil, nil, err
+	}
+	return c.entries, c.problems, nil
+}
+
+type btreeChecker struct {
+	index      *BTreeIndex
+	seenDirs   map[int]bool
+	seenLeaves map[int]bool
+	entries    []IndexEntry
+	problems   []string
+}
+
+func (c *btreeChecker) problemf(format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// keyが[lo, hi)に収まるか. nilは制限なし
+func inRange(key, lo, hi dbconstant.Constant) bool {
+	return (lo == nil || key.Compare(lo) >= 0) && (hi == nil || key.Compare(hi) < 0)
+}
+
+func (c *btreeChecker) checkDir(ctx context.Context, blockNum int, lo, hi dbconstant.Constant, wantLevel int) error {
+	if c.seenDirs[blockNum] {
+		c.problemf("dir block %d is referenced more than once", blockNum)
+		return nil
+	}
+	c.seenDirs[blockNum] = true
+
+	blk := dbfile.NewBlockID(c.index.rootBlock.FileName(), blockNum)
+	level, keys, children, err := c.readDir(ctx, blk)
+	if err != nil {
+		return err
+	}
+	if wantLevel >= 0 && level != wantLevel {
+		c.problemf("dir block %d has level %d, want %d", blockNum, level, wantLevel)
+	}
+	if level < 0 {
+		c.problemf("dir block %d has negative level %d", blockNum, level)
+		return nil
+	}
+	if len(keys) == 0 {
+		c.problemf("dir block %d has no entries", blockNum)
+		return nil
+	}
+	for i, key := range keys {
+		if i > 0 && key.Compare(keys[i-1]) < 0 {
+			c.problemf("dir block %d: key %s at slot %d is smaller than the previous key %s", blockNum, key, i, keys[i-1])
+		}
+		// rootの先頭は番兵なので範囲を見ない
+		if (blockNum != c.index.rootBlock.BlockNum() || i > 0) && !inRange(key, lo, hi) {
+			c.problemf("dir block %d: key %s at slot %d is out of range [%v, %v)", blockNum, key, i, lo, hi)
+		}
+	}
+	for i, child := range children {
+		// 子の範囲は[keys[i], keys[i+1]). 先頭の子は親から受け取った下限を使う(rootの先頭は番兵)
+		childLo := keys[i]
+		if i == 0 {
+			childLo = lo
+		}
+		childHi := hi
+		if i+1 < len(keys) {
+			childHi = keys[i+1]
+		}
+		if level == 0 {
+			err = c.checkLeaf(ctx, child, childLo, childHi)
+		} else {
+			err = c.checkDir(ctx, child, childLo, childHi, level-1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 子を辿る前にpinを外せるよう, dir blockの中身をまとめて読む
+func (c *btreeChecker) readDir(ctx context.Context, blk dbfile.BlockID) (level int, keys []dbconstant.Constant, children []int, err error) {
+	page, err := NewBTreePage(ctx, c.index.tx, &blk, c.index.dirLayout)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("new btree page for %s: %w", blk, err)
+	}
+	defer page.Close(ctx)
+	level, err = page.GetFlag(ctx)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("get level of %s: %w", blk, err)
+	}
+	n, err := page.GetNumRecords(ctx)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("get number of records of %s: %w", blk, err)
+	}
+	for slot := range n {
+		key, err := page.GetDataValue(ctx, slot)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("get key at slot %d of %s: %w", slot, blk, err)
+		}
+		child, err := page.GetChildNum(ctx, slot)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("get child at slot %d of %s: %w", slot, blk, err)
+		}
+		keys = append(keys, key)
+		children = append(children, child)
+	}
+	return level, keys, children, nil
+}
+
+// leafと, そこから続くoverflow blockを検査する
+func (c *btreeChecker) checkLeaf(ctx context.Context, blockNum int, lo, hi dbconstant.Constant) error {
+	// overflow blockのkey. 先頭のleafが空ならoverflow blockの最初のkey
+	var chainKey dbconstant.Constant
+	for head := true; blockNum >= 0; head = false {
+		if c.seenLeaves[blockNum] {
+			if head {
+				c.problemf("leaf block %d is referenced more than once", blockNum)
+			} else {
+				c.problemf("overflow chain loops back to leaf block %d", blockNum)
+			}
+			return nil
+		}
+		c.seenLeaves[blockNum] = true
+
+		blk := dbfile.NewBlockID(c.index.leafTable, blockNum)
+		next, entries, err := c.readLeaf(ctx, blk)
+		if err != nil {
+			return err
+		}
+		for i, e := range entries {
+			if !inRange(e.Value, lo, hi) {
+				c.problemf("leaf block %d: key %s at slot %d is out of range [%v, %v)", blockNum, e.Value, i, lo, hi)
+			}
+			if head {
+				if i > 0 && e.Value.Compare(entries[i-1].Value) < 0 {
+					c.problemf("leaf block %d: key %s at slot %d is smaller than the previous key %s", blockNum, e.Value, i, entries[i-1].Value)
+				}
+				continue
+			}
+			if chainKey == nil {
+				chainKey = e.Value
+			}
+			if !e.Value.Equals(chainKey) {
+				c.problemf("overflow block %d: key %s at slot %d differs from the overflow key %s", blockNum, e.Value, i, chainKey)
+			}
+		}
+		if head && len(entries) > 0 {
+			chainKey = entries[0].Value
+		}
+		c.entries = append(c.entries, entries...)
+		blockNum = next
+	}
+	return nil
+}
+
+// leaf blockのoverflow先と全entryを読む
+func (c *btreeChecker) readLeaf(ctx context.Context, blk dbfile.BlockID) (overflow int, entries []IndexEntry, err error) {
+	page, err := NewBTreePage(ctx, c.index.tx, &blk, c.index.leafLayout)
+	if err != nil {
+		return 0, nil, fmt.Errorf("new btree page for %s: %w", blk, err)
+	}
+	defer page.Close(ctx)
+	overflow, err = page.GetFlag(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("get overflow block of %s: %w", blk, err)
+	}
+	n, err := page.GetNumRecords(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("get number of records of %s: %w", blk, err)
+	}
+	for slot := range n {
+		value, err := page.GetDataValue(ctx, slot)
+		if err != nil {
+			return 0, nil, fmt.Errorf("get key at slot %d of %s: %w", slot, blk, err)
+		}
+		rid, err := page.GetDataRID(ctx, slot)
+		if err != nil {
+			return 0, nil, fmt.Errorf("get rid at slot %d of %s: %w", slot, blk, err)
+		}
+		entries = append(entries, IndexEntry{Value: value, RID: *rid})
+	}
+	return overflow, entries, nil
+}
+
+// 全てのentryを消し, 空のleafを1つだけ持つ木に戻す. 作り直した後はInsertで入れ直す
+// それまで使っていたblockはファイルに残るが, どこからも指されない
+func (b *BTreeIndex) Reset(ctx context.Context) error {
+	if err := b.Close(ctx); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	leafBlk := dbfile.NewBlockID(b.leafTable, 0)
+	leaf, err := NewBTreePage(ctx, b.tx, &leafBlk, b.leafLayout)
+	if err != nil {
+		return fmt.Errorf("new btree page: %w", err)
+	}
+	defer leaf.Close(ctx)
+	if err := leaf.SetFlag(ctx, -1); err != nil {
+		return fmt.Errorf("set flag of %s: %w", leafBlk, err)
+	}
+	if err := leaf.SetNumRecords(ctx, 0); err != nil {
+		return fmt.Errorf("set number of records of %s: %w", leafBlk, err)
+	}
+
+	root, err := NewBTreePage(ctx, b.tx, &b.rootBlock, b.dirLayout)
+	if err != nil {
+		return fmt.Errorf("new btree page: %w", err)
+	}
+	defer root.Close(ctx)
+	if err := root.SetFlag(ctx, 0); err != nil {
+		return fmt.Errorf("set flag of %s: %w", b.rootBlock, err)
+	}
+	if err := root.SetNumRecords(ctx, 0); err != nil {
+		return fmt.Errorf("set number of records of %s: %w", b.rootBlock, err)
+	}
+	if err := root.InsertDir(ctx, 0, minDataValue(b.dirLayout), leafBlk.BlockNum()); err != nil {
+		return fmt.Errorf("insert dir: %w", err)
+	}
+	return nil
+}
@@ -175,12 +175,19 @@ func (b *BTreeDir) findChildBlock(ctx context.Context, searchKey dbconstant.Cons
 		return dbfile.BlockID{}, fmt.Errorf("find child block: %w", err)
 	}
 	// 次のスロットと一致している時だけ進める（左閉区間
-	val, err := b.contents.GetDataValue(ctx, slot+1)
+	// レコード数より後ろのスロットには古い値が残っていることがあるので見ない
+	n, err := b.contents.GetNumRecords(ctx)
 	if err != nil {
-		return dbfile.BlockID{}, fmt.Errorf("gat data value: %w", err)
+		return dbfile.BlockID{}, fmt.Errorf("get num records: %w", err)
 	}
-	if val.Equals(searchKey) {
-		slot++
+	if slot+1 < n {
+		val, err := b.contents.GetDataValue(ctx, slot+1)
+		if err != nil {
+			return dbfile.BlockID{}, fmt.Errorf("gat data value: %w", err)
+		}
+		if val.Equals(searchKey) {
+			slot++
+		}
 	}
 
 	blk, err := b.contents.GetChildNum(ctx, slot)
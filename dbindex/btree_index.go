@@ -21,8 +21,14 @@ type BTreeIndex struct {
 	rootBlock             dbfile.BlockID
 }
 
+// idxNameのindexが使うファイル名. leaf, dirの順
+func BTreeIndexFileNames(idxName string) []string {
+	return []string{idxName + "leaf", idxName + "dir"}
+}
+
 func NewBTreeIndex(ctx context.Context, tx *dbtx.Transaction, idxName string, leafLayout *dbrecord.Layout) (*BTreeIndex, error) {
-	leafTable := idxName + "leaf"
+	fileNames := BTreeIndexFileNames(idxName)
+	leafTable := fileNames[0]
 	leafTableSize, err := tx.Size(ctx, leafTable)
 	if err != nil {
 		return nil, fmt.Errorf("get size of leaf table %q: %w", leafTable, err)
@@ -47,7 +53,7 @@ func NewBTreeIndex(ctx context.Context, tx *dbtx.Transaction, idxName string, le
 	dirSchema.Add(dbname.IndexFieldBlock, leafLayout.Schema())
 	dirSchema.Add(dbname.IndexFieldDataValue, leafLayout.Schema())
 
-	dirTable := idxName + "dir"
+	dirTable := fileNames[1]
 	dirLayout := dbrecord.NewLayout(dirSchema)
 	rootBlock := dbfile.NewBlockID(dirTable, 0)
 
@@ -67,14 +73,7 @@ func NewBTreeIndex(ctx context.Context, tx *dbtx.Transaction, idxName string, le
 		if err := node.Format(ctx, rootBlock, 0); err != nil {
 			return nil, fmt.Errorf("format: %w", err)
 		}
-		fieldType := dirLayout.Schema().FieldType(dbname.IndexFieldDataValue)
-		var minValue dbconstant.Constant
-		if fieldType == dbrecord.FieldTypeInt {
-			minValue = dbconstant.NewIntConstant(math.MinInt)
-		} else {
-			minValue = dbconstant.NewStringConstant("")
-		}
-		if err := node.InsertDir(ctx, 0, minValue, rootBlock.BlockNum()); err != nil {
+		if err := node.InsertDir(ctx, 0, minDataValue(dirLayout), rootBlock.BlockNum()); err != nil {
 			return nil, fmt.Errorf("insert dir: %w", err)
 		}
 		if err := node.Close(ctx); err != nil {
@@ -91,6 +90,14 @@ func NewBTreeIndex(ctx context.Context, tx *dbtx.Transaction, idxName string, le
 	}, nil
 }
 
+// rootの先頭に置く番兵. どのkeyよりも小さい
+func minDataValue(dirLayout *dbrecord.Layout) dbconstant.Constant {
+	if dirLayout.Schema().FieldType(dbname.IndexFieldDataValue) == dbrecord.FieldTypeInt {
+		return dbconstant.NewIntConstant(math.MinInt)
+	}
+	return dbconstant.NewStringConstant("")
+}
+
 func (b *BTreeIndex) BeforeFirst(ctx context.Context, searchKey dbconstant.Constant) (err error) {
 	if err := b.Close(ctx); err != nil {
 		return fmt.Errorf("close: %w", err)
@@ -136,6 +143,10 @@ func (b *BTreeIndex) Close(ctx context.Context) error {
 
 // インデックスに挿入する
 func (b *BTreeIndex) Insert(ctx context.Context, dataValue dbconstant.Constant, dataRID dbrecord.RID) (err error) {
+	// NULLはindexに登録しない
+	if dbconstant.IsNull(dataValue) {
+		return nil
+	}
 	if err := b.BeforeFirst(ctx, dataValue); err != nil {
 		return fmt.Errorf("before first: %w", err)
 	}
@@ -172,6 +183,9 @@ func (b *BTreeIndex) Insert(ctx context.Context, dataValue dbconstant.Constant,
 }
 
 func (b *BTreeIndex) Delete(ctx context.Context, dataValue dbconstant.Constant, dataRID dbrecord.RID) error {
+	if dbconstant.IsNull(dataValue) {
+		return nil
+	}
 	if err := b.BeforeFirst(ctx, dataValue); err != nil {
 		return fmt.Errorf("before first: %w", err)
 	}
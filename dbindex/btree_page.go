@@ -127,7 +127,8 @@ func (b *BTreePage) AppendNew(ctx context.Context, flag int) (dbfile.BlockID, er
 }
 
 func (b *BTreePage) Format(ctx context.Context, blk dbfile.BlockID, flag int) error {
-	if err := b.tx.SetInt(ctx, blk, 0, flag, false); err != nil {
+	// flagだけは0でないことがあるので, logからredoできるようにする
+	if err := b.tx.SetInt(ctx, blk, 0, flag, true); err != nil {
 		return fmt.Errorf("set flag: %w", err)
 	}
 	// set number of records
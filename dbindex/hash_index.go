@@ -87,6 +87,10 @@ func (h *HashIndex) GetDataRID(ctx context.Context) (dbrecord.RID, error) {
 
 // dataRIDをインデックスに記録する
 func (h *HashIndex) Insert(ctx context.Context, val dbconstant.Constant, dataRID dbrecord.RID) error {
+	// NULLはindexに登録しない
+	if dbconstant.IsNull(val) {
+		return nil
+	}
 	if err := h.BeforeFirst(ctx, val); err != nil {
 		return fmt.Errorf("before first while inserting to %q: %w", &dataRID, err)
 	}
@@ -106,6 +110,9 @@ func (h *HashIndex) Insert(ctx context.Context, val dbconstant.Constant, dataRID
 }
 
 func (h *HashIndex) Delete(ctx context.Context, val dbconstant.Constant, dataRID dbrecord.RID) error {
+	if dbconstant.IsNull(val) {
+		return nil
+	}
 	if err := h.BeforeFirst(ctx, val); err != nil {
 		return fmt.Errorf("before first while inserting to %q: %w", &dataRID, err)
 	}
@@ -0,0 +1,68 @@
+package dblog
+
+import (
+	"log/slog"
+	"maps"
+	"sync"
+)
+
+// 実行中のtransactionとSTART recordのLSN
+// checkpointに記録する内容がlogの順序と食い違わないよう, START/COMMIT/ROLLBACKとcheckpointのlog recordは表のlock内で書く
+type activeTxTable struct {
+	mu  sync.Mutex
+	txs map[uint64]int
+}
+
+func newActiveTxTable() *activeTxTable {
+	return &activeTxTable{txs: make(map[uint64]int)}
+}
+
+// このlogに書き込む次のtransactionの番号
+func (lm *LogManager) NextTxNum() uint64 {
+	txNum := lm.txNum.Add(1)
+	slog.Debug("new transaction", slog.Uint64("nextTx", txNum))
+	return txNum
+}
+
+// writeでSTART recordを書き, txNumを実行中にする
+func (lm *LogManager) StartTx(txNum uint64, write func() (int, error)) (int, error) {
+	a := lm.activeTxs
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lsn, err := write()
+	if err != nil {
+		return 0, err
+	}
+	a.txs[txNum] = lsn
+	return lsn, nil
+}
+
+// writeでCOMMIT/ROLLBACK recordを書き, txNumを実行中から外す
+func (lm *LogManager) FinishTx(txNum uint64, write func() (int, error)) (int, error) {
+	a := lm.activeTxs
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lsn, err := write()
+	if err != nil {
+		return 0, err
+	}
+	delete(a.txs, txNum)
+	return lsn, nil
+}
+
+// 実行中のtransactionを渡してwriteでcheckpointを書く
+func (lm *LogManager) WriteCheckpoint(write func(active map[uint64]int) (int, error)) (int, error) {
+	a := lm.activeTxs
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return write(maps.Clone(a.txs))
+}
+
+// checkpointとbackupを同時に行わない. backup中にcheckpointでlogを捨てると, backupに必要なsegmentが消える
+func (lm *LogManager) LockCheckpoint() {
+	lm.checkpointMu.Lock()
+}
+
+func (lm *LogManager) UnlockCheckpoint() {
+	lm.checkpointMu.Unlock()
+}
@@ -0,0 +1,197 @@
+package dblog
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+type LogOption func(*LogManager)
+
+// commit時のflushを1つのflusherにまとめて行う(group commit)
+// flusherは最初の要求からmaxDelayだけ他のcommitを待ち, 集まった分を1回の書き込みでflushする
+// maxDelayが0でも, 書き込み中に溜まった要求は次の1回にまとめる
+func WithGroupCommit(maxDelay time.Duration) LogOption {
+	return func(lm *LogManager) {
+		lm.groupCommitter = &groupCommitter{
+			maxDelay: maxDelay,
+			requests: make(chan flushRequest),
+			stopCh:   make(chan struct{}),
+			doneCh:   make(chan struct{}),
+		}
+	}
+}
+
+type groupCommitter struct {
+	maxDelay time.Duration
+	requests chan flushRequest
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+
+	mu    sync.Mutex
+	stats GroupCommitStats
+}
+
+type flushRequest struct {
+	lsn  int
+	done chan error
+}
+
+// group commitで達成したbatchの大きさ
+type GroupCommitStats struct {
+	// flusherがlog pageを書いた回数
+	Flushes int
+	// flushを待ったcommitの数
+	Commits int
+	// 1回のflushでまとめたcommitの最大数
+	MaxBatchSize int
+	// batchの大きさごとのflush回数
+	BatchSizes map[int]int
+}
+
+func (s GroupCommitStats) AvgBatchSize() float64 {
+	if s.Flushes == 0 {
+		return 0
+	}
+	return float64(s.Commits) / float64(s.Flushes)
+}
+
+// commit用のflush. lsnまでflushされるまで待つ
+// group commitが無効な場合はFlushWithLSNと同じ
+func (lm *LogManager) GroupFlush(lsn int) error {
+	gc := lm.groupCommitter
+	if gc == nil {
+		return lm.FlushWithLSN(lsn)
+	}
+	lm.mu.RLock()
+	saved := lsn <= lm.state.lastSavedLSN
+	lm.mu.RUnlock()
+	if saved {
+		return nil
+	}
+	req := flushRequest{lsn: lsn, done: make(chan error, 1)}
+	select {
+	case gc.requests <- req:
+	case <-gc.stopCh:
+		return fmt.Errorf("log manager for %q is closed", lm.logFileName)
+	}
+	return <-req.done
+}
+
+func (lm *LogManager) GroupCommitStats() GroupCommitStats {
+	gc := lm.groupCommitter
+	if gc == nil {
+		return GroupCommitStats{}
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	stats := gc.stats
+	stats.BatchSizes = maps.Clone(gc.stats.BatchSizes)
+	return stats
+}
+
+func (lm *LogManager) ResetGroupCommitStats() {
+	gc := lm.groupCommitter
+	if gc == nil {
+		return
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.stats = GroupCommitStats{}
+}
+
+// flusherを止める. 受け付けた要求はflushしてから止まる
+func (lm *LogManager) Close() {
+	gc := lm.groupCommitter
+	if gc == nil {
+		return
+	}
+	close(gc.stopCh)
+	<-gc.doneCh
+}
+
+func (lm *LogManager) runGroupCommitter() {
+	gc := lm.groupCommitter
+	defer close(gc.doneCh)
+	for {
+		var batch []flushRequest
+		select {
+		case <-gc.stopCh:
+			return
+		case req := <-gc.requests:
+			batch = append(batch, req)
+		}
+		if gc.maxDelay > 0 {
+			timer := time.NewTimer(gc.maxDelay)
+		wait:
+			for {
+				select {
+				case req := <-gc.requests:
+					batch = append(batch, req)
+				case <-timer.C:
+					break wait
+				}
+			}
+			timer.Stop()
+		}
+		// 既に待っている要求もまとめる
+	drain:
+		for {
+			select {
+			case req := <-gc.requests:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+
+		upTo := 0
+		for _, req := range batch {
+			upTo = max(upTo, req.lsn)
+		}
+		err := lm.flushSnapshot(upTo)
+		for _, req := range batch {
+			req.done <- err
+		}
+		gc.record(len(batch))
+	}
+}
+
+func (gc *groupCommitter) record(batchSize int) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.stats.BatchSizes == nil {
+		gc.stats.BatchSizes = make(map[int]int)
+	}
+	gc.stats.Flushes++
+	gc.stats.Commits += batchSize
+	gc.stats.MaxBatchSize = max(gc.stats.MaxBatchSize, batchSize)
+	gc.stats.BatchSizes[batchSize]++
+}
+
+// lsnまで書かれていなければlog pageの写しを最新のLSNまで書く. 書いている間も他のtransactionはAppendできる
+func (lm *LogManager) flushSnapshot(lsn int) error {
+	lm.mu.Lock()
+	if lsn <= lm.state.lastSavedLSN {
+		// Appendでblockが溢れた時などに既に書かれた
+		lm.mu.Unlock()
+		return nil
+	}
+	blk := lm.state.currentBlock
+	p := lm.state.logPage.Clone()
+	latestLSN := lm.state.latestLSN
+	// lockを外す前にflushMuを取り, 後から書かれる新しい内容を古い写しで上書きしないようにする
+	lm.flushMu.Lock()
+	lm.mu.Unlock()
+	err := lm.writeBlock(blk, p)
+	lm.flushMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("flush log page to block %s: %w", blk, err)
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.state.lastSavedLSN = max(lm.state.lastSavedLSN, latestLSN)
+	return nil
+}
@@ -1,65 +1,261 @@
 package dblog
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"iter"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 
+	"github.com/teru01/simpledb-go/dberr"
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dbsize"
 )
 
+// log recordの前に置くLSNとchecksumの大きさ. 内容はその後ろにlength, payloadの順に置く
+const recordHeaderSize = 2 * dbsize.IntSize
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type LogManager struct {
 	mu          sync.RWMutex
 	fileManager *dbfile.FileManager
+	// segmentのファイル名の接頭辞
 	logFileName string
 	state       logManagerState
+	// log pageの書き込みを直列にする. muより後に取る
+	flushMu sync.Mutex
+	// nilならgroup commitは無効
+	groupCommitter *groupCommitter
+	// 1 segmentのblock数
+	segmentBlocks int
+	// 書き終えたsegmentを写すディレクトリ. 空ならarchiveしない
+	archiveDir string
+	// pageの変更のlogとcheckpointの開始を排他する
+	redoMu sync.RWMutex
+	// 最後に始めたcheckpointのredoを始めるLSN. これより前に最後に変更されたpageは次の変更の前にfull page imageを残す
+	redoLSN int
+	// このlogに書き込む実行中のtransaction
+	activeTxs *activeTxTable
+	// checkpointとbackupを排他する
+	checkpointMu sync.Mutex
+	// 最後に振ったtransaction番号
+	txNum atomic.Uint64
 }
 
 type logManagerState struct {
-	logPage      *dbfile.Page
+	logPage *dbfile.Page
+	// 書き込み中のsegment内のblock
 	currentBlock dbfile.BlockID
 	latestLSN    int
 	lastSavedLSN int
+	// 残っている最も古いsegmentと書き込み中のsegmentの番号
+	firstSegment   int
+	currentSegment int
 }
 
-func NewLogManager(fm *dbfile.FileManager, logFileName string) (*LogManager, error) {
-	var (
-		currentBlock dbfile.BlockID
-		err          error
-	)
+// LSNつきのlog record
+type Record struct {
+	LSN  int
+	Data []byte
+}
+
+// logのblockの位置
+type logPosition struct {
+	segment int
+	block   int
+}
 
+func NewLogManager(fm *dbfile.FileManager, logFileName string, opts ...LogOption) (*LogManager, error) {
 	lm := LogManager{
-		fileManager: fm,
-		logFileName: logFileName,
+		fileManager:   fm,
+		logFileName:   logFileName,
+		segmentBlocks: DefaultSegmentBlocks,
+		activeTxs:     newActiveTxTable(),
+	}
+	for _, opt := range opts {
+		opt(&lm)
 	}
 
-	logSize, err := fm.FileBlockLength(logFileName)
-	if err != nil {
-		return nil, fmt.Errorf("get file block length for log file %q: %w", logFileName, err)
+	if err := lm.openLocked(); err != nil {
+		return nil, fmt.Errorf("open log %q: %w", logFileName, err)
 	}
+	if lm.groupCommitter != nil {
+		go lm.runGroupCommitter()
+	}
+
+	return &lm, nil
+}
 
-	b := make([]byte, fm.BlockSize())
-	p := dbfile.NewPageFromBytes(b)
+// ディスク上のsegmentから状態を読み直す
+func (lm *LogManager) openLocked() error {
+	segments, err := lm.segments()
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		segments = []int{0}
+	}
+	first, current := segments[0], segments[len(segments)-1]
+	if len(segments) != current-first+1 {
+		return fmt.Errorf("log segments between %d and %d are missing", first, current)
+	}
 
-	if logSize == 0 {
-		currentBlock, err = lm.appendNewBlockLocked(p)
+	fileName := SegmentFileName(lm.logFileName, current)
+	size, err := lm.fileManager.FileBlockLength(fileName)
+	if err != nil {
+		return fmt.Errorf("get file block length for log segment %q: %w", fileName, err)
+	}
+	p := dbfile.NewPage(lm.fileManager.BlockSize())
+	var currentBlock dbfile.BlockID
+	if size == 0 {
+		currentBlock, err = lm.appendNewBlockLocked(fileName, p)
 	} else {
-		currentBlock = dbfile.NewBlockID(lm.logFileName, logSize-1)
+		currentBlock = dbfile.NewBlockID(fileName, size-1)
 		err = lm.fileManager.Read(currentBlock, p)
+		if dberr.IsCode(err, dberr.CodeChecksumMismatch) {
+			err = lm.repairTailLocked(currentBlock, p)
+		}
 	}
 	if err != nil {
-		return nil, fmt.Errorf("initialize log page for log file %q: %w", logFileName, err)
+		return fmt.Errorf("initialize log page for log segment %q: %w", fileName, err)
 	}
 
 	lm.state = logManagerState{
-		logPage:      p,
-		currentBlock: currentBlock,
-		latestLSN:    0,
-		lastSavedLSN: 0,
+		logPage:        p,
+		currentBlock:   currentBlock,
+		firstSegment:   first,
+		currentSegment: current,
 	}
+	// page LSNと比較するので, 再起動してもLSNは前回の続きから振る
+	for seg := current; seg >= first; seg-- {
+		lsn, ok, err := lm.lastLSNLocked(seg)
+		if err != nil {
+			return fmt.Errorf("find last LSN in log segment %d: %w", seg, err)
+		}
+		if ok {
+			lm.state.latestLSN = lsn
+			break
+		}
+	}
+	lm.state.lastSavedLSN = lm.state.latestLSN
+	// 起動前のcheckpointより後の変更がディスクに乗っているかは分からないので, 全てのpageは次の変更の前にimageを残す
+	lm.redoLSN = lm.state.latestLSN + 1
 
-	return &lm, nil
+	// 前回archiveする前に止まったsegmentを写す
+	for seg := first; seg < current; seg++ {
+		if err := lm.archiveLocked(seg); err != nil {
+			slog.Error("failed to archive log segment", "segment", seg, "error", err)
+			break
+		}
+	}
+	return nil
+}
+
+// 書き込み中にcrashして壊れた末尾のblockを, 壊れていない最も古いlog recordまでに切り詰めて書き直す
+// 末尾のblockはflushのたびに書き直すので, 書きかけのblockが残るのはcrashの通常の結果である
+// 前回までにflushしたlog recordは同じ位置に同じ内容で書き直されるので, 壊れるのは最後のflushで追加したlog recordだけ
+// それらのcommitはflushが終わる前に返っていないので, 捨ててもよい
+func (lm *LogManager) repairTailLocked(blk dbfile.BlockID, p *dbfile.Page) error {
+	if err := lm.fileManager.ReadUnverified(blk, p); err != nil {
+		return fmt.Errorf("read torn log block %s: %w", blk, err)
+	}
+	boundary := validRecordsStart(p)
+	if err := p.SetInt(0, boundary); err != nil {
+		return err
+	}
+	slog.Warn("truncated torn log block", "block", blk.String(), "records", len(recordsInPage(p)))
+	return lm.writeBlock(blk, p)
+}
+
+// pageの末尾から続く, checksumが合いLSNが連続するlog recordの並びのうち最も長いものの開始位置
+// 最後のflushで追加したlog recordの一部が古い内容のまま残った場合, それより前(古い方)までにする
+func validRecordsStart(p *dbfile.Page) int {
+	for start := dbsize.IntSize; start < p.Length(); start++ {
+		if validRecordsFrom(p, start) {
+			return start
+		}
+	}
+	return p.Length()
+}
+
+// startからpageの末尾までが壊れていないlog recordで埋まっているか
+func validRecordsFrom(p *dbfile.Page, start int) bool {
+	prevLSN := -1
+	for j := start; j < p.Length(); {
+		dataPos := j + recordHeaderSize
+		if dataPos+dbsize.IntSize > p.Length() {
+			return false
+		}
+		n := p.GetInt(dataPos)
+		if n < 0 || dataPos+dbsize.IntSize+n > p.Length() {
+			return false
+		}
+		lsn := p.GetInt(j)
+		data := p.GetBytes(dataPos)
+		if uint32(p.GetInt(j+dbsize.IntSize)) != recordChecksum(lsn, data) {
+			return false
+		}
+		// 新しい順に並ぶので, LSNは1ずつ減る
+		if prevLSN >= 0 && lsn != prevLSN-1 {
+			return false
+		}
+		prevLSN = lsn
+		j = dataPos + dbsize.IntSize + n
+	}
+	return true
+}
+
+// LSNと内容のCRC32C
+func recordChecksum(lsn int, data []byte) uint32 {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(lsn))
+	return crc32.Update(crc32.Checksum(b[:], castagnoli), castagnoli, data)
+}
+
+// log recordがblock内で占める大きさ
+func recordLength(data []byte) int {
+	return recordHeaderSize + dbsize.IntSize + len(data)
+}
+
+// segmentの最後のlog recordのLSN. segmentが空の場合はfalse
+func (lm *LogManager) lastLSNLocked(segment int) (int, bool, error) {
+	n, err := lm.segmentLengthLocked(segment)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := n - 1; i >= 0; i-- {
+		records, err := lm.readBlockLocked(dbfile.NewBlockID(SegmentFileName(lm.logFileName, segment), i))
+		if err != nil {
+			return 0, false, err
+		}
+		if len(records) > 0 {
+			return records[0].LSN, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// segmentのblock数. 書き込み中のsegmentはcurrentBlockまで
+func (lm *LogManager) segmentLengthLocked(segment int) (int, error) {
+	if segment == lm.state.currentSegment {
+		return lm.state.currentBlock.BlockNum() + 1, nil
+	}
+	fileName := SegmentFileName(lm.logFileName, segment)
+	n, err := lm.fileManager.FileBlockLength(fileName)
+	if err != nil {
+		return 0, fmt.Errorf("get file block length for log segment %q: %w", fileName, err)
+	}
+	return n, nil
+}
+
+func (lm *LogManager) LatestLSN() int {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	return lm.state.latestLSN
 }
 
 // 指定のlog sequenceまでのflushを保証する
@@ -81,85 +277,265 @@ func (lm *LogManager) Flush() error {
 
 // 最新のlog sequenceまでFlushする
 func (lm *LogManager) flushlocked() error {
-	if err := lm.fileManager.Write(lm.state.currentBlock, lm.state.logPage); err != nil {
+	lm.flushMu.Lock()
+	defer lm.flushMu.Unlock()
+	if err := lm.writeBlock(lm.state.currentBlock, lm.state.logPage); err != nil {
 		return fmt.Errorf("flush log page to block %s: %w", lm.state.currentBlock, err)
 	}
 	lm.state.lastSavedLSN = lm.state.latestLSN
 	return nil
 }
 
+// log recordはLSN, checksum, 長さ, 内容の順にblockの末尾から詰めていく
 func (lm *LogManager) Append(logRecord []byte) (int, error) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
 	boundary := lm.state.logPage.GetInt(0)
-	recordSize := len(logRecord)
-	bytesNeeded := recordSize + dbsize.IntSize
+	bytesNeeded := recordLength(logRecord)
 	if boundary-bytesNeeded < dbsize.IntSize {
 		// はみ出る
 		if err := lm.flushlocked(); err != nil {
 			return 0, fmt.Errorf("flush log page before appending new block: %w", err)
 		}
-		blk, err := lm.appendNewBlockLocked(lm.state.logPage)
-		if err != nil {
-			return 0, fmt.Errorf("append new block to log file %q: %w", lm.logFileName, err)
+		if err := lm.nextBlockLocked(); err != nil {
+			return 0, err
 		}
-		lm.state.currentBlock = blk
 		boundary = lm.state.logPage.GetInt(0)
 	}
 
+	lsn := lm.state.latestLSN + 1
 	recordPos := boundary - bytesNeeded
-	if err := lm.state.logPage.SetBytes(recordPos, logRecord); err != nil {
+	if err := lm.state.logPage.SetInt(recordPos, lsn); err != nil {
+		return 0, err
+	}
+	if err := lm.state.logPage.SetInt(recordPos+dbsize.IntSize, int(recordChecksum(lsn, logRecord))); err != nil {
+		return 0, err
+	}
+	if err := lm.state.logPage.SetBytes(recordPos+recordHeaderSize, logRecord); err != nil {
 		return 0, err
 	}
 	if err := lm.state.logPage.SetInt(0, recordPos); err != nil {
 		return 0, err
 	}
-	lm.state.latestLSN++
-	return lm.state.latestLSN, nil
+	lm.state.latestLSN = lsn
+	return lsn, nil
+}
+
+// 書き込み先を次のblockに移す. segmentがいっぱいなら次のsegmentを作り, 書き終えたsegmentをarchiveする
+func (lm *LogManager) nextBlockLocked() error {
+	fileName := lm.state.currentBlock.FileName()
+	full := lm.state.currentBlock.BlockNum()+1 >= lm.segmentBlocks
+	if full {
+		fileName = SegmentFileName(lm.logFileName, lm.state.currentSegment+1)
+	}
+	blk, err := lm.appendNewBlockLocked(fileName, lm.state.logPage)
+	if err != nil {
+		return err
+	}
+	lm.state.currentBlock = blk
+	if !full {
+		return nil
+	}
+	completed := lm.state.currentSegment
+	lm.state.currentSegment++
+	// archiveに失敗してもlogの書き込みは続ける. 写していないsegmentはTruncateで消さない
+	if err := lm.archiveLocked(completed); err != nil {
+		slog.Error("failed to archive log segment", "segment", completed, "error", err)
+	}
+	return nil
 }
 
 // log fileに1ブロック追加しページを初期化する. lock前提
-func (lm *LogManager) appendNewBlockLocked(p *dbfile.Page) (dbfile.BlockID, error) {
-	block, err := lm.fileManager.Append(lm.logFileName)
+func (lm *LogManager) appendNewBlockLocked(fileName string, p *dbfile.Page) (dbfile.BlockID, error) {
+	block, err := lm.fileManager.Append(fileName)
 	if err != nil {
-		return dbfile.BlockID{}, fmt.Errorf("append new block to log file %q: %w", lm.logFileName, err)
+		return dbfile.BlockID{}, fmt.Errorf("append new block to log file %q: %w", fileName, err)
 	}
 	if err := p.SetInt(0, lm.fileManager.BlockSize()); err != nil {
 		return dbfile.BlockID{}, err
 	}
-	if err := lm.fileManager.Write(block, p); err != nil {
+	if err := lm.writeBlock(block, p); err != nil {
 		return dbfile.BlockID{}, err
 	}
 	return block, nil
 }
 
-func (lm *LogManager) Iterator() (iter.Seq2[[]byte, error], error) {
-	if err := lm.Flush(); err != nil {
-		return nil, fmt.Errorf("flush log before creating iterator: %w", err)
+// log pageを書いてディスクに乗るまで待つ. FileManagerがO_SYNCで書かない場合はここでfsyncする
+func (lm *LogManager) writeBlock(blk dbfile.BlockID, p *dbfile.Page) error {
+	if err := lm.fileManager.Write(blk, p); err != nil {
+		return err
 	}
+	return lm.fileManager.Sync(blk.FileName())
+}
 
-	// appendしかされず過去のブロックは変更されないのでcurrent blockだけread lockする
-	lm.mu.RLock()
-	currentBlk := lm.state.currentBlock
-	lm.mu.RUnlock()
+// 新しい順にlog recordを返す
+func (lm *LogManager) Iterator() (iter.Seq2[[]byte, error], error) {
+	records, err := lm.RecordIterator()
+	if err != nil {
+		return nil, err
+	}
 	return func(yield func([]byte, error) bool) {
-		var iterError error
-		for i := currentBlk.BlockNum(); i >= 0; i-- {
-			currentBlock := dbfile.NewBlockID(lm.logFileName, i)
-			p := dbfile.NewPage(lm.fileManager.BlockSize())
-			if err := lm.fileManager.Read(currentBlock, p); err != nil {
-				iterError = fmt.Errorf("read log block %s: %w", currentBlock, err)
-				if !yield(nil, iterError) {
+		for record, err := range records {
+			if !yield(record.Data, err) {
+				return
+			}
+		}
+	}, nil
+}
+
+// 新しい順にLSNつきのlog recordを返す
+func (lm *LogManager) RecordIterator() (iter.Seq2[Record, error], error) {
+	blocks, err := lm.flushedBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(Record, error) bool) {
+		for _, pos := range slices.Backward(blocks) {
+			records, err := lm.readBlock(pos)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			for _, record := range records {
+				if !yield(record, nil) {
 					return
 				}
 			}
-			boundary := p.GetInt(0)
-			for j := boundary; j < p.Length(); j += p.GetInt(j) + dbsize.IntSize {
-				if !yield(p.GetBytes(j), nil) {
+		}
+	}, nil
+}
+
+// LSNがfromLSN以上のlog recordを古い順に返す
+func (lm *LogManager) ForwardIterator(fromLSN int) (iter.Seq2[Record, error], error) {
+	blocks, err := lm.flushedBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(Record, error) bool) {
+		// fromLSNを含むblockまで遡る
+		start := len(blocks) - 1
+		for ; start > 0; start-- {
+			records, err := lm.readBlock(blocks[start])
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if len(records) > 0 && records[len(records)-1].LSN <= fromLSN {
+				break
+			}
+		}
+		for _, pos := range blocks[start:] {
+			records, err := lm.readBlock(pos)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			for _, record := range slices.Backward(records) {
+				if record.LSN < fromLSN {
+					continue
+				}
+				if !yield(record, nil) {
 					return
 				}
 			}
 		}
 	}, nil
 }
+
+// iteratorが辿るblockを古い順に返す
+// appendしかされず過去のブロックは変更されないので, 読む時にはblockごとにread lockする
+func (lm *LogManager) flushedBlocks() ([]logPosition, error) {
+	if err := lm.Flush(); err != nil {
+		return nil, fmt.Errorf("flush log before creating iterator: %w", err)
+	}
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	var blocks []logPosition
+	for seg := lm.state.firstSegment; seg <= lm.state.currentSegment; seg++ {
+		n, err := lm.segmentLengthLocked(seg)
+		if err != nil {
+			return nil, err
+		}
+		for i := range n {
+			blocks = append(blocks, logPosition{segment: seg, block: i})
+		}
+	}
+	return blocks, nil
+}
+
+// posのblock内のlog recordを新しい順に返す
+func (lm *LogManager) readBlock(pos logPosition) ([]Record, error) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	if pos.segment < lm.state.firstSegment {
+		return nil, fmt.Errorf("log segment %d was truncated", pos.segment)
+	}
+	return lm.readBlockLocked(dbfile.NewBlockID(SegmentFileName(lm.logFileName, pos.segment), pos.block))
+}
+
+func (lm *LogManager) readBlockLocked(blk dbfile.BlockID) ([]Record, error) {
+	p := dbfile.NewPage(lm.fileManager.BlockSize())
+	if err := lm.fileManager.Read(blk, p); err != nil {
+		return nil, fmt.Errorf("read log block %s: %w", blk, err)
+	}
+	return recordsInPage(p), nil
+}
+
+// pageのlog recordを新しい順に返す
+func recordsInPage(p *dbfile.Page) []Record {
+	var records []Record
+	boundary := p.GetInt(0)
+	for j := boundary; j < p.Length(); {
+		data := p.GetBytes(j + recordHeaderSize)
+		records = append(records, Record{LSN: p.GetInt(j), Data: data})
+		j += recordLength(data)
+	}
+	return records
+}
+
+// checkpointのredoを始めるLSNを決めて返す. 以降, それより前に最後に変更されたpageは変更の前にfull page imageを残す
+// 書きかけのpageがディスクに残っても, redoでimageから復元できるようにする
+func (lm *LogManager) BeginCheckpoint() int {
+	lm.redoMu.Lock()
+	defer lm.redoMu.Unlock()
+	lm.redoLSN = lm.LatestLSN() + 1
+	return lm.redoLSN
+}
+
+// LSNがpageLSNのpageへの変更をwriteでlogに書く. imageがtrueなら変更の前にfull page imageを書く必要がある
+// writeの間はcheckpointを始めないので, imageが要らないと判断した後にredoを始めるLSNが変わることはない
+func (lm *LogManager) LogPageChange(pageLSN int, write func(image bool) (int, error)) (int, error) {
+	lm.redoMu.RLock()
+	defer lm.redoMu.RUnlock()
+	return write(pageLSN < lm.redoLSN)
+}
+
+// LSNがbeforeLSNより小さいlog recordしか含まないsegmentを捨て, 捨てたsegmentの数を返す
+// archiveする場合は, 写し終えたsegmentだけを捨てる
+func (lm *LogManager) Truncate(beforeLSN int) (int, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if err := lm.flushlocked(); err != nil {
+		return 0, fmt.Errorf("flush log before truncation: %w", err)
+	}
+	n := 0
+	for seg := lm.state.firstSegment; seg < lm.state.currentSegment; seg++ {
+		lsn, ok, err := lm.lastLSNLocked(seg)
+		if err != nil {
+			return n, err
+		}
+		if ok && lsn >= beforeLSN {
+			break
+		}
+		if err := lm.archiveLocked(seg); err != nil {
+			return n, fmt.Errorf("archive log segment %d before truncation: %w", seg, err)
+		}
+		if err := lm.fileManager.Remove(SegmentFileName(lm.logFileName, seg)); err != nil {
+			return n, fmt.Errorf("remove log segment %d: %w", seg, err)
+		}
+		lm.state.firstSegment = seg + 1
+		n++
+	}
+	return n, nil
+}
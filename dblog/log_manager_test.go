@@ -1,12 +1,18 @@
 package dblog_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dblog"
+	"github.com/teru01/simpledb-go/dbsize"
 )
 
 func setupTestDir(t *testing.T) (*os.File, func()) {
@@ -184,15 +190,15 @@ func TestLogManagerMultipleBlocks(t *testing.T) {
 	}
 
 	// Verify we created multiple blocks by checking file size
-	logPath := filepath.Join(dirFile.Name(), "test.log")
+	logPath := filepath.Join(dirFile.Name(), dblog.SegmentFileName("test.log", 0))
 	info, err := os.Stat(logPath)
 	if err != nil {
 		t.Fatalf("failed to stat log file: %v", err)
 	}
 
 	expectedBlocks := 1
-	if info.Size() > int64(blockSize) {
-		expectedBlocks = int(info.Size()) / blockSize
+	if info.Size() > int64(dbfile.PageHeaderSize+blockSize) {
+		expectedBlocks = int(info.Size()) / (dbfile.PageHeaderSize + blockSize)
 	}
 
 	if expectedBlocks < 2 {
@@ -232,3 +238,407 @@ func TestLogManagerEmptyLog(t *testing.T) {
 		t.Errorf("expected 0 records in empty log, got %d", count)
 	}
 }
+
+func TestLogManagerRecordIterators(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fm, err := dbfile.NewFileManager(dir, 100)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm, err := dblog.NewLogManager(fm, "test.log")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	// 複数blockにまたがるように書く
+	for i := range 10 {
+		if _, err := lm.Append([]byte(fmt.Sprintf("record %02d", i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := lm.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// 再起動してもLSNは続きから振られる
+	lm, err = dblog.NewLogManager(fm, "test.log")
+	if err != nil {
+		t.Fatalf("failed to reopen log manager: %v", err)
+	}
+	lsn, err := lm.Append([]byte("record 10"))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if lsn != 11 {
+		t.Errorf("expected LSN 11 after reopen, got %d", lsn)
+	}
+
+	backward, err := lm.RecordIterator()
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	want := 11
+	for rec, err := range backward {
+		if err != nil {
+			t.Fatalf("iterator error: %v", err)
+		}
+		if rec.LSN != want || string(rec.Data) != fmt.Sprintf("record %02d", want-1) {
+			t.Errorf("expected LSN %d, got %d (%q)", want, rec.LSN, rec.Data)
+		}
+		want--
+	}
+	if want != 0 {
+		t.Errorf("expected to iterate down to LSN 1, stopped at %d", want+1)
+	}
+
+	forward, err := lm.ForwardIterator(4)
+	if err != nil {
+		t.Fatalf("failed to create forward iterator: %v", err)
+	}
+	want = 4
+	for rec, err := range forward {
+		if err != nil {
+			t.Fatalf("iterator error: %v", err)
+		}
+		if rec.LSN != want {
+			t.Errorf("expected LSN %d, got %d", want, rec.LSN)
+		}
+		want++
+	}
+	if want != 12 {
+		t.Errorf("expected to iterate up to LSN 11, stopped at %d", want-1)
+	}
+}
+
+func TestLogManagerTruncate(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fm, err := dbfile.NewFileManager(dir, 100)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	// 1 blockごとにsegmentを分ける
+	lm, err := dblog.NewLogManager(fm, "test.log", dblog.WithSegmentBlocks(1))
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	for i := range 10 {
+		if _, err := lm.Append([]byte(fmt.Sprintf("record %02d", i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	before := countSegments(t, fm, "test.log")
+
+	n, err := lm.Truncate(6)
+	if err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	after := countSegments(t, fm, "test.log")
+	if n == 0 || after != before-n {
+		t.Fatalf("expected %d segments to be removed from %d segments, got %d segments", n, before, after)
+	}
+
+	// LSN 6以降は残り, 以降のappendも続きのLSNになる
+	if lsn, err := lm.Append([]byte("record 10")); err != nil || lsn != 11 {
+		t.Fatalf("expected LSN 11 after truncation, got %d (err=%v)", lsn, err)
+	}
+	it, err := lm.RecordIterator()
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	oldest := 0
+	for rec, err := range it {
+		if err != nil {
+			t.Fatalf("iterator error: %v", err)
+		}
+		oldest = rec.LSN
+	}
+	if oldest > 6 {
+		t.Errorf("expected records from LSN 6 to remain, oldest is %d", oldest)
+	}
+}
+
+func TestLogManagerGroupCommit(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fm, err := dbfile.NewFileManager(dir, 100)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm, err := dblog.NewLogManager(fm, "test.log", dblog.WithGroupCommit(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+
+	// block数個分のrecordを並行にappendしてからまとめてflushを待つ
+	const committers = 20
+	var appended, wg sync.WaitGroup
+	appended.Add(committers)
+	errs := make(chan error, committers)
+	for i := range committers {
+		wg.Go(func() {
+			lsn, err := lm.Append([]byte(fmt.Sprintf("commit %02d", i)))
+			appended.Done()
+			if err != nil {
+				errs <- err
+				return
+			}
+			appended.Wait()
+			if err := lm.GroupFlush(lsn); err != nil {
+				errs <- err
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("commit failed: %v", err)
+	}
+	lm.Close()
+
+	stats := lm.GroupCommitStats()
+	if stats.Flushes == 0 || stats.Flushes >= committers {
+		t.Errorf("expected commits to be batched, got %d flushes for %d commits", stats.Flushes, stats.Commits)
+	}
+
+	// flushを待ったrecordは全てディスクに乗っている
+	lm2, err := dblog.NewLogManager(fm, "test.log")
+	if err != nil {
+		t.Fatalf("failed to reopen log manager: %v", err)
+	}
+	if got := lm2.LatestLSN(); got != committers {
+		t.Errorf("expected latest LSN %d after reopening, got %d", committers, got)
+	}
+}
+
+func countSegments(t *testing.T, fm *dbfile.FileManager, logFileName string) int {
+	t.Helper()
+	files, err := fm.Files()
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	n := 0
+	for _, file := range files {
+		if strings.HasPrefix(file, logFileName+".") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLogManagerArchiveAndRestore(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+	archiveDir := t.TempDir()
+
+	fm, err := dbfile.NewFileManager(dir, 100)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm, err := dblog.NewLogManager(fm, "test.log", dblog.WithSegmentBlocks(2), dblog.WithArchiveDir(archiveDir))
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	for i := range 30 {
+		if _, err := lm.Append([]byte(fmt.Sprintf("record %02d", i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	// 書き込み中のsegment以外はarchiveされている
+	archived, err := os.ReadDir(archiveDir)
+	if err != nil {
+		t.Fatalf("failed to read archive dir: %v", err)
+	}
+	if len(archived) == 0 || len(archived) != countSegments(t, fm, "test.log")-1 {
+		t.Fatalf("expected all completed segments to be archived, got %d archived segments", len(archived))
+	}
+
+	// 空のlogにarchiveを繋げる
+	dir2, cleanup2 := setupTestDir(t)
+	defer cleanup2()
+	fm2, err := dbfile.NewFileManager(dir2, 100)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm2, err := dblog.NewLogManager(fm2, "test.log", dblog.WithSegmentBlocks(2))
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	n, err := lm2.RestoreArchive(archiveDir)
+	if err != nil {
+		t.Fatalf("RestoreArchive failed: %v", err)
+	}
+	if n != len(archived) {
+		t.Errorf("expected %d segments to be restored, got %d", len(archived), n)
+	}
+	restored := lm2.LatestLSN()
+	if restored < 10 || restored >= 30 {
+		t.Fatalf("expected archived records up to the last completed segment, got latest LSN %d", restored)
+	}
+
+	// LSN 10より後を捨てると, 次のrecordはLSN 11になる
+	if err := lm2.DiscardAfter(10); err != nil {
+		t.Fatalf("DiscardAfter failed: %v", err)
+	}
+	if lsn, err := lm2.Append([]byte("record 10")); err != nil || lsn != 11 {
+		t.Fatalf("expected LSN 11 after discarding, got %d (err=%v)", lsn, err)
+	}
+	it, err := lm2.RecordIterator()
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	want := 11
+	for rec, err := range it {
+		if err != nil {
+			t.Fatalf("iterator error: %v", err)
+		}
+		if rec.LSN != want {
+			t.Fatalf("expected LSN %d, got %d", want, rec.LSN)
+		}
+		if got := string(rec.Data); got != fmt.Sprintf("record %02d", want-1) {
+			t.Errorf("LSN %d: expected %q, got %q", want, fmt.Sprintf("record %02d", want-1), got)
+		}
+		want--
+	}
+	if want != 0 {
+		t.Errorf("expected to iterate down to LSN 1, stopped at %d", want+1)
+	}
+}
+
+func TestLogManagerTornTail(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	blockSize := 400
+	fm, err := dbfile.NewFileManager(dir, blockSize)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm, err := dblog.NewLogManager(fm, "test.log")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	appendAndFlush := func(records ...string) {
+		t.Helper()
+		lsn := 0
+		for _, rec := range records {
+			if lsn, err = lm.Append([]byte(rec)); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+		if err := lm.FlushWithLSN(lsn); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+	}
+	logPath := filepath.Join(dir.Name(), dblog.SegmentFileName("test.log", 0))
+	readLog := func() []byte {
+		t.Helper()
+		b, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+		return b
+	}
+
+	appendAndFlush("old 1", "old 2", "old 3")
+	before := readLog()
+	appendAndFlush("new 1", "new 2")
+	after := readLog()
+	if len(before) != dbfile.PageHeaderSize+blockSize || len(after) != len(before) {
+		t.Fatalf("expected the log to fit in one block, got %d and %d bytes", len(before), len(after))
+	}
+
+	// blockの書き込み途中でcrashし, headerと境界は新しく, 追加したrecordの部分は古いままになる
+	oldBoundary := dbfile.NewPageFromBytes(before[dbfile.PageHeaderSize:]).GetInt(0)
+	torn := slices.Clone(after)
+	copy(torn[dbfile.PageHeaderSize+dbsize.IntSize:dbfile.PageHeaderSize+oldBoundary], before[dbfile.PageHeaderSize+dbsize.IntSize:])
+	if err := os.WriteFile(logPath, torn, 0o644); err != nil {
+		t.Fatalf("failed to write torn log: %v", err)
+	}
+
+	// 壊れた末尾はlogの終わりとして扱い, 最後の正しいrecordまでを残す
+	lm, err = dblog.NewLogManager(fm, "test.log")
+	if err != nil {
+		t.Fatalf("expected torn log tail to be repaired, got %v", err)
+	}
+	lsn, err := lm.Append([]byte("after restart"))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if lsn != 4 {
+		t.Errorf("expected LSN to continue from the last valid record, got %d", lsn)
+	}
+	if err := lm.FlushWithLSN(lsn); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	iter, err := lm.Iterator()
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	var got []string
+	for rec, err := range iter {
+		if err != nil {
+			t.Fatalf("iterator error: %v", err)
+		}
+		got = append(got, string(rec))
+	}
+	want := []string{"after restart", "old 3", "old 2", "old 1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected records %q, got %q", want, got)
+	}
+	if _, err := dblog.NewLogManager(fm, "test.log"); err != nil {
+		t.Errorf("expected repaired log to reopen, got %v", err)
+	}
+}
+
+// 実行中のtransactionはlogごとに管理する
+func TestLogManagerActiveTxs(t *testing.T) {
+	newLogManager := func() *dblog.LogManager {
+		t.Helper()
+		dir, cleanup := setupTestDir(t)
+		t.Cleanup(cleanup)
+		fm, err := dbfile.NewFileManager(dir, 400)
+		if err != nil {
+			t.Fatalf("failed to create file manager: %v", err)
+		}
+		lm, err := dblog.NewLogManager(fm, "test.log")
+		if err != nil {
+			t.Fatalf("failed to create log manager: %v", err)
+		}
+		return lm
+	}
+	activeTxs := func(lm *dblog.LogManager) map[uint64]int {
+		t.Helper()
+		var active map[uint64]int
+		if _, err := lm.WriteCheckpoint(func(a map[uint64]int) (int, error) {
+			active = a
+			return 0, nil
+		}); err != nil {
+			t.Fatalf("failed to write checkpoint: %v", err)
+		}
+		return active
+	}
+	lm, other := newLogManager(), newLogManager()
+
+	startLSN, err := lm.StartTx(1, func() (int, error) { return lm.Append([]byte("start")) })
+	if err != nil {
+		t.Fatalf("failed to start transaction: %v", err)
+	}
+	if got := activeTxs(lm); len(got) != 1 || got[1] != startLSN {
+		t.Errorf("expected transaction 1 to be active from LSN %d, got %v", startLSN, got)
+	}
+	if got := activeTxs(other); len(got) != 0 {
+		t.Errorf("expected no active transactions in another log, got %v", got)
+	}
+
+	if _, err := lm.FinishTx(1, func() (int, error) { return lm.Append([]byte("commit")) }); err != nil {
+		t.Fatalf("failed to finish transaction: %v", err)
+	}
+	if got := activeTxs(lm); len(got) != 0 {
+		t.Errorf("expected no active transactions after finish, got %v", got)
+	}
+}
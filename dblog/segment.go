@@ -0,0 +1,241 @@
+package dblog
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/teru01/simpledb-go/dbfile"
+)
+
+// 1 segmentのblock数のデフォルト
+const DefaultSegmentBlocks = 256
+
+// segmentの番号の桁数
+const segmentDigits = 8
+
+// logを分けるsegmentのblock数
+func WithSegmentBlocks(n int) LogOption {
+	return func(lm *LogManager) {
+		lm.segmentBlocks = n
+	}
+}
+
+// 書き終えたsegmentをdirに写す. point-in-time recoveryではbase backupにdirのsegmentを繋げて復元する
+func WithArchiveDir(dir string) LogOption {
+	return func(lm *LogManager) {
+		lm.archiveDir = dir
+	}
+}
+
+// segmentのファイル名. logFileNameの後ろに通し番号をつける
+func SegmentFileName(logFileName string, segment int) string {
+	return fmt.Sprintf("%s.%0*d", logFileName, segmentDigits, segment)
+}
+
+// ディスク上にあるsegmentの番号を古い順に返す
+func (lm *LogManager) segments() ([]int, error) {
+	files, err := lm.fileManager.Files()
+	if err != nil {
+		return nil, fmt.Errorf("list log segments: %w", err)
+	}
+	var segments []int
+	for _, file := range files {
+		if n, ok := lm.segmentNumber(file); ok {
+			segments = append(segments, n)
+		}
+	}
+	slices.Sort(segments)
+	if len(segments) == 0 && slices.Contains(files, lm.logFileName) {
+		// segmentに分ける前のlog fileは最初のsegmentとして使う
+		if err := lm.fileManager.Rename(lm.logFileName, SegmentFileName(lm.logFileName, 0)); err != nil {
+			return nil, fmt.Errorf("convert log file %q to segment: %w", lm.logFileName, err)
+		}
+		segments = []int{0}
+	}
+	return segments, nil
+}
+
+func (lm *LogManager) segmentNumber(fileName string) (int, bool) {
+	suffix, ok := strings.CutPrefix(fileName, lm.logFileName+".")
+	if !ok || len(suffix) != segmentDigits {
+		return 0, false
+	}
+	n, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// fileNameがlogのファイルかどうか
+func (lm *LogManager) IsLogFile(fileName string) bool {
+	_, ok := lm.segmentNumber(fileName)
+	return ok || fileName == lm.logFileName
+}
+
+// 書き終えたsegmentをarchive dirに写す. 同じ内容のものが既にあれば何もしない
+func (lm *LogManager) archiveLocked(segment int) error {
+	if lm.archiveDir == "" {
+		return nil
+	}
+	fileName := SegmentFileName(lm.logFileName, segment)
+	dst := filepath.Join(lm.archiveDir, fileName)
+	if _, err := os.Stat(dst); err == nil {
+		same, err := lm.fileManager.EqualFile(fileName, dst)
+		if err != nil {
+			return fmt.Errorf("compare log segment %q with archive: %w", fileName, err)
+		}
+		if !same {
+			// point-in-time recoveryで捨てた後のlogがarchiveに残っている
+			return fmt.Errorf("archived log segment %s differs from the log. use a new archive directory after point-in-time recovery", dst)
+		}
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("stat archived log segment %s: %w", dst, err)
+	}
+	if err := os.MkdirAll(lm.archiveDir, 0755); err != nil {
+		return fmt.Errorf("create archive dir %s: %w", lm.archiveDir, err)
+	}
+	if err := lm.fileManager.CopyFile(fileName, dst); err != nil {
+		return fmt.Errorf("copy log segment %q to archive: %w", fileName, err)
+	}
+	return nil
+}
+
+// archiveDirにあるsegmentで書き込み中のsegmentとそれ以降を置き換え, logの続きとして繋げる. 繋げたsegmentの数を返す
+// archiveされたsegmentは書き終えたものなので, 書き込み中のsegmentの内容を全て含んでいる
+func (lm *LogManager) RestoreArchive(archiveDir string) (int, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if err := lm.flushlocked(); err != nil {
+		return 0, fmt.Errorf("flush log before restoring archive: %w", err)
+	}
+	n := 0
+	for seg := lm.state.currentSegment; ; seg++ {
+		fileName := SegmentFileName(lm.logFileName, seg)
+		src := filepath.Join(archiveDir, fileName)
+		if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("stat archived log segment %s: %w", src, err)
+		}
+		if err := lm.fileManager.ImportFile(src, fileName); err != nil {
+			return n, fmt.Errorf("restore archived log segment %s: %w", src, err)
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := lm.openLocked(); err != nil {
+		return n, fmt.Errorf("reopen log after restoring archive: %w", err)
+	}
+	return n, nil
+}
+
+// LSNがlsnより後のlog recordを捨てる. point-in-time recoveryで復元先より後のlogを消すのに使う
+// lsnを含むblockより後のblockは空にし, 後のsegmentは削除する
+func (lm *LogManager) DiscardAfter(lsn int) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if err := lm.flushlocked(); err != nil {
+		return fmt.Errorf("flush log before discarding: %w", err)
+	}
+	if lsn >= lm.state.latestLSN {
+		return nil
+	}
+	pos, p, err := lm.findBlockLocked(lsn)
+	if err != nil {
+		return err
+	}
+
+	fileName := SegmentFileName(lm.logFileName, pos.segment)
+	oldBoundary := p.GetInt(0)
+	boundary := oldBoundary
+	for _, record := range recordsInPage(p) {
+		if record.LSN <= lsn {
+			break
+		}
+		boundary += recordLength(record.Data)
+	}
+	if err := p.SetInt(0, boundary); err != nil {
+		return err
+	}
+	// 捨てたlog recordが書きかけのblockの修復で拾われないように消す
+	if boundary > oldBoundary {
+		if err := p.SetRawBytes(oldBoundary, make([]byte, boundary-oldBoundary)); err != nil {
+			return err
+		}
+	}
+	if err := lm.writeBlock(dbfile.NewBlockID(fileName, pos.block), p); err != nil {
+		return fmt.Errorf("write log block %d of segment %d: %w", pos.block, pos.segment, err)
+	}
+
+	n, err := lm.segmentLengthLocked(pos.segment)
+	if err != nil {
+		return err
+	}
+	empty := dbfile.NewPage(lm.fileManager.BlockSize())
+	if err := empty.SetInt(0, lm.fileManager.BlockSize()); err != nil {
+		return err
+	}
+	for i := pos.block + 1; i < n; i++ {
+		if err := lm.writeBlock(dbfile.NewBlockID(fileName, i), empty); err != nil {
+			return fmt.Errorf("clear log block %d of segment %d: %w", i, pos.segment, err)
+		}
+	}
+	for seg := lm.state.currentSegment; seg > pos.segment; seg-- {
+		if err := lm.fileManager.Remove(SegmentFileName(lm.logFileName, seg)); err != nil {
+			return fmt.Errorf("remove log segment %d: %w", seg, err)
+		}
+	}
+	return lm.openLocked()
+}
+
+// LSNがlsnのlog recordを含むblockを新しい方から探す
+func (lm *LogManager) findBlockLocked(lsn int) (logPosition, *dbfile.Page, error) {
+	for seg := lm.state.currentSegment; seg >= lm.state.firstSegment; seg-- {
+		n, err := lm.segmentLengthLocked(seg)
+		if err != nil {
+			return logPosition{}, nil, err
+		}
+		for i := n - 1; i >= 0; i-- {
+			blk := dbfile.NewBlockID(SegmentFileName(lm.logFileName, seg), i)
+			p := dbfile.NewPage(lm.fileManager.BlockSize())
+			if err := lm.fileManager.Read(blk, p); err != nil {
+				return logPosition{}, nil, fmt.Errorf("read log block %s: %w", blk, err)
+			}
+			records := recordsInPage(p)
+			if len(records) > 0 && records[len(records)-1].LSN <= lsn {
+				return logPosition{segment: seg, block: i}, p, nil
+			}
+		}
+	}
+	return logPosition{}, nil, fmt.Errorf("log record with LSN %d was already truncated", lsn)
+}
+
+// logを全てflushしてから, ディスク上のsegmentをdirに写す(online backup用). 写したlogに含まれる最後のLSNを返す
+// 写している間もAppendは止めないが, Truncateでsegmentが消えないようにするのは呼び出し側の責任
+func (lm *LogManager) CopyTo(dir string) (int, error) {
+	lm.mu.Lock()
+	if err := lm.flushlocked(); err != nil {
+		lm.mu.Unlock()
+		return 0, fmt.Errorf("flush log before copying: %w", err)
+	}
+	lsn := lm.state.latestLSN
+	first, current := lm.state.firstSegment, lm.state.currentSegment
+	lm.mu.Unlock()
+	for seg := first; seg <= current; seg++ {
+		fileName := SegmentFileName(lm.logFileName, seg)
+		if err := lm.fileManager.CopyFile(fileName, filepath.Join(dir, fileName)); err != nil {
+			return 0, fmt.Errorf("copy log segment %q: %w", fileName, err)
+		}
+	}
+	return lsn, nil
+}
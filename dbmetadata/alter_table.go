@@ -0,0 +1,318 @@
+package dbmetadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbindex"
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// valueOfは書き直し後のfieldの値を書き直し前のレコードから求める
+type valueOf func(ctx context.Context, src *dbrecord.TableScan, fieldName string) (dbconstant.Constant, error)
+
+func copyValue(ctx context.Context, src *dbrecord.TableScan, fieldName string) (dbconstant.Constant, error) {
+	return src.GetValue(ctx, fieldName)
+}
+
+// 既存のレコードにはdefaultValueを入れる
+func (m *MetadataManager) AddColumn(ctx context.Context, tableName string, fieldName string, fieldType int, length int, defaultValue dbconstant.Constant, tx *dbtx.Transaction) error {
+	layout, err := m.alterableLayout(ctx, tableName, tx)
+	if err != nil {
+		return err
+	}
+	if layout.Schema().HasField(fieldName) {
+		return fmt.Errorf("column %q already exists in %q", fieldName, tableName)
+	}
+	if err := checkDefaultValue(defaultValue, fieldType, length); err != nil {
+		return fmt.Errorf("default value for %q: %w", fieldName, err)
+	}
+	newSchema := dbrecord.NewSchema()
+	newSchema.AddAll(layout.Schema())
+	newSchema.AddField(fieldName, fieldType, length)
+	return m.rewriteTable(ctx, tableName, tableName, layout, newSchema, func(ctx context.Context, src *dbrecord.TableScan, f string) (dbconstant.Constant, error) {
+		if f == fieldName {
+			return defaultValue, nil
+		}
+		return src.GetValue(ctx, f)
+	}, tx)
+}
+
+func checkDefaultValue(defaultValue dbconstant.Constant, fieldType int, length int) error {
+	switch v := defaultValue.(type) {
+	case *dbconstant.NullConstant:
+		return nil
+	case *dbconstant.IntConstant:
+		if fieldType != dbrecord.FieldTypeInt {
+			return fmt.Errorf("int value %s for varchar column", v)
+		}
+	case *dbconstant.StringConstant:
+		if fieldType != dbrecord.FieldTypeString {
+			return fmt.Errorf("string value %q for int column", v)
+		}
+		if len(v.String()) > length {
+			return fmt.Errorf("value %q is longer than %d", v, length)
+		}
+	}
+	return nil
+}
+
+// fieldNameに張られたindexも削除する
+func (m *MetadataManager) DropColumn(ctx context.Context, tableName string, fieldName string, tx *dbtx.Transaction) error {
+	layout, err := m.alterableLayout(ctx, tableName, tx)
+	if err != nil {
+		return err
+	}
+	schema := layout.Schema()
+	if !schema.HasField(fieldName) {
+		return fmt.Errorf("column %q does not exist in %q", fieldName, tableName)
+	}
+	if len(schema.Fields()) == 1 {
+		return fmt.Errorf("cannot drop the only column %q of %q", fieldName, tableName)
+	}
+	indexes, err := m.indexManager.GetIndexInfo(ctx, tableName, tx)
+	if err != nil {
+		return fmt.Errorf("get index info for %q: %w", tableName, err)
+	}
+	if ii, ok := indexes[fieldName]; ok {
+		if err := m.indexManager.DropIndex(ctx, ii.IndexName(), tx); err != nil {
+			return fmt.Errorf("drop index on %q: %w", fieldName, err)
+		}
+	}
+	newSchema := dbrecord.NewSchema()
+	for _, f := range schema.Fields() {
+		if f != fieldName {
+			newSchema.Add(f, schema)
+		}
+	}
+	return m.rewriteTable(ctx, tableName, tableName, layout, newSchema, copyValue, tx)
+}
+
+// offsetは変わらないのでcatalogの書き換えだけで済む
+func (m *MetadataManager) RenameColumn(ctx context.Context, tableName string, fieldName string, newFieldName string, tx *dbtx.Transaction) error {
+	layout, err := m.alterableLayout(ctx, tableName, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(fieldName) {
+		return fmt.Errorf("column %q does not exist in %q", fieldName, tableName)
+	}
+	if layout.Schema().HasField(newFieldName) {
+		return fmt.Errorf("column %q already exists in %q", newFieldName, tableName)
+	}
+	where := map[string]string{"tablename": tableName, "fieldname": fieldName}
+	if _, err := updateCatalogRows(ctx, tx, FieldCatalogTableName, m.tableManager.fieldCatalogLayout, where, "fieldname", newFieldName); err != nil {
+		return fmt.Errorf("rename %q in %q: %w", fieldName, FieldCatalogTableName, err)
+	}
+	if _, err := updateCatalogRows(ctx, tx, IndexCatalogTableName, m.indexManager.layout, where, "fieldname", newFieldName); err != nil {
+		return fmt.Errorf("rename %q in %q: %w", fieldName, IndexCatalogTableName, err)
+	}
+	m.statManager.Invalidate(tableName)
+	return nil
+}
+
+// レコードを新しい名前のファイルに移し、元のファイルはcommit時に削除する
+func (m *MetadataManager) RenameTable(ctx context.Context, tableName string, newTableName string, tx *dbtx.Transaction) error {
+	layout, err := m.alterableLayout(ctx, tableName, tx)
+	if err != nil {
+		return err
+	}
+	if isCatalogTable(newTableName) {
+		return fmt.Errorf("cannot rename to catalog table %q", newTableName)
+	}
+	if err := checkUserTableName(newTableName); err != nil {
+		return err
+	}
+	if _, err := m.tableManager.GetLayout(ctx, newTableName, tx); err == nil {
+		return fmt.Errorf("table %q already exists", newTableName)
+	}
+	return m.rewriteTable(ctx, tableName, newTableName, layout, layout.Schema(), copyValue, tx)
+}
+
+func (m *MetadataManager) alterableLayout(ctx context.Context, tableName string, tx *dbtx.Transaction) (*dbrecord.Layout, error) {
+	if isCatalogTable(tableName) {
+		return nil, fmt.Errorf("cannot alter catalog table %q", tableName)
+	}
+	return m.tableManager.GetLayout(ctx, tableName, tx)
+}
+
+// tableNameのレコードをnewSchemaのlayoutでnewTableNameに書き直す
+// slot sizeが変わると同じblockに収まらないので、一度一時テーブルに退避する
+// tableへの書き込みは全てlogに残るのでrollbackできる. 一時テーブルはtransactionの終了時に削除される
+func (m *MetadataManager) rewriteTable(ctx context.Context, tableName string, newTableName string, layout *dbrecord.Layout, newSchema *dbrecord.Schema, valueOf valueOf, tx *dbtx.Transaction) (err error) {
+	temp := dbquery.NewTempTable(tx, newSchema)
+	tempScan, err := temp.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("open temp table: %w", err)
+	}
+	defer func() {
+		if closeErr := tempScan.Close(ctx); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}()
+
+	// RIDが変わるのでindexのエントリは一度全て消して入れ直す
+	indexes, err := m.openIndexes(ctx, tableName, tx)
+	if err != nil {
+		return err
+	}
+	if err := m.saveRecords(ctx, tableName, layout, newSchema, valueOf, tempScan, indexes, tx); err != nil {
+		return errors.Join(err, closeIndexes(ctx, indexes))
+	}
+	if err := closeIndexes(ctx, indexes); err != nil {
+		return err
+	}
+
+	if err := m.tableManager.replaceTable(ctx, tableName, newTableName, newSchema, tx); err != nil {
+		return fmt.Errorf("replace catalog for %q: %w", tableName, err)
+	}
+	if newTableName != tableName {
+		if _, err := updateCatalogRows(ctx, tx, IndexCatalogTableName, m.indexManager.layout, map[string]string{"tablename": tableName}, "tablename", newTableName); err != nil {
+			return fmt.Errorf("rename %q in %q: %w", tableName, IndexCatalogTableName, err)
+		}
+		if err := tx.DropFile(ctx, dbrecord.TableFileName(tableName)); err != nil {
+			return fmt.Errorf("drop file for %q: %w", tableName, err)
+		}
+	}
+
+	newLayout, err := m.tableManager.GetLayout(ctx, newTableName, tx)
+	if err != nil {
+		return fmt.Errorf("get layout for %q: %w", newTableName, err)
+	}
+	// 前のlayoutで書かれたblockを空にしてから書き戻す
+	if err := dbrecord.ClearTable(ctx, tx, newTableName); err != nil {
+		return fmt.Errorf("clear %q: %w", newTableName, err)
+	}
+	newIndexes, err := m.openIndexes(ctx, newTableName, tx)
+	if err != nil {
+		return err
+	}
+	if err := restoreRecords(ctx, tempScan, newTableName, newLayout, newIndexes, tx); err != nil {
+		return errors.Join(err, closeIndexes(ctx, newIndexes))
+	}
+	if err := closeIndexes(ctx, newIndexes); err != nil {
+		return err
+	}
+
+	m.statManager.Invalidate(tableName)
+	m.statManager.Invalidate(newTableName)
+	return nil
+}
+
+// tableNameのレコードを一時テーブルに移し、indexからエントリを消す
+func (m *MetadataManager) saveRecords(ctx context.Context, tableName string, layout *dbrecord.Layout, newSchema *dbrecord.Schema, valueOf valueOf, tempScan dbquery.UpdateScan, indexes map[string]dbindex.Index, tx *dbtx.Transaction) (err error) {
+	src, err := dbrecord.NewTableScan(ctx, tx, tableName, layout, false)
+	if err != nil {
+		return fmt.Errorf("create table scan for %q: %w", tableName, err)
+	}
+	defer func() {
+		if closeErr := src.Close(ctx); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}()
+	for {
+		next, err := src.Next(ctx)
+		if err != nil {
+			return fmt.Errorf("go next for %q: %w", tableName, err)
+		}
+		if !next {
+			break
+		}
+		if err := tempScan.Insert(ctx); err != nil {
+			return fmt.Errorf("insert to temp table: %w", err)
+		}
+		for _, f := range newSchema.Fields() {
+			val, err := valueOf(ctx, src, f)
+			if err != nil {
+				return fmt.Errorf("get value for %q: %w", f, err)
+			}
+			if err := tempScan.SetValue(ctx, f, val); err != nil {
+				return fmt.Errorf("set value to %q: %w", f, err)
+			}
+		}
+		for f, idx := range indexes {
+			val, err := src.GetValue(ctx, f)
+			if err != nil {
+				return fmt.Errorf("get value for %q: %w", f, err)
+			}
+			if err := idx.Delete(ctx, val, *src.RID()); err != nil {
+				return fmt.Errorf("delete index entry for %q: %w", f, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 一時テーブルのレコードをtableNameに書き戻し、indexにエントリを入れる
+func restoreRecords(ctx context.Context, tempScan dbquery.UpdateScan, tableName string, layout *dbrecord.Layout, indexes map[string]dbindex.Index, tx *dbtx.Transaction) (err error) {
+	dst, err := dbrecord.NewTableScan(ctx, tx, tableName, layout, false)
+	if err != nil {
+		return fmt.Errorf("create table scan for %q: %w", tableName, err)
+	}
+	defer func() {
+		if closeErr := dst.Close(ctx); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}()
+	if err := tempScan.SetStateToBeforeFirst(ctx); err != nil {
+		return fmt.Errorf("rewind temp table: %w", err)
+	}
+	for {
+		next, err := tempScan.Next(ctx)
+		if err != nil {
+			return fmt.Errorf("go next for temp table: %w", err)
+		}
+		if !next {
+			break
+		}
+		if err := dst.Insert(ctx); err != nil {
+			return fmt.Errorf("insert to %q: %w", tableName, err)
+		}
+		for _, f := range layout.Schema().Fields() {
+			val, err := tempScan.GetValue(ctx, f)
+			if err != nil {
+				return fmt.Errorf("get value for %q: %w", f, err)
+			}
+			if err := dst.SetValue(ctx, f, val); err != nil {
+				return fmt.Errorf("set value to %q: %w", f, err)
+			}
+			if idx, ok := indexes[f]; ok {
+				if err := idx.Insert(ctx, val, *dst.RID()); err != nil {
+					return fmt.Errorf("insert index entry for %q: %w", f, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tableNameに張られたindexをfield名をキーにして開く
+func (m *MetadataManager) openIndexes(ctx context.Context, tableName string, tx *dbtx.Transaction) (map[string]dbindex.Index, error) {
+	infos, err := m.indexManager.GetIndexInfo(ctx, tableName, tx)
+	if err != nil {
+		return nil, fmt.Errorf("get index info for %q: %w", tableName, err)
+	}
+	indexes := make(map[string]dbindex.Index, len(infos))
+	for f, ii := range infos {
+		idx, err := ii.Open(ctx)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("open index %q: %w", ii.IndexName(), err), closeIndexes(ctx, indexes))
+		}
+		indexes[f] = idx
+	}
+	return indexes, nil
+}
+
+func closeIndexes(ctx context.Context, indexes map[string]dbindex.Index) error {
+	var errs []error
+	for f, idx := range indexes {
+		if err := idx.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("close index on %q: %w", f, err))
+		}
+	}
+	return errors.Join(errs...)
+}
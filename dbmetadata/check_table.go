@@ -0,0 +1,171 @@
+package dbmetadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbindex"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// 構造の検査と作り直しができるindex
+type checkableIndex interface {
+	dbindex.Index
+	Check(ctx context.Context) ([]dbindex.IndexEntry, []string, error)
+	Reset(ctx context.Context) error
+}
+
+// 1つのindexの検査結果
+type IndexCheck struct {
+	IndexName string
+	FieldName string
+	Problems  []string
+	// repairでindexを作り直した
+	Repaired bool
+}
+
+// tableNameに張られた各indexを検査する
+// indexの木の構造に加え, NULLでない全てのレコードがちょうど1つのentryを持ち, 全てのentryが生きているレコードを指すことを確かめる
+// repairなら問題のあったindexをレコードから作り直す
+func (m *MetadataManager) CheckTable(ctx context.Context, tableName string, repair bool, tx *dbtx.Transaction) ([]IndexCheck, error) {
+	layout, err := m.tableManager.GetLayout(ctx, tableName, tx)
+	if err != nil {
+		return nil, fmt.Errorf("get layout for %q: %w", tableName, err)
+	}
+	infos, err := m.indexManager.GetIndexInfo(ctx, tableName, tx)
+	if err != nil {
+		return nil, fmt.Errorf("get index info for %q: %w", tableName, err)
+	}
+	var checks []IndexCheck
+	for _, fieldName := range slices.Sorted(maps.Keys(infos)) {
+		ii := infos[fieldName]
+		check, err := checkIndex(ctx, tableName, layout, ii, repair, tx)
+		if err != nil {
+			return nil, fmt.Errorf("check index %q: %w", ii.IndexName(), err)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func checkIndex(ctx context.Context, tableName string, layout *dbrecord.Layout, ii *IndexInfo, repair bool, tx *dbtx.Transaction) (check IndexCheck, err error) {
+	check = IndexCheck{IndexName: ii.IndexName(), FieldName: ii.FieldName()}
+	opened, err := ii.Open(ctx)
+	if err != nil {
+		return check, fmt.Errorf("open: %w", err)
+	}
+	defer func() {
+		if closeErr := opened.Close(ctx); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close: %w", closeErr))
+		}
+	}()
+	idx, ok := opened.(checkableIndex)
+	if !ok {
+		return check, fmt.Errorf("index type %T cannot be checked", opened)
+	}
+
+	records, err := indexedValues(ctx, tableName, layout, ii.FieldName(), tx)
+	if err != nil {
+		return check, err
+	}
+	entries, problems, err := idx.Check(ctx)
+	if err != nil {
+		return check, fmt.Errorf("check structure: %w", err)
+	}
+	check.Problems = append(problems, compareEntries(records, entries)...)
+
+	if !repair || len(check.Problems) == 0 {
+		return check, nil
+	}
+	if err := idx.Reset(ctx); err != nil {
+		return check, fmt.Errorf("reset: %w", err)
+	}
+	for _, r := range records {
+		if err := idx.Insert(ctx, r.Value, r.RID); err != nil {
+			return check, fmt.Errorf("insert %s %s: %w", r.Value, &r.RID, err)
+		}
+	}
+	check.Repaired = true
+	return check, nil
+}
+
+// tableNameの全レコードについて, fieldNameのNULLでない値とRIDを読む
+func indexedValues(ctx context.Context, tableName string, layout *dbrecord.Layout, fieldName string, tx *dbtx.Transaction) (records []dbindex.IndexEntry, err error) {
+	ts, err := dbrecord.NewTableScan(ctx, tx, tableName, layout, false)
+	if err != nil {
+		return nil, fmt.Errorf("create table scan for %q: %w", tableName, err)
+	}
+	defer func() {
+		if closeErr := ts.Close(ctx); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close table scan for %q: %w", tableName, closeErr))
+		}
+	}()
+	for {
+		next, err := ts.Next(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("scan next for %q: %w", tableName, err)
+		}
+		if !next {
+			break
+		}
+		val, err := ts.GetValue(ctx, fieldName)
+		if err != nil {
+			return nil, fmt.Errorf("get value for %q: %w", fieldName, err)
+		}
+		if dbconstant.IsNull(val) {
+			continue
+		}
+		records = append(records, dbindex.IndexEntry{Value: val, RID: *ts.RID()})
+	}
+	return records, nil
+}
+
+// レコードとindexのentryを突き合わせる
+func compareEntries(records, entries []dbindex.IndexEntry) []string {
+	var problems []string
+	byRID := make(map[dbrecord.RID]dbconstant.Constant, len(records))
+	for _, r := range records {
+		byRID[r.RID] = r.Value
+	}
+	seen := make(map[dbrecord.RID]bool, len(entries))
+	for _, e := range entries {
+		val, ok := byRID[e.RID]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("entry %s points to %s, which is not a live record", e.Value, &e.RID))
+		case !val.Equals(e.Value):
+			problems = append(problems, fmt.Sprintf("entry %s points to %s, whose value is %s", e.Value, &e.RID, val))
+		case seen[e.RID]:
+			problems = append(problems, fmt.Sprintf("record %s has more than one entry", &e.RID))
+		}
+		seen[e.RID] = true
+	}
+	for _, r := range records {
+		if !seen[r.RID] {
+			problems = append(problems, fmt.Sprintf("record %s with value %s has no entry", &r.RID, r.Value))
+		}
+	}
+	return problems
+}
+
+// table_catalogに登録された全てのtable名
+func (m *MetadataManager) TableNames(ctx context.Context, tx *dbtx.Transaction) ([]string, error) {
+	var names []string
+	_, err := forEachCatalogRow(ctx, tx, TableCatalogTableName, m.tableManager.tableCatalogLayout, nil, func(ts *dbrecord.TableScan) error {
+		name, err := ts.GetString(ctx, "tablename")
+		if err != nil {
+			return fmt.Errorf("get tablename: %w", err)
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -120,6 +120,44 @@ func (i *IndexManager) CreateIndex(ctx context.Context, indexName string, tableN
 	return nil
 }
 
+// indexNameのcatalogを削除し、indexのファイルをcommit時に削除する
+func (i *IndexManager) DropIndex(ctx context.Context, indexName string, tx *dbtx.Transaction) error {
+	n, err := deleteCatalogRows(ctx, tx, IndexCatalogTableName, i.layout, map[string]string{"indexname": indexName})
+	if err != nil {
+		return fmt.Errorf("delete %q from %q: %w", indexName, IndexCatalogTableName, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("index %q not found in catalog", indexName)
+	}
+	return dropIndexFiles(ctx, indexName, tx)
+}
+
+// tableNameのテーブルに張られたindexを全て削除する
+func (i *IndexManager) DropIndexesOn(ctx context.Context, tableName string, tx *dbtx.Transaction) error {
+	indexInfos, err := i.GetIndexInfo(ctx, tableName, tx)
+	if err != nil {
+		return fmt.Errorf("get index info for %q: %w", tableName, err)
+	}
+	if _, err := deleteCatalogRows(ctx, tx, IndexCatalogTableName, i.layout, map[string]string{"tablename": tableName}); err != nil {
+		return fmt.Errorf("delete indexes on %q from %q: %w", tableName, IndexCatalogTableName, err)
+	}
+	for _, ii := range indexInfos {
+		if err := dropIndexFiles(ctx, ii.IndexName(), tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropIndexFiles(ctx context.Context, indexName string, tx *dbtx.Transaction) error {
+	for _, fileName := range dbindex.BTreeIndexFileNames(indexName) {
+		if err := tx.DropFile(ctx, fileName); err != nil {
+			return fmt.Errorf("drop file %q for index %q: %w", fileName, indexName, err)
+		}
+	}
+	return nil
+}
+
 // tableNameのテーブルに対して、フィールド名をキーにしたインデックスのマップを返す
 func (i *IndexManager) GetIndexInfo(ctx context.Context, tableName string, tx *dbtx.Transaction) (indexInfos map[string]*IndexInfo, err error) {
 	indexInfos = make(map[string]*IndexInfo)
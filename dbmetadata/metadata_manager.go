@@ -41,9 +41,35 @@ func NewMetadataManager(ctx context.Context, isNew bool, tx *dbtx.Transaction) (
 }
 
 func (m *MetadataManager) CreateTable(ctx context.Context, tableName string, schema *dbrecord.Schema, tx *dbtx.Transaction) error {
+	if err := checkUserTableName(tableName); err != nil {
+		return err
+	}
 	return m.tableManager.CreateTable(ctx, tableName, schema, tx)
 }
 
+// テーブルに張られたindexも合わせて削除する
+func (m *MetadataManager) DropTable(ctx context.Context, tableName string, tx *dbtx.Transaction) error {
+	if isCatalogTable(tableName) {
+		return fmt.Errorf("cannot drop catalog table %q", tableName)
+	}
+	// 存在しないテーブルのファイルを削除しないよう, lockを取る前にcatalogを確かめる
+	if _, err := m.tableManager.GetLayout(ctx, tableName, tx); err != nil {
+		return err
+	}
+	// catalogを書き換える前に, テーブルを使っているtransactionの終了を待つ
+	if err := tx.DropFile(ctx, dbrecord.TableFileName(tableName)); err != nil {
+		return fmt.Errorf("drop file for %q: %w", tableName, err)
+	}
+	if err := m.indexManager.DropIndexesOn(ctx, tableName, tx); err != nil {
+		return fmt.Errorf("drop indexes on %q: %w", tableName, err)
+	}
+	if err := m.tableManager.DropTable(ctx, tableName, tx); err != nil {
+		return err
+	}
+	m.statManager.Invalidate(tableName)
+	return nil
+}
+
 func (m *MetadataManager) GetLayout(ctx context.Context, tableName string, tx *dbtx.Transaction) (*dbrecord.Layout, error) {
 	return m.tableManager.GetLayout(ctx, tableName, tx)
 }
@@ -56,14 +82,25 @@ func (m *MetadataManager) CreateIndex(ctx context.Context, indexName string, tab
 	return m.indexManager.CreateIndex(ctx, indexName, tableName, fieldName, tx)
 }
 
+func (m *MetadataManager) DropIndex(ctx context.Context, indexName string, tx *dbtx.Transaction) error {
+	return m.indexManager.DropIndex(ctx, indexName, tx)
+}
+
 func (m *MetadataManager) GetIndexInfo(ctx context.Context, tableName string, tx *dbtx.Transaction) (indexInfos map[string]*IndexInfo, err error) {
 	return m.indexManager.GetIndexInfo(ctx, tableName, tx)
 }
 
 func (m *MetadataManager) CreateView(ctx context.Context, viewName string, viewDef string, tx *dbtx.Transaction) error {
+	if err := checkUserTableName(viewName); err != nil {
+		return err
+	}
 	return m.viewManager.CreateView(ctx, viewName, viewDef, tx)
 }
 
+func (m *MetadataManager) DropView(ctx context.Context, viewName string, tx *dbtx.Transaction) error {
+	return m.viewManager.DropView(ctx, viewName, tx)
+}
+
 func (m *MetadataManager) GetViewDef(ctx context.Context, viewName string, tx *dbtx.Transaction) (string, error) {
 	return m.viewManager.GetViewDef(ctx, viewName, tx)
 }
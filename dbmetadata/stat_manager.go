@@ -62,6 +62,13 @@ func (s *StatManager) GetStatInfo(ctx context.Context, tableName string, layout
 	return si, nil
 }
 
+// tableNameの統計情報を捨て、次回参照時に再計算させる
+func (s *StatManager) Invalidate(tableName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tableStats, tableName)
+}
+
 func (s *StatManager) refreshStatisticsLocked(ctx context.Context, tx *dbtx.Transaction) (err error) {
 	tableStats := make(map[string]*StatInfo)
 	s.numCalls = 0
@@ -2,7 +2,9 @@ package dbmetadata
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/teru01/simpledb-go/dbrecord"
 	"github.com/teru01/simpledb-go/dbtx"
@@ -12,6 +14,8 @@ const (
 	MaxNameLength         = 16
 	TableCatalogTableName = "table_catalog"
 	FieldCatalogTableName = "field_catalog"
+	// system tableの名前のprefix. 同じprefixのtableやviewは作れない
+	SystemTablePrefix = "sys_"
 )
 
 type TableManager struct {
@@ -19,19 +23,28 @@ type TableManager struct {
 	fieldCatalogLayout *dbrecord.Layout
 }
 
+// table_catalogの配置. 全てのtableの名前とslotの大きさを持つ
+func TableCatalogLayout() *dbrecord.Layout {
+	schema := dbrecord.NewSchema()
+	schema.AddStringField("tablename", MaxNameLength)
+	schema.AddIntField("slotsize")
+	return dbrecord.NewLayout(schema)
+}
+
+// field_catalogの配置. 全てのtableのフィールドの型と位置を持つ
+func FieldCatalogLayout() *dbrecord.Layout {
+	schema := dbrecord.NewSchema()
+	schema.AddStringField("tablename", MaxNameLength)
+	schema.AddStringField("fieldname", MaxNameLength)
+	schema.AddIntField("type")
+	schema.AddIntField("length")
+	schema.AddIntField("offset")
+	return dbrecord.NewLayout(schema)
+}
+
 func NewTableManager(ctx context.Context, isNew bool, tx *dbtx.Transaction) (*TableManager, error) {
-	tableCatalogSchema := dbrecord.NewSchema()
-	tableCatalogSchema.AddStringField("tablename", MaxNameLength)
-	tableCatalogSchema.AddIntField("slotsize")
-	tableCatalogLayout := dbrecord.NewLayout(tableCatalogSchema)
-
-	fieldCatalogSchema := dbrecord.NewSchema()
-	fieldCatalogSchema.AddStringField("tablename", MaxNameLength)
-	fieldCatalogSchema.AddStringField("fieldname", MaxNameLength)
-	fieldCatalogSchema.AddIntField("type")
-	fieldCatalogSchema.AddIntField("length")
-	fieldCatalogSchema.AddIntField("offset")
-	fieldCatalogLayout := dbrecord.NewLayout(fieldCatalogSchema)
+	tableCatalogLayout := TableCatalogLayout()
+	fieldCatalogLayout := FieldCatalogLayout()
 
 	t := &TableManager{
 		tableCatalogLayout: tableCatalogLayout,
@@ -44,10 +57,10 @@ func NewTableManager(ctx context.Context, isNew bool, tx *dbtx.Transaction) (*Ta
 	}
 
 	if isNew || !exists {
-		if err := t.CreateTable(ctx, TableCatalogTableName, tableCatalogSchema, tx); err != nil {
+		if err := t.CreateTable(ctx, TableCatalogTableName, tableCatalogLayout.Schema(), tx); err != nil {
 			return nil, fmt.Errorf("create table catalog: %w", err)
 		}
-		if err := t.CreateTable(ctx, FieldCatalogTableName, fieldCatalogSchema, tx); err != nil {
+		if err := t.CreateTable(ctx, FieldCatalogTableName, fieldCatalogLayout.Schema(), tx); err != nil {
 			return nil, fmt.Errorf("create field catalog: %w", err)
 		}
 	}
@@ -111,6 +124,111 @@ func (t *TableManager) CreateTable(ctx context.Context, tableName string, schema
 	return nil
 }
 
+// tableNameのcatalogを削除する. テーブルのファイルはMetadataManager.DropTableが先にDropFileで削除を予約する
+func (t *TableManager) DropTable(ctx context.Context, tableName string, tx *dbtx.Transaction) error {
+	if isCatalogTable(tableName) {
+		return fmt.Errorf("cannot drop catalog table %q", tableName)
+	}
+	n, err := deleteCatalogRows(ctx, tx, TableCatalogTableName, t.tableCatalogLayout, map[string]string{"tablename": tableName})
+	if err != nil {
+		return fmt.Errorf("delete %q from %q: %w", tableName, TableCatalogTableName, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("table %q not found in catalog", tableName)
+	}
+	if _, err := deleteCatalogRows(ctx, tx, FieldCatalogTableName, t.fieldCatalogLayout, map[string]string{"tablename": tableName}); err != nil {
+		return fmt.Errorf("delete %q from %q: %w", tableName, FieldCatalogTableName, err)
+	}
+	return nil
+}
+
+// tableNameのcatalogをnewTableName, schemaで作り直す. レコードの移行は呼び出し側で行う
+func (t *TableManager) replaceTable(ctx context.Context, tableName string, newTableName string, schema *dbrecord.Schema, tx *dbtx.Transaction) error {
+	if _, err := deleteCatalogRows(ctx, tx, TableCatalogTableName, t.tableCatalogLayout, map[string]string{"tablename": tableName}); err != nil {
+		return fmt.Errorf("delete %q from %q: %w", tableName, TableCatalogTableName, err)
+	}
+	if _, err := deleteCatalogRows(ctx, tx, FieldCatalogTableName, t.fieldCatalogLayout, map[string]string{"tablename": tableName}); err != nil {
+		return fmt.Errorf("delete %q from %q: %w", tableName, FieldCatalogTableName, err)
+	}
+	return t.CreateTable(ctx, newTableName, schema, tx)
+}
+
+func isCatalogTable(tableName string) bool {
+	switch tableName {
+	case TableCatalogTableName, FieldCatalogTableName, IndexCatalogTableName, ViewCatalogTableName:
+		return true
+	}
+	return false
+}
+
+// system tableと同じ名前になりうるので, tableやviewの名前に使えない
+func checkUserTableName(tableName string) error {
+	if strings.HasPrefix(tableName, SystemTablePrefix) {
+		return fmt.Errorf("table name %q must not start with %q", tableName, SystemTablePrefix)
+	}
+	return nil
+}
+
+// catalogのうちwhereの全fieldが一致する行を削除し、削除した行数を返す
+func deleteCatalogRows(ctx context.Context, tx *dbtx.Transaction, catalogName string, layout *dbrecord.Layout, where map[string]string) (int, error) {
+	return forEachCatalogRow(ctx, tx, catalogName, layout, where, func(ts *dbrecord.TableScan) error {
+		return ts.Delete(ctx)
+	})
+}
+
+// catalogのうちwhereの全fieldが一致する行のfieldNameをvalueに書き換え、書き換えた行数を返す
+func updateCatalogRows(ctx context.Context, tx *dbtx.Transaction, catalogName string, layout *dbrecord.Layout, where map[string]string, fieldName string, value string) (int, error) {
+	return forEachCatalogRow(ctx, tx, catalogName, layout, where, func(ts *dbrecord.TableScan) error {
+		return ts.SetString(ctx, fieldName, value)
+	})
+}
+
+func forEachCatalogRow(ctx context.Context, tx *dbtx.Transaction, catalogName string, layout *dbrecord.Layout, where map[string]string, fn func(ts *dbrecord.TableScan) error) (n int, err error) {
+	ts, err := dbrecord.NewTableScan(ctx, tx, catalogName, layout, true)
+	if err != nil {
+		return 0, fmt.Errorf("create table scan for %q: %w", catalogName, err)
+	}
+	defer func() {
+		if closeErr := ts.Close(ctx); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close table scan for %q: %w", catalogName, closeErr))
+		}
+	}()
+	for {
+		next, err := ts.Next(ctx)
+		if err != nil {
+			return n, fmt.Errorf("go next for %q: %w", catalogName, err)
+		}
+		if !next {
+			break
+		}
+		matched, err := catalogRowMatches(ctx, ts, where)
+		if err != nil {
+			return n, fmt.Errorf("match row in %q: %w", catalogName, err)
+		}
+		if !matched {
+			continue
+		}
+		if err := fn(ts); err != nil {
+			return n, fmt.Errorf("update row in %q: %w", catalogName, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func catalogRowMatches(ctx context.Context, ts *dbrecord.TableScan, where map[string]string) (bool, error) {
+	for fieldName, value := range where {
+		v, err := ts.GetString(ctx, fieldName)
+		if err != nil {
+			return false, fmt.Errorf("get %s: %w", fieldName, err)
+		}
+		if v != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func (t *TableManager) GetLayout(ctx context.Context, tableName string, tx *dbtx.Transaction) (*dbrecord.Layout, error) {
 	tableCatlog, err := dbrecord.NewTableScan(ctx, tx, TableCatalogTableName, t.tableCatalogLayout, true)
 	if err != nil {
@@ -55,6 +55,21 @@ func (v *ViewManager) CreateView(ctx context.Context, viewName string, viewDef s
 	return nil
 }
 
+func (v *ViewManager) DropView(ctx context.Context, viewName string, tx *dbtx.Transaction) error {
+	layout, err := v.tableManager.GetLayout(ctx, ViewCatalogTableName, tx)
+	if err != nil {
+		return fmt.Errorf("get layout before dropping view %q: %w", viewName, err)
+	}
+	n, err := deleteCatalogRows(ctx, tx, ViewCatalogTableName, layout, map[string]string{"viewname": viewName})
+	if err != nil {
+		return fmt.Errorf("delete %q from %q: %w", viewName, ViewCatalogTableName, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("view %q not found in catalog", viewName)
+	}
+	return nil
+}
+
 // viewが見つからない時は空文字を返す
 func (v *ViewManager) GetViewDef(ctx context.Context, viewName string, tx *dbtx.Transaction) (string, error) {
 	layout, err := v.tableManager.GetLayout(ctx, ViewCatalogTableName, tx)
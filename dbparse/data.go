@@ -1,9 +1,12 @@
 package dbparse
 
 import (
+	"strings"
+
 	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbquery"
 	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
 )
 
 // InsertData represents an INSERT statement
@@ -138,14 +141,230 @@ func (d *CreateIndexData) FieldName() string {
 	return d.fieldName
 }
 
+// DropTableData represents a DROP TABLE statement
+type DropTableData struct {
+	tableName string
+}
+
+func NewDropTableData(tableName string) *DropTableData {
+	return &DropTableData{tableName: tableName}
+}
+
+func (d *DropTableData) TableName() string {
+	return d.tableName
+}
+
+// DropViewData represents a DROP VIEW statement
+type DropViewData struct {
+	viewName string
+}
+
+func NewDropViewData(viewName string) *DropViewData {
+	return &DropViewData{viewName: viewName}
+}
+
+func (d *DropViewData) ViewName() string {
+	return d.viewName
+}
+
+// DropIndexData represents a DROP INDEX statement
+type DropIndexData struct {
+	indexName string
+}
+
+func NewDropIndexData(indexName string) *DropIndexData {
+	return &DropIndexData{indexName: indexName}
+}
+
+func (d *DropIndexData) IndexName() string {
+	return d.indexName
+}
+
+// AddColumnData represents an ALTER TABLE ... ADD COLUMN statement
+type AddColumnData struct {
+	tableName    string
+	fieldName    string
+	fieldType    int
+	length       int
+	defaultValue dbconstant.Constant
+}
+
+func NewAddColumnData(tableName string, fieldName string, fieldType int, length int, defaultValue dbconstant.Constant) *AddColumnData {
+	return &AddColumnData{tableName: tableName, fieldName: fieldName, fieldType: fieldType, length: length, defaultValue: defaultValue}
+}
+
+func (d *AddColumnData) TableName() string {
+	return d.tableName
+}
+
+func (d *AddColumnData) FieldName() string {
+	return d.fieldName
+}
+
+func (d *AddColumnData) FieldType() int {
+	return d.fieldType
+}
+
+func (d *AddColumnData) Length() int {
+	return d.length
+}
+
+// DEFAULTが省略された場合はNULL
+func (d *AddColumnData) DefaultValue() dbconstant.Constant {
+	return d.defaultValue
+}
+
+// DropColumnData represents an ALTER TABLE ... DROP COLUMN statement
+type DropColumnData struct {
+	tableName string
+	fieldName string
+}
+
+func NewDropColumnData(tableName string, fieldName string) *DropColumnData {
+	return &DropColumnData{tableName: tableName, fieldName: fieldName}
+}
+
+func (d *DropColumnData) TableName() string {
+	return d.tableName
+}
+
+func (d *DropColumnData) FieldName() string {
+	return d.fieldName
+}
+
+// RenameColumnData represents an ALTER TABLE ... RENAME COLUMN statement
+type RenameColumnData struct {
+	tableName    string
+	fieldName    string
+	newFieldName string
+}
+
+func NewRenameColumnData(tableName string, fieldName string, newFieldName string) *RenameColumnData {
+	return &RenameColumnData{tableName: tableName, fieldName: fieldName, newFieldName: newFieldName}
+}
+
+func (d *RenameColumnData) TableName() string {
+	return d.tableName
+}
+
+func (d *RenameColumnData) FieldName() string {
+	return d.fieldName
+}
+
+func (d *RenameColumnData) NewFieldName() string {
+	return d.newFieldName
+}
+
+// RenameTableData represents an ALTER TABLE ... RENAME TO statement
+type RenameTableData struct {
+	tableName    string
+	newTableName string
+}
+
+func NewRenameTableData(tableName string, newTableName string) *RenameTableData {
+	return &RenameTableData{tableName: tableName, newTableName: newTableName}
+}
+
+func (d *RenameTableData) TableName() string {
+	return d.tableName
+}
+
+func (d *RenameTableData) NewTableName() string {
+	return d.newTableName
+}
+
+// StartTransactionData represents a START TRANSACTION statement
+type StartTransactionData struct {
+	isolationLevel    dbtx.IsolationLevel
+	hasIsolationLevel bool
+}
+
+func NewStartTransactionData(isolationLevel dbtx.IsolationLevel, hasIsolationLevel bool) *StartTransactionData {
+	return &StartTransactionData{isolationLevel: isolationLevel, hasIsolationLevel: hasIsolationLevel}
+}
+
+// 省略された場合はfalseを返す
+func (d *StartTransactionData) IsolationLevel() (dbtx.IsolationLevel, bool) {
+	return d.isolationLevel, d.hasIsolationLevel
+}
+
+// SetTransactionData represents a SET TRANSACTION statement
+type SetTransactionData struct {
+	isolationLevel dbtx.IsolationLevel
+}
+
+func NewSetTransactionData(isolationLevel dbtx.IsolationLevel) *SetTransactionData {
+	return &SetTransactionData{isolationLevel: isolationLevel}
+}
+
+func (d *SetTransactionData) IsolationLevel() dbtx.IsolationLevel {
+	return d.isolationLevel
+}
+
+// RecoverToData represents a RECOVER TO statement
+type RecoverToData struct {
+	target dbtx.RecoveryTarget
+}
+
+func NewRecoverToData(target dbtx.RecoveryTarget) *RecoverToData {
+	return &RecoverToData{target: target}
+}
+
+func (d *RecoverToData) Target() dbtx.RecoveryTarget {
+	return d.target
+}
+
+// BackupData represents a BACKUP TO statement
+type BackupData struct {
+	dir string
+}
+
+func NewBackupData(dir string) *BackupData {
+	return &BackupData{dir: dir}
+}
+
+func (d *BackupData) Dir() string {
+	return d.dir
+}
+
+// CheckTableData represents a CHECK TABLE statement
+type CheckTableData struct {
+	tableName string
+	repair    bool
+}
+
+func NewCheckTableData(tableName string, repair bool) *CheckTableData {
+	return &CheckTableData{tableName: tableName, repair: repair}
+}
+
+func (d *CheckTableData) TableName() string {
+	return d.tableName
+}
+
+// 問題のあったindexを作り直す
+func (d *CheckTableData) Repair() bool {
+	return d.repair
+}
+
 type QueryData struct {
 	fields    []string
 	tables    []string
 	predicate *dbquery.Predicate
-}
-
-func NewQueryData(fields []string, tables []string, predicate *dbquery.Predicate) *QueryData {
-	return &QueryData{fields: fields, tables: tables, predicate: predicate}
+	// select listの集約. 出力フィールド名はfieldsにも含まれる
+	aggregations []*dbquery.Aggregation
+	groupBy      []string
+	orderBy      []*dbquery.SortKey
+}
+
+func NewQueryData(fields []string, tables []string, predicate *dbquery.Predicate, aggregations []*dbquery.Aggregation, groupBy []string, orderBy []*dbquery.SortKey) *QueryData {
+	return &QueryData{
+		fields:       fields,
+		tables:       tables,
+		predicate:    predicate,
+		aggregations: aggregations,
+		groupBy:      groupBy,
+		orderBy:      orderBy,
+	}
 }
 
 func (q *QueryData) Fields() []string {
@@ -160,6 +379,18 @@ func (q *QueryData) Predicate() *dbquery.Predicate {
 	return q.predicate
 }
 
+func (q *QueryData) Aggregations() []*dbquery.Aggregation {
+	return q.aggregations
+}
+
+func (q *QueryData) GroupBy() []string {
+	return q.groupBy
+}
+
+func (q *QueryData) OrderBy() []*dbquery.SortKey {
+	return q.orderBy
+}
+
 func (q *QueryData) String() string {
 	result := "SELECT "
 	for _, field := range q.fields {
@@ -172,9 +403,19 @@ func (q *QueryData) String() string {
 	}
 	result = result[:len(result)-2]
 
-	if q.predicate != nil {
+	if q.predicate != nil && q.predicate.String() != "" {
 		result += " WHERE "
 		result += q.predicate.String()
 	}
+	if len(q.groupBy) > 0 {
+		result += " GROUP BY " + strings.Join(q.groupBy, ", ")
+	}
+	if len(q.orderBy) > 0 {
+		result += " ORDER BY "
+		for _, key := range q.orderBy {
+			result += key.String() + ", "
+		}
+		result = result[:len(result)-2]
+	}
 	return result
 }
@@ -10,95 +10,161 @@ import (
 	"github.com/teru01/simpledb-go/dberr"
 )
 
+// 2文字の比較演算子のtoken. text/scannerのtokenと重ならない負の値にする
+const operatorToken rune = -100
+
 type Lexer struct {
-	keywords  []string
-	scanner   scanner.Scanner
-	nextToken rune
+	keywords []string
+	scanner  scanner.Scanner
+	next     token
+	// nextの次のtoken. 予約語でないkeywordと識別子を区別するのに使う
+	afterNext token
+}
+
+type token struct {
+	kind rune
+	text string
 }
 
 func NewLexer(s string) *Lexer {
-	var scanner scanner.Scanner
-	scanner.Init(strings.NewReader(s))
-	nextToken := scanner.Scan()
-	return &Lexer{
+	l := &Lexer{
+		// 集約関数名やDDL, ORDER BYなど特定の句でしか使わない語は, 前後のtokenで判断するので予約しない
 		keywords: []string{"select", "from", "where", "and",
 			"insert", "into", "values", "delete", "update",
 			"set", "create", "table", "varchar",
-			"int", "view", "as", "index", "on"},
-		scanner:   scanner,
-		nextToken: nextToken,
+			"int", "view", "as", "index", "on",
+			"or", "not"},
 	}
+	l.scanner.Init(strings.NewReader(s))
+	l.next = l.scan()
+	l.afterNext = l.scan()
+	return l
+}
+
+// 次のtokenに進む
+func (l *Lexer) advance() {
+	l.next = l.afterNext
+	l.afterNext = l.scan()
+}
+
+// tokenを1つ読む. <=, >=, <>, != は1つのtokenにまとめる
+func (l *Lexer) scan() token {
+	kind := l.scanner.Scan()
+	t := token{kind: kind, text: l.scanner.TokenText()}
+	next := l.scanner.Peek()
+	if ((kind == '<' || kind == '>' || kind == '!') && next == '=') || (kind == '<' && next == '>') {
+		l.scanner.Next()
+		return token{kind: operatorToken, text: string(kind) + string(next)}
+	}
+	return t
+}
+
+// 1文字の演算子はdelimiterとしても扱う
+func (l *Lexer) IsNextOperator(op string) bool {
+	if len(op) == 1 {
+		return l.IsNextDelimiter(rune(op[0]))
+	}
+	return l.next.kind == operatorToken && l.next.text == op
+}
+
+func (l *Lexer) EatOperator(op string) error {
+	if !l.IsNextOperator(op) {
+		return dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected operator %q but got %q", op, l.next.text), nil)
+	}
+	l.advance()
+	return nil
+}
+
+func (l *Lexer) tokenText() string {
+	return l.next.text
 }
 
 func (l *Lexer) IsNextString() bool {
-	return l.nextToken == scanner.String
+	return l.next.kind == scanner.String
 }
 
 func (l *Lexer) IsNextIdentifier() bool {
-	return l.nextToken == scanner.Ident
+	return l.next.kind == scanner.Ident
 }
 
 func (l *Lexer) IsNextInt() bool {
-	return l.nextToken == scanner.Int
+	return l.next.kind == scanner.Int
 }
 
 func (l *Lexer) IsNextKeyword(w string) bool {
-	return l.nextToken == scanner.Ident && strings.ToLower(l.scanner.TokenText()) == w
+	return l.next.isKeyword(w)
 }
 
 func (l *Lexer) IsNextDelimiter(d rune) bool {
-	return l.nextToken == d
+	return l.next.kind == d
+}
+
+// 次の次のtokenが識別子か. 予約語も含む
+func (l *Lexer) IsAfterNextIdentifier() bool {
+	return l.afterNext.kind == scanner.Ident
+}
+
+func (l *Lexer) IsAfterNextKeyword(w string) bool {
+	return l.afterNext.isKeyword(w)
+}
+
+func (l *Lexer) IsAfterNextDelimiter(d rune) bool {
+	return l.afterNext.kind == d
+}
+
+func (t token) isKeyword(w string) bool {
+	return t.kind == scanner.Ident && strings.ToLower(t.text) == w
 }
 
 func (l *Lexer) EatDelimiter(d rune) error {
-	if l.nextToken != d {
-		return dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected delimiter %q but got %q", d, l.nextToken), nil)
+	if l.next.kind != d {
+		return dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected delimiter %q but got %q", d, l.next.kind), nil)
 	}
-	l.nextToken = l.scanner.Scan()
+	l.advance()
 	return nil
 }
 
 func (l *Lexer) EatIntConstant() (int, error) {
-	if l.nextToken != scanner.Int {
-		return 0, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected int but got %q", l.nextToken), nil)
+	if l.next.kind != scanner.Int {
+		return 0, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected int but got %q", l.next.kind), nil)
 	}
-	val, err := strconv.Atoi(l.scanner.TokenText())
+	val, err := strconv.Atoi(l.next.text)
 	if err != nil {
-		return 0, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("invalid int constant: %q", l.scanner.TokenText()), nil)
+		return 0, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("invalid int constant: %q", l.next.text), nil)
 	}
-	l.nextToken = l.scanner.Scan()
+	l.advance()
 	return val, nil
 }
 
 func (l *Lexer) EatStringConstant() (string, error) {
-	if l.nextToken != scanner.String {
-		return "", dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected string but got %q", l.nextToken), nil)
+	if l.next.kind != scanner.String {
+		return "", dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected string but got %q", l.next.kind), nil)
 	}
-	str, err := strconv.Unquote(l.scanner.TokenText())
+	str, err := strconv.Unquote(l.next.text)
 	if err != nil {
 		return "", dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("invalid string constant: %q", str), nil)
 	}
-	l.nextToken = l.scanner.Scan()
+	l.advance()
 	return str, nil
 }
 
 func (l *Lexer) EatIdentifier() (string, error) {
-	if l.nextToken != scanner.Ident {
-		return "", dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected identifier but got %q", l.nextToken), nil)
+	if l.next.kind != scanner.Ident {
+		return "", dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected identifier but got %q", l.next.kind), nil)
 	}
-	id := strings.ToLower(l.scanner.TokenText())
+	id := strings.ToLower(l.next.text)
 	if slices.Contains(l.keywords, id) {
 		return "", dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("using reserved keyword: %q", id), nil)
 	}
-	l.nextToken = l.scanner.Scan()
+	l.advance()
 	return id, nil
 }
 
 func (l *Lexer) EatKeyword(w string) error {
-	text := strings.ToLower(l.scanner.TokenText())
-	if l.nextToken != scanner.Ident || text != w {
+	text := strings.ToLower(l.next.text)
+	if !l.next.isKeyword(w) {
 		return dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected keyword %q but got %q", w, text), nil)
 	}
-	l.nextToken = l.scanner.Scan()
+	l.advance()
 	return nil
 }
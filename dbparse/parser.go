@@ -2,10 +2,13 @@ package dbparse
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dberr"
 	"github.com/teru01/simpledb-go/dbquery"
 	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
 )
 
 type Parser struct {
@@ -23,8 +26,14 @@ func (p *Parser) Field() (string, error) {
 	return p.lex.EatIdentifier()
 }
 
-// <Constant> := StrTok | IntTok
+// <Constant> := StrTok | IntTok | NULL
 func (p *Parser) Constant() (dbconstant.Constant, error) {
+	if p.lex.IsNextKeyword("null") {
+		if err := p.lex.EatKeyword("null"); err != nil {
+			return nil, err
+		}
+		return dbconstant.NewNullConstant(), nil
+	}
 	if p.lex.IsNextString() {
 		s, err := p.lex.EatStringConstant()
 		if err != nil {
@@ -41,7 +50,7 @@ func (p *Parser) Constant() (dbconstant.Constant, error) {
 
 // <Expression> := <Field> | <Constant>
 func (p *Parser) Expression() (*dbquery.Expression, error) {
-	if p.lex.IsNextIdentifier() {
+	if p.lex.IsNextIdentifier() && !p.lex.IsNextKeyword("null") {
 		field, err := p.Field()
 		if err != nil {
 			return nil, err
@@ -55,31 +64,46 @@ func (p *Parser) Expression() (*dbquery.Expression, error) {
 	return dbquery.NewExpressionFromValue(constant), nil
 }
 
-// <Term> := <Expression> = <Expression>
+// <Term> := <Expression> <Operator> <Expression> | <Expression> IS [ NOT ] NULL
+// <Operator> := = | < | > | <= | >= | <> | !=
 func (p *Parser) Term() (*dbquery.Term, error) {
 	lhs, err := p.Expression()
 	if err != nil {
 		return nil, err
 	}
 
-	var op dbquery.Operator
-	if p.lex.IsNextDelimiter('=') {
-		if err := p.lex.EatDelimiter('='); err != nil {
+	if p.lex.IsNextKeyword("is") {
+		if err := p.lex.EatKeyword("is"); err != nil {
 			return nil, err
 		}
-		op = dbquery.Equator
-	} else if p.lex.IsNextDelimiter('<') {
-		if err := p.lex.EatDelimiter('<'); err != nil {
-			return nil, err
+		op := dbquery.IsNull
+		if p.lex.IsNextKeyword("not") {
+			if err := p.lex.EatKeyword("not"); err != nil {
+				return nil, err
+			}
+			op = dbquery.IsNotNull
 		}
-		op = dbquery.LessThan
-	} else if p.lex.IsNextDelimiter('>') {
-		if err := p.lex.EatDelimiter('>'); err != nil {
+		if err := p.lex.EatKeyword("null"); err != nil {
 			return nil, err
 		}
-		op = dbquery.GreaterThan
-	} else {
-		return nil, fmt.Errorf("unexpected delimiter %q", p.lex.nextToken)
+		return dbquery.NewTerm(lhs, dbquery.NewExpressionFromValue(dbconstant.NewNullConstant()), op), nil
+	}
+
+	var (
+		op    dbquery.Operator
+		found bool
+	)
+	for _, candidate := range termOperators {
+		if p.lex.IsNextOperator(candidate.text) {
+			if err := p.lex.EatOperator(candidate.text); err != nil {
+				return nil, err
+			}
+			op, found = candidate.op, true
+			break
+		}
+	}
+	if !found {
+		return nil, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected comparison operator but got %q", p.lex.tokenText()), nil)
 	}
 
 	rhs, err := p.Expression()
@@ -89,32 +113,101 @@ func (p *Parser) Term() (*dbquery.Term, error) {
 	return dbquery.NewTerm(lhs, rhs, op), nil
 }
 
-// <Predicate> := <Term> [ AND <Predicate> ]
+var termOperators = []struct {
+	text string
+	op   dbquery.Operator
+}{
+	{"=", dbquery.Equator},
+	{"<", dbquery.LessThan},
+	{">", dbquery.GreaterThan},
+	{"<=", dbquery.LessThanOrEqual},
+	{">=", dbquery.GreaterThanOrEqual},
+	{"<>", dbquery.NotEqual},
+	{"!=", dbquery.NotEqual},
+}
+
+// <Predicate> := <Conjunction> [ OR <Predicate> ]
 func (p *Parser) Predicate() (*dbquery.Predicate, error) {
-	term, err := p.Term()
+	pred, err := p.conjunction()
 	if err != nil {
 		return nil, err
 	}
-	pred := dbquery.NewPredicate(term)
-	for p.lex.IsNextKeyword("and") {
-		if err := p.lex.EatKeyword("and"); err != nil {
+	if !p.lex.IsNextKeyword("or") {
+		return pred, nil
+	}
+	disjuncts := []*dbquery.Predicate{pred}
+	for p.lex.IsNextKeyword("or") {
+		if err := p.lex.EatKeyword("or"); err != nil {
 			return nil, err
 		}
-		term, err := p.Term()
+		pred, err := p.conjunction()
 		if err != nil {
 			return nil, err
 		}
-		pred.ConjoinWith(dbquery.NewPredicate(term))
+		disjuncts = append(disjuncts, pred)
 	}
+	// トップレベルは常にAND
+	pred = dbquery.NewPredicate()
+	pred.ConjoinWith(dbquery.NewOrPredicate(disjuncts...))
 	return pred, nil
 }
 
-// <Query> := SELECT <SelectList> FROM <TableList> [ WHERE <Predicate> ]
+// <Conjunction> := <BoolFactor> [ AND <Conjunction> ]
+func (p *Parser) conjunction() (*dbquery.Predicate, error) {
+	pred := dbquery.NewPredicate()
+	for {
+		factor, err := p.boolFactor()
+		if err != nil {
+			return nil, err
+		}
+		pred.ConjoinWith(factor)
+		if !p.lex.IsNextKeyword("and") {
+			return pred, nil
+		}
+		if err := p.lex.EatKeyword("and"); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// <BoolFactor> := NOT <BoolFactor> | ( <Predicate> ) | <Term>
+func (p *Parser) boolFactor() (*dbquery.Predicate, error) {
+	if p.lex.IsNextKeyword("not") {
+		if err := p.lex.EatKeyword("not"); err != nil {
+			return nil, err
+		}
+		pred, err := p.boolFactor()
+		if err != nil {
+			return nil, err
+		}
+		return dbquery.NewNotPredicate(pred), nil
+	}
+	if p.lex.IsNextDelimiter('(') {
+		if err := p.lex.EatDelimiter('('); err != nil {
+			return nil, err
+		}
+		pred, err := p.Predicate()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.lex.EatDelimiter(')'); err != nil {
+			return nil, err
+		}
+		return pred, nil
+	}
+	term, err := p.Term()
+	if err != nil {
+		return nil, err
+	}
+	return dbquery.NewPredicate(term), nil
+}
+
+// <Query> := SELECT <SelectList> FROM <TableList> [ WHERE <Predicate> ] [ GROUP BY <FieldList> ] [ ORDER BY <OrderList> ]
 func (p *Parser) Query() (*QueryData, error) {
 	if err := p.lex.EatKeyword("select"); err != nil {
 		return nil, err
 	}
-	fields, err := p.selectList()
+	fields, aggregations, err := p.selectList()
 	if err != nil {
 		return nil, err
 	}
@@ -135,28 +228,160 @@ func (p *Parser) Query() (*QueryData, error) {
 			return nil, err
 		}
 	}
-	return NewQueryData(fields, tables, pred), nil
+	var groupBy []string
+	// groupはよく使うfield名なので予約しない. 句の終わりに続くgroupはGROUP BYとして読み, byがなければエラーにする
+	if p.lex.IsNextKeyword("group") {
+		if err := p.lex.EatKeyword("group"); err != nil {
+			return nil, err
+		}
+		if err := p.lex.EatKeyword("by"); err != nil {
+			return nil, err
+		}
+		groupBy, err = p.fieldList()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var orderBy []*dbquery.SortKey
+	// 句の終わりには識別子が続かないので, orderは予約しなくてもORDER BYと判断できる
+	if p.lex.IsNextKeyword("order") {
+		if err := p.lex.EatKeyword("order"); err != nil {
+			return nil, err
+		}
+		if err := p.lex.EatKeyword("by"); err != nil {
+			return nil, err
+		}
+		orderBy, err = p.orderList()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewQueryData(fields, tables, pred, aggregations, groupBy, orderBy), nil
 }
 
-// <SelectList> := <Field> [ , <SelectList> ]
-func (p *Parser) selectList() ([]string, error) {
+// <OrderList> := <OrderItem> [ ASC | DESC ] [ , <OrderList> ]
+// <OrderItem> := <Field> | <Aggregate>
+func (p *Parser) orderList() ([]*dbquery.SortKey, error) {
+	keys := []*dbquery.SortKey{}
+	for {
+		var field string
+		if p.isNextAggregate() {
+			a, err := p.aggregate()
+			if err != nil {
+				return nil, err
+			}
+			field = a.OutputName()
+		} else {
+			f, err := p.Field()
+			if err != nil {
+				return nil, err
+			}
+			field = f
+		}
+		desc := false
+		if p.lex.IsNextKeyword("asc") {
+			if err := p.lex.EatKeyword("asc"); err != nil {
+				return nil, err
+			}
+		} else if p.lex.IsNextKeyword("desc") {
+			if err := p.lex.EatKeyword("desc"); err != nil {
+				return nil, err
+			}
+			desc = true
+		}
+		keys = append(keys, dbquery.NewSortKey(field, desc))
+		if !p.lex.IsNextDelimiter(',') {
+			return keys, nil
+		}
+		if err := p.lex.EatDelimiter(','); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// <SelectList> := <SelectItem> [ , <SelectList> ]
+// <SelectItem> := <Field> | <Aggregate>
+// 集約は出力フィールド名としてfieldsにも含める
+func (p *Parser) selectList() ([]string, []*dbquery.Aggregation, error) {
 	fields := []string{}
-	field, err := p.Field()
-	if err != nil {
+	aggregations := []*dbquery.Aggregation{}
+	for {
+		if p.isNextAggregate() {
+			a, err := p.aggregate()
+			if err != nil {
+				return nil, nil, err
+			}
+			aggregations = append(aggregations, a)
+			fields = append(fields, a.OutputName())
+		} else {
+			field, err := p.Field()
+			if err != nil {
+				return nil, nil, err
+			}
+			fields = append(fields, field)
+		}
+		if !p.lex.IsNextDelimiter(',') {
+			return fields, aggregations, nil
+		}
+		if err := p.lex.EatDelimiter(','); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+var aggregateFuncs = []dbquery.AggregateFunc{
+	dbquery.AggregateCount,
+	dbquery.AggregateSum,
+	dbquery.AggregateMin,
+	dbquery.AggregateMax,
+	dbquery.AggregateAvg,
+}
+
+// 集約関数名は予約語ではないので, 続く ( で関数として扱う
+func (p *Parser) isNextAggregate() bool {
+	if !p.lex.IsAfterNextDelimiter('(') {
+		return false
+	}
+	for _, fn := range aggregateFuncs {
+		if p.lex.IsNextKeyword(fn.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// <Aggregate> := ( COUNT | SUM | MIN | MAX | AVG ) ( <Field> ) | COUNT ( * )
+func (p *Parser) aggregate() (*dbquery.Aggregation, error) {
+	var fn dbquery.AggregateFunc
+	for _, f := range aggregateFuncs {
+		if p.lex.IsNextKeyword(f.String()) {
+			fn = f
+			if err := p.lex.EatKeyword(f.String()); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if err := p.lex.EatDelimiter('('); err != nil {
 		return nil, err
 	}
-	fields = append(fields, field)
-	for p.lex.IsNextDelimiter(',') {
-		if err := p.lex.EatDelimiter(','); err != nil {
+	var field string
+	if fn == dbquery.AggregateCount && p.lex.IsNextDelimiter('*') {
+		if err := p.lex.EatDelimiter('*'); err != nil {
 			return nil, err
 		}
-		field, err := p.Field()
+		field = dbquery.AllFields
+	} else {
+		f, err := p.Field()
 		if err != nil {
 			return nil, err
 		}
-		fields = append(fields, field)
+		field = f
 	}
-	return fields, nil
+	if err := p.lex.EatDelimiter(')'); err != nil {
+		return nil, err
+	}
+	return dbquery.NewAggregation(fn, field), nil
 }
 
 // <TableList> := IdTok [ , <TableList> ]
@@ -180,7 +405,7 @@ func (p *Parser) tableList() ([]string, error) {
 	return tables, nil
 }
 
-// <UpdateCmd> := <Insert> | <Delete> | <Modify> | <Create>
+// <UpdateCmd> := <Insert> | <Delete> | <Modify> | <Create> | <Drop> | <AlterTable>
 func (p *Parser) UpdateCmd() (any, error) {
 	if p.lex.IsNextKeyword("insert") {
 		return p.Insert()
@@ -190,8 +415,12 @@ func (p *Parser) UpdateCmd() (any, error) {
 		return p.Modify()
 	} else if p.lex.IsNextKeyword("create") {
 		return p.Create()
+	} else if p.lex.IsNextKeyword("drop") {
+		return p.Drop()
+	} else if p.lex.IsNextKeyword("alter") {
+		return p.AlterTable()
 	}
-	return nil, fmt.Errorf("unexpected token: expected insert, delete, update, or create")
+	return nil, fmt.Errorf("unexpected token: expected insert, delete, update, create, drop, or alter")
 }
 
 // <Create> := <CreateTable> | <CreateView> | <CreateIndex>
@@ -209,6 +438,150 @@ func (p *Parser) Create() (any, error) {
 	return nil, fmt.Errorf("unexpected token: expected table, view, or index")
 }
 
+// <Drop> := DROP TABLE IdTok | DROP VIEW IdTok | DROP INDEX IdTok
+func (p *Parser) Drop() (any, error) {
+	if err := p.lex.EatKeyword("drop"); err != nil {
+		return nil, err
+	}
+	var kind string
+	switch {
+	case p.lex.IsNextKeyword("table"):
+		kind = "table"
+	case p.lex.IsNextKeyword("view"):
+		kind = "view"
+	case p.lex.IsNextKeyword("index"):
+		kind = "index"
+	default:
+		return nil, fmt.Errorf("unexpected token: expected table, view, or index")
+	}
+	if err := p.lex.EatKeyword(kind); err != nil {
+		return nil, err
+	}
+	name, err := p.lex.EatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "table":
+		return NewDropTableData(name), nil
+	case "view":
+		return NewDropViewData(name), nil
+	default:
+		return NewDropIndexData(name), nil
+	}
+}
+
+// <AlterTable> := ALTER TABLE IdTok <AlterAction>
+// <AlterAction> := ADD [ COLUMN ] <FieldDef> [ DEFAULT <Constant> ]
+//
+//	| DROP [ COLUMN ] IdTok
+//	| RENAME [ COLUMN ] IdTok TO IdTok
+//	| RENAME TO IdTok
+func (p *Parser) AlterTable() (any, error) {
+	if err := p.lex.EatKeyword("alter"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("table"); err != nil {
+		return nil, err
+	}
+	tableName, err := p.lex.EatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.lex.IsNextKeyword("add"):
+		return p.addColumn(tableName)
+	case p.lex.IsNextKeyword("drop"):
+		return p.dropColumn(tableName)
+	case p.lex.IsNextKeyword("rename"):
+		return p.rename(tableName)
+	}
+	return nil, fmt.Errorf("unexpected token: expected add, drop, or rename")
+}
+
+func (p *Parser) addColumn(tableName string) (*AddColumnData, error) {
+	if err := p.lex.EatKeyword("add"); err != nil {
+		return nil, err
+	}
+	// ADD column INT のように型が続く場合はcolumnという名前のフィールド
+	if err := p.eatOptionalKeyword("column", p.lex.IsAfterNextIdentifier() && !p.isAfterNextType()); err != nil {
+		return nil, err
+	}
+	fieldName, fieldType, length, err := p.fieldDef()
+	if err != nil {
+		return nil, err
+	}
+	var defaultValue dbconstant.Constant = dbconstant.NewNullConstant()
+	if p.lex.IsNextKeyword("default") {
+		if err := p.lex.EatKeyword("default"); err != nil {
+			return nil, err
+		}
+		defaultValue, err = p.Constant()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewAddColumnData(tableName, fieldName, fieldType, length, defaultValue), nil
+}
+
+func (p *Parser) dropColumn(tableName string) (*DropColumnData, error) {
+	if err := p.lex.EatKeyword("drop"); err != nil {
+		return nil, err
+	}
+	if err := p.eatOptionalKeyword("column", p.lex.IsAfterNextIdentifier()); err != nil {
+		return nil, err
+	}
+	fieldName, err := p.lex.EatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	return NewDropColumnData(tableName, fieldName), nil
+}
+
+func (p *Parser) rename(tableName string) (any, error) {
+	if err := p.lex.EatKeyword("rename"); err != nil {
+		return nil, err
+	}
+	// RENAME to TO x はtoという名前のフィールドの変更
+	if p.lex.IsNextKeyword("to") && !p.lex.IsAfterNextKeyword("to") {
+		if err := p.lex.EatKeyword("to"); err != nil {
+			return nil, err
+		}
+		newTableName, err := p.lex.EatIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		return NewRenameTableData(tableName, newTableName), nil
+	}
+	if err := p.eatOptionalKeyword("column", !p.lex.IsAfterNextKeyword("to")); err != nil {
+		return nil, err
+	}
+	fieldName, err := p.lex.EatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("to"); err != nil {
+		return nil, err
+	}
+	newFieldName, err := p.lex.EatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	return NewRenameColumnData(tableName, fieldName, newFieldName), nil
+}
+
+// 予約語でないkeywordは, 続くtokenからkeywordと判断できる(isKeyword)場合のみ読む
+func (p *Parser) eatOptionalKeyword(keyword string, isKeyword bool) error {
+	if !p.lex.IsNextKeyword(keyword) || !isKeyword {
+		return nil
+	}
+	return p.lex.EatKeyword(keyword)
+}
+
+func (p *Parser) isAfterNextType() bool {
+	return p.lex.IsAfterNextKeyword("int") || p.lex.IsAfterNextKeyword("varchar")
+}
+
 // <Insert> := INSERT INTO IdTok ( <FieldList> ) VALUES ( <ConstList> )
 func (p *Parser) Insert() (*InsertData, error) {
 	if err := p.lex.EatKeyword("insert"); err != nil {
@@ -477,3 +850,162 @@ func (p *Parser) CreateIndex() (*CreateIndexData, error) {
 	}
 	return NewCreateIndexData(indexName, tableName, fieldName), nil
 }
+
+// <StartTransaction> := START TRANSACTION [ <IsolationLevelClause> | WITH CONSISTENT SNAPSHOT ]
+func (p *Parser) StartTransaction() (*StartTransactionData, error) {
+	if err := p.lex.EatKeyword("start"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("transaction"); err != nil {
+		return nil, err
+	}
+	if p.lex.IsNextKeyword("isolation") {
+		level, err := p.isolationLevelClause()
+		if err != nil {
+			return nil, err
+		}
+		return NewStartTransactionData(level, true), nil
+	}
+	if p.lex.IsNextKeyword("with") {
+		for _, keyword := range []string{"with", "consistent", "snapshot"} {
+			if err := p.lex.EatKeyword(keyword); err != nil {
+				return nil, err
+			}
+		}
+		return NewStartTransactionData(dbtx.IsolationSnapshot, true), nil
+	}
+	return NewStartTransactionData(dbtx.IsolationSerializable, false), nil
+}
+
+// <SetTransaction> := SET TRANSACTION <IsolationLevelClause>
+func (p *Parser) SetTransaction() (*SetTransactionData, error) {
+	if err := p.lex.EatKeyword("set"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("transaction"); err != nil {
+		return nil, err
+	}
+	level, err := p.isolationLevelClause()
+	if err != nil {
+		return nil, err
+	}
+	return NewSetTransactionData(level), nil
+}
+
+// <RecoverTo> := RECOVER TO { LSN <IntConstant> | TIMESTAMP <StringConstant> | LATEST }
+func (p *Parser) RecoverTo() (*RecoverToData, error) {
+	if err := p.lex.EatKeyword("recover"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("to"); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.lex.IsNextKeyword("lsn"):
+		if err := p.lex.EatKeyword("lsn"); err != nil {
+			return nil, err
+		}
+		lsn, err := p.lex.EatIntConstant()
+		if err != nil {
+			return nil, err
+		}
+		if lsn <= 0 {
+			return nil, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("recovery target LSN must be positive but got %d", lsn), nil)
+		}
+		return NewRecoverToData(dbtx.RecoveryTarget{LSN: lsn}), nil
+	case p.lex.IsNextKeyword("timestamp"):
+		if err := p.lex.EatKeyword("timestamp"); err != nil {
+			return nil, err
+		}
+		str, err := p.lex.EatStringConstant()
+		if err != nil {
+			return nil, err
+		}
+		ts, err := parseTimestamp(str)
+		if err != nil {
+			return nil, err
+		}
+		return NewRecoverToData(dbtx.RecoveryTarget{Time: ts}), nil
+	case p.lex.IsNextKeyword("latest"):
+		return NewRecoverToData(dbtx.RecoveryTarget{}), p.lex.EatKeyword("latest")
+	}
+	return nil, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected recovery target but got %q", p.lex.tokenText()), nil)
+}
+
+// <Backup> := BACKUP TO <StringConstant>
+func (p *Parser) Backup() (*BackupData, error) {
+	if err := p.lex.EatKeyword("backup"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("to"); err != nil {
+		return nil, err
+	}
+	dir, err := p.lex.EatStringConstant()
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return nil, dberr.New(dberr.CodeSyntaxError, "backup directory must not be empty", nil)
+	}
+	return NewBackupData(dir), nil
+}
+
+// <CheckTable> := CHECK TABLE <Id> [REPAIR]
+func (p *Parser) CheckTable() (*CheckTableData, error) {
+	if err := p.lex.EatKeyword("check"); err != nil {
+		return nil, err
+	}
+	if err := p.lex.EatKeyword("table"); err != nil {
+		return nil, err
+	}
+	tableName, err := p.lex.EatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	if !p.lex.IsNextKeyword("repair") {
+		return NewCheckTableData(tableName, false), nil
+	}
+	return NewCheckTableData(tableName, true), p.lex.EatKeyword("repair")
+}
+
+// RFC3339か, タイムゾーンを省略した場合はローカル時刻の"2006-01-02 15:04:05"
+func parseTimestamp(s string) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339, s); err == nil {
+		return ts, nil
+	}
+	ts, err := time.ParseInLocation(time.DateTime, s, time.Local)
+	if err != nil {
+		return time.Time{}, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("invalid timestamp %q", s), err)
+	}
+	return ts, nil
+}
+
+// <IsolationLevelClause> := ISOLATION LEVEL { READ UNCOMMITTED | READ COMMITTED | REPEATABLE READ | SERIALIZABLE | SNAPSHOT }
+func (p *Parser) isolationLevelClause() (dbtx.IsolationLevel, error) {
+	if err := p.lex.EatKeyword("isolation"); err != nil {
+		return 0, err
+	}
+	if err := p.lex.EatKeyword("level"); err != nil {
+		return 0, err
+	}
+	switch {
+	case p.lex.IsNextKeyword("read"):
+		if err := p.lex.EatKeyword("read"); err != nil {
+			return 0, err
+		}
+		if p.lex.IsNextKeyword("uncommitted") {
+			return dbtx.IsolationReadUncommitted, p.lex.EatKeyword("uncommitted")
+		}
+		return dbtx.IsolationReadCommitted, p.lex.EatKeyword("committed")
+	case p.lex.IsNextKeyword("repeatable"):
+		if err := p.lex.EatKeyword("repeatable"); err != nil {
+			return 0, err
+		}
+		return dbtx.IsolationRepeatableRead, p.lex.EatKeyword("read")
+	case p.lex.IsNextKeyword("serializable"):
+		return dbtx.IsolationSerializable, p.lex.EatKeyword("serializable")
+	case p.lex.IsNextKeyword("snapshot"):
+		return dbtx.IsolationSnapshot, p.lex.EatKeyword("snapshot")
+	}
+	return 0, dberr.New(dberr.CodeSyntaxError, fmt.Sprintf("expected isolation level but got %q", p.lex.tokenText()), nil)
+}
@@ -1,10 +1,16 @@
 package dbparse_test
 
 import (
+	"reflect"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbparse"
+	"github.com/teru01/simpledb-go/dbquery"
 	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
 )
 
 func TestParseQuery(t *testing.T) {
@@ -279,6 +285,99 @@ func TestParseCreateIndex(t *testing.T) {
 	}
 }
 
+func TestParseDrop(t *testing.T) {
+	cmd, err := dbparse.NewParser("DROP TABLE users").UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse drop table: %v", err)
+	}
+	if d, ok := cmd.(*dbparse.DropTableData); !ok || d.TableName() != "users" {
+		t.Errorf("expected DropTableData for users, got %#v", cmd)
+	}
+
+	cmd, err = dbparse.NewParser("drop index idx_name").UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse drop index: %v", err)
+	}
+	if d, ok := cmd.(*dbparse.DropIndexData); !ok || d.IndexName() != "idx_name" {
+		t.Errorf("expected DropIndexData for idx_name, got %#v", cmd)
+	}
+
+	cmd, err = dbparse.NewParser("DROP VIEW v").UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse drop view: %v", err)
+	}
+	if d, ok := cmd.(*dbparse.DropViewData); !ok || d.ViewName() != "v" {
+		t.Errorf("expected DropViewData for v, got %#v", cmd)
+	}
+
+	for _, input := range []string{"DROP users", "DROP TABLE", "DROP COLUMN a"} {
+		if _, err := dbparse.NewParser(input).UpdateCmd(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseAlterTable(t *testing.T) {
+	cmd, err := dbparse.NewParser(`ALTER TABLE users ADD COLUMN name VARCHAR(10) DEFAULT "x"`).UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse add column: %v", err)
+	}
+	add, ok := cmd.(*dbparse.AddColumnData)
+	if !ok {
+		t.Fatalf("expected *AddColumnData, got %T", cmd)
+	}
+	if add.TableName() != "users" || add.FieldName() != "name" || add.FieldType() != dbrecord.FieldTypeString || add.Length() != 10 {
+		t.Errorf("unexpected add column: %+v", add)
+	}
+	if !add.DefaultValue().Equals(dbconstant.NewStringConstant("x")) {
+		t.Errorf("expected default \"x\", got %v", add.DefaultValue())
+	}
+
+	cmd, err = dbparse.NewParser(`alter table users add age int`).UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse add column: %v", err)
+	}
+	if add, ok := cmd.(*dbparse.AddColumnData); !ok || !dbconstant.IsNull(add.DefaultValue()) {
+		t.Errorf("expected NULL default, got %#v", cmd)
+	}
+
+	cmd, err = dbparse.NewParser(`ALTER TABLE users DROP COLUMN age`).UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse drop column: %v", err)
+	}
+	if d, ok := cmd.(*dbparse.DropColumnData); !ok || d.TableName() != "users" || d.FieldName() != "age" {
+		t.Errorf("unexpected drop column: %#v", cmd)
+	}
+
+	cmd, err = dbparse.NewParser(`ALTER TABLE users RENAME COLUMN age TO years`).UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse rename column: %v", err)
+	}
+	if r, ok := cmd.(*dbparse.RenameColumnData); !ok || r.FieldName() != "age" || r.NewFieldName() != "years" {
+		t.Errorf("unexpected rename column: %#v", cmd)
+	}
+
+	cmd, err = dbparse.NewParser(`ALTER TABLE users RENAME TO members`).UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse rename table: %v", err)
+	}
+	if r, ok := cmd.(*dbparse.RenameTableData); !ok || r.TableName() != "users" || r.NewTableName() != "members" {
+		t.Errorf("unexpected rename table: %#v", cmd)
+	}
+
+	for _, input := range []string{
+		`ALTER users ADD a INT`,
+		`ALTER TABLE users ADD a`,
+		`ALTER TABLE users ADD a INT DEFAULT`,
+		`ALTER TABLE users RENAME a b`,
+		`ALTER TABLE users MODIFY a INT`,
+	} {
+		if _, err := dbparse.NewParser(input).UpdateCmd(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
 func TestParseUpdateCmd(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -411,3 +510,444 @@ func TestParsePredicate(t *testing.T) {
 		})
 	}
 }
+
+func TestParseQueryWithOrderBy(t *testing.T) {
+	input := `SELECT name FROM users WHERE id = 1 ORDER BY age DESC, name ASC, id`
+	p := dbparse.NewParser(input)
+	q, err := p.Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+
+	expected := []struct {
+		fieldName string
+		desc      bool
+	}{
+		{"age", true},
+		{"name", false},
+		{"id", false},
+	}
+	if len(q.OrderBy()) != len(expected) {
+		t.Fatalf("expected %d sort keys, got %d", len(expected), len(q.OrderBy()))
+	}
+	for i, key := range q.OrderBy() {
+		if key.FieldName() != expected[i].fieldName || key.IsDesc() != expected[i].desc {
+			t.Errorf("sort key %d: expected %v, got %q", i, expected[i], key)
+		}
+	}
+	if got := q.String(); got != "SELECT name FROM users WHERE id = 1 ORDER BY age DESC, name, id" {
+		t.Errorf("unexpected query string: %q", got)
+	}
+}
+
+func TestParseQueryWithInvalidOrderBy(t *testing.T) {
+	for _, input := range []string{
+		`SELECT name FROM users ORDER age`,
+		`SELECT name FROM users ORDER BY`,
+		`SELECT name FROM users ORDER BY age,`,
+	} {
+		if _, err := dbparse.NewParser(input).Query(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseQueryWithGroupBy(t *testing.T) {
+	input := `SELECT class, COUNT(*), SUM(score), avg(score) FROM results GROUP BY class ORDER BY COUNT(*) DESC`
+	p := dbparse.NewParser(input)
+	q, err := p.Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+
+	expectedFields := []string{"class", "count(*)", "sum(score)", "avg(score)"}
+	if len(q.Fields()) != len(expectedFields) {
+		t.Fatalf("expected fields %v, got %v", expectedFields, q.Fields())
+	}
+	for i, f := range q.Fields() {
+		if f != expectedFields[i] {
+			t.Errorf("expected field %q, got %q", expectedFields[i], f)
+		}
+	}
+	if len(q.Aggregations()) != 3 {
+		t.Fatalf("expected 3 aggregations, got %d", len(q.Aggregations()))
+	}
+	if a := q.Aggregations()[1]; a.Func() != dbquery.AggregateSum || a.FieldName() != "score" {
+		t.Errorf("unexpected aggregation: %s", a)
+	}
+	if len(q.GroupBy()) != 1 || q.GroupBy()[0] != "class" {
+		t.Errorf("unexpected group by: %v", q.GroupBy())
+	}
+	if len(q.OrderBy()) != 1 || q.OrderBy()[0].FieldName() != "count(*)" || !q.OrderBy()[0].IsDesc() {
+		t.Errorf("unexpected order by: %v", q.OrderBy())
+	}
+
+	// view定義として保存した文字列を再度parseできる
+	want := "SELECT class, count(*), sum(score), avg(score) FROM results GROUP BY class ORDER BY count(*) DESC"
+	if got := q.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if _, err := dbparse.NewParser(q.String()).Query(); err != nil {
+		t.Errorf("failed to reparse %q: %v", q.String(), err)
+	}
+}
+
+func TestParseQueryWithInvalidAggregation(t *testing.T) {
+	for _, input := range []string{
+		`SELECT SUM(*) FROM results`,
+		`SELECT COUNT(id FROM results`,
+		`SELECT count( FROM results`,
+		`SELECT id FROM results GROUP id`,
+	} {
+		if _, err := dbparse.NewParser(input).Query(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+// 集約関数名やDDLの語は予約語ではなく, 続くtokenから判断できる位置では識別子として使える
+func TestParseContextualKeywords(t *testing.T) {
+	q, err := dbparse.NewParser(`SELECT count, max FROM results WHERE sum = 1 ORDER BY avg`).Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	if !slices.Equal(q.Fields(), []string{"count", "max"}) || len(q.Aggregations()) != 0 {
+		t.Errorf("expected fields [count max] without aggregations, got %v and %v", q.Fields(), q.Aggregations())
+	}
+	q, err = dbparse.NewParser(`SELECT min, COUNT(count) FROM results GROUP BY min`).Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	if len(q.Aggregations()) != 1 || q.Aggregations()[0].OutputName() != "count(count)" {
+		t.Errorf("expected count of field count, got %v", q.Aggregations())
+	}
+
+	// ORDER BYの語はfieldやtableの名前に使える
+	q, err = dbparse.NewParser(`SELECT desc, by FROM order WHERE asc = 1 ORDER BY desc DESC, by`).Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	if got := q.String(); got != "SELECT desc, by FROM order WHERE asc = 1 ORDER BY desc DESC, by" {
+		t.Errorf("unexpected query string: %q", got)
+	}
+	q, err = dbparse.NewParser(`SELECT group, COUNT(group) FROM group WHERE group > 1 GROUP BY group ORDER BY group`).Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	if !slices.Equal(q.GroupBy(), []string{"group"}) || !slices.Equal(q.Tables(), []string{"group"}) {
+		t.Errorf("expected group by and table group, got %v and %v", q.GroupBy(), q.Tables())
+	}
+
+	ct, err := dbparse.NewParser(`CREATE TABLE to (is INT, null INT, column VARCHAR(5), default INT)`).Create()
+	if err != nil {
+		t.Fatalf("failed to parse create table: %v", err)
+	}
+	if c, ok := ct.(*dbparse.CreateTableData); !ok || c.TableName() != "to" || !slices.Equal(c.Schema().Fields(), []string{"is", "null", "column", "default"}) {
+		t.Errorf("unexpected create table: %#v", ct)
+	}
+
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`ALTER TABLE t ADD column INT`, "column"},
+		{`ALTER TABLE t ADD COLUMN column INT`, "column"},
+		{`ALTER TABLE t DROP column`, dbparse.NewDropColumnData("t", "column")},
+		{`ALTER TABLE t DROP COLUMN column`, dbparse.NewDropColumnData("t", "column")},
+		{`ALTER TABLE t RENAME to TO x`, dbparse.NewRenameColumnData("t", "to", "x")},
+		{`ALTER TABLE t RENAME column TO x`, dbparse.NewRenameColumnData("t", "column", "x")},
+		{`ALTER TABLE t RENAME TO column`, dbparse.NewRenameTableData("t", "column")},
+	}
+	for _, tt := range tests {
+		cmd, err := dbparse.NewParser(tt.input).UpdateCmd()
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", tt.input, err)
+			continue
+		}
+		if add, ok := cmd.(*dbparse.AddColumnData); ok {
+			if add.FieldName() != tt.want || add.FieldType() != dbrecord.FieldTypeInt {
+				t.Errorf("%q: unexpected add column: %+v", tt.input, add)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(cmd, tt.want) {
+			t.Errorf("%q: expected %#v, got %#v", tt.input, tt.want, cmd)
+		}
+	}
+}
+
+func TestParsePredicateWithOrNot(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// ANDはORより優先される
+		{"a = 1 OR b = 2 AND c = 3", "a = 1 OR (b = 2 AND c = 3)"},
+		{"(a = 1 OR b = 2) AND c = 3", "(a = 1 OR b = 2) AND c = 3"},
+		{"NOT a = 1 AND b = 2", "NOT a = 1 AND b = 2"},
+		{"NOT (a = 1 OR b = 2)", "NOT (a = 1 OR b = 2)"},
+		{"((a = 1))", "a = 1"},
+		{"a = 1 OR b = 2 OR c = 3", "a = 1 OR b = 2 OR c = 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			pred, err := dbparse.NewParser(tt.input).Predicate()
+			if err != nil {
+				t.Fatalf("failed to parse predicate: %v", err)
+			}
+			if got := pred.String(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+			// 文字列化したものを再度parseしても同じになる
+			reparsed, err := dbparse.NewParser(pred.String()).Predicate()
+			if err != nil {
+				t.Fatalf("failed to reparse %q: %v", pred.String(), err)
+			}
+			if reparsed.String() != pred.String() {
+				t.Errorf("round trip mismatch: %q != %q", reparsed.String(), pred.String())
+			}
+		})
+	}
+}
+
+func TestParseInvalidPredicate(t *testing.T) {
+	for _, input := range []string{
+		"(a = 1",
+		"a = 1 OR",
+		"NOT",
+		"a = 1 AND ()",
+	} {
+		if _, err := dbparse.NewParser(input).Predicate(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseComparisonOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`a = 1`, `a = 1`},
+		{`a < 1`, `a < 1`},
+		{`a > 1`, `a > 1`},
+		{`a <= 1`, `a <= 1`},
+		{`a >= 1`, `a >= 1`},
+		{`a <> 1`, `a <> 1`},
+		{`a != 1`, `a <> 1`},
+		{`a<=b`, `a <= b`},
+		{`name >= "x y"`, `name >= "x y"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			pred, err := dbparse.NewParser(tt.input).Predicate()
+			if err != nil {
+				t.Fatalf("failed to parse predicate: %v", err)
+			}
+			if got := pred.String(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+			reparsed, err := dbparse.NewParser(pred.String()).Predicate()
+			if err != nil {
+				t.Fatalf("failed to reparse %q: %v", pred.String(), err)
+			}
+			if reparsed.String() != pred.String() {
+				t.Errorf("round trip mismatch: %q != %q", reparsed.String(), pred.String())
+			}
+		})
+	}
+
+	for _, input := range []string{`a ! 1`, `a < = 1`, `a =< 1`, `a 1`} {
+		if _, err := dbparse.NewParser(input).Predicate(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseNull(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`a IS NULL`, `a IS NULL`},
+		{`a is not null`, `a IS NOT NULL`},
+		{`a = NULL`, `a = NULL`},
+		{`NOT a IS NULL OR b = 1`, `NOT a IS NULL OR b = 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			pred, err := dbparse.NewParser(tt.input).Predicate()
+			if err != nil {
+				t.Fatalf("failed to parse predicate: %v", err)
+			}
+			if got := pred.String(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+			reparsed, err := dbparse.NewParser(pred.String()).Predicate()
+			if err != nil {
+				t.Fatalf("failed to reparse %q: %v", pred.String(), err)
+			}
+			if reparsed.String() != pred.String() {
+				t.Errorf("round trip mismatch: %q != %q", reparsed.String(), pred.String())
+			}
+		})
+	}
+
+	for _, input := range []string{`a IS 1`, `a IS NOT`, `a NULL`} {
+		if _, err := dbparse.NewParser(input).Predicate(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+
+	cmd, err := dbparse.NewParser(`INSERT INTO t (a, b) VALUES (NULL, "x")`).UpdateCmd()
+	if err != nil {
+		t.Fatalf("failed to parse insert: %v", err)
+	}
+	insert, ok := cmd.(*dbparse.InsertData)
+	if !ok {
+		t.Fatalf("expected InsertData, got %T", cmd)
+	}
+	if !dbconstant.IsNull(insert.Vals()[0]) {
+		t.Errorf("expected NULL, got %v", insert.Vals()[0])
+	}
+}
+
+func TestParseTransaction(t *testing.T) {
+	start, err := dbparse.NewParser("START TRANSACTION").StartTransaction()
+	if err != nil {
+		t.Fatalf("failed to parse start transaction: %v", err)
+	}
+	if _, ok := start.IsolationLevel(); ok {
+		t.Errorf("expected no isolation level")
+	}
+
+	tests := []struct {
+		clause string
+		want   dbtx.IsolationLevel
+	}{
+		{"ISOLATION LEVEL READ UNCOMMITTED", dbtx.IsolationReadUncommitted},
+		{"isolation level read committed", dbtx.IsolationReadCommitted},
+		{"ISOLATION LEVEL REPEATABLE READ", dbtx.IsolationRepeatableRead},
+		{"ISOLATION LEVEL SERIALIZABLE", dbtx.IsolationSerializable},
+		{"ISOLATION LEVEL SNAPSHOT", dbtx.IsolationSnapshot},
+	}
+	for _, tt := range tests {
+		start, err := dbparse.NewParser("START TRANSACTION " + tt.clause).StartTransaction()
+		if err != nil {
+			t.Fatalf("failed to parse start transaction %s: %v", tt.clause, err)
+		}
+		if got, ok := start.IsolationLevel(); !ok || got != tt.want {
+			t.Errorf("START TRANSACTION %s: expected %v, got %v", tt.clause, tt.want, got)
+		}
+
+		set, err := dbparse.NewParser("SET TRANSACTION " + tt.clause).SetTransaction()
+		if err != nil {
+			t.Fatalf("failed to parse set transaction %s: %v", tt.clause, err)
+		}
+		if got := set.IsolationLevel(); got != tt.want {
+			t.Errorf("SET TRANSACTION %s: expected %v, got %v", tt.clause, tt.want, got)
+		}
+	}
+
+	start, err = dbparse.NewParser("START TRANSACTION WITH CONSISTENT SNAPSHOT").StartTransaction()
+	if err != nil {
+		t.Fatalf("failed to parse start transaction with consistent snapshot: %v", err)
+	}
+	if got, ok := start.IsolationLevel(); !ok || got != dbtx.IsolationSnapshot {
+		t.Errorf("expected SNAPSHOT, got %v", got)
+	}
+
+	for _, input := range []string{"START TRANSACTION ISOLATION LEVEL READ", "START TRANSACTION ISOLATION LEVEL CHAOS", "START TRANSACTION WITH SNAPSHOT"} {
+		if _, err := dbparse.NewParser(input).StartTransaction(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+	for _, input := range []string{"SET TRANSACTION", "SET TRANSACTION ISOLATION READ COMMITTED"} {
+		if _, err := dbparse.NewParser(input).SetTransaction(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseRecoverTo(t *testing.T) {
+	recoverTo, err := dbparse.NewParser("RECOVER TO LSN 120").RecoverTo()
+	if err != nil {
+		t.Fatalf("failed to parse recover to lsn: %v", err)
+	}
+	if got := recoverTo.Target(); got.LSN != 120 || !got.Time.IsZero() {
+		t.Errorf("expected LSN 120, got %v", got)
+	}
+
+	recoverTo, err = dbparse.NewParser(`recover to timestamp "2026-10-16T12:00:00+09:00"`).RecoverTo()
+	if err != nil {
+		t.Fatalf("failed to parse recover to timestamp: %v", err)
+	}
+	want := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	if got := recoverTo.Target(); !got.Time.Equal(want) || got.LSN != 0 {
+		t.Errorf("expected %v, got %v", want, got.Time)
+	}
+
+	recoverTo, err = dbparse.NewParser(`RECOVER TO TIMESTAMP "2026-10-16 12:00:00"`).RecoverTo()
+	if err != nil {
+		t.Fatalf("failed to parse recover to local timestamp: %v", err)
+	}
+	if got := recoverTo.Target(); !got.Time.Equal(time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)) {
+		t.Errorf("expected local time, got %v", got.Time)
+	}
+
+	recoverTo, err = dbparse.NewParser("RECOVER TO LATEST").RecoverTo()
+	if err != nil {
+		t.Fatalf("failed to parse recover to latest: %v", err)
+	}
+	if got := recoverTo.Target(); got != (dbtx.RecoveryTarget{}) {
+		t.Errorf("expected latest, got %v", got)
+	}
+
+	for _, input := range []string{"RECOVER TO", "RECOVER TO LSN 0", `RECOVER TO TIMESTAMP "yesterday"`, "RECOVER LSN 1"} {
+		if _, err := dbparse.NewParser(input).RecoverTo(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseBackup(t *testing.T) {
+	backup, err := dbparse.NewParser(`BACKUP TO "/tmp/simpledb-backup"`).Backup()
+	if err != nil {
+		t.Fatalf("failed to parse backup: %v", err)
+	}
+	if got := backup.Dir(); got != "/tmp/simpledb-backup" {
+		t.Errorf("expected /tmp/simpledb-backup, got %q", got)
+	}
+
+	for _, input := range []string{"BACKUP", "BACKUP TO", `BACKUP TO ""`, `BACKUP "/tmp/x"`} {
+		if _, err := dbparse.NewParser(input).Backup(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseCheckTable(t *testing.T) {
+	tests := []struct {
+		input  string
+		table  string
+		repair bool
+	}{
+		{"CHECK TABLE student", "student", false},
+		{"check table student repair", "student", true},
+	}
+	for _, tt := range tests {
+		data, err := dbparse.NewParser(tt.input).CheckTable()
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.input, err)
+		}
+		if data.TableName() != tt.table || data.Repair() != tt.repair {
+			t.Errorf("%q: expected (%s, %v), got (%s, %v)", tt.input, tt.table, tt.repair, data.TableName(), data.Repair())
+		}
+	}
+
+	for _, input := range []string{"CHECK", "CHECK TABLE", "CHECK student", "CHECK TABLE select"} {
+		if _, err := dbparse.NewParser(input).CheckTable(); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
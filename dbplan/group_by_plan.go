@@ -0,0 +1,91 @@
+package dbplan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// GroupByPlan aggregates the output of child after sorting it by the group fields.
+type GroupByPlan struct {
+	child        dbquery.Plan
+	groupFields  []string
+	aggregations []*dbquery.Aggregation
+	schema       *dbrecord.Schema
+}
+
+func NewGroupByPlan(tx *dbtx.Transaction, child dbquery.Plan, groupFields []string, aggregations []*dbquery.Aggregation) *GroupByPlan {
+	schema := groupBySchema(child.Schema(), groupFields, aggregations)
+	if len(groupFields) > 0 {
+		keys := make([]*dbquery.SortKey, len(groupFields))
+		for i, fieldName := range groupFields {
+			keys[i] = dbquery.NewSortKey(fieldName, false)
+		}
+		child = NewSortPlan(tx, child, keys)
+	}
+	return &GroupByPlan{
+		child:        child,
+		groupFields:  groupFields,
+		aggregations: aggregations,
+		schema:       schema,
+	}
+}
+
+func (p *GroupByPlan) Open(ctx context.Context) (dbquery.Scan, error) {
+	scan, err := p.child.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open child: %w", err)
+	}
+	groupScan, err := dbquery.NewGroupByScan(ctx, scan, p.child.Schema(), p.groupFields, p.aggregations)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("aggregate: %w", err), scan.Close(ctx))
+	}
+	return groupScan, nil
+}
+
+func (p *GroupByPlan) BlockAccessed() int {
+	return p.child.BlockAccessed()
+}
+
+func (p *GroupByPlan) RecordsOutput() int {
+	return estimateGroups(p.child, p.groupFields)
+}
+
+func (p *GroupByPlan) DistinctValues(fieldName string) int {
+	if slices.Contains(p.groupFields, fieldName) {
+		return p.child.DistinctValues(fieldName)
+	}
+	return p.RecordsOutput()
+}
+
+func (p *GroupByPlan) Schema() *dbrecord.Schema {
+	return p.schema
+}
+
+func groupBySchema(input *dbrecord.Schema, groupFields []string, aggregations []*dbquery.Aggregation) *dbrecord.Schema {
+	schema := dbrecord.NewSchema()
+	for _, fieldName := range groupFields {
+		schema.Add(fieldName, input)
+	}
+	for _, a := range aggregations {
+		a.AddOutputField(schema, input)
+	}
+	return schema
+}
+
+// グループ数の見積もり. 各グループ化フィールドのdistinct valueの積で近似する
+func estimateGroups(child dbquery.Plan, groupFields []string) int {
+	numGroups := 1
+	for _, fieldName := range groupFields {
+		numGroups *= max(1, child.DistinctValues(fieldName))
+		if numGroups >= child.RecordsOutput() {
+			return max(1, child.RecordsOutput())
+		}
+	}
+	return numGroups
+}
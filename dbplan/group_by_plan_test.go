@@ -0,0 +1,131 @@
+package dbplan_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/teru01/simpledb-go/dbmetadata"
+	"github.com/teru01/simpledb-go/dbplan"
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+func readGroupCounts(t *testing.T, ctx context.Context, plan dbquery.Plan, groupField string) map[int]int {
+	t.Helper()
+	scan, err := plan.Open(ctx)
+	if err != nil {
+		t.Fatalf("failed to open plan: %v", err)
+	}
+	defer scan.Close(ctx)
+	counts := make(map[int]int)
+	for {
+		ok, err := scan.Next(ctx)
+		if err != nil {
+			t.Fatalf("failed to get next: %v", err)
+		}
+		if !ok {
+			return counts
+		}
+		g, err := scan.GetInt(ctx, groupField)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", groupField, err)
+		}
+		c, err := scan.GetInt(ctx, "count(*)")
+		if err != nil {
+			t.Fatalf("failed to get count: %v", err)
+		}
+		if _, ok := counts[g]; ok {
+			t.Fatalf("group %d returned twice", g)
+		}
+		counts[g] = c
+	}
+}
+
+func setupGroupByPlanTest(t *testing.T, ctx context.Context, mm *dbmetadata.MetadataManager, tx *dbtx.Transaction, numRecords int) *dbplan.TablePlan {
+	t.Helper()
+	schema := dbrecord.NewSchema()
+	schema.AddIntField("id")
+	schema.AddIntField("grp")
+	if err := mm.CreateTable(ctx, "items", schema, tx); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	layout, err := mm.GetLayout(ctx, "items", tx)
+	if err != nil {
+		t.Fatalf("failed to get layout: %v", err)
+	}
+	ts, err := dbrecord.NewTableScan(ctx, tx, "items", layout, false)
+	if err != nil {
+		t.Fatalf("failed to create table scan: %v", err)
+	}
+	for i := range numRecords {
+		if err := ts.Insert(ctx); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		if err := ts.SetInt(ctx, "id", i); err != nil {
+			t.Fatalf("failed to set id: %v", err)
+		}
+		if err := ts.SetInt(ctx, "grp", (i*7)%5); err != nil {
+			t.Fatalf("failed to set grp: %v", err)
+		}
+	}
+	ts.Close(ctx)
+
+	tp, err := dbplan.NewTablePlan(ctx, tx, "items", mm)
+	if err != nil {
+		t.Fatalf("failed to create table plan: %v", err)
+	}
+	return tp
+}
+
+func TestGroupByPlanAndHashGroupByPlanAgree(t *testing.T) {
+	mm, tx, cleanup := setupQueryPlannerTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	const numRecords = 1000
+	tp := setupGroupByPlanTest(t, ctx, mm, tx, numRecords)
+
+	aggregations := []*dbquery.Aggregation{dbquery.NewAggregation(dbquery.AggregateCount, dbquery.AllFields)}
+	for _, tc := range []struct {
+		name string
+		plan dbquery.Plan
+	}{
+		{"sort", dbplan.NewGroupByPlan(tx, tp, []string{"grp"}, aggregations)},
+		{"hash", dbplan.NewHashGroupByPlan(tp, []string{"grp"}, aggregations)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			counts := readGroupCounts(t, ctx, tc.plan, "grp")
+			if len(counts) != 5 {
+				t.Fatalf("expected 5 groups, got %d", len(counts))
+			}
+			for g, c := range counts {
+				if c != numRecords/5 {
+					t.Errorf("group %d: expected count %d, got %d", g, numRecords/5, c)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupByPlanRecordsOutput(t *testing.T) {
+	mm, tx, cleanup := setupQueryPlannerTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	tp := setupGroupByPlanTest(t, ctx, mm, tx, 1000)
+
+	aggregations := []*dbquery.Aggregation{dbquery.NewAggregation(dbquery.AggregateCount, dbquery.AllFields)}
+	// グループ数はdistinct valueの見積もりに基づく
+	if got := dbplan.NewHashGroupByPlan(tp, []string{"grp"}, aggregations).RecordsOutput(); got != tp.DistinctValues("grp") {
+		t.Errorf("expected %d groups, got %d", tp.DistinctValues("grp"), got)
+	}
+	// レコード数を超えない
+	if got := dbplan.NewGroupByPlan(tx, tp, []string{"id", "grp"}, aggregations).RecordsOutput(); got != tp.RecordsOutput() {
+		t.Errorf("expected %d groups, got %d", tp.RecordsOutput(), got)
+	}
+	// GROUP BYなしなら1行
+	if got := dbplan.NewHashGroupByPlan(tp, nil, aggregations).RecordsOutput(); got != 1 {
+		t.Errorf("expected 1 group, got %d", got)
+	}
+}
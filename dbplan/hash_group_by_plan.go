@@ -0,0 +1,59 @@
+package dbplan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+// HashGroupByPlan aggregates the output of child in an in-memory hash table without sorting.
+type HashGroupByPlan struct {
+	child        dbquery.Plan
+	groupFields  []string
+	aggregations []*dbquery.Aggregation
+	schema       *dbrecord.Schema
+}
+
+func NewHashGroupByPlan(child dbquery.Plan, groupFields []string, aggregations []*dbquery.Aggregation) *HashGroupByPlan {
+	return &HashGroupByPlan{
+		child:        child,
+		groupFields:  groupFields,
+		aggregations: aggregations,
+		schema:       groupBySchema(child.Schema(), groupFields, aggregations),
+	}
+}
+
+func (p *HashGroupByPlan) Open(ctx context.Context) (dbquery.Scan, error) {
+	scan, err := p.child.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open child: %w", err)
+	}
+	groupScan, err := dbquery.NewHashGroupByScan(ctx, scan, p.child.Schema(), p.groupFields, p.aggregations)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("aggregate: %w", err), scan.Close(ctx))
+	}
+	return groupScan, nil
+}
+
+func (p *HashGroupByPlan) BlockAccessed() int {
+	return p.child.BlockAccessed()
+}
+
+func (p *HashGroupByPlan) RecordsOutput() int {
+	return estimateGroups(p.child, p.groupFields)
+}
+
+func (p *HashGroupByPlan) DistinctValues(fieldName string) int {
+	if slices.Contains(p.groupFields, fieldName) {
+		return p.child.DistinctValues(fieldName)
+	}
+	return p.RecordsOutput()
+}
+
+func (p *HashGroupByPlan) Schema() *dbrecord.Schema {
+	return p.schema
+}
@@ -40,6 +40,9 @@ func (p *IndexUpdatePlanner) ExecuteInsert(ctx context.Context, data *dbparse.In
 		}
 	}()
 
+	if err := setOmittedFieldsNull(ctx, scan, plan.Schema(), data.Fields()); err != nil {
+		return 0, err
+	}
 	indexes, err := p.metadataManager.GetIndexInfo(ctx, tableName, tx)
 	if err != nil {
 		return 0, fmt.Errorf("get index info: %w", err)
@@ -155,20 +158,20 @@ func (p *IndexUpdatePlanner) ExecuteModify(ctx context.Context, modifyData *dbpa
 		if err != nil {
 			return 0, fmt.Errorf("evaluate new value for %q: %w", modifyData.TableName(), err)
 		}
+		// 更新前の値をindexから消すため先に読んでおく
+		oldVal, err := scan.GetValue(ctx, modifyData.FieldName())
+		if err != nil {
+			return affectedRows, fmt.Errorf("get value for %q: %w", modifyData.FieldName(), err)
+		}
 		if err := scan.SetValue(ctx, modifyData.FieldName(), newVal); err != nil {
 			return 0, fmt.Errorf("delete for %q: %w", modifyData.TableName(), err)
 		}
 
-		for field, ii := range indexes {
+		if ii, ok := indexes[modifyData.FieldName()]; ok {
 			index, err := ii.Open(ctx)
 			if err != nil {
 				return affectedRows, fmt.Errorf("open: %w", err)
 			}
-			oldVal, err := scan.GetValue(ctx, field)
-			if err != nil {
-				return affectedRows, fmt.Errorf("get value for %q: %w", field, err)
-			}
-
 			if err := index.Delete(ctx, oldVal, *scan.RID()); err != nil {
 				return affectedRows, fmt.Errorf("delete index: %w", err)
 			}
@@ -204,3 +207,52 @@ func (p *IndexUpdatePlanner) ExecuteCreateView(ctx context.Context, data *dbpars
 	}
 	return 0, nil
 }
+
+func (p *IndexUpdatePlanner) ExecuteDropTable(ctx context.Context, data *dbparse.DropTableData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.DropTable(ctx, data.TableName(), tx); err != nil {
+		return 0, fmt.Errorf("drop table %q: %w", data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (p *IndexUpdatePlanner) ExecuteDropIndex(ctx context.Context, data *dbparse.DropIndexData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.DropIndex(ctx, data.IndexName(), tx); err != nil {
+		return 0, fmt.Errorf("drop index %q: %w", data.IndexName(), err)
+	}
+	return 0, nil
+}
+
+func (p *IndexUpdatePlanner) ExecuteDropView(ctx context.Context, data *dbparse.DropViewData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.DropView(ctx, data.ViewName(), tx); err != nil {
+		return 0, fmt.Errorf("drop view %q: %w", data.ViewName(), err)
+	}
+	return 0, nil
+}
+
+func (p *IndexUpdatePlanner) ExecuteAddColumn(ctx context.Context, data *dbparse.AddColumnData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.AddColumn(ctx, data.TableName(), data.FieldName(), data.FieldType(), data.Length(), data.DefaultValue(), tx); err != nil {
+		return 0, fmt.Errorf("add column %q to %q: %w", data.FieldName(), data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (p *IndexUpdatePlanner) ExecuteDropColumn(ctx context.Context, data *dbparse.DropColumnData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.DropColumn(ctx, data.TableName(), data.FieldName(), tx); err != nil {
+		return 0, fmt.Errorf("drop column %q from %q: %w", data.FieldName(), data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (p *IndexUpdatePlanner) ExecuteRenameColumn(ctx context.Context, data *dbparse.RenameColumnData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.RenameColumn(ctx, data.TableName(), data.FieldName(), data.NewFieldName(), tx); err != nil {
+		return 0, fmt.Errorf("rename column %q of %q: %w", data.FieldName(), data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (p *IndexUpdatePlanner) ExecuteRenameTable(ctx context.Context, data *dbparse.RenameTableData, tx *dbtx.Transaction) (int, error) {
+	if err := p.metadataManager.RenameTable(ctx, data.TableName(), data.NewTableName(), tx); err != nil {
+		return 0, fmt.Errorf("rename table %q: %w", data.TableName(), err)
+	}
+	return 0, nil
+}
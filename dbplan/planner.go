@@ -20,6 +20,13 @@ type UpdatePlanner interface {
 	ExecuteCreateTable(ctx context.Context, data *dbparse.CreateTableData, tx *dbtx.Transaction) (int, error)
 	ExecuteCreateIndex(ctx context.Context, data *dbparse.CreateIndexData, tx *dbtx.Transaction) (int, error)
 	ExecuteCreateView(ctx context.Context, data *dbparse.CreateViewData, tx *dbtx.Transaction) (int, error)
+	ExecuteDropTable(ctx context.Context, data *dbparse.DropTableData, tx *dbtx.Transaction) (int, error)
+	ExecuteDropIndex(ctx context.Context, data *dbparse.DropIndexData, tx *dbtx.Transaction) (int, error)
+	ExecuteDropView(ctx context.Context, data *dbparse.DropViewData, tx *dbtx.Transaction) (int, error)
+	ExecuteAddColumn(ctx context.Context, data *dbparse.AddColumnData, tx *dbtx.Transaction) (int, error)
+	ExecuteDropColumn(ctx context.Context, data *dbparse.DropColumnData, tx *dbtx.Transaction) (int, error)
+	ExecuteRenameColumn(ctx context.Context, data *dbparse.RenameColumnData, tx *dbtx.Transaction) (int, error)
+	ExecuteRenameTable(ctx context.Context, data *dbparse.RenameTableData, tx *dbtx.Transaction) (int, error)
 }
 
 type Planner struct {
@@ -59,6 +66,20 @@ func (p *Planner) ExecuteUpdate(ctx context.Context, sql string, tx *dbtx.Transa
 		return p.updatePlanner.ExecuteCreateIndex(ctx, updateData, tx)
 	case *dbparse.CreateViewData:
 		return p.updatePlanner.ExecuteCreateView(ctx, updateData, tx)
+	case *dbparse.DropTableData:
+		return p.updatePlanner.ExecuteDropTable(ctx, updateData, tx)
+	case *dbparse.DropIndexData:
+		return p.updatePlanner.ExecuteDropIndex(ctx, updateData, tx)
+	case *dbparse.DropViewData:
+		return p.updatePlanner.ExecuteDropView(ctx, updateData, tx)
+	case *dbparse.AddColumnData:
+		return p.updatePlanner.ExecuteAddColumn(ctx, updateData, tx)
+	case *dbparse.DropColumnData:
+		return p.updatePlanner.ExecuteDropColumn(ctx, updateData, tx)
+	case *dbparse.RenameColumnData:
+		return p.updatePlanner.ExecuteRenameColumn(ctx, updateData, tx)
+	case *dbparse.RenameTableData:
+		return p.updatePlanner.ExecuteRenameTable(ctx, updateData, tx)
 	default:
 		return 0, fmt.Errorf("unexpected update data: %T", updateData)
 	}
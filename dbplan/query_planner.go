@@ -3,30 +3,50 @@ package dbplan
 import (
 	"context"
 	"fmt"
+	"slices"
 
+	"github.com/teru01/simpledb-go/dberr"
 	"github.com/teru01/simpledb-go/dbmetadata"
 	"github.com/teru01/simpledb-go/dbparse"
 	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
 	"github.com/teru01/simpledb-go/dbtx"
 )
 
+// 見積もりグループ数がこれ以下ならソートせずハッシュで集約する
+const hashAggregateMaxGroups = 1000
+
 type BasicQueryPlanner struct {
 	metadataManager *dbmetadata.MetadataManager
+	// 同じ名前のtableやviewより優先する
+	systemTables map[string]*SystemTable
 }
 
 func NewQueryPlanner(metadataManager *dbmetadata.MetadataManager) *BasicQueryPlanner {
-	return &BasicQueryPlanner{metadataManager: metadataManager}
+	return &BasicQueryPlanner{metadataManager: metadataManager, systemTables: make(map[string]*SystemTable)}
+}
+
+// FROMでtableNameとして参照できるようにする
+// tableNameはdbmetadata.SystemTablePrefixで始め, 作成されたtableやviewと重ならないようにする
+func (q *BasicQueryPlanner) AddSystemTable(tableName string, table *SystemTable) {
+	q.systemTables[tableName] = table
 }
 
 // create plan from query data
-// step1: create plan for each table or view
+// step1: create plan for each table, view or system table
 // step2: apply index select if possible (WHERE field = constant on indexed field)
 // step3: create product plan for each pair of plans
 // step4: create select plan
-// step5: create project plan for the final plan
+// step5: create group by plan if GROUP BY or aggregations are specified
+// step6: create sort plan if ORDER BY is specified
+// step7: create project plan for the final plan
 func (q *BasicQueryPlanner) CreatePlan(ctx context.Context, queryData *dbparse.QueryData, tx *dbtx.Transaction) (dbquery.Plan, error) {
 	var plans []dbquery.Plan
 	for _, tableName := range queryData.Tables() {
+		if table, ok := q.systemTables[tableName]; ok {
+			plans = append(plans, NewSystemTablePlan(table))
+			continue
+		}
 		viewDef, err := q.metadataManager.GetViewDef(ctx, tableName, tx)
 		if err != nil {
 			return nil, fmt.Errorf("get view def for plan: %w", err)
@@ -86,6 +106,19 @@ func (q *BasicQueryPlanner) CreatePlan(ctx context.Context, queryData *dbparse.Q
 	}
 
 	plan = NewSelectPlan(plan, queryData.Predicate())
+	if len(queryData.GroupBy()) > 0 || len(queryData.Aggregations()) > 0 {
+		if err := checkGroupBy(plan.Schema(), queryData); err != nil {
+			return nil, fmt.Errorf("check group by: %w", err)
+		}
+		plan = newGroupByPlan(tx, plan, queryData.GroupBy(), queryData.Aggregations())
+	}
+	// 射影で消えるフィールドでもソートできるようにprojectの前でソートする
+	if len(queryData.OrderBy()) > 0 {
+		if err := checkOrderBy(plan.Schema(), queryData); err != nil {
+			return nil, fmt.Errorf("check order by: %w", err)
+		}
+		plan = NewSortPlan(tx, plan, queryData.OrderBy())
+	}
 	return NewProjectPlan(plan, queryData.Fields()), nil
 }
 
@@ -107,3 +140,50 @@ func (q *BasicQueryPlanner) tryIndexJoin(ctx context.Context, p1, p2 dbquery.Pla
 	}
 	return nil
 }
+
+// グループ数が少なければメモリ上のハッシュ表で、多ければソートしてから集約する
+func newGroupByPlan(tx *dbtx.Transaction, child dbquery.Plan, groupFields []string, aggregations []*dbquery.Aggregation) dbquery.Plan {
+	if estimateGroups(child, groupFields) <= hashAggregateMaxGroups {
+		return NewHashGroupByPlan(child, groupFields, aggregations)
+	}
+	return NewGroupByPlan(tx, child, groupFields, aggregations)
+}
+
+// ソートキーはソートする時点のschemaに含まれていなければならない
+// GROUP BYや集約がある場合はGROUP BYのフィールドか集約の結果だけでソートできる
+func checkOrderBy(schema *dbrecord.Schema, queryData *dbparse.QueryData) error {
+	grouped := len(queryData.GroupBy()) > 0 || len(queryData.Aggregations()) > 0
+	for _, key := range queryData.OrderBy() {
+		if schema.HasField(key.FieldName()) {
+			continue
+		}
+		if grouped {
+			return dberr.New(dberr.CodeSemanticError, fmt.Sprintf("order by field %q must appear in the GROUP BY clause or be an aggregate function in the select list", key.FieldName()), nil)
+		}
+		return dberr.New(dberr.CodeSemanticError, fmt.Sprintf("order by field %q not found", key.FieldName()), nil)
+	}
+	return nil
+}
+
+// select listの集約以外のフィールドはGROUP BYに含まれていなければならない
+func checkGroupBy(schema *dbrecord.Schema, queryData *dbparse.QueryData) error {
+	for _, fieldName := range queryData.GroupBy() {
+		if !schema.HasField(fieldName) {
+			return fmt.Errorf("group by field %q not found", fieldName)
+		}
+	}
+	for _, a := range queryData.Aggregations() {
+		if err := a.Check(schema); err != nil {
+			return fmt.Errorf("aggregation %s: %w", a, err)
+		}
+	}
+	for _, fieldName := range queryData.Fields() {
+		isAggregation := slices.ContainsFunc(queryData.Aggregations(), func(a *dbquery.Aggregation) bool {
+			return a.OutputName() == fieldName
+		})
+		if !isAggregation && !slices.Contains(queryData.GroupBy(), fieldName) {
+			return fmt.Errorf("field %q must appear in the GROUP BY clause or be used in an aggregate function", fieldName)
+		}
+	}
+	return nil
+}
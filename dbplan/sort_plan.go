@@ -0,0 +1,188 @@
+package dbplan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+// SortPlan sorts the output of child with an external merge sort.
+// step1: 空きバッファ数に収まる分ずつレコードを読み込みメモリ上でソートし、runとしてtemp tableに書き出す
+// step2: runが2つ以下になるまで2つずつマージする
+// step3: 残ったrunをSortScanでマージしながら返す
+// runのファイルはtransactionの終了時に削除される
+type SortPlan struct {
+	tx         *dbtx.Transaction
+	child      dbquery.Plan
+	schema     *dbrecord.Schema
+	comparator *dbquery.RecordComparator
+}
+
+func NewSortPlan(tx *dbtx.Transaction, child dbquery.Plan, sortKeys []*dbquery.SortKey) *SortPlan {
+	return &SortPlan{
+		tx:         tx,
+		child:      child,
+		schema:     child.Schema(),
+		comparator: dbquery.NewRecordComparator(sortKeys),
+	}
+}
+
+func (p *SortPlan) Open(ctx context.Context) (dbquery.Scan, error) {
+	src, err := p.child.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open child: %w", err)
+	}
+	runs, err := p.splitIntoRuns(ctx, src)
+	if err := errors.Join(err, src.Close(ctx)); err != nil {
+		return nil, fmt.Errorf("split into runs: %w", err)
+	}
+	for len(runs) > 2 {
+		runs, err = p.mergeIteration(ctx, runs)
+		if err != nil {
+			return nil, fmt.Errorf("merge runs: %w", err)
+		}
+	}
+	return dbquery.NewSortScan(ctx, runs, p.comparator)
+}
+
+// ソート後のtemp tableのブロック数. ソート自体のコストは含まない
+func (p *SortPlan) BlockAccessed() int {
+	layout := dbrecord.NewLayout(p.schema)
+	recordsPerBlock := max(1, p.tx.BlockSize()/layout.SlotSize())
+	return (p.child.RecordsOutput() + recordsPerBlock - 1) / recordsPerBlock
+}
+
+func (p *SortPlan) RecordsOutput() int {
+	return p.child.RecordsOutput()
+}
+
+func (p *SortPlan) DistinctValues(fieldName string) int {
+	return p.child.DistinctValues(fieldName)
+}
+
+func (p *SortPlan) Schema() *dbrecord.Schema {
+	return p.schema
+}
+
+// 1つのrunは空きバッファ数分のブロックに収まるレコード数とする
+func (p *SortPlan) runSize() int {
+	layout := dbrecord.NewLayout(p.schema)
+	recordsPerBlock := max(1, p.tx.BlockSize()/layout.SlotSize())
+	return max(1, p.tx.AvailableBuffs()) * recordsPerBlock
+}
+
+func (p *SortPlan) splitIntoRuns(ctx context.Context, src dbquery.Scan) ([]*dbquery.TempTable, error) {
+	var runs []*dbquery.TempTable
+	runSize := p.runSize()
+	records := make([]map[string]dbconstant.Constant, 0, runSize)
+	for {
+		hasNext, err := src.Next(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("next: %w", err)
+		}
+		if hasNext {
+			record := make(map[string]dbconstant.Constant, len(p.schema.Fields()))
+			for _, fieldName := range p.schema.Fields() {
+				val, err := src.GetValue(ctx, fieldName)
+				if err != nil {
+					return nil, fmt.Errorf("get value of %q: %w", fieldName, err)
+				}
+				record[fieldName] = val
+			}
+			records = append(records, record)
+		}
+		if len(records) == runSize || (!hasNext && (len(records) > 0 || len(runs) == 0)) {
+			// 入力が空でも1つの空のrunを作る
+			run, err := p.writeRun(ctx, records)
+			if err != nil {
+				return nil, fmt.Errorf("write run: %w", err)
+			}
+			runs = append(runs, run)
+			records = records[:0]
+		}
+		if !hasNext {
+			return runs, nil
+		}
+	}
+}
+
+func (p *SortPlan) writeRun(ctx context.Context, records []map[string]dbconstant.Constant) (_ *dbquery.TempTable, err error) {
+	slices.SortStableFunc(records, p.comparator.CompareValues)
+	run := dbquery.NewTempTable(p.tx, p.schema)
+	dest, err := run.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, dest.Close(ctx))
+	}()
+	for _, record := range records {
+		if err := dest.Insert(ctx); err != nil {
+			return nil, fmt.Errorf("insert: %w", err)
+		}
+		for _, fieldName := range p.schema.Fields() {
+			if err := dest.SetValue(ctx, fieldName, record[fieldName]); err != nil {
+				return nil, fmt.Errorf("set value of %q: %w", fieldName, err)
+			}
+		}
+	}
+	return run, nil
+}
+
+func (p *SortPlan) mergeIteration(ctx context.Context, runs []*dbquery.TempTable) ([]*dbquery.TempTable, error) {
+	var result []*dbquery.TempTable
+	for len(runs) > 1 {
+		merged, err := p.mergeTwoRuns(ctx, runs[0], runs[1])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, merged)
+		runs = runs[2:]
+	}
+	return append(result, runs...), nil
+}
+
+func (p *SortPlan) mergeTwoRuns(ctx context.Context, r1, r2 *dbquery.TempTable) (_ *dbquery.TempTable, err error) {
+	src, err := dbquery.NewSortScan(ctx, []*dbquery.TempTable{r1, r2}, p.comparator)
+	if err != nil {
+		return nil, fmt.Errorf("open runs: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, src.Close(ctx))
+	}()
+	result := dbquery.NewTempTable(p.tx, p.schema)
+	dest, err := result.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, dest.Close(ctx))
+	}()
+	for {
+		hasNext, err := src.Next(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("next: %w", err)
+		}
+		if !hasNext {
+			return result, nil
+		}
+		if err := dest.Insert(ctx); err != nil {
+			return nil, fmt.Errorf("insert: %w", err)
+		}
+		for _, fieldName := range p.schema.Fields() {
+			val, err := src.GetValue(ctx, fieldName)
+			if err != nil {
+				return nil, fmt.Errorf("get value of %q: %w", fieldName, err)
+			}
+			if err := dest.SetValue(ctx, fieldName, val); err != nil {
+				return nil, fmt.Errorf("set value of %q: %w", fieldName, err)
+			}
+		}
+	}
+}
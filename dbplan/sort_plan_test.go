@@ -0,0 +1,169 @@
+package dbplan_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/teru01/simpledb-go/dberr"
+	"github.com/teru01/simpledb-go/dbparse"
+	"github.com/teru01/simpledb-go/dbplan"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+func TestSortPlanLargerThanBufferPool(t *testing.T) {
+	mm, tx, cleanup := setupQueryPlannerTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	schema := dbrecord.NewSchema()
+	schema.AddIntField("id")
+	schema.AddStringField("name", 20)
+	schema.AddIntField("age")
+	if err := mm.CreateTable(ctx, "users", schema, tx); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	layout, err := mm.GetLayout(ctx, "users", tx)
+	if err != nil {
+		t.Fatalf("failed to get layout: %v", err)
+	}
+	ts, err := dbrecord.NewTableScan(ctx, tx, "users", layout, false)
+	if err != nil {
+		t.Fatalf("failed to create table scan: %v", err)
+	}
+	// バッファプール(8)より多いブロック数になるようにする
+	const numRecords = 1000
+	for i := range numRecords {
+		id := (i * 7919) % numRecords
+		if err := ts.Insert(ctx); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		if err := ts.SetInt(ctx, "id", id); err != nil {
+			t.Fatalf("failed to set id: %v", err)
+		}
+		if err := ts.SetString(ctx, "name", "user"); err != nil {
+			t.Fatalf("failed to set name: %v", err)
+		}
+		if err := ts.SetInt(ctx, "age", id%10); err != nil {
+			t.Fatalf("failed to set age: %v", err)
+		}
+	}
+	ts.Close(ctx)
+
+	qp := dbplan.NewQueryPlanner(mm)
+	queryData, err := dbparse.NewParser("SELECT id FROM users ORDER BY age DESC, id").Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	plan, err := qp.CreatePlan(ctx, queryData, tx)
+	if err != nil {
+		t.Fatalf("failed to create plan: %v", err)
+	}
+	scan, err := plan.Open(ctx)
+	if err != nil {
+		t.Fatalf("failed to open plan: %v", err)
+	}
+	defer scan.Close(ctx)
+
+	var got []int
+	for {
+		ok, err := scan.Next(ctx)
+		if err != nil {
+			t.Fatalf("failed to get next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		id, err := scan.GetInt(ctx, "id")
+		if err != nil {
+			t.Fatalf("failed to get id: %v", err)
+		}
+		got = append(got, id)
+	}
+
+	if len(got) != numRecords {
+		t.Fatalf("expected %d records, got %d", numRecords, len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		prevAge, age := got[i-1]%10, got[i]%10
+		if prevAge < age || (prevAge == age && got[i-1] >= got[i]) {
+			t.Fatalf("records not sorted at %d: %d then %d", i, got[i-1], got[i])
+		}
+	}
+}
+
+func TestSortPlanEmptyInput(t *testing.T) {
+	mm, tx, cleanup := setupQueryPlannerTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	schema := dbrecord.NewSchema()
+	schema.AddIntField("id")
+	if err := mm.CreateTable(ctx, "users", schema, tx); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	qp := dbplan.NewQueryPlanner(mm)
+	queryData, err := dbparse.NewParser("SELECT id FROM users ORDER BY id").Query()
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	plan, err := qp.CreatePlan(ctx, queryData, tx)
+	if err != nil {
+		t.Fatalf("failed to create plan: %v", err)
+	}
+	scan, err := plan.Open(ctx)
+	if err != nil {
+		t.Fatalf("failed to open plan: %v", err)
+	}
+	defer scan.Close(ctx)
+
+	ok, err := scan.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to get next: %v", err)
+	}
+	if ok {
+		t.Errorf("expected no records")
+	}
+}
+
+func TestSortPlanInvalidSortKeys(t *testing.T) {
+	mm, tx, cleanup := setupQueryPlannerTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	schema := dbrecord.NewSchema()
+	schema.AddIntField("id")
+	schema.AddIntField("age")
+	if err := mm.CreateTable(ctx, "users", schema, tx); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	qp := dbplan.NewQueryPlanner(mm)
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		// 射影しないフィールドでもソートできる
+		{"SELECT id FROM users ORDER BY age", true},
+		{"SELECT age, COUNT(id) FROM users GROUP BY age ORDER BY COUNT(id) DESC, age", true},
+		{"SELECT id FROM users ORDER BY nosuch", false},
+		{"SELECT age, COUNT(id) FROM users GROUP BY age ORDER BY id", false},
+		{"SELECT age FROM users GROUP BY age ORDER BY COUNT(id)", false},
+	}
+	for _, tt := range tests {
+		queryData, err := dbparse.NewParser(tt.query).Query()
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.query, err)
+		}
+		_, err = qp.CreatePlan(ctx, queryData, tx)
+		if tt.valid && err != nil {
+			t.Errorf("%q: failed to create plan: %v", tt.query, err)
+		}
+		if !tt.valid && !dberr.IsCode(err, dberr.CodeSemanticError) {
+			t.Errorf("%q: expected semantic error, got %v", tt.query, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package dbplan
+
+import (
+	"context"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+// SystemTable is a read-only table whose rows are generated from the server state instead of being stored on disk.
+// rowsの各行はschemaのfieldと同じ順で値を持つ
+type SystemTable struct {
+	schema *dbrecord.Schema
+	rows   func() [][]dbconstant.Constant
+}
+
+func NewSystemTable(schema *dbrecord.Schema, rows func() [][]dbconstant.Constant) *SystemTable {
+	return &SystemTable{schema: schema, rows: rows}
+}
+
+// SystemTablePlan scans the rows of a system table taken when the plan is created.
+type SystemTablePlan struct {
+	schema *dbrecord.Schema
+	rows   [][]dbconstant.Constant
+}
+
+func NewSystemTablePlan(table *SystemTable) *SystemTablePlan {
+	return &SystemTablePlan{schema: table.schema, rows: table.rows()}
+}
+
+func (p *SystemTablePlan) Open(ctx context.Context) (dbquery.Scan, error) {
+	return dbquery.NewValuesScan(p.schema.Fields(), p.rows), nil
+}
+
+// メモリ上にあるのでblockは読まない
+func (p *SystemTablePlan) BlockAccessed() int {
+	return 0
+}
+
+func (p *SystemTablePlan) RecordsOutput() int {
+	return len(p.rows)
+}
+
+func (p *SystemTablePlan) DistinctValues(fieldName string) int {
+	pos := slices.Index(p.schema.Fields(), fieldName)
+	if pos < 0 {
+		return 0
+	}
+	values := make(map[string]struct{})
+	for _, row := range p.rows {
+		values[row[pos].String()] = struct{}{}
+	}
+	return len(values)
+}
+
+func (p *SystemTablePlan) Schema() *dbrecord.Schema {
+	return p.schema
+}
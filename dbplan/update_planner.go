@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 
+	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbmetadata"
 	"github.com/teru01/simpledb-go/dbparse"
 	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
 	"github.com/teru01/simpledb-go/dbtx"
 )
 
@@ -105,6 +108,9 @@ func (u *BasicUpdatePlanner) ExecuteInsert(ctx context.Context, insertData *dbpa
 		return 0, fmt.Errorf("insert to %q: %w", insertData.TableName(), err)
 	}
 
+	if err = setOmittedFieldsNull(ctx, scan.(dbquery.UpdateScan), p.Schema(), insertData.Fields()); err != nil {
+		return 0, err
+	}
 	for i, field := range insertData.Fields() {
 		if err = scan.(dbquery.UpdateScan).SetValue(ctx, field, insertData.Vals()[i]); err != nil {
 			return 0, fmt.Errorf("set value to %q: %w", field, err)
@@ -113,6 +119,19 @@ func (u *BasicUpdatePlanner) ExecuteInsert(ctx context.Context, insertData *dbpa
 	return 1, nil
 }
 
+// INSERTで指定されなかったfieldはNULLにする
+func setOmittedFieldsNull(ctx context.Context, scan dbquery.UpdateScan, schema *dbrecord.Schema, fields []string) error {
+	for _, field := range schema.Fields() {
+		if slices.Contains(fields, field) {
+			continue
+		}
+		if err := scan.SetValue(ctx, field, dbconstant.NewNullConstant()); err != nil {
+			return fmt.Errorf("set null to %q: %w", field, err)
+		}
+	}
+	return nil
+}
+
 func (u *BasicUpdatePlanner) ExecuteCreateTable(ctx context.Context, createTableData *dbparse.CreateTableData, tx *dbtx.Transaction) (int, error) {
 	if err := u.metadataManager.CreateTable(ctx, createTableData.TableName(), createTableData.Schema(), tx); err != nil {
 		return 0, fmt.Errorf("create table for %q: %w", createTableData.TableName(), err)
@@ -133,3 +152,52 @@ func (u *BasicUpdatePlanner) ExecuteCreateView(ctx context.Context, createViewDa
 	}
 	return 0, nil
 }
+
+func (u *BasicUpdatePlanner) ExecuteDropTable(ctx context.Context, data *dbparse.DropTableData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.DropTable(ctx, data.TableName(), tx); err != nil {
+		return 0, fmt.Errorf("drop table %q: %w", data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (u *BasicUpdatePlanner) ExecuteDropIndex(ctx context.Context, data *dbparse.DropIndexData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.DropIndex(ctx, data.IndexName(), tx); err != nil {
+		return 0, fmt.Errorf("drop index %q: %w", data.IndexName(), err)
+	}
+	return 0, nil
+}
+
+func (u *BasicUpdatePlanner) ExecuteDropView(ctx context.Context, data *dbparse.DropViewData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.DropView(ctx, data.ViewName(), tx); err != nil {
+		return 0, fmt.Errorf("drop view %q: %w", data.ViewName(), err)
+	}
+	return 0, nil
+}
+
+func (u *BasicUpdatePlanner) ExecuteAddColumn(ctx context.Context, data *dbparse.AddColumnData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.AddColumn(ctx, data.TableName(), data.FieldName(), data.FieldType(), data.Length(), data.DefaultValue(), tx); err != nil {
+		return 0, fmt.Errorf("add column %q to %q: %w", data.FieldName(), data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (u *BasicUpdatePlanner) ExecuteDropColumn(ctx context.Context, data *dbparse.DropColumnData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.DropColumn(ctx, data.TableName(), data.FieldName(), tx); err != nil {
+		return 0, fmt.Errorf("drop column %q from %q: %w", data.FieldName(), data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (u *BasicUpdatePlanner) ExecuteRenameColumn(ctx context.Context, data *dbparse.RenameColumnData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.RenameColumn(ctx, data.TableName(), data.FieldName(), data.NewFieldName(), tx); err != nil {
+		return 0, fmt.Errorf("rename column %q of %q: %w", data.FieldName(), data.TableName(), err)
+	}
+	return 0, nil
+}
+
+func (u *BasicUpdatePlanner) ExecuteRenameTable(ctx context.Context, data *dbparse.RenameTableData, tx *dbtx.Transaction) (int, error) {
+	if err := u.metadataManager.RenameTable(ctx, data.TableName(), data.NewTableName(), tx); err != nil {
+		return 0, fmt.Errorf("rename table %q: %w", data.TableName(), err)
+	}
+	return 0, nil
+}
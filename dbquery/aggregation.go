@@ -0,0 +1,226 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+type AggregateFunc int
+
+const (
+	AggregateCount AggregateFunc = iota
+	AggregateSum
+	AggregateMin
+	AggregateMax
+	AggregateAvg
+)
+
+// COUNT(*)のフィールド名
+const AllFields = "*"
+
+func (f AggregateFunc) String() string {
+	switch f {
+	case AggregateCount:
+		return "count"
+	case AggregateSum:
+		return "sum"
+	case AggregateMin:
+		return "min"
+	case AggregateMax:
+		return "max"
+	case AggregateAvg:
+		return "avg"
+	}
+	return "unknown"
+}
+
+// Aggregation is an aggregate expression such as SUM(score) in the select list.
+type Aggregation struct {
+	fn        AggregateFunc
+	fieldName string
+}
+
+func NewAggregation(fn AggregateFunc, fieldName string) *Aggregation {
+	return &Aggregation{fn: fn, fieldName: fieldName}
+}
+
+func (a *Aggregation) Func() AggregateFunc {
+	return a.fn
+}
+
+func (a *Aggregation) FieldName() string {
+	return a.fieldName
+}
+
+// 集約結果のフィールド名. SQLの表記と同じにしてview定義から再度parseできるようにする
+func (a *Aggregation) OutputName() string {
+	return fmt.Sprintf("%s(%s)", a.fn, a.fieldName)
+}
+
+func (a *Aggregation) String() string {
+	return a.OutputName()
+}
+
+// 入力のschemaに対して集約できるか検証する
+func (a *Aggregation) Check(schema *dbrecord.Schema) error {
+	if a.fieldName == AllFields {
+		if a.fn != AggregateCount {
+			return fmt.Errorf("%s(*) is not supported", a.fn)
+		}
+		return nil
+	}
+	if !schema.HasField(a.fieldName) {
+		return fmt.Errorf("field %q not found", a.fieldName)
+	}
+	if (a.fn == AggregateSum || a.fn == AggregateAvg) && schema.FieldType(a.fieldName) != dbrecord.FieldTypeInt {
+		return fmt.Errorf("%s requires int field but %q is not", a.fn, a.fieldName)
+	}
+	return nil
+}
+
+// 集約結果のフィールドをschemaに追加する. inputは集約前のschema
+func (a *Aggregation) AddOutputField(schema *dbrecord.Schema, input *dbrecord.Schema) {
+	if (a.fn == AggregateMin || a.fn == AggregateMax) && input.FieldType(a.fieldName) == dbrecord.FieldTypeString {
+		schema.AddStringField(a.OutputName(), input.Length(a.fieldName))
+		return
+	}
+	schema.AddIntField(a.OutputName())
+}
+
+func (a *Aggregation) newAccumulator() *accumulator {
+	return &accumulator{aggregation: a}
+}
+
+// accumulator holds the running state of an aggregation for one group.
+type accumulator struct {
+	aggregation *Aggregation
+	// NULLでない入力の数. COUNT(*)は全行
+	count int
+	sum   int
+	best  dbconstant.Constant
+}
+
+// NULLは集約の対象にしない
+func (acc *accumulator) add(ctx context.Context, s Scan) error {
+	if acc.aggregation.fieldName == AllFields {
+		acc.count++
+		return nil
+	}
+	val, err := s.GetValue(ctx, acc.aggregation.fieldName)
+	if err != nil {
+		return fmt.Errorf("get value of %q: %w", acc.aggregation.fieldName, err)
+	}
+	if dbconstant.IsNull(val) {
+		return nil
+	}
+	acc.count++
+	switch acc.aggregation.fn {
+	case AggregateSum, AggregateAvg:
+		n, ok := val.AsRaw().(int)
+		if !ok {
+			return fmt.Errorf("%s of non-int value %q", acc.aggregation.fn, val)
+		}
+		acc.sum += n
+	case AggregateMin:
+		if acc.best == nil || val.Compare(acc.best) < 0 {
+			acc.best = val
+		}
+	case AggregateMax:
+		if acc.best == nil || val.Compare(acc.best) > 0 {
+			acc.best = val
+		}
+	}
+	return nil
+}
+
+// COUNT以外は対象の値がなければNULL
+func (acc *accumulator) value() dbconstant.Constant {
+	if acc.aggregation.fn == AggregateCount {
+		return dbconstant.NewIntConstant(acc.count)
+	}
+	if acc.count == 0 {
+		return dbconstant.NewNullConstant()
+	}
+	switch acc.aggregation.fn {
+	case AggregateSum:
+		return dbconstant.NewIntConstant(acc.sum)
+	case AggregateAvg:
+		// int型しかないので切り捨てる
+		return dbconstant.NewIntConstant(acc.sum / acc.count)
+	default:
+		return acc.best
+	}
+}
+
+// group is the values of group by fields and the accumulators of one group.
+type group struct {
+	values       map[string]dbconstant.Constant
+	accumulators []*accumulator
+}
+
+func newGroup(values map[string]dbconstant.Constant, aggregations []*Aggregation) *group {
+	accs := make([]*accumulator, len(aggregations))
+	for i, a := range aggregations {
+		accs[i] = a.newAccumulator()
+	}
+	return &group{values: values, accumulators: accs}
+}
+
+func (g *group) add(ctx context.Context, s Scan) error {
+	for _, acc := range g.accumulators {
+		if err := acc.add(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *group) getValue(fieldName string) (dbconstant.Constant, error) {
+	if v, ok := g.values[fieldName]; ok {
+		return v, nil
+	}
+	for _, acc := range g.accumulators {
+		if acc.aggregation.OutputName() == fieldName {
+			return acc.value(), nil
+		}
+	}
+	return nil, fmt.Errorf("field %q not found", fieldName)
+}
+
+func groupValues(ctx context.Context, s Scan, groupFields []string) (map[string]dbconstant.Constant, error) {
+	values := make(map[string]dbconstant.Constant, len(groupFields))
+	for _, fieldName := range groupFields {
+		v, err := s.GetValue(ctx, fieldName)
+		if err != nil {
+			return nil, fmt.Errorf("get value of %q: %w", fieldName, err)
+		}
+		values[fieldName] = v
+	}
+	return values, nil
+}
+
+// NULLはゼロ値として返す
+func constantAsInt(v dbconstant.Constant, fieldName string) (int, error) {
+	if dbconstant.IsNull(v) {
+		return 0, nil
+	}
+	n, ok := v.AsRaw().(int)
+	if !ok {
+		return 0, fmt.Errorf("field %q is not int", fieldName)
+	}
+	return n, nil
+}
+
+func constantAsString(v dbconstant.Constant, fieldName string) (string, error) {
+	if dbconstant.IsNull(v) {
+		return "", nil
+	}
+	str, ok := v.AsRaw().(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not string", fieldName)
+	}
+	return str, nil
+}
@@ -2,6 +2,7 @@ package dbquery
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbrecord"
@@ -35,6 +36,10 @@ func (e *Expression) String() string {
 	if e.IsFieldName() {
 		return e.fieldName
 	}
+	// parserで再度読めるように文字列はquoteする
+	if str, ok := e.value.AsRaw().(string); ok {
+		return strconv.Quote(str)
+	}
 	return e.value.String()
 }
 
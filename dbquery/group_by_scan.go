@@ -0,0 +1,139 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+// GroupByScan aggregates the records of scan which is sorted by groupFields.
+type GroupByScan struct {
+	scan         Scan
+	schema       *dbrecord.Schema
+	groupFields  []string
+	aggregations []*Aggregation
+	state        groupByScanState
+}
+
+type groupByScanState struct {
+	moreGroups bool
+	// 入力が空のままGROUP BYなしで集約したとき1行返したか
+	emptyGroupReturned bool
+	currentGroup       *group
+}
+
+// schemaは集約前の入力のschema
+func NewGroupByScan(ctx context.Context, scan Scan, schema *dbrecord.Schema, groupFields []string, aggregations []*Aggregation) (*GroupByScan, error) {
+	s := &GroupByScan{
+		scan:         scan,
+		schema:       schema,
+		groupFields:  groupFields,
+		aggregations: aggregations,
+	}
+	if err := s.SetStateToBeforeFirst(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *GroupByScan) SetStateToBeforeFirst(ctx context.Context) error {
+	if err := s.scan.SetStateToBeforeFirst(ctx); err != nil {
+		return fmt.Errorf("move to before first: %w", err)
+	}
+	moreGroups, err := s.scan.Next(ctx)
+	if err != nil {
+		return fmt.Errorf("next: %w", err)
+	}
+	s.state = groupByScanState{moreGroups: moreGroups}
+	return nil
+}
+
+// 同じグループの値が続く間入力を読み進めて集約する
+func (s *GroupByScan) Next(ctx context.Context) (bool, error) {
+	if !s.state.moreGroups {
+		if len(s.groupFields) == 0 && s.state.currentGroup == nil && !s.state.emptyGroupReturned {
+			s.state.emptyGroupReturned = true
+			s.state.currentGroup = newGroup(nil, s.aggregations)
+			return true, nil
+		}
+		return false, nil
+	}
+	values, err := groupValues(ctx, s.scan, s.groupFields)
+	if err != nil {
+		return false, err
+	}
+	g := newGroup(values, s.aggregations)
+	for {
+		if err := g.add(ctx, s.scan); err != nil {
+			return false, err
+		}
+		s.state.moreGroups, err = s.scan.Next(ctx)
+		if err != nil {
+			return false, fmt.Errorf("next: %w", err)
+		}
+		if !s.state.moreGroups {
+			break
+		}
+		nextValues, err := groupValues(ctx, s.scan, s.groupFields)
+		if err != nil {
+			return false, err
+		}
+		if !sameGroup(values, nextValues) {
+			break
+		}
+	}
+	s.state.currentGroup = g
+	return true, nil
+}
+
+func (s *GroupByScan) GetInt(ctx context.Context, fieldName string) (int, error) {
+	v, err := s.GetValue(ctx, fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return constantAsInt(v, fieldName)
+}
+
+func (s *GroupByScan) GetString(ctx context.Context, fieldName string) (string, error) {
+	v, err := s.GetValue(ctx, fieldName)
+	if err != nil {
+		return "", err
+	}
+	return constantAsString(v, fieldName)
+}
+
+func (s *GroupByScan) GetValue(ctx context.Context, fieldName string) (dbconstant.Constant, error) {
+	if s.state.currentGroup == nil {
+		return nil, fmt.Errorf("group by scan has no current group")
+	}
+	return s.state.currentGroup.getValue(fieldName)
+}
+
+func (s *GroupByScan) HasField(fieldName string) bool {
+	return hasGroupByField(fieldName, s.groupFields, s.aggregations)
+}
+
+func (s *GroupByScan) Close(ctx context.Context) error {
+	return s.scan.Close(ctx)
+}
+
+func sameGroup(v1, v2 map[string]dbconstant.Constant) bool {
+	for fieldName, v := range v1 {
+		if !v.Equals(v2[fieldName]) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasGroupByField(fieldName string, groupFields []string, aggregations []*Aggregation) bool {
+	if slices.Contains(groupFields, fieldName) {
+		return true
+	}
+	return slices.ContainsFunc(aggregations, func(a *Aggregation) bool {
+		return a.OutputName() == fieldName
+	})
+}
@@ -0,0 +1,129 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+// HashGroupByScan aggregates the records of unsorted scan in an in-memory hash table.
+// グループ数がメモリに収まる程度に少ないときに使う
+type HashGroupByScan struct {
+	scan         Scan
+	schema       *dbrecord.Schema
+	groupFields  []string
+	aggregations []*Aggregation
+	state        hashGroupByScanState
+}
+
+type hashGroupByScanState struct {
+	// 入力で最初に現れた順
+	groups []*group
+	// 次に返すgroupの位置
+	pos int
+}
+
+// schemaは集約前の入力のschema
+func NewHashGroupByScan(ctx context.Context, scan Scan, schema *dbrecord.Schema, groupFields []string, aggregations []*Aggregation) (*HashGroupByScan, error) {
+	s := &HashGroupByScan{
+		scan:         scan,
+		schema:       schema,
+		groupFields:  groupFields,
+		aggregations: aggregations,
+	}
+	if err := s.SetStateToBeforeFirst(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 入力をすべて読み込んでグループごとに集約する
+func (s *HashGroupByScan) SetStateToBeforeFirst(ctx context.Context) error {
+	if err := s.scan.SetStateToBeforeFirst(ctx); err != nil {
+		return fmt.Errorf("move to before first: %w", err)
+	}
+	var groups []*group
+	table := make(map[string]*group)
+	for {
+		ok, err := s.scan.Next(ctx)
+		if err != nil {
+			return fmt.Errorf("next: %w", err)
+		}
+		if !ok {
+			break
+		}
+		values, err := groupValues(ctx, s.scan, s.groupFields)
+		if err != nil {
+			return err
+		}
+		key := s.groupKey(values)
+		g, ok := table[key]
+		if !ok {
+			g = newGroup(values, s.aggregations)
+			table[key] = g
+			groups = append(groups, g)
+		}
+		if err := g.add(ctx, s.scan); err != nil {
+			return err
+		}
+	}
+	if len(groups) == 0 && len(s.groupFields) == 0 {
+		groups = append(groups, newGroup(nil, s.aggregations))
+	}
+	// Nextで最初のgroupに進む
+	s.state = hashGroupByScanState{groups: groups, pos: -1}
+	return nil
+}
+
+func (s *HashGroupByScan) groupKey(values map[string]dbconstant.Constant) string {
+	var b strings.Builder
+	for _, fieldName := range s.groupFields {
+		v := values[fieldName]
+		// 型の異なる同じ表記の値を区別する
+		fmt.Fprintf(&b, "%T:%d:%s", v, len(v.String()), v.String())
+	}
+	return b.String()
+}
+
+func (s *HashGroupByScan) Next(ctx context.Context) (bool, error) {
+	if s.state.pos+1 >= len(s.state.groups) {
+		s.state.pos = len(s.state.groups)
+		return false, nil
+	}
+	s.state.pos++
+	return true, nil
+}
+
+func (s *HashGroupByScan) GetInt(ctx context.Context, fieldName string) (int, error) {
+	v, err := s.GetValue(ctx, fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return constantAsInt(v, fieldName)
+}
+
+func (s *HashGroupByScan) GetString(ctx context.Context, fieldName string) (string, error) {
+	v, err := s.GetValue(ctx, fieldName)
+	if err != nil {
+		return "", err
+	}
+	return constantAsString(v, fieldName)
+}
+
+func (s *HashGroupByScan) GetValue(ctx context.Context, fieldName string) (dbconstant.Constant, error) {
+	if s.state.pos < 0 || s.state.pos >= len(s.state.groups) {
+		return nil, fmt.Errorf("hash group by scan has no current group")
+	}
+	return s.state.groups[s.state.pos].getValue(fieldName)
+}
+
+func (s *HashGroupByScan) HasField(fieldName string) bool {
+	return hasGroupByField(fieldName, s.groupFields, s.aggregations)
+}
+
+func (s *HashGroupByScan) Close(ctx context.Context) error {
+	return s.scan.Close(ctx)
+}
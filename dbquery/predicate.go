@@ -3,54 +3,209 @@ package dbquery
 import (
 	"context"
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbrecord"
 )
 
+// truth is a value of three-valued logic.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthTrue
+	truthUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+func (t truth) not() truth {
+	switch t {
+	case truthTrue:
+		return truthFalse
+	case truthFalse:
+		return truthTrue
+	}
+	return truthUnknown
+}
+
+type predicateKind int
+
+const (
+	predicateAnd predicateKind = iota
+	predicateOr
+	predicateNot
+	predicateTerm
+)
+
+// Predicate is a boolean expression tree of terms combined with AND, OR and NOT.
+// トップレベルはANDで、childrenが空のANDは常に真
 type Predicate struct {
-	terms []*Term
+	kind     predicateKind
+	term     *Term
+	children []*Predicate
 }
 
+// termsをAND結合したpredicateを作る
 func NewPredicate(terms ...*Term) *Predicate {
-	return &Predicate{terms: terms[:]}
+	p := &Predicate{kind: predicateAnd}
+	for _, term := range terms {
+		p.children = append(p.children, &Predicate{kind: predicateTerm, term: term})
+	}
+	return p
+}
+
+func NewOrPredicate(preds ...*Predicate) *Predicate {
+	children := make([]*Predicate, len(preds))
+	for i, pred := range preds {
+		children[i] = pred.unwrap()
+	}
+	return &Predicate{kind: predicateOr, children: children}
+}
+
+func NewNotPredicate(pred *Predicate) *Predicate {
+	return &Predicate{kind: predicateNot, children: []*Predicate{pred.unwrap()}}
+}
+
+// 子が1つだけのANDはその子と同じ
+func (p *Predicate) unwrap() *Predicate {
+	if p.kind == predicateAnd && len(p.children) == 1 {
+		return p.children[0].unwrap()
+	}
+	return p
 }
 
 // otherから条件を抜き出し結合する
 func (p *Predicate) ConjoinWith(other *Predicate) {
-	p.terms = append(p.terms, other.terms...)
+	if p.kind != predicateAnd {
+		// 自身をANDの子に移す
+		self := *p
+		*p = Predicate{kind: predicateAnd, children: []*Predicate{&self}}
+	}
+	if other.kind == predicateAnd {
+		p.children = append(p.children, other.children...)
+	} else {
+		p.children = append(p.children, other)
+	}
 }
 
-// termはAND結合
+// WHEREと同様にUNKNOWNは満たさないとする
 func (p *Predicate) IsSatisfied(ctx context.Context, s Scan) (bool, error) {
-	for _, term := range p.terms {
-		satisfied, err := term.IsSatisfied(ctx, s)
+	result, err := p.evaluate(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	return result == truthTrue, nil
+}
+
+// 三値論理で評価する. ANDはFALSE, ORはTRUEが1つでもあれば確定し、それ以外でUNKNOWNがあればUNKNOWN
+func (p *Predicate) evaluate(ctx context.Context, s Scan) (truth, error) {
+	switch p.kind {
+	case predicateTerm:
+		return p.term.evaluate(ctx, s)
+	case predicateNot:
+		result, err := p.children[0].evaluate(ctx, s)
 		if err != nil {
-			return false, fmt.Errorf("is satisfied: %w", err)
+			return truthFalse, err
+		}
+		return result.not(), nil
+	default:
+		decisive, result := truthFalse, truthTrue
+		if p.kind == predicateOr {
+			decisive, result = truthTrue, truthFalse
 		}
-		if !satisfied {
-			return false, nil
+		for _, child := range p.children {
+			r, err := child.evaluate(ctx, s)
+			if err != nil {
+				return truthFalse, fmt.Errorf("is satisfied: %w", err)
+			}
+			if r == decisive {
+				return decisive, nil
+			}
+			if r == truthUnknown {
+				result = truthUnknown
+			}
 		}
+		return result, nil
 	}
-	return true, nil
 }
 
+// 出力レコード数が何分の1になるか
+// 入力のレコード数より大きくはしない. 常に偽の条件でも出力は1レコードと見積もる
 func (p *Predicate) ReductionFactor(plan Plan) int {
-	factor := 1
-	for _, term := range p.terms {
-		factor *= term.ReductionFactor(plan)
+	return max(1, min(p.reductionFactor(plan), plan.RecordsOutput()))
+}
+
+func (p *Predicate) reductionFactor(plan Plan) int {
+	switch p.kind {
+	case predicateTerm:
+		return p.term.ReductionFactor(plan)
+	case predicateNot:
+		return factorFromSelectivity(1 - selectivity(p.children[0].reductionFactor(plan)))
+	case predicateOr:
+		// 各条件が独立と仮定し、どれも満たさない確率から求める
+		none := 1.0
+		for _, child := range p.children {
+			none *= 1 - selectivity(child.reductionFactor(plan))
+		}
+		return factorFromSelectivity(1 - none)
+	default:
+		factor := 1
+		for _, child := range p.children {
+			factor = saturatingMul(factor, child.reductionFactor(plan))
+		}
+		return factor
+	}
+}
+
+// 溢れる場合はmath.MaxIntにする. factorはどれも1以上
+func saturatingMul(a, b int) int {
+	if a > math.MaxInt/max(1, b) {
+		return math.MaxInt
 	}
-	return factor
+	return a * b
 }
 
+func selectivity(factor int) float64 {
+	return 1 / float64(max(1, factor))
+}
+
+func factorFromSelectivity(sel float64) int {
+	if sel <= 0 || 1/sel >= math.MaxInt {
+		return math.MaxInt
+	}
+	return int(math.Ceil(1 / sel))
+}
+
+// 全フィールドがschemaに含まれるか
+func (p *Predicate) AppliesTo(schema *dbrecord.Schema) bool {
+	if p.kind == predicateTerm {
+		return p.term.AppliesTo(schema)
+	}
+	for _, child := range p.children {
+		if !child.AppliesTo(schema) {
+			return false
+		}
+	}
+	return true
+}
+
+// schemaだけで評価できるトップレベルの条件を抜き出す. ORやNOTは分割しない
 func (p *Predicate) SelectSubPredicate(schema *dbrecord.Schema) *Predicate {
 	result := NewPredicate()
-	for _, term := range p.terms {
-		if term.AppliesTo(schema) {
-			result.terms = append(result.terms, term)
+	for _, conjunct := range p.conjuncts() {
+		if conjunct.AppliesTo(schema) {
+			result.children = append(result.children, conjunct)
 		}
 	}
-	if len(result.terms) == 0 {
+	if len(result.children) == 0 {
 		return nil
 	}
 	return result
@@ -62,20 +217,25 @@ func (p *Predicate) JoinSubPredicate(schema1 *dbrecord.Schema, schema2 *dbrecord
 	newSchema.AddAll(schema1)
 	newSchema.AddAll(schema2)
 
-	for _, term := range p.terms {
-		if !term.AppliesTo(schema1) && !term.AppliesTo(schema2) && term.AppliesTo(newSchema) {
-			result.terms = append(result.terms, term)
+	for _, conjunct := range p.conjuncts() {
+		if !conjunct.AppliesTo(schema1) && !conjunct.AppliesTo(schema2) && conjunct.AppliesTo(newSchema) {
+			result.children = append(result.children, conjunct)
 		}
 	}
-	if len(result.terms) == 0 {
+	if len(result.children) == 0 {
 		return nil
 	}
 	return result
 }
 
+// トップレベルでAND結合されたtermからのみ探す.
+// OR/NOTの中の条件は他の条件次第で成り立たないのでindexの選択などに使えない
 func (p *Predicate) EquatesWithConstant(fieldName string) dbconstant.Constant {
-	for _, term := range p.terms {
-		constant := term.EquatesWithConstant(fieldName)
+	for _, conjunct := range p.conjuncts() {
+		if conjunct.kind != predicateTerm {
+			continue
+		}
+		constant := conjunct.term.EquatesWithConstant(fieldName)
 		if constant != nil {
 			return constant
 		}
@@ -84,8 +244,11 @@ func (p *Predicate) EquatesWithConstant(fieldName string) dbconstant.Constant {
 }
 
 func (p *Predicate) EquatesWithFieldName(fieldName string) string {
-	for _, term := range p.terms {
-		fieldName := term.EquatesWithFieldName(fieldName)
+	for _, conjunct := range p.conjuncts() {
+		if conjunct.kind != predicateTerm {
+			continue
+		}
+		fieldName := conjunct.term.EquatesWithFieldName(fieldName)
 		if fieldName != "" {
 			return fieldName
 		}
@@ -93,10 +256,45 @@ func (p *Predicate) EquatesWithFieldName(fieldName string) string {
 	return ""
 }
 
+// ネストしたANDを展開してトップレベルの条件を返す
+func (p *Predicate) conjuncts() []*Predicate {
+	if p.kind != predicateAnd {
+		return []*Predicate{p}
+	}
+	var result []*Predicate
+	for _, child := range p.children {
+		result = append(result, child.conjuncts()...)
+	}
+	return result
+}
+
 func (p *Predicate) String() string {
-	result := ""
-	for _, term := range p.terms {
-		result += term.String() + " AND "
+	switch p.kind {
+	case predicateTerm:
+		return p.term.String()
+	case predicateNot:
+		child := p.children[0]
+		if child.kind == predicateTerm || child.kind == predicateNot {
+			return "NOT " + child.String()
+		}
+		return "NOT (" + child.String() + ")"
+	default:
+		sep := " AND "
+		if p.kind == predicateOr {
+			sep = " OR "
+		}
+		var parts []string
+		for _, child := range p.children {
+			s := child.String()
+			if s == "" {
+				continue
+			}
+			// ANDの中のOR, ORの中のANDは括弧で囲む
+			if child.kind != predicateTerm && child.kind != predicateNot && child.kind != p.kind && len(p.children) > 1 {
+				s = "(" + s + ")"
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, sep)
 	}
-	return result[:len(result)-5]
 }
@@ -0,0 +1,182 @@
+package dbquery_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbquery"
+	"github.com/teru01/simpledb-go/dbrecord"
+)
+
+func fieldEqualsInt(fieldName string, val int) *dbquery.Term {
+	return dbquery.NewTerm(
+		dbquery.NewExpressionFromFieldName(fieldName),
+		dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(val)),
+		dbquery.Equator,
+	)
+}
+
+func TestPredicateOrNot(t *testing.T) {
+	tx, layout, tableName, cleanup := setupSelectScanTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	ts, err := dbrecord.NewTableScan(ctx, tx, tableName, layout, false)
+	if err != nil {
+		t.Fatalf("failed to create table scan: %v", err)
+	}
+	for i, age := range []int{25, 30, 25, 35, 40} {
+		if err := ts.Insert(ctx); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		if err := ts.SetInt(ctx, "id", i+1); err != nil {
+			t.Fatalf("failed to set id: %v", err)
+		}
+		if err := ts.SetInt(ctx, "age", age); err != nil {
+			t.Fatalf("failed to set age: %v", err)
+		}
+	}
+
+	// (age = 25 OR age = 35) AND NOT id = 1
+	pred := dbquery.NewPredicate()
+	pred.ConjoinWith(dbquery.NewOrPredicate(
+		dbquery.NewPredicate(fieldEqualsInt("age", 25)),
+		dbquery.NewPredicate(fieldEqualsInt("age", 35)),
+	))
+	pred.ConjoinWith(dbquery.NewNotPredicate(dbquery.NewPredicate(fieldEqualsInt("id", 1))))
+
+	if got, want := pred.String(), "(age = 25 OR age = 35) AND NOT id = 1"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if err := ts.SetStateToBeforeFirst(ctx); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	s := dbquery.NewSelectScan(ts, pred)
+	defer s.Close(ctx)
+	var ids []int
+	for {
+		ok, err := s.Next(ctx)
+		if err != nil {
+			t.Fatalf("failed to move to next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		id, err := s.GetInt(ctx, "id")
+		if err != nil {
+			t.Fatalf("failed to get id: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if !slices.Equal(ids, []int{3, 4}) {
+		t.Errorf("expected ids [3 4], got %v", ids)
+	}
+}
+
+func TestPredicateEquatesWithConstantIgnoresDisjunction(t *testing.T) {
+	// id = 1 OR age = 30 ではidが1とは限らない
+	pred := dbquery.NewPredicate()
+	pred.ConjoinWith(dbquery.NewOrPredicate(
+		dbquery.NewPredicate(fieldEqualsInt("id", 1)),
+		dbquery.NewPredicate(fieldEqualsInt("age", 30)),
+	))
+	if c := pred.EquatesWithConstant("id"); c != nil {
+		t.Errorf("expected nil through OR, got %v", c)
+	}
+
+	notPred := dbquery.NewPredicate()
+	notPred.ConjoinWith(dbquery.NewNotPredicate(dbquery.NewPredicate(fieldEqualsInt("id", 1))))
+	if c := notPred.EquatesWithConstant("id"); c != nil {
+		t.Errorf("expected nil through NOT, got %v", c)
+	}
+
+	// ANDで結合された条件は使える
+	pred.ConjoinWith(dbquery.NewPredicate(fieldEqualsInt("age", 25)))
+	if c := pred.EquatesWithConstant("age"); c == nil || !c.Equals(dbconstant.NewIntConstant(25)) {
+		t.Errorf("expected 25, got %v", c)
+	}
+}
+
+func TestPredicateSubPredicateKeepsDisjunction(t *testing.T) {
+	schema1 := dbrecord.NewSchema()
+	schema1.AddIntField("a")
+	schema2 := dbrecord.NewSchema()
+	schema2.AddIntField("b")
+
+	joinTerm := dbquery.NewTerm(dbquery.NewExpressionFromFieldName("a"), dbquery.NewExpressionFromFieldName("b"), dbquery.Equator)
+	// a = 1 AND (a = 2 OR b = 3) AND a = b
+	pred := dbquery.NewPredicate(fieldEqualsInt("a", 1))
+	pred.ConjoinWith(dbquery.NewOrPredicate(
+		dbquery.NewPredicate(fieldEqualsInt("a", 2)),
+		dbquery.NewPredicate(fieldEqualsInt("b", 3)),
+	))
+	pred.ConjoinWith(dbquery.NewPredicate(joinTerm))
+
+	// ORは片方のschemaだけでは評価できないので含めない
+	if got := pred.SelectSubPredicate(schema1).String(); got != "a = 1" {
+		t.Errorf("unexpected select sub predicate: %q", got)
+	}
+	if got := pred.JoinSubPredicate(schema1, schema2).String(); got != "(a = 2 OR b = 3) AND a = b" {
+		t.Errorf("unexpected join sub predicate: %q", got)
+	}
+	if sub := pred.SelectSubPredicate(dbrecord.NewSchema()); sub != nil {
+		t.Errorf("expected nil, got %q", sub)
+	}
+}
+
+// 見積もり用のPlan. 全フィールドのdistinct valueはrecordsと同じとする
+type statPlan struct {
+	records int
+}
+
+func (p statPlan) Open(ctx context.Context) (dbquery.Scan, error) { return nil, nil }
+func (p statPlan) BlockAccessed() int                             { return 1 }
+func (p statPlan) RecordsOutput() int                             { return p.records }
+func (p statPlan) DistinctValues(fieldName string) int            { return p.records }
+func (p statPlan) Schema() *dbrecord.Schema                       { return dbrecord.NewSchema() }
+
+func TestPredicateReductionFactorIsClamped(t *testing.T) {
+	alwaysFalse := dbquery.NewTerm(
+		dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(1)),
+		dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(2)),
+		dbquery.Equator,
+	)
+	plan := statPlan{records: 1000}
+
+	// 常に偽の条件をANDでつないでも溢れず, 入力のレコード数で止まる
+	pred := dbquery.NewPredicate(alwaysFalse)
+	pred.ConjoinWith(dbquery.NewPredicate(fieldEqualsInt("a", 1)))
+	pred.ConjoinWith(dbquery.NewPredicate(alwaysFalse))
+	if got := pred.ReductionFactor(plan); got != 1000 {
+		t.Errorf("expected factor clamped to 1000, got %d", got)
+	}
+
+	// 積が入力のレコード数を超えても, 出力は1レコードと見積もる
+	pred = dbquery.NewPredicate(fieldEqualsInt("a", 1))
+	pred.ConjoinWith(dbquery.NewPredicate(fieldEqualsInt("b", 2)))
+	if got := pred.ReductionFactor(plan); got != 1000 {
+		t.Errorf("expected factor 1000, got %d", got)
+	}
+	if got := dbquery.NewPredicate(fieldEqualsInt("a", 1)).ReductionFactor(statPlan{records: 10}); got != 10 {
+		t.Errorf("expected factor 10, got %d", got)
+	}
+
+	// NOTやORで包んでも正の値になる
+	not := dbquery.NewNotPredicate(dbquery.NewPredicate(dbquery.NewTerm(
+		dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(1)),
+		dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(1)),
+		dbquery.Equator,
+	)))
+	or := dbquery.NewOrPredicate(dbquery.NewPredicate(alwaysFalse), dbquery.NewPredicate(alwaysFalse))
+	for _, p := range []*dbquery.Predicate{not, or} {
+		if got := p.ReductionFactor(plan); got != 1000 {
+			t.Errorf("expected factor of %s clamped to 1000, got %d", p, got)
+		}
+	}
+	if got := dbquery.NewPredicate(alwaysFalse).ReductionFactor(statPlan{records: 0}); got != 1 {
+		t.Errorf("expected factor 1 for an empty input, got %d", got)
+	}
+}
@@ -0,0 +1,87 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+)
+
+type SortKey struct {
+	fieldName string
+	desc      bool
+}
+
+func NewSortKey(fieldName string, desc bool) *SortKey {
+	return &SortKey{fieldName: fieldName, desc: desc}
+}
+
+func (k *SortKey) FieldName() string {
+	return k.fieldName
+}
+
+func (k *SortKey) IsDesc() bool {
+	return k.desc
+}
+
+func (k *SortKey) String() string {
+	if k.desc {
+		return k.fieldName + " DESC"
+	}
+	return k.fieldName
+}
+
+// RecordComparator compares records by the sort keys in order.
+type RecordComparator struct {
+	sortKeys []*SortKey
+}
+
+func NewRecordComparator(sortKeys []*SortKey) *RecordComparator {
+	return &RecordComparator{sortKeys: sortKeys}
+}
+
+func (c *RecordComparator) SortKeys() []*SortKey {
+	return c.sortKeys
+}
+
+// 各scanの現在のレコードを比較する
+func (c *RecordComparator) Compare(ctx context.Context, s1, s2 Scan) (int, error) {
+	for _, key := range c.sortKeys {
+		v1, err := s1.GetValue(ctx, key.fieldName)
+		if err != nil {
+			return 0, fmt.Errorf("get value of %q: %w", key.fieldName, err)
+		}
+		v2, err := s2.GetValue(ctx, key.fieldName)
+		if err != nil {
+			return 0, fmt.Errorf("get value of %q: %w", key.fieldName, err)
+		}
+		if r := compareConstant(v1, v2, key.desc); r != 0 {
+			return r, nil
+		}
+	}
+	return 0, nil
+}
+
+// メモリ上に読み込んだレコード同士を比較する
+func (c *RecordComparator) CompareValues(r1, r2 map[string]dbconstant.Constant) int {
+	for _, key := range c.sortKeys {
+		if r := compareConstant(r1[key.fieldName], r2[key.fieldName], key.desc); r != 0 {
+			return r
+		}
+	}
+	return 0
+}
+
+func compareConstant(v1, v2 dbconstant.Constant, desc bool) int {
+	r := v1.Compare(v2)
+	switch {
+	case r < 0:
+		r = -1
+	case r > 0:
+		r = 1
+	}
+	if desc {
+		return -r
+	}
+	return r
+}
@@ -0,0 +1,143 @@
+package dbquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+)
+
+// SortScan merges at most two sorted runs.
+type SortScan struct {
+	s1         UpdateScan
+	s2         UpdateScan
+	comparator *RecordComparator
+	state      sortScanState
+}
+
+type sortScanState struct {
+	currentScan UpdateScan
+	hasMore1    bool
+	hasMore2    bool
+}
+
+func NewSortScan(ctx context.Context, runs []*TempTable, comparator *RecordComparator) (*SortScan, error) {
+	if len(runs) == 0 || len(runs) > 2 {
+		return nil, fmt.Errorf("sort scan requires 1 or 2 runs but got %d", len(runs))
+	}
+	s := &SortScan{comparator: comparator}
+	s1, err := runs[0].Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open run: %w", err)
+	}
+	s.s1 = s1
+	if len(runs) > 1 {
+		s2, err := runs[1].Open(ctx)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("open run: %w", err), s1.Close(ctx))
+		}
+		s.s2 = s2
+	}
+	if err := s.SetStateToBeforeFirst(ctx); err != nil {
+		return nil, errors.Join(err, s.Close(ctx))
+	}
+	return s, nil
+}
+
+func (s *SortScan) SetStateToBeforeFirst(ctx context.Context) error {
+	state := sortScanState{}
+	if err := s.s1.SetStateToBeforeFirst(ctx); err != nil {
+		return fmt.Errorf("move to before first: %w", err)
+	}
+	hasMore1, err := s.s1.Next(ctx)
+	if err != nil {
+		return fmt.Errorf("next: %w", err)
+	}
+	state.hasMore1 = hasMore1
+	if s.s2 != nil {
+		if err := s.s2.SetStateToBeforeFirst(ctx); err != nil {
+			return fmt.Errorf("move to before first: %w", err)
+		}
+		hasMore2, err := s.s2.Next(ctx)
+		if err != nil {
+			return fmt.Errorf("next: %w", err)
+		}
+		state.hasMore2 = hasMore2
+	}
+	s.state = state
+	return nil
+}
+
+// 前回返したscanだけを進め、2つのrunのうち小さい方を現在のレコードとする
+func (s *SortScan) Next(ctx context.Context) (bool, error) {
+	var err error
+	switch {
+	case s.state.currentScan == nil:
+	case s.state.currentScan == s.s1:
+		if s.state.hasMore1, err = s.s1.Next(ctx); err != nil {
+			return false, fmt.Errorf("next: %w", err)
+		}
+	default:
+		if s.state.hasMore2, err = s.s2.Next(ctx); err != nil {
+			return false, fmt.Errorf("next: %w", err)
+		}
+	}
+
+	switch {
+	case s.state.hasMore1 && s.state.hasMore2:
+		c, err := s.comparator.Compare(ctx, s.s1, s.s2)
+		if err != nil {
+			return false, fmt.Errorf("compare records: %w", err)
+		}
+		if c <= 0 {
+			s.state.currentScan = s.s1
+		} else {
+			s.state.currentScan = s.s2
+		}
+	case s.state.hasMore1:
+		s.state.currentScan = s.s1
+	case s.state.hasMore2:
+		s.state.currentScan = s.s2
+	default:
+		s.state.currentScan = nil
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *SortScan) GetInt(ctx context.Context, fieldName string) (int, error) {
+	if s.state.currentScan == nil {
+		return 0, fmt.Errorf("sort scan has no current record")
+	}
+	return s.state.currentScan.GetInt(ctx, fieldName)
+}
+
+func (s *SortScan) GetString(ctx context.Context, fieldName string) (string, error) {
+	if s.state.currentScan == nil {
+		return "", fmt.Errorf("sort scan has no current record")
+	}
+	return s.state.currentScan.GetString(ctx, fieldName)
+}
+
+func (s *SortScan) GetValue(ctx context.Context, fieldName string) (dbconstant.Constant, error) {
+	if s.state.currentScan == nil {
+		return nil, fmt.Errorf("sort scan has no current record")
+	}
+	return s.state.currentScan.GetValue(ctx, fieldName)
+}
+
+func (s *SortScan) HasField(fieldName string) bool {
+	return s.s1.HasField(fieldName)
+}
+
+func (s *SortScan) Close(ctx context.Context) error {
+	var errs []error
+	if s.s1 != nil {
+		errs = append(errs, s.s1.Close(ctx))
+	}
+	if s.s2 != nil {
+		errs = append(errs, s.s2.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,46 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbrecord"
+	"github.com/teru01/simpledb-go/dbtx"
+)
+
+var nextTempTableNum atomic.Uint64
+
+// TempTable is a table without catalog entries used for materializing intermediate results.
+// 名前はdbfile.TempFilePrefixで始まるので, 作成されたtableと重ならない
+// ファイルはtransactionの終了時に削除される
+type TempTable struct {
+	tx        *dbtx.Transaction
+	tableName string
+	layout    *dbrecord.Layout
+}
+
+func NewTempTable(tx *dbtx.Transaction, schema *dbrecord.Schema) *TempTable {
+	return &TempTable{
+		tx:        tx,
+		tableName: fmt.Sprintf("%s%d", dbfile.TempFilePrefix, nextTempTableNum.Add(1)),
+		layout:    dbrecord.NewLayout(schema),
+	}
+}
+
+func (t *TempTable) Open(ctx context.Context) (UpdateScan, error) {
+	scan, err := dbrecord.NewTableScan(ctx, t.tx, t.tableName, t.layout, false)
+	if err != nil {
+		return nil, fmt.Errorf("open temp table %q: %w", t.tableName, err)
+	}
+	return scan, nil
+}
+
+func (t *TempTable) TableName() string {
+	return t.tableName
+}
+
+func (t *TempTable) Layout() *dbrecord.Layout {
+	return t.layout
+}
@@ -12,11 +12,45 @@ import (
 type Operator int
 
 const (
-	Equator     Operator = 0  // =
-	LessThan    Operator = -1 // <
-	GreaterThan Operator = 1  // >
+	Equator            Operator = 0  // =
+	LessThan           Operator = -1 // <
+	GreaterThan        Operator = 1  // >
+	LessThanOrEqual    Operator = -2 // <=
+	GreaterThanOrEqual Operator = 2  // >=
+	NotEqual           Operator = 3  // <>
+	IsNull             Operator = 4  // IS NULL
+	IsNotNull          Operator = 5  // IS NOT NULL
 )
 
+const (
+	// 範囲条件で出力レコード数が何分の1になるかの見積もり
+	rangeReductionFactor = 3
+	// NULLの統計はないので1割がNULLと仮定する
+	nullReductionFactor = 10
+)
+
+func (o Operator) String() string {
+	switch o {
+	case Equator:
+		return "="
+	case LessThan:
+		return "<"
+	case GreaterThan:
+		return ">"
+	case LessThanOrEqual:
+		return "<="
+	case GreaterThanOrEqual:
+		return ">="
+	case NotEqual:
+		return "<>"
+	case IsNull:
+		return "IS NULL"
+	case IsNotNull:
+		return "IS NOT NULL"
+	}
+	return "?"
+}
+
 type Term struct {
 	lhs      *Expression
 	rhs      *Expression
@@ -28,24 +62,60 @@ func NewTerm(lhs *Expression, rhs *Expression, op Operator) *Term {
 }
 
 func (t *Term) IsSatisfied(ctx context.Context, s Scan) (bool, error) {
+	result, err := t.evaluate(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	return result == truthTrue, nil
+}
+
+func (t *Term) evaluate(ctx context.Context, s Scan) (truth, error) {
 	lhs, err := t.lhs.Evaluate(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("evaluate lhs: %w", err)
+		return truthFalse, fmt.Errorf("evaluate lhs: %w", err)
+	}
+	switch t.operator {
+	case IsNull:
+		return truthOf(dbconstant.IsNull(lhs)), nil
+	case IsNotNull:
+		return truthOf(!dbconstant.IsNull(lhs)), nil
 	}
 	rhs, err := t.rhs.Evaluate(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("evaluate rhs: %w", err)
+		return truthFalse, fmt.Errorf("evaluate rhs: %w", err)
 	}
-	result := lhs.Compare(rhs)
-	switch t.operator {
+	return t.operator.compare(lhs, rhs)
+}
+
+// NULLとの比較はUNKNOWNになる
+func (o Operator) compare(lhs, rhs dbconstant.Constant) (truth, error) {
+	if dbconstant.IsNull(lhs) || dbconstant.IsNull(rhs) {
+		return truthUnknown, nil
+	}
+	ok, err := o.apply(lhs.Compare(rhs))
+	if err != nil {
+		return truthFalse, err
+	}
+	return truthOf(ok), nil
+}
+
+// Compareの結果に演算子を適用する
+func (o Operator) apply(result int) (bool, error) {
+	switch o {
 	case Equator:
 		return result == 0, nil
 	case LessThan:
 		return result < 0, nil
 	case GreaterThan:
 		return result > 0, nil
+	case LessThanOrEqual:
+		return result <= 0, nil
+	case GreaterThanOrEqual:
+		return result >= 0, nil
+	case NotEqual:
+		return result != 0, nil
 	default:
-		return false, fmt.Errorf("unknown operator: %d", t.operator)
+		return false, fmt.Errorf("unknown operator: %d", o)
 	}
 }
 
@@ -54,23 +124,43 @@ func (t *Term) AppliesTo(schema *dbrecord.Schema) bool {
 }
 
 func (t *Term) ReductionFactor(plan Plan) int {
-	if t.lhs.IsFieldName() && t.rhs.IsFieldName() {
-		return int(math.Max(float64(plan.DistinctValues(t.lhs.AsFieldName())), float64(plan.DistinctValues(t.rhs.AsFieldName()))))
+	if !t.lhs.IsFieldName() && !t.rhs.IsFieldName() {
+		// 定数同士は常に真か常に偽
+		if result, err := t.evaluate(context.Background(), nil); err == nil && result == truthTrue {
+			return 1
+		}
+		return math.MaxInt
 	}
-	if t.lhs.IsFieldName() {
-		return plan.DistinctValues(t.lhs.AsFieldName())
-	}
-	if t.rhs.IsFieldName() {
+	switch t.operator {
+	case IsNull:
+		return nullReductionFactor
+	case IsNotNull:
+		return 1
+	case Equator:
+		if t.lhs.IsFieldName() && t.rhs.IsFieldName() {
+			return int(math.Max(float64(plan.DistinctValues(t.lhs.AsFieldName())), float64(plan.DistinctValues(t.rhs.AsFieldName()))))
+		}
+		if t.lhs.IsFieldName() {
+			return plan.DistinctValues(t.lhs.AsFieldName())
+		}
 		return plan.DistinctValues(t.rhs.AsFieldName())
-	}
-	if t.lhs.AsConstant().Equals(t.rhs.AsConstant()) {
+	case NotEqual:
+		// 1つの値以外はすべて満たすのでほとんど減らない
 		return 1
+	default:
+		return rangeReductionFactor
 	}
-	return math.MaxInt
 }
 
 // 右辺か左辺がfieldNameと一致するときもう片方が定数ならそれを返す.それ以外はnil
+// = 以外の演算子やNULLとの比較はfieldNameの値を1つに定めないのでnil
 func (t *Term) EquatesWithConstant(fieldName string) dbconstant.Constant {
+	if t.operator != Equator {
+		return nil
+	}
+	if (!t.lhs.IsFieldName() && dbconstant.IsNull(t.lhs.AsConstant())) || (!t.rhs.IsFieldName() && dbconstant.IsNull(t.rhs.AsConstant())) {
+		return nil
+	}
 	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && !t.rhs.IsFieldName() {
 		return t.rhs.AsConstant()
 	}
@@ -82,6 +172,9 @@ func (t *Term) EquatesWithConstant(fieldName string) dbconstant.Constant {
 
 // 右辺か左辺がfieldNameと一致するときもう片方がfield nameならそれを返す.それ以外は空文字
 func (t *Term) EquatesWithFieldName(fieldName string) string {
+	if t.operator != Equator {
+		return ""
+	}
 	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && t.rhs.IsFieldName() {
 		return t.rhs.AsFieldName()
 	}
@@ -92,5 +185,8 @@ func (t *Term) EquatesWithFieldName(fieldName string) string {
 }
 
 func (t *Term) String() string {
-	return fmt.Sprintf("%s = %s", t.lhs.String(), t.rhs.String())
+	if t.operator == IsNull || t.operator == IsNotNull {
+		return fmt.Sprintf("%s %s", t.lhs.String(), t.operator)
+	}
+	return fmt.Sprintf("%s %s %s", t.lhs.String(), t.operator, t.rhs.String())
 }
@@ -0,0 +1,122 @@
+package dbquery_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+	"github.com/teru01/simpledb-go/dbquery"
+)
+
+func TestTermOperators(t *testing.T) {
+	ctx := context.Background()
+	intExpr := func(v int) *dbquery.Expression {
+		return dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(v))
+	}
+	tests := []struct {
+		op       dbquery.Operator
+		lhs, rhs int
+		expected bool
+	}{
+		{dbquery.Equator, 1, 1, true},
+		{dbquery.Equator, 1, 2, false},
+		{dbquery.LessThan, 1, 2, true},
+		{dbquery.LessThan, 2, 2, false},
+		{dbquery.GreaterThan, 3, 2, true},
+		{dbquery.GreaterThan, 2, 2, false},
+		{dbquery.LessThanOrEqual, 2, 2, true},
+		{dbquery.LessThanOrEqual, 1, 2, true},
+		{dbquery.LessThanOrEqual, 3, 2, false},
+		{dbquery.GreaterThanOrEqual, 2, 2, true},
+		{dbquery.GreaterThanOrEqual, 3, 2, true},
+		{dbquery.GreaterThanOrEqual, 1, 2, false},
+		{dbquery.NotEqual, 1, 2, true},
+		{dbquery.NotEqual, 2, 2, false},
+	}
+	for _, tt := range tests {
+		term := dbquery.NewTerm(intExpr(tt.lhs), intExpr(tt.rhs), tt.op)
+		// 定数同士なのでscanは参照されない
+		got, err := term.IsSatisfied(ctx, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to evaluate: %v", term, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", term, tt.expected, got)
+		}
+	}
+}
+
+func TestTermString(t *testing.T) {
+	field := dbquery.NewExpressionFromFieldName("name")
+	str := dbquery.NewExpressionFromValue(dbconstant.NewStringConstant(`a"b`))
+	tests := []struct {
+		op       dbquery.Operator
+		expected string
+	}{
+		{dbquery.Equator, `name = "a\"b"`},
+		{dbquery.LessThan, `name < "a\"b"`},
+		{dbquery.GreaterThan, `name > "a\"b"`},
+		{dbquery.LessThanOrEqual, `name <= "a\"b"`},
+		{dbquery.GreaterThanOrEqual, `name >= "a\"b"`},
+		{dbquery.NotEqual, `name <> "a\"b"`},
+	}
+	for _, tt := range tests {
+		if got := dbquery.NewTerm(field, str, tt.op).String(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestTermNull(t *testing.T) {
+	ctx := context.Background()
+	null := dbquery.NewExpressionFromValue(dbconstant.NewNullConstant())
+	one := dbquery.NewExpressionFromValue(dbconstant.NewIntConstant(1))
+	tests := []struct {
+		term     *dbquery.Term
+		expected bool
+	}{
+		{dbquery.NewTerm(null, null, dbquery.IsNull), true},
+		{dbquery.NewTerm(one, null, dbquery.IsNull), false},
+		{dbquery.NewTerm(one, null, dbquery.IsNotNull), true},
+		{dbquery.NewTerm(null, null, dbquery.IsNotNull), false},
+		// NULLとの比較はUNKNOWN
+		{dbquery.NewTerm(null, null, dbquery.Equator), false},
+		{dbquery.NewTerm(one, null, dbquery.Equator), false},
+		{dbquery.NewTerm(one, null, dbquery.NotEqual), false},
+	}
+	for _, tt := range tests {
+		got, err := tt.term.IsSatisfied(ctx, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to evaluate: %v", tt.term, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.term, tt.expected, got)
+		}
+	}
+
+	// UNKNOWNの否定もUNKNOWN. UNKNOWN OR TRUEはTRUE
+	unknown := dbquery.NewPredicate(dbquery.NewTerm(one, null, dbquery.Equator))
+	truePred := dbquery.NewPredicate(dbquery.NewTerm(one, one, dbquery.Equator))
+	falsePred := dbquery.NewPredicate(dbquery.NewTerm(one, one, dbquery.NotEqual))
+	unknownAndFalse := dbquery.NewPredicate(dbquery.NewTerm(one, null, dbquery.Equator))
+	unknownAndFalse.ConjoinWith(falsePred)
+	predicates := []struct {
+		pred     *dbquery.Predicate
+		expected bool
+	}{
+		{dbquery.NewNotPredicate(unknown), false},
+		{dbquery.NewOrPredicate(unknown, truePred), true},
+		{dbquery.NewOrPredicate(unknown, falsePred), false},
+		{dbquery.NewNotPredicate(dbquery.NewOrPredicate(unknown, falsePred)), false},
+		{dbquery.NewNotPredicate(unknownAndFalse), true},
+	}
+	for _, tt := range predicates {
+		got, err := tt.pred.IsSatisfied(ctx, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to evaluate: %v", tt.pred, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.pred, tt.expected, got)
+		}
+	}
+}
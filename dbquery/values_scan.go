@@ -0,0 +1,79 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/teru01/simpledb-go/dbconstant"
+)
+
+// ValuesScan scans rows held in memory. system tableのようにディスク上にないrecordを返すのに使う
+type ValuesScan struct {
+	fields []string
+	rows   [][]dbconstant.Constant
+	// 現在の行. -1は最初の行の前
+	pos int
+}
+
+// rowsの各行はfieldsと同じ順で値を持つ
+func NewValuesScan(fields []string, rows [][]dbconstant.Constant) *ValuesScan {
+	return &ValuesScan{fields: fields, rows: rows, pos: -1}
+}
+
+func (s *ValuesScan) SetStateToBeforeFirst(ctx context.Context) error {
+	s.pos = -1
+	return nil
+}
+
+func (s *ValuesScan) Next(ctx context.Context) (bool, error) {
+	if s.pos+1 >= len(s.rows) {
+		s.pos = len(s.rows)
+		return false, nil
+	}
+	s.pos++
+	return true, nil
+}
+
+func (s *ValuesScan) GetInt(ctx context.Context, fieldName string) (int, error) {
+	v, err := s.GetValue(ctx, fieldName)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := v.AsRaw().(int)
+	if !ok {
+		return 0, fmt.Errorf("field %q is not an int", fieldName)
+	}
+	return i, nil
+}
+
+func (s *ValuesScan) GetString(ctx context.Context, fieldName string) (string, error) {
+	v, err := s.GetValue(ctx, fieldName)
+	if err != nil {
+		return "", err
+	}
+	str, ok := v.AsRaw().(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not a string", fieldName)
+	}
+	return str, nil
+}
+
+func (s *ValuesScan) GetValue(ctx context.Context, fieldName string) (dbconstant.Constant, error) {
+	i := slices.Index(s.fields, fieldName)
+	if i < 0 {
+		return nil, fmt.Errorf("field %q not found", fieldName)
+	}
+	if s.pos < 0 || s.pos >= len(s.rows) {
+		return nil, fmt.Errorf("no current row")
+	}
+	return s.rows[s.pos][i], nil
+}
+
+func (s *ValuesScan) HasField(fieldName string) bool {
+	return slices.Contains(s.fields, fieldName)
+}
+
+func (s *ValuesScan) Close(ctx context.Context) error {
+	return nil
+}
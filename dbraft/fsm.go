@@ -78,19 +78,23 @@ func (f *FSM) Apply(ctx context.Context, data []byte, isLeader bool) error {
 		if err != nil {
 			return fmt.Errorf("pin block %s for redo: %w", blk, err)
 		}
+		buf.Latch()
 		switch rec.Op {
 		case OpSetInt:
 			if err := buf.Contents().SetInt(rec.Offset, rec.IntNewVal); err != nil {
+				buf.Unlatch()
 				f.bufferManager.Unpin(buf)
 				return fmt.Errorf("set int at offset %d in block %s: %w", rec.Offset, blk, err)
 			}
 		case OpSetString:
 			if err := buf.Contents().SetString(rec.Offset, rec.StrNewVal); err != nil {
+				buf.Unlatch()
 				f.bufferManager.Unpin(buf)
 				return fmt.Errorf("set string at offset %d in block %s: %w", rec.Offset, blk, err)
 			}
 		}
 		buf.SetModified(cmd.TxNum, -1)
+		buf.Unlatch()
 		f.bufferManager.Unpin(buf)
 	}
 
@@ -1,20 +1,29 @@
 package dbrecord
 
 import (
+	"slices"
+
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dbsize"
 )
 
+// null bitmapの1 intあたりのbit数
+const nullBitsPerInt = dbsize.IntSize * 8
+
 // schemaのフィールドの配置情報
+// slotは [使用中flag][null bitmap][field...] の順に並ぶ
+// 配置を変えたらdbfile.FormatVersionを上げ, 以前の配置のdatabaseを開かないようにする
 type Layout struct {
 	schema   *Schema
 	offsets  map[string]int
 	slotSize int
+	// null bitmap上のフィールドのbit位置
+	nullBits map[string]int
 }
 
 func NewLayout(schema *Schema) *Layout {
 	layout := Layout{schema: schema}
-	pos := dbsize.IntSize
+	pos := dbsize.IntSize + nullBitmapSize(len(schema.fields))
 	offsets := make(map[string]int)
 	for _, field := range schema.fields {
 		offsets[field] = pos
@@ -22,6 +31,7 @@ func NewLayout(schema *Schema) *Layout {
 	}
 	layout.offsets = offsets
 	layout.slotSize = pos
+	layout.nullBits = nullBitsFromOffsets(schema, offsets)
 	return &layout
 }
 
@@ -30,7 +40,25 @@ func NewLayoutFromOffsets(schema *Schema, offsets map[string]int, slotSize int)
 		schema:   schema,
 		offsets:  offsets,
 		slotSize: slotSize,
+		nullBits: nullBitsFromOffsets(schema, offsets),
+	}
+}
+
+func nullBitmapSize(numFields int) int {
+	return dbsize.IntSize * ((numFields + nullBitsPerInt - 1) / nullBitsPerInt)
+}
+
+// bit位置はschemaの順序に依存しないようoffset順に割り当てる
+func nullBitsFromOffsets(schema *Schema, offsets map[string]int) map[string]int {
+	fields := slices.Clone(schema.fields)
+	slices.SortFunc(fields, func(a, b string) int {
+		return offsets[a] - offsets[b]
+	})
+	bits := make(map[string]int, len(fields))
+	for i, field := range fields {
+		bits[field] = i
 	}
+	return bits
 }
 
 func (l *Layout) Schema() *Schema {
@@ -45,6 +73,21 @@ func (l *Layout) SlotSize() int {
 	return l.slotSize
 }
 
+// slot内でfieldNameのnull bitを含むintのoffsetとそのmask
+func (l *Layout) NullBit(fieldName string) (offset int, mask int) {
+	bit := l.nullBits[fieldName]
+	return dbsize.IntSize + dbsize.IntSize*(bit/nullBitsPerInt), 1 << (bit % nullBitsPerInt)
+}
+
+// slot内のnull bitmapを構成するintのoffset
+func (l *Layout) NullBitmapOffsets() []int {
+	var offsets []int
+	for pos := dbsize.IntSize; pos < dbsize.IntSize+nullBitmapSize(len(l.schema.fields)); pos += dbsize.IntSize {
+		offsets = append(offsets, pos)
+	}
+	return offsets
+}
+
 // layout上でのfield valueのサイズ
 func (l *Layout) LengthInBytes(fieldName string) int {
 	switch l.schema.FieldType(fieldName) {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/teru01/simpledb-go/dbbuffer"
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dbtx"
 )
@@ -39,6 +40,18 @@ func NewRecordPage(ctx context.Context, tx *dbtx.Transaction, blk dbfile.BlockID
 	}, nil
 }
 
+// blockを読み込むbufferをsに従って選んでpinする. 順に読むscanで使う
+func NewRecordPageWithStrategy(ctx context.Context, tx *dbtx.Transaction, blk dbfile.BlockID, layout *Layout, s *dbbuffer.AccessStrategy) (*RecordPage, error) {
+	if err := tx.PinWithStrategy(ctx, blk, s); err != nil {
+		return nil, fmt.Errorf("pin block %s: %w", blk, err)
+	}
+	return &RecordPage{
+		tx:     tx,
+		blk:    blk,
+		layout: layout,
+	}, nil
+}
+
 func (r *RecordPage) GetInt(ctx context.Context, slot int, fieldName string) (int, error) {
 	pos := r.slotOffset(slot) + r.layout.Offset(fieldName)
 	value, err := r.tx.GetInt(ctx, r.blk, pos)
@@ -49,6 +62,9 @@ func (r *RecordPage) GetInt(ctx context.Context, slot int, fieldName string) (in
 }
 
 func (r *RecordPage) SetInt(ctx context.Context, slot int, fieldName string, value int) error {
+	if err := r.setNullBit(ctx, slot, fieldName, false); err != nil {
+		return err
+	}
 	pos := r.slotOffset(slot) + r.layout.Offset(fieldName)
 	if err := r.tx.SetInt(ctx, r.blk, pos, value, true); err != nil {
 		return fmt.Errorf("set int value %d to field %q at slot %d in block %s: %w", value, fieldName, slot, r.blk, err)
@@ -66,6 +82,9 @@ func (r *RecordPage) GetString(ctx context.Context, slot int, fieldName string)
 }
 
 func (r *RecordPage) SetString(ctx context.Context, slot int, fieldName string, value string) error {
+	if err := r.setNullBit(ctx, slot, fieldName, false); err != nil {
+		return err
+	}
 	pos := r.slotOffset(slot) + r.layout.Offset(fieldName)
 	if err := r.tx.SetString(ctx, r.blk, pos, value, true); err != nil {
 		return fmt.Errorf("set string value %q to field %q at slot %d in block %s: %w", value, fieldName, slot, r.blk, err)
@@ -73,6 +92,59 @@ func (r *RecordPage) SetString(ctx context.Context, slot int, fieldName string,
 	return nil
 }
 
+func (r *RecordPage) IsNull(ctx context.Context, slot int, fieldName string) (bool, error) {
+	offset, mask := r.layout.NullBit(fieldName)
+	bits, err := r.tx.GetInt(ctx, r.blk, r.slotOffset(slot)+offset)
+	if err != nil {
+		return false, fmt.Errorf("get null bitmap of field %q at slot %d in block %s: %w", fieldName, slot, r.blk, err)
+	}
+	return bits&mask != 0, nil
+}
+
+// 値はそのままでnull bitだけを立てる
+func (r *RecordPage) SetNull(ctx context.Context, slot int, fieldName string) error {
+	return r.setNullBit(ctx, slot, fieldName, true)
+}
+
+// bitが変わるときだけ書き込み、ログを減らす
+func (r *RecordPage) setNullBit(ctx context.Context, slot int, fieldName string, isNull bool) error {
+	offset, mask := r.layout.NullBit(fieldName)
+	pos := r.slotOffset(slot) + offset
+	bits, err := r.tx.GetInt(ctx, r.blk, pos)
+	if err != nil {
+		return fmt.Errorf("get null bitmap of field %q at slot %d in block %s: %w", fieldName, slot, r.blk, err)
+	}
+	newBits := bits &^ mask
+	if isNull {
+		newBits = bits | mask
+	}
+	if newBits == bits {
+		return nil
+	}
+	if err := r.tx.SetInt(ctx, r.blk, pos, newBits, true); err != nil {
+		return fmt.Errorf("set null bitmap of field %q at slot %d in block %s: %w", fieldName, slot, r.blk, err)
+	}
+	return nil
+}
+
+// 削除済みslotを再利用するときに前のレコードのnull bitmapを消す
+func (r *RecordPage) clearNullBitmap(ctx context.Context, slot int) error {
+	for _, offset := range r.layout.NullBitmapOffsets() {
+		pos := r.slotOffset(slot) + offset
+		bits, err := r.tx.GetInt(ctx, r.blk, pos)
+		if err != nil {
+			return fmt.Errorf("get null bitmap at slot %d in block %s: %w", slot, r.blk, err)
+		}
+		if bits == 0 {
+			continue
+		}
+		if err := r.tx.SetInt(ctx, r.blk, pos, 0, true); err != nil {
+			return fmt.Errorf("clear null bitmap at slot %d in block %s: %w", slot, r.blk, err)
+		}
+	}
+	return nil
+}
+
 func (r *RecordPage) Delete(ctx context.Context, slot int) error {
 	if err := r.SetFlag(ctx, slot, SlotEmpty); err != nil {
 		return fmt.Errorf("set empty flag for slot %d in block %s: %w", slot, r.blk, err)
@@ -110,6 +182,9 @@ func (r *RecordPage) InsertNextAvabilableSlotAfter(ctx context.Context, slot int
 	if err := r.SetFlag(ctx, slot, SlotUsed); err != nil {
 		return 0, fmt.Errorf("set used flag for slot %d in block %s: %w", slot, r.blk, err)
 	}
+	if err := r.clearNullBitmap(ctx, slot); err != nil {
+		return 0, err
+	}
 	return slot, nil
 }
 
@@ -142,6 +217,11 @@ func (r *RecordPage) Format(ctx context.Context) error {
 		if err := r.tx.SetInt(ctx, r.blk, r.slotOffset(i), int(SlotEmpty), false); err != nil {
 			return fmt.Errorf("set empty flag for slot %d in block %s: %w", i, r.blk, err)
 		}
+		for _, offset := range r.layout.NullBitmapOffsets() {
+			if err := r.tx.SetInt(ctx, r.blk, r.slotOffset(i)+offset, 0, false); err != nil {
+				return fmt.Errorf("clear null bitmap for slot %d in block %s: %w", i, r.blk, err)
+			}
+		}
 		for _, field := range r.layout.Schema().Fields() {
 			pos := r.slotOffset(i) + r.layout.Offset(field)
 			switch r.layout.Schema().FieldType(field) {
@@ -349,3 +349,72 @@ func TestRecordPageBlock(t *testing.T) {
 		t.Errorf("expected block %v, got %v", blk, rp.Block())
 	}
 }
+
+func TestRecordPageNull(t *testing.T) {
+	tx, _, layout, blk, cleanup := setupTestRecordPage(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	rp, err := dbrecord.NewRecordPage(ctx, tx, blk, layout, false)
+	if err != nil {
+		t.Fatalf("failed to create record page: %v", err)
+	}
+	if err := rp.Format(ctx); err != nil {
+		t.Fatalf("failed to format: %v", err)
+	}
+
+	slot, err := rp.InsertNextAvabilableSlotAfter(ctx, -1)
+	if err != nil {
+		t.Fatalf("failed to insert after: %v", err)
+	}
+	// 挿入直後はNULLではない
+	for _, f := range []string{"id", "name", "age"} {
+		isNull, err := rp.IsNull(ctx, slot, f)
+		if err != nil {
+			t.Fatalf("failed to check null of %q: %v", f, err)
+		}
+		if isNull {
+			t.Errorf("expected %q not to be null", f)
+		}
+	}
+
+	if err := rp.SetNull(ctx, slot, "name"); err != nil {
+		t.Fatalf("failed to set null: %v", err)
+	}
+	if err := rp.SetInt(ctx, slot, "age", 30); err != nil {
+		t.Fatalf("failed to set age: %v", err)
+	}
+	if isNull, err := rp.IsNull(ctx, slot, "name"); err != nil || !isNull {
+		t.Errorf("expected name to be null, got %v (err=%v)", isNull, err)
+	}
+	if isNull, err := rp.IsNull(ctx, slot, "age"); err != nil || isNull {
+		t.Errorf("expected age not to be null, got %v (err=%v)", isNull, err)
+	}
+
+	// 値を設定するとNULLではなくなる
+	if err := rp.SetString(ctx, slot, "name", "Bob"); err != nil {
+		t.Fatalf("failed to set name: %v", err)
+	}
+	if isNull, err := rp.IsNull(ctx, slot, "name"); err != nil || isNull {
+		t.Errorf("expected name not to be null, got %v (err=%v)", isNull, err)
+	}
+
+	// 削除後に再利用したslotはNULLビットが初期化される
+	if err := rp.SetNull(ctx, slot, "id"); err != nil {
+		t.Fatalf("failed to set null: %v", err)
+	}
+	if err := rp.Delete(ctx, slot); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	reused, err := rp.InsertNextAvabilableSlotAfter(ctx, -1)
+	if err != nil {
+		t.Fatalf("failed to insert after: %v", err)
+	}
+	if reused != slot {
+		t.Fatalf("expected slot %d to be reused, got %d", slot, reused)
+	}
+	if isNull, err := rp.IsNull(ctx, reused, "id"); err != nil || isNull {
+		t.Errorf("expected id not to be null, got %v (err=%v)", isNull, err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/teru01/simpledb-go/dbbuffer"
 	"github.com/teru01/simpledb-go/dbconstant"
 	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbsize"
 	"github.com/teru01/simpledb-go/dbtx"
 )
 
@@ -16,6 +18,10 @@ type TableScan struct {
 	tableName string
 	permanent bool
 	state     *TableScanState
+	// 順に読む時のbufferの使い方. permanentの場合はnil
+	strategy *dbbuffer.AccessStrategy
+	// このblockより前は先読み済み
+	readAheadUntil int
 }
 
 type TableScanState struct {
@@ -27,6 +33,48 @@ func TableFileName(tableName string) string {
 	return fmt.Sprintf("%s.tbl", tableName)
 }
 
+// tableNameの全blockを0で埋めて全slotを空にする. blockはそのまま残して次の挿入で再利用する
+// 別のlayoutで書かれたblockを再利用するときに使う. Formatと異なりlogを残すのでrollbackできる
+// 前の内容を文字列として読むとlayoutによっては壊れた長さになるので、intとして上書きする
+func ClearTable(ctx context.Context, tx *dbtx.Transaction, tableName string) error {
+	fileName := TableFileName(tableName)
+	size, err := tx.Size(ctx, fileName)
+	if err != nil {
+		return fmt.Errorf("get table size for %q: %w", fileName, err)
+	}
+	for i := range size {
+		blk := dbfile.NewBlockID(fileName, i)
+		if err := tx.Pin(ctx, blk); err != nil {
+			return fmt.Errorf("pin block %s: %w", blk, err)
+		}
+		if err := clearBlock(ctx, tx, blk); err != nil {
+			return fmt.Errorf("clear block %s: %w", blk, err)
+		}
+		if err := tx.UnPin(blk); err != nil {
+			return fmt.Errorf("unpin block %s: %w", blk, err)
+		}
+	}
+	return nil
+}
+
+func clearBlock(ctx context.Context, tx *dbtx.Transaction, blk dbfile.BlockID) error {
+	for pos := 0; pos < tx.BlockSize(); pos += dbsize.IntSize {
+		// 最後の1語がblockからはみ出す場合は重ねて書く
+		pos = min(pos, tx.BlockSize()-dbsize.IntSize)
+		v, err := tx.GetInt(ctx, blk, pos)
+		if err != nil {
+			return fmt.Errorf("get int at offset %d: %w", pos, err)
+		}
+		if v == 0 {
+			continue
+		}
+		if err := tx.SetInt(ctx, blk, pos, 0, true); err != nil {
+			return fmt.Errorf("set int at offset %d: %w", pos, err)
+		}
+	}
+	return nil
+}
+
 func NewTableScan(ctx context.Context, tx *dbtx.Transaction, tableName string, layout *Layout, permanent bool) (*TableScan, error) {
 	var (
 		state *TableScanState
@@ -40,10 +88,17 @@ func NewTableScan(ctx context.Context, tx *dbtx.Transaction, tableName string, l
 		fileName:  fileName,
 		permanent: permanent,
 	}
+	// scanしている間にtableがDROPされないようにする
+	if err := tx.LockFile(ctx, fileName); err != nil {
+		return nil, fmt.Errorf("lock table %q: %w", tableName, err)
+	}
 	size, err := tx.Size(ctx, fileName)
 	if err != nil {
 		return nil, fmt.Errorf("get table size for %q: %w", fileName, err)
 	}
+	if !permanent {
+		t.strategy = tx.SequentialStrategy(size)
+	}
 	if size == 0 {
 		state, err = t.stateForNewBlock(ctx)
 		if err != nil {
@@ -72,6 +127,7 @@ func (t *TableScan) Close(ctx context.Context) error {
 }
 
 func (t *TableScan) SetStateToBeforeFirst(ctx context.Context) error {
+	t.readAheadUntil = 0
 	state, err := t.stateForBlock(ctx, 0)
 	if err != nil {
 		return fmt.Errorf("move to block 0 for table %q: %w", t.fileName, err)
@@ -97,6 +153,13 @@ func (t *TableScan) GetString(ctx context.Context, fieldName string) (string, er
 }
 
 func (t *TableScan) GetValue(ctx context.Context, fieldName string) (dbconstant.Constant, error) {
+	isNull, err := t.state.recordPage.IsNull(ctx, t.state.currentSlot, fieldName)
+	if err != nil {
+		return nil, fmt.Errorf("check null of field %q at slot %d: %w", fieldName, t.state.currentSlot, err)
+	}
+	if isNull {
+		return dbconstant.NewNullConstant(), nil
+	}
 	switch t.layout.Schema().FieldType(fieldName) {
 	case FieldTypeInt:
 		i, err := t.GetInt(ctx, fieldName)
@@ -130,6 +193,7 @@ func (t *TableScan) MoveToRID(ctx context.Context, rID RID) error {
 	if err := t.Close(ctx); err != nil {
 		return fmt.Errorf("close current block before moving to RID %v: %w", rID, err)
 	}
+	t.readAheadUntil = 0
 	blk := dbfile.NewBlockID(t.fileName, rID.BlockNum())
 	rp, err := NewRecordPage(ctx, t.tx, blk, t.layout, t.permanent)
 	if err != nil {
@@ -152,6 +216,12 @@ func (t *TableScan) SetString(ctx context.Context, fieldName string, value strin
 }
 
 func (t *TableScan) SetValue(ctx context.Context, fieldName string, value dbconstant.Constant) error {
+	if dbconstant.IsNull(value) {
+		if err := t.state.recordPage.SetNull(ctx, t.state.currentSlot, fieldName); err != nil {
+			return fmt.Errorf("set null to field %q at slot %d: %w", fieldName, t.state.currentSlot, err)
+		}
+		return nil
+	}
 	switch t.layout.Schema().FieldType(fieldName) {
 	case FieldTypeInt:
 		val, ok := value.AsRaw().(int)
@@ -251,8 +321,19 @@ func (t *TableScan) stateForBlock(ctx context.Context, blkNum int) (*TableScanSt
 	if err := t.Close(ctx); err != nil {
 		return nil, fmt.Errorf("close current block before moving to block %d: %w", blkNum, err)
 	}
+	if err := t.readAhead(ctx, blkNum); err != nil {
+		return nil, fmt.Errorf("read ahead from block %d: %w", blkNum, err)
+	}
 	blk := dbfile.NewBlockID(t.fileName, blkNum)
-	rp, err := NewRecordPage(ctx, t.tx, blk, t.layout, t.permanent)
+	var (
+		rp  *RecordPage
+		err error
+	)
+	if t.strategy != nil {
+		rp, err = NewRecordPageWithStrategy(ctx, t.tx, blk, t.layout, t.strategy)
+	} else {
+		rp, err = NewRecordPage(ctx, t.tx, blk, t.layout, t.permanent)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("create record page for block %s: %w", blk, err)
 	}
@@ -262,6 +343,23 @@ func (t *TableScan) stateForBlock(ctx context.Context, blkNum int) (*TableScanSt
 	}, nil
 }
 
+// blkNumから先のblockをまとめてbuffer poolに読んでおく. 前回先読みした範囲を読み終えた時だけ読む
+func (t *TableScan) readAhead(ctx context.Context, blkNum int) error {
+	if t.strategy == nil || blkNum < t.readAheadUntil {
+		return nil
+	}
+	size, err := t.tx.Size(ctx, t.fileName)
+	if err != nil {
+		return fmt.Errorf("get table size for %q: %w", t.fileName, err)
+	}
+	n := min(t.strategy.ReadAhead(), size-blkNum)
+	if n <= 1 {
+		return nil
+	}
+	t.readAheadUntil = blkNum + n
+	return t.tx.Prefetch(dbfile.NewBlockID(t.fileName, blkNum), n, t.strategy)
+}
+
 // blockを追加し、新たなstateを返す
 func (t *TableScan) stateForNewBlock(ctx context.Context) (*TableScanState, error) {
 	if err := t.Close(ctx); err != nil {
@@ -1,6 +1,10 @@
 package dbserver
 
-import "encoding/binary"
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+)
 
 type MessageIdentifier rune
 
@@ -92,11 +96,11 @@ func buildRowDescription(fields []string, fieldTypes []int) []byte {
 }
 
 // buildDataRow builds a DataRow ('D') message for a single row of string values.
-func buildDataRow(values []string) []byte {
+func buildDataRow(values []sql.NullString) []byte {
 	// 2 bytes for column count + for each column: 4 bytes length + value bytes
 	payloadSize := 2
 	for _, v := range values {
-		payloadSize += 4 + len(v)
+		payloadSize += 4 + len(v.String)
 	}
 
 	buf := make([]byte, 0, payloadSize)
@@ -104,10 +108,15 @@ func buildDataRow(values []string) []byte {
 	buf = binary.BigEndian.AppendUint16(buf, uint16(len(values)))
 
 	for _, v := range values {
+		if !v.Valid {
+			// NULL is represented by length -1 with no value bytes
+			buf = binary.BigEndian.AppendUint32(buf, math.MaxUint32)
+			continue
+		}
 		// column value length (Int32)
-		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v.String)))
 		// column value
-		buf = append(buf, []byte(v)...)
+		buf = append(buf, []byte(v.String)...)
 	}
 
 	msg := NewMessage(DataRow, buf)
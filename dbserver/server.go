@@ -91,7 +91,13 @@ func handleStartup(conn net.Conn) error {
 	}
 }
 
-func handleQueryLoop(ctx context.Context, conn net.Conn, db *dbexecutor.SimpleDB) error {
+func handleQueryLoop(ctx context.Context, conn net.Conn, db *dbexecutor.SimpleDB) (err error) {
+	session := db.NewSession()
+	defer func() {
+		if closeErr := session.Close(ctx); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close session: %w", closeErr))
+		}
+	}()
 	for {
 		sql, terminate, err := readQuery(conn)
 		if err != nil {
@@ -105,7 +111,7 @@ func handleQueryLoop(ctx context.Context, conn net.Conn, db *dbexecutor.SimpleDB
 		}
 
 		slog.Debug("executing query", "sql", sql)
-		result, err := db.Execute(ctx, sql)
+		result, err := session.Execute(ctx, sql)
 		if err != nil {
 			slog.Error("query execution error", "sql", sql, "error", err)
 			errMsg := err.Error()
@@ -117,14 +123,14 @@ func handleQueryLoop(ctx context.Context, conn net.Conn, db *dbexecutor.SimpleDB
 			if _, wErr := conn.Write(buildErrorResponse("ERROR", errMsg)); wErr != nil {
 				return fmt.Errorf("write error response: %w", wErr)
 			}
-			ready := NewMessage(ReadyForQuery, []byte("I"))
+			ready := NewMessage(ReadyForQuery, []byte{byte(session.TxStatus())})
 			if _, wErr := conn.Write(ready.ToByte()); wErr != nil {
 				return fmt.Errorf("write ready for query: %w", wErr)
 			}
 			continue
 		}
 
-		if err := writeResult(conn, result); err != nil {
+		if err := writeResult(conn, result, session.TxStatus()); err != nil {
 			return fmt.Errorf("write result: %w", err)
 		}
 	}
@@ -165,7 +171,7 @@ func readQuery(conn io.ReadWriter) (sql string, terminate bool, err error) {
 	}
 }
 
-func writeResult(conn net.Conn, result *dbexecutor.ExecuteResult) error {
+func writeResult(conn net.Conn, result *dbexecutor.ExecuteResult, status dbexecutor.TxStatus) error {
 	// For SELECT queries, send RowDescription + DataRows
 	if len(result.Fields) > 0 {
 		if _, err := conn.Write(buildRowDescription(result.Fields, result.FieldTypes)); err != nil {
@@ -184,7 +190,7 @@ func writeResult(conn net.Conn, result *dbexecutor.ExecuteResult) error {
 	}
 
 	// ReadyForQuery
-	ready := NewMessage(ReadyForQuery, []byte("I"))
+	ready := NewMessage(ReadyForQuery, []byte{byte(status)})
 	if _, err := conn.Write(ready.ToByte()); err != nil {
 		return fmt.Errorf("write ready for query: %w", err)
 	}
@@ -0,0 +1,138 @@
+package dbtx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dblog"
+)
+
+// backupのディレクトリに置くファイル. 起動時にこのファイルがあれば, 記録されたcheckpointからrecoveryする
+const BackupLabelFileName = "backup_label"
+
+// online backupの情報
+type BackupLabel struct {
+	// backupの最初に取ったcheckpointのLSN. 復元時はここからredoする
+	CheckpointLSN int
+	// backupに含まれるlogの最後のLSN. ここまでredoするとデータファイルの写しが一貫した状態になる
+	EndLSN    int
+	StartTime time.Time
+}
+
+// 動いているdatabaseのデータファイルとlogをdirに写す(online hot backup)
+//  1. checkpointを取る. 写したデータファイルにはこれより前の変更が全て乗っている
+//  2. データファイルをblockごとに写す. 写している間の変更が一部だけ乗ったblockがあってもよい
+//  3. logをflushしてから写す. WALなので, 写したblockの変更は全てlogに含まれている
+//  4. 1のcheckpointのLSNをbackup labelに書く. 復元時はそこからredoし, 完了していないtransactionを取り消す
+//
+// backup labelは最後に書くので, labelのないdirは途中で失敗したbackupである
+func Backup(fm *dbfile.FileManager, lm *dblog.LogManager, bm *dbbuffer.BufferManager, dir string) (BackupLabel, error) {
+	if err := prepareBackupDir(dir); err != nil {
+		return BackupLabel{}, err
+	}
+	// 写し終えるまでcheckpointでlogが捨てられないようにする
+	lm.LockCheckpoint()
+	defer lm.UnlockCheckpoint()
+
+	label := BackupLabel{StartTime: time.Now()}
+	lsn, err := checkpoint(lm, bm)
+	if err != nil {
+		return BackupLabel{}, fmt.Errorf("checkpoint before backup: %w", err)
+	}
+	label.CheckpointLSN = lsn
+
+	files, err := fm.Files()
+	if err != nil {
+		return BackupLabel{}, fmt.Errorf("list data files: %w", err)
+	}
+	for _, file := range files {
+		if lm.IsLogFile(file) || strings.HasPrefix(file, dbfile.TempFilePrefix) || file == BackupLabelFileName {
+			continue
+		}
+		if err := fm.CopyFile(file, filepath.Join(dir, file)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// 写している間に削除された. 復元時はDROPFILEのredoで削除される
+				continue
+			}
+			return BackupLabel{}, fmt.Errorf("copy data file %q: %w", file, err)
+		}
+	}
+
+	if err := dbfile.WriteFormatFile(dir); err != nil {
+		return BackupLabel{}, err
+	}
+	endLSN, err := lm.CopyTo(dir)
+	if err != nil {
+		return BackupLabel{}, fmt.Errorf("copy log: %w", err)
+	}
+	label.EndLSN = endLSN
+	if err := writeBackupLabel(dir, label); err != nil {
+		return BackupLabel{}, err
+	}
+	slog.Info("backup completed", slog.String("dir", dir), slog.Int("checkpointLSN", label.CheckpointLSN), slog.Int("endLSN", label.EndLSN))
+	return label, nil
+}
+
+// backup先は存在しないか空のディレクトリにする
+func prepareBackupDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("create backup dir %s: %w", dir, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read backup dir %s: %w", dir, err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("backup dir %s is not empty", dir)
+	}
+	return nil
+}
+
+func writeBackupLabel(dir string, label BackupLabel) error {
+	content := fmt.Sprintf("CHECKPOINT LSN: %d\nEND LSN: %d\nSTART TIME: %s\n", label.CheckpointLSN, label.EndLSN, label.StartTime.Format(time.RFC3339Nano))
+	path := filepath.Join(dir, BackupLabelFileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("write backup label %s: %w", path, err)
+	}
+	return nil
+}
+
+// dirのbackup labelを読む. labelがなければokはfalse
+func ReadBackupLabel(dir string) (label BackupLabel, ok bool, err error) {
+	path := filepath.Join(dir, BackupLabelFileName)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return BackupLabel{}, false, nil
+	}
+	if err != nil {
+		return BackupLabel{}, false, fmt.Errorf("read backup label %s: %w", path, err)
+	}
+	var startTime string
+	if _, err := fmt.Sscanf(string(content), "CHECKPOINT LSN: %d\nEND LSN: %d\nSTART TIME: %s\n", &label.CheckpointLSN, &label.EndLSN, &startTime); err != nil {
+		return BackupLabel{}, false, fmt.Errorf("parse backup label %s: %w", path, err)
+	}
+	if label.StartTime, err = time.Parse(time.RFC3339Nano, startTime); err != nil {
+		return BackupLabel{}, false, fmt.Errorf("parse start time in backup label %s: %w", path, err)
+	}
+	return label, true, nil
+}
+
+// 復元を終えたらbackup labelを消す. 次の起動からは通常のrecoveryを行う
+func RemoveBackupLabel(dir string) error {
+	path := filepath.Join(dir, BackupLabelFileName)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove backup label %s: %w", path, err)
+	}
+	return nil
+}
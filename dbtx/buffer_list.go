@@ -31,15 +31,18 @@ func (b *BufferList) Buffer(blk dbfile.BlockID) (*dbbuffer.Buffer, error) {
 }
 
 func (b *BufferList) Pin(ctx context.Context, blk dbfile.BlockID, permanent bool) error {
-	var (
-		buf *dbbuffer.Buffer
-		err error
-	)
 	if permanent {
-		buf, err = b.bufferManager.PinPermanent(ctx, blk)
-	} else {
-		buf, err = b.bufferManager.Pin(ctx, blk)
+		return b.add(blk, func() (*dbbuffer.Buffer, error) { return b.bufferManager.PinPermanent(ctx, blk) })
 	}
+	return b.add(blk, func() (*dbbuffer.Buffer, error) { return b.bufferManager.Pin(ctx, blk) })
+}
+
+func (b *BufferList) PinWithStrategy(ctx context.Context, blk dbfile.BlockID, s *dbbuffer.AccessStrategy) error {
+	return b.add(blk, func() (*dbbuffer.Buffer, error) { return b.bufferManager.PinWithStrategy(ctx, blk, s) })
+}
+
+func (b *BufferList) add(blk dbfile.BlockID, pin func() (*dbbuffer.Buffer, error)) error {
+	buf, err := pin()
 	if err != nil {
 		return fmt.Errorf("pin block %s: %w", blk, err)
 	}
@@ -0,0 +1,96 @@
+package dbtx
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dblog"
+)
+
+// 実行中のtransactionを止めずにcheckpointを取る(non-quiescent checkpoint)
+//  1. 現在のLSNを覚えてから変更されたbufferを全てflushする. 覚えたLSNまでの変更は全てディスクに乗る
+//  2. redoを始めるLSNと実行中のtransactionをNQCKPTに書く
+//  3. redoにも実行中のtransactionのrollbackにも使わない古いlogを捨てる
+func Checkpoint(lm *dblog.LogManager, bm *dbbuffer.BufferManager) error {
+	lm.LockCheckpoint()
+	defer lm.UnlockCheckpoint()
+	_, err := checkpoint(lm, bm)
+	return err
+}
+
+// lm.LockCheckpointを取った状態で呼ぶ. NQCKPTのLSNを返す
+func checkpoint(lm *dblog.LogManager, bm *dbbuffer.BufferManager) (int, error) {
+	// 以降に初めて変更されるpageは, redoで復元できるようにfull page imageをlogに残す
+	redoLSN := lm.BeginCheckpoint()
+	if err := bm.FlushModified(); err != nil {
+		return 0, fmt.Errorf("flush modified buffers: %w", err)
+	}
+	oldestLSN := redoLSN
+	numActive := 0
+	lsn, err := lm.WriteCheckpoint(func(active map[uint64]int) (int, error) {
+		numActive = len(active)
+		for _, startLSN := range active {
+			oldestLSN = min(oldestLSN, startLSN)
+		}
+		return WriteNQCheckpointToLog(lm, redoLSN, active)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("write nqcheckpoint record to log: %w", err)
+	}
+	if err := lm.FlushWithLSN(lsn); err != nil {
+		return 0, fmt.Errorf("flush log with LSN %d: %w", lsn, err)
+	}
+	n, err := lm.Truncate(oldestLSN)
+	if err != nil {
+		return 0, fmt.Errorf("truncate log before LSN %d: %w", oldestLSN, err)
+	}
+	slog.Debug("checkpoint", slog.Int("lsn", lsn), slog.Int("redoLSN", redoLSN), slog.Int("activeTxs", numActive), slog.Int("truncatedSegments", n))
+	return lsn, nil
+}
+
+// 一定間隔でbackgroundでcheckpointを取る
+type Checkpointer struct {
+	logManager    *dblog.LogManager
+	bufferManager *dbbuffer.BufferManager
+	interval      time.Duration
+	stopCh        chan struct{}
+	doneCh        chan struct{}
+}
+
+func NewCheckpointer(lm *dblog.LogManager, bm *dbbuffer.BufferManager, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		logManager:    lm,
+		bufferManager: bm,
+		interval:      interval,
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+}
+
+func (c *Checkpointer) Start() {
+	go c.run()
+}
+
+// 実行中のcheckpointが終わるのを待って止める
+func (c *Checkpointer) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+func (c *Checkpointer) run() {
+	defer close(c.doneCh)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if err := Checkpoint(c.logManager, c.bufferManager); err != nil {
+				slog.Error("checkpoint failed", "error", err)
+			}
+		}
+	}
+}
@@ -4,26 +4,63 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/teru01/simpledb-go/dbbuffer"
 	"github.com/teru01/simpledb-go/dbfile"
 )
 
-// 全てのtransactionで共有
-var lockTable = NewLockTable()
+type IsolationLevel int
+
+const (
+	// 読み込みのS lockをcommitまで保持し, EOFマーカーのlockでファントムも防ぐ
+	IsolationSerializable IsolationLevel = iota
+	// 読み込みのS lockをcommitまで保持するが, EOFマーカーはlockしない
+	IsolationRepeatableRead
+	// 読み込みが終わるとすぐにS lockを外す
+	IsolationReadCommitted
+	// 読み込みでS lockを取らない
+	IsolationReadUncommitted
+	// MVCC. 読み込みはlockを取らずに開始時点のsnapshotを読む
+	IsolationSnapshot
+)
+
+func (l IsolationLevel) String() string {
+	switch l {
+	case IsolationSerializable:
+		return "SERIALIZABLE"
+	case IsolationRepeatableRead:
+		return "REPEATABLE READ"
+	case IsolationReadCommitted:
+		return "READ COMMITTED"
+	case IsolationReadUncommitted:
+		return "READ UNCOMMITTED"
+	case IsolationSnapshot:
+		return "SNAPSHOT"
+	default:
+		return fmt.Sprintf("IsolationLevel(%d)", int(l))
+	}
+}
 
 // 個々のtransactionが別個のインスタンスを保持する.
 type ConcurrencyManager struct {
-	locks map[dbfile.BlockID]string
+	// databaseの全てのtransactionで共有する
+	lockTable      *dbbuffer.LockTable
+	txNum          uint64
+	isolationLevel IsolationLevel
+	locks          map[dbfile.BlockID]string
 }
 
-func NewConcurrencyManager() *ConcurrencyManager {
+func NewConcurrencyManager(lockTable *dbbuffer.LockTable, txNum uint64, isolationLevel IsolationLevel) *ConcurrencyManager {
 	return &ConcurrencyManager{
-		locks: make(map[dbfile.BlockID]string),
+		lockTable:      lockTable,
+		txNum:          txNum,
+		isolationLevel: isolationLevel,
+		locks:          make(map[dbfile.BlockID]string),
 	}
 }
 
 func (c *ConcurrencyManager) SLock(ctx context.Context, blk dbfile.BlockID) error {
 	if _, ok := c.locks[blk]; !ok {
-		if err := lockTable.SLock(ctx, blk); err != nil {
+		if err := c.lockTable.SLock(ctx, c.txNum, blk); err != nil {
 			return fmt.Errorf("acquire shared lock on block %s: %w", blk, err)
 		}
 		c.locks[blk] = "S"
@@ -36,7 +73,7 @@ func (c *ConcurrencyManager) XLock(ctx context.Context, blk dbfile.BlockID) erro
 		if err := c.SLock(ctx, blk); err != nil {
 			return fmt.Errorf("acquire shared lock on block %s: %w", blk, err)
 		}
-		if err := lockTable.XLock(ctx, blk); err != nil {
+		if err := c.lockTable.XLock(ctx, c.txNum, blk); err != nil {
 			return fmt.Errorf("upgrade to exclusive lock on block %s: %w", blk, err)
 		}
 		c.locks[blk] = "X"
@@ -44,9 +81,31 @@ func (c *ConcurrencyManager) XLock(ctx context.Context, blk dbfile.BlockID) erro
 	return nil
 }
 
+// 読み込みの前に呼ぶ. isolation levelに応じてS lockを取る
+func (c *ConcurrencyManager) ReadLock(ctx context.Context, blk dbfile.BlockID) error {
+	switch c.isolationLevel {
+	case IsolationReadUncommitted, IsolationSnapshot:
+		return nil
+	case IsolationRepeatableRead:
+		if blk.BlockNum() == EndOfFile {
+			return nil
+		}
+	}
+	return c.SLock(ctx, blk)
+}
+
+// 読み込みが終わった時に呼ぶ. READ COMMITTEDではS lockをすぐに外す
+func (c *ConcurrencyManager) ReadDone(blk dbfile.BlockID) {
+	if c.isolationLevel != IsolationReadCommitted || c.locks[blk] != "S" {
+		return
+	}
+	c.lockTable.UnLock(c.txNum, blk)
+	delete(c.locks, blk)
+}
+
 func (c *ConcurrencyManager) Release() {
 	for blk := range c.locks {
-		lockTable.UnLock(blk)
+		c.lockTable.UnLock(c.txNum, blk)
 	}
 	clear(c.locks)
 }
@@ -3,6 +3,7 @@ package dbtx
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dblog"
@@ -16,13 +17,40 @@ const (
 	ROLLBACK   = 3
 	SETINT     = 4
 	SETSTRING  = 5
+	// rollbackで変更を取り消したことを表すCLR(compensation log record). redoのみ行う
+	COMPENSATEINT    = 6
+	COMPENSATESTRING = 7
+	DROPFILE         = 8
+	// 実行中のtransactionを止めずに取るcheckpoint
+	NQCHECKPOINT = 9
+	// checkpoint以降初めて変更するpageの変更前の内容の一部. redoで書きかけのpageを復元するのに使う
+	FULLPAGE = 10
 )
 
+// full page imageを分けて書く数. 1つのlog recordはlogの1 blockに収める
+const fullPageChunks = 4
+
+// lsnはlog record自身のLSN
 type LogRecord interface {
 	op() int
 	txNumber() uint64
-	undo(ctx context.Context, tx *Transaction) error
-	redo(ctx context.Context, tx *Transaction) error
+	undo(ctx context.Context, tx *Transaction, lsn int) error
+	redo(ctx context.Context, tx *Transaction, lsn int) error
+}
+
+// 特定のファイルに対するlog record
+type fileLogRecord interface {
+	fileName() string
+}
+
+// 取り消した変更のLSNを持つCLR
+type compensationLogRecord interface {
+	undoneLSN() int
+}
+
+// recを書いたtransactionの番号. checkpointは0
+func LogRecordTxNumber(rec LogRecord) uint64 {
+	return rec.txNumber()
 }
 
 func NewLogRecord(contents []byte) LogRecord {
@@ -40,6 +68,16 @@ func NewLogRecord(contents []byte) LogRecord {
 		return NewSetIntLogRecord(page)
 	case SETSTRING:
 		return NewSetStringLogRecord(page)
+	case COMPENSATEINT:
+		return NewCompensateIntLogRecord(page)
+	case COMPENSATESTRING:
+		return NewCompensateStringLogRecord(page)
+	case DROPFILE:
+		return NewDropFileLogRecord(page)
+	case NQCHECKPOINT:
+		return NewNQCheckpointLogRecord(page)
+	case FULLPAGE:
+		return NewFullPageLogRecord(page)
 	}
 	return nil
 }
@@ -58,11 +96,11 @@ func (l *checkpointLogRecord) txNumber() uint64 {
 	return 0
 }
 
-func (l *checkpointLogRecord) undo(ctx context.Context, tx *Transaction) error {
+func (l *checkpointLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
-func (l *checkpointLogRecord) redo(ctx context.Context, tx *Transaction) error {
+func (l *checkpointLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
@@ -83,6 +121,81 @@ func WriteCheckpointToLog(lm *dblog.LogManager) (int, error) {
 	return lsn, nil
 }
 
+type nqCheckpointLogRecord struct {
+	// これより前のlog recordの変更はディスクに乗っている
+	redoLSN int
+	// checkpoint時点で実行中のtransactionとSTART recordのLSN
+	activeTxs map[uint64]int
+}
+
+func NewNQCheckpointLogRecord(page *dbfile.Page) LogRecord {
+	redoLSNPos := dbsize.IntSize
+	redoLSN := page.GetInt(redoLSNPos)
+	countPos := redoLSNPos + dbsize.IntSize
+	count := page.GetInt(countPos)
+	activeTxs := make(map[uint64]int, count)
+	pos := countPos + dbsize.IntSize
+	for range count {
+		txNum := page.GetUint64(pos)
+		activeTxs[txNum] = page.GetInt(pos + dbsize.Uint64Size)
+		pos += dbsize.Uint64Size + dbsize.IntSize
+	}
+	return &nqCheckpointLogRecord{redoLSN: redoLSN, activeTxs: activeTxs}
+}
+
+func (l *nqCheckpointLogRecord) op() int {
+	return NQCHECKPOINT
+}
+
+func (l *nqCheckpointLogRecord) txNumber() uint64 {
+	return 0
+}
+
+func (l *nqCheckpointLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+func (l *nqCheckpointLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+func (l *nqCheckpointLogRecord) String() string {
+	return fmt.Sprintf("{\"kind\": \"nqcheckpoint\", \"redoLSN\": %d, \"activeTxs\": %v}", l.redoLSN, l.activeTxs)
+}
+
+// NQCHECKPOINT,REDOLSN,COUNT,(TXNUM,STARTLSN)...
+func WriteNQCheckpointToLog(lm *dblog.LogManager, redoLSN int, activeTxs map[uint64]int) (int, error) {
+	redoLSNPos := dbsize.IntSize
+	countPos := redoLSNPos + dbsize.IntSize
+	txsPos := countPos + dbsize.IntSize
+	b := make([]byte, txsPos+len(activeTxs)*(dbsize.Uint64Size+dbsize.IntSize))
+	page := dbfile.NewPageFromBytes(b)
+	if err := page.SetInt(0, NQCHECKPOINT); err != nil {
+		return 0, fmt.Errorf("set nqcheckpoint operation code at offset 0: %w", err)
+	}
+	if err := page.SetInt(redoLSNPos, redoLSN); err != nil {
+		return 0, fmt.Errorf("set redo LSN %d at offset %d: %w", redoLSN, redoLSNPos, err)
+	}
+	if err := page.SetInt(countPos, len(activeTxs)); err != nil {
+		return 0, fmt.Errorf("set transaction count %d at offset %d: %w", len(activeTxs), countPos, err)
+	}
+	pos := txsPos
+	for txNum, startLSN := range activeTxs {
+		if err := page.SetUint64(pos, txNum); err != nil {
+			return 0, fmt.Errorf("set transaction number %d at offset %d: %w", txNum, pos, err)
+		}
+		if err := page.SetInt(pos+dbsize.Uint64Size, startLSN); err != nil {
+			return 0, fmt.Errorf("set start LSN %d at offset %d: %w", startLSN, pos+dbsize.Uint64Size, err)
+		}
+		pos += dbsize.Uint64Size + dbsize.IntSize
+	}
+	lsn, err := lm.Append(b)
+	if err != nil {
+		return 0, fmt.Errorf("append nqcheckpoint record to log: %w", err)
+	}
+	return lsn, nil
+}
+
 type startLogRecord struct {
 	txNum uint64
 }
@@ -105,11 +218,11 @@ func (l *startLogRecord) String() string {
 	return fmt.Sprintf("{\"kind\": \"start\", \"txNum\": %d}", l.txNumber())
 }
 
-func (l *startLogRecord) undo(ctx context.Context, tx *Transaction) error {
+func (l *startLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
-func (l *startLogRecord) redo(ctx context.Context, tx *Transaction) error {
+func (l *startLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
@@ -132,12 +245,16 @@ func WriteStartToLog(lm *dblog.LogManager, txNum uint64) (int, error) {
 
 type commitLogRecord struct {
 	txNum uint64
+	// point-in-time recoveryで時刻を指定した時の復元先の判定に使う
+	committedAt time.Time
 }
 
 func NewCommitLogRecord(page *dbfile.Page) LogRecord {
 	txPos := dbsize.IntSize
 	txNum := page.GetUint64(txPos)
-	return &commitLogRecord{txNum: txNum}
+	timePos := txPos + dbsize.Uint64Size
+	committedAt := time.Unix(0, int64(page.GetUint64(timePos)))
+	return &commitLogRecord{txNum: txNum, committedAt: committedAt}
 }
 
 func (l *commitLogRecord) op() int {
@@ -148,21 +265,22 @@ func (l *commitLogRecord) txNumber() uint64 {
 	return l.txNum
 }
 
-func (l *commitLogRecord) undo(ctx context.Context, tx *Transaction) error {
+func (l *commitLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
-func (l *commitLogRecord) redo(ctx context.Context, tx *Transaction) error {
+func (l *commitLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
 func (l *commitLogRecord) String() string {
-	return fmt.Sprintf("{\"kind\": \"commit\", \"txNum\": %d}", l.txNumber())
+	return fmt.Sprintf("{\"kind\": \"commit\", \"txNum\": %d, \"committedAt\": %q}", l.txNumber(), l.committedAt.Format(time.RFC3339Nano))
 }
 
 func WriteCommitToLog(lm *dblog.LogManager, txNum uint64) (int, error) {
-	b := make([]byte, dbsize.IntSize+dbsize.Uint64Size)
+	b := make([]byte, dbsize.IntSize+2*dbsize.Uint64Size)
 	txPos := dbsize.IntSize
+	timePos := txPos + dbsize.Uint64Size
 	page := dbfile.NewPageFromBytes(b)
 	if err := page.SetInt(0, COMMIT); err != nil {
 		return 0, fmt.Errorf("set commit operation code at offset 0: %w", err)
@@ -170,6 +288,9 @@ func WriteCommitToLog(lm *dblog.LogManager, txNum uint64) (int, error) {
 	if err := page.SetUint64(txPos, txNum); err != nil {
 		return 0, fmt.Errorf("set transaction number %d at offset %d: %w", txNum, txPos, err)
 	}
+	if err := page.SetUint64(timePos, uint64(time.Now().UnixNano())); err != nil {
+		return 0, fmt.Errorf("set commit time at offset %d: %w", timePos, err)
+	}
 	lsn, err := lm.Append(b)
 	if err != nil {
 		return 0, fmt.Errorf("append commit record to log for transaction %d: %w", txNum, err)
@@ -199,11 +320,11 @@ func (l *rollbackLogRecord) String() string {
 	return fmt.Sprintf("{\"kind\": \"rollback\", \"txNum\": %d}", l.txNumber())
 }
 
-func (l *rollbackLogRecord) undo(ctx context.Context, tx *Transaction) error {
+func (l *rollbackLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
-func (l *rollbackLogRecord) redo(ctx context.Context, tx *Transaction) error {
+func (l *rollbackLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
 	return nil
 }
 
@@ -257,11 +378,16 @@ func (l *setIntLogRecord) txNumber() uint64 {
 	return l.txNum
 }
 
-func (l *setIntLogRecord) undo(ctx context.Context, tx *Transaction) error {
+func (l *setIntLogRecord) fileName() string {
+	return l.blockID.FileName()
+}
+
+// 元の値に戻し, CLRを残す
+func (l *setIntLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
 	if err := tx.Pin(ctx, l.blockID); err != nil {
 		return fmt.Errorf("pin block %s for undo: %w", l.blockID, err)
 	}
-	if err := tx.SetInt(ctx, l.blockID, l.offset, l.value, false); err != nil {
+	if err := tx.compensateInt(l.txNum, l.blockID, l.offset, l.value, lsn); err != nil {
 		return fmt.Errorf("set int value %d at offset %d in block %s for undo: %w", l.value, l.offset, l.blockID, err)
 	}
 	if err := tx.UnPin(l.blockID); err != nil {
@@ -270,16 +396,12 @@ func (l *setIntLogRecord) undo(ctx context.Context, tx *Transaction) error {
 	return nil
 }
 
-func (l *setIntLogRecord) redo(ctx context.Context, tx *Transaction) error {
-	if err := tx.Pin(ctx, l.blockID); err != nil {
-		return fmt.Errorf("pin block %s for redo: %w", l.blockID, err)
-	}
-	if err := tx.SetInt(ctx, l.blockID, l.offset, l.newValue, false); err != nil {
+func (l *setIntLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	if err := tx.redo(ctx, l.blockID, lsn, func(p *dbfile.Page) error {
+		return p.SetInt(l.offset, l.newValue)
+	}); err != nil {
 		return fmt.Errorf("set int value %d at offset %d in block %s for redo: %w", l.newValue, l.offset, l.blockID, err)
 	}
-	if err := tx.UnPin(l.blockID); err != nil {
-		return fmt.Errorf("unpin block %s after redo: %w", l.blockID, err)
-	}
 	return nil
 }
 
@@ -359,11 +481,16 @@ func (l *setStringLogRecord) txNumber() uint64 {
 	return l.txNum
 }
 
-func (l *setStringLogRecord) undo(ctx context.Context, tx *Transaction) error {
+func (l *setStringLogRecord) fileName() string {
+	return l.blockID.FileName()
+}
+
+// 元の値に戻し, CLRを残す
+func (l *setStringLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
 	if err := tx.Pin(ctx, l.blockID); err != nil {
 		return fmt.Errorf("pin block %s for undo: %w", l.blockID, err)
 	}
-	if err := tx.SetString(ctx, l.blockID, l.offset, l.value, false); err != nil {
+	if err := tx.compensateString(l.txNum, l.blockID, l.offset, l.value, lsn); err != nil {
 		return fmt.Errorf("set string value %q at offset %d in block %s for undo: %w", l.value, l.offset, l.blockID, err)
 	}
 	if err := tx.UnPin(l.blockID); err != nil {
@@ -372,16 +499,12 @@ func (l *setStringLogRecord) undo(ctx context.Context, tx *Transaction) error {
 	return nil
 }
 
-func (l *setStringLogRecord) redo(ctx context.Context, tx *Transaction) error {
-	if err := tx.Pin(ctx, l.blockID); err != nil {
-		return fmt.Errorf("pin block %s for redo: %w", l.blockID, err)
-	}
-	if err := tx.SetString(ctx, l.blockID, l.offset, l.newValue, false); err != nil {
+func (l *setStringLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	if err := tx.redo(ctx, l.blockID, lsn, func(p *dbfile.Page) error {
+		return p.SetString(l.offset, l.newValue)
+	}); err != nil {
 		return fmt.Errorf("set string value %q at offset %d in block %s for redo: %w", l.newValue, l.offset, l.blockID, err)
 	}
-	if err := tx.UnPin(l.blockID); err != nil {
-		return fmt.Errorf("unpin block %s after redo: %w", l.blockID, err)
-	}
 	return nil
 }
 
@@ -427,3 +550,358 @@ func WriteStringToLog(lm *dblog.LogManager, txNum uint64, blockID dbfile.BlockID
 	}
 	return lsn, nil
 }
+
+type compensateIntLogRecord struct {
+	txNum   uint64
+	blockID dbfile.BlockID
+	offset  int
+	value   int
+	// 取り消したSETINTのLSN
+	undone int
+}
+
+func NewCompensateIntLogRecord(page *dbfile.Page) LogRecord {
+	txPos := dbsize.IntSize
+	txNum := page.GetUint64(txPos)
+	fileNamePos := txPos + dbsize.Uint64Size
+	fileName := page.GetString(fileNamePos)
+	blockNumPos := fileNamePos + dbfile.MaxStringLengthOnPage(len(fileName))
+	blockNum := page.GetInt(blockNumPos)
+	blockID := dbfile.NewBlockID(fileName, blockNum)
+	offsetPos := blockNumPos + dbsize.IntSize
+	offset := page.GetInt(offsetPos)
+	undonePos := offsetPos + dbsize.IntSize
+	undone := page.GetInt(undonePos)
+	valuePos := undonePos + dbsize.IntSize
+	value := page.GetInt(valuePos)
+	return &compensateIntLogRecord{txNum: txNum, blockID: blockID, offset: offset, value: value, undone: undone}
+}
+
+func (l *compensateIntLogRecord) op() int {
+	return COMPENSATEINT
+}
+
+func (l *compensateIntLogRecord) txNumber() uint64 {
+	return l.txNum
+}
+
+func (l *compensateIntLogRecord) fileName() string {
+	return l.blockID.FileName()
+}
+
+func (l *compensateIntLogRecord) undoneLSN() int {
+	return l.undone
+}
+
+// CLR自体は取り消さない
+func (l *compensateIntLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+func (l *compensateIntLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	if err := tx.redo(ctx, l.blockID, lsn, func(p *dbfile.Page) error {
+		return p.SetInt(l.offset, l.value)
+	}); err != nil {
+		return fmt.Errorf("set int value %d at offset %d in block %s for redo: %w", l.value, l.offset, l.blockID, err)
+	}
+	return nil
+}
+
+func (l *compensateIntLogRecord) String() string {
+	return fmt.Sprintf("{\"kind\": \"compensateInt\", \"txNum\": %d, \"blockID\": %s, \"offset\": %d, \"value\": %d, \"undoneLSN\": %d}", l.txNumber(), l.blockID.String(), l.offset, l.value, l.undone)
+}
+
+// COMPENSATEINT,TXNUM,FILENAME,BLOCKNUM,OFFSET,UNDONELSN,VALUE
+func WriteCompensateIntToLog(lm *dblog.LogManager, txNum uint64, blockID dbfile.BlockID, offset int, value int, undoneLSN int) (int, error) {
+	txPos := dbsize.IntSize
+	fileNamePos := txPos + dbsize.Uint64Size
+	blockPos := fileNamePos + dbfile.MaxStringLengthOnPage(len(blockID.FileName()))
+	offsetPos := blockPos + dbsize.IntSize
+	undonePos := offsetPos + dbsize.IntSize
+	valuePos := undonePos + dbsize.IntSize
+	recordLen := valuePos + dbsize.IntSize
+	b := make([]byte, recordLen)
+	page := dbfile.NewPageFromBytes(b)
+	if err := page.SetInt(0, COMPENSATEINT); err != nil {
+		return 0, fmt.Errorf("set COMPENSATEINT operation code at offset 0: %w", err)
+	}
+	if err := page.SetUint64(txPos, txNum); err != nil {
+		return 0, fmt.Errorf("set transaction number %d at offset %d: %w", txNum, txPos, err)
+	}
+	if err := page.SetString(fileNamePos, blockID.FileName()); err != nil {
+		return 0, fmt.Errorf("set block file name %q at offset %d: %w", blockID.FileName(), fileNamePos, err)
+	}
+	if err := page.SetInt(blockPos, blockID.BlockNum()); err != nil {
+		return 0, fmt.Errorf("set block number %d at offset %d: %w", blockID.BlockNum(), blockPos, err)
+	}
+	if err := page.SetInt(offsetPos, offset); err != nil {
+		return 0, fmt.Errorf("set offset %d at offset %d: %w", offset, offsetPos, err)
+	}
+	if err := page.SetInt(undonePos, undoneLSN); err != nil {
+		return 0, fmt.Errorf("set undone LSN %d at offset %d: %w", undoneLSN, undonePos, err)
+	}
+	if err := page.SetInt(valuePos, value); err != nil {
+		return 0, fmt.Errorf("set int value %d at offset %d: %w", value, valuePos, err)
+	}
+	lsn, err := lm.Append(b)
+	if err != nil {
+		return 0, fmt.Errorf("append COMPENSATEINT log record for transaction %d: %w", txNum, err)
+	}
+	return lsn, nil
+}
+
+type compensateStringLogRecord struct {
+	txNum   uint64
+	blockID dbfile.BlockID
+	offset  int
+	value   string
+	// 取り消したSETSTRINGのLSN
+	undone int
+}
+
+func NewCompensateStringLogRecord(page *dbfile.Page) LogRecord {
+	txPos := dbsize.IntSize
+	txNum := page.GetUint64(txPos)
+	fileNamePos := txPos + dbsize.Uint64Size
+	fileName := page.GetString(fileNamePos)
+	blockNumPos := fileNamePos + dbfile.MaxStringLengthOnPage(len(fileName))
+	blockNum := page.GetInt(blockNumPos)
+	blockID := dbfile.NewBlockID(fileName, blockNum)
+	offsetPos := blockNumPos + dbsize.IntSize
+	offset := page.GetInt(offsetPos)
+	undonePos := offsetPos + dbsize.IntSize
+	undone := page.GetInt(undonePos)
+	valuePos := undonePos + dbsize.IntSize
+	value := page.GetString(valuePos)
+	return &compensateStringLogRecord{txNum: txNum, blockID: blockID, offset: offset, value: value, undone: undone}
+}
+
+func (l *compensateStringLogRecord) op() int {
+	return COMPENSATESTRING
+}
+
+func (l *compensateStringLogRecord) txNumber() uint64 {
+	return l.txNum
+}
+
+func (l *compensateStringLogRecord) fileName() string {
+	return l.blockID.FileName()
+}
+
+func (l *compensateStringLogRecord) undoneLSN() int {
+	return l.undone
+}
+
+// CLR自体は取り消さない
+func (l *compensateStringLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+func (l *compensateStringLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	if err := tx.redo(ctx, l.blockID, lsn, func(p *dbfile.Page) error {
+		return p.SetString(l.offset, l.value)
+	}); err != nil {
+		return fmt.Errorf("set string value %q at offset %d in block %s for redo: %w", l.value, l.offset, l.blockID, err)
+	}
+	return nil
+}
+
+func (l *compensateStringLogRecord) String() string {
+	return fmt.Sprintf("{\"kind\": \"compensateString\", \"txNum\": %d, \"blockID\": %s, \"offset\": %d, \"value\": %s, \"undoneLSN\": %d}", l.txNumber(), l.blockID.String(), l.offset, l.value, l.undone)
+}
+
+// COMPENSATESTRING,TXNUM,FILENAME,BLOCKNUM,OFFSET,UNDONELSN,VALUE
+func WriteCompensateStringToLog(lm *dblog.LogManager, txNum uint64, blockID dbfile.BlockID, offset int, value string, undoneLSN int) (int, error) {
+	txPos := dbsize.IntSize
+	fileNamePos := txPos + dbsize.Uint64Size
+	blockPos := fileNamePos + dbfile.MaxStringLengthOnPage(len(blockID.FileName()))
+	offsetPos := blockPos + dbsize.IntSize
+	undonePos := offsetPos + dbsize.IntSize
+	valuePos := undonePos + dbsize.IntSize
+	recordLen := valuePos + dbfile.MaxStringLengthOnPage(len(value))
+	b := make([]byte, recordLen)
+	page := dbfile.NewPageFromBytes(b)
+	if err := page.SetInt(0, COMPENSATESTRING); err != nil {
+		return 0, fmt.Errorf("set COMPENSATESTRING operation code at offset 0: %w", err)
+	}
+	if err := page.SetUint64(txPos, txNum); err != nil {
+		return 0, fmt.Errorf("set transaction number %d at offset %d: %w", txNum, txPos, err)
+	}
+	if err := page.SetString(fileNamePos, blockID.FileName()); err != nil {
+		return 0, fmt.Errorf("set block file name %q at offset %d: %w", blockID.FileName(), fileNamePos, err)
+	}
+	if err := page.SetInt(blockPos, blockID.BlockNum()); err != nil {
+		return 0, fmt.Errorf("set block number %d at offset %d: %w", blockID.BlockNum(), blockPos, err)
+	}
+	if err := page.SetInt(offsetPos, offset); err != nil {
+		return 0, fmt.Errorf("set offset %d at offset %d: %w", offset, offsetPos, err)
+	}
+	if err := page.SetInt(undonePos, undoneLSN); err != nil {
+		return 0, fmt.Errorf("set undone LSN %d at offset %d: %w", undoneLSN, undonePos, err)
+	}
+	if err := page.SetString(valuePos, value); err != nil {
+		return 0, fmt.Errorf("set string value %q at offset %d: %w", value, valuePos, err)
+	}
+	lsn, err := lm.Append(b)
+	if err != nil {
+		return 0, fmt.Errorf("append COMPENSATESTRING log record for transaction %d: %w", txNum, err)
+	}
+	return lsn, nil
+}
+
+// commitしたtransactionがファイルを削除したことを表す. COMMITの直前に書く
+type dropFileLogRecord struct {
+	txNum uint64
+	file  string
+}
+
+func NewDropFileLogRecord(page *dbfile.Page) LogRecord {
+	txPos := dbsize.IntSize
+	txNum := page.GetUint64(txPos)
+	fileNamePos := txPos + dbsize.Uint64Size
+	return &dropFileLogRecord{txNum: txNum, file: page.GetString(fileNamePos)}
+}
+
+func (l *dropFileLogRecord) op() int {
+	return DROPFILE
+}
+
+func (l *dropFileLogRecord) txNumber() uint64 {
+	return l.txNum
+}
+
+func (l *dropFileLogRecord) fileName() string {
+	return l.file
+}
+
+// ファイルはcommit後に削除するので取り消すものはない
+func (l *dropFileLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+// 削除はrecoveryの最後にまとめて行う
+func (l *dropFileLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+func (l *dropFileLogRecord) String() string {
+	return fmt.Sprintf("{\"kind\": \"dropFile\", \"txNum\": %d, \"fileName\": %s}", l.txNumber(), l.file)
+}
+
+// DROPFILE,TXNUM,FILENAME
+func WriteDropFileToLog(lm *dblog.LogManager, txNum uint64, fileName string) (int, error) {
+	txPos := dbsize.IntSize
+	fileNamePos := txPos + dbsize.Uint64Size
+	recordLen := fileNamePos + dbfile.MaxStringLengthOnPage(len(fileName))
+	b := make([]byte, recordLen)
+	page := dbfile.NewPageFromBytes(b)
+	if err := page.SetInt(0, DROPFILE); err != nil {
+		return 0, fmt.Errorf("set DROPFILE operation code at offset 0: %w", err)
+	}
+	if err := page.SetUint64(txPos, txNum); err != nil {
+		return 0, fmt.Errorf("set transaction number %d at offset %d: %w", txNum, txPos, err)
+	}
+	if err := page.SetString(fileNamePos, fileName); err != nil {
+		return 0, fmt.Errorf("set file name %q at offset %d: %w", fileName, fileNamePos, err)
+	}
+	lsn, err := lm.Append(b)
+	if err != nil {
+		return 0, fmt.Errorf("append DROPFILE log record for transaction %d: %w", txNum, err)
+	}
+	return lsn, nil
+}
+
+type fullPageLogRecord struct {
+	txNum   uint64
+	blockID dbfile.BlockID
+	offset  int
+	image   []byte
+}
+
+func NewFullPageLogRecord(page *dbfile.Page) LogRecord {
+	txPos := dbsize.IntSize
+	txNum := page.GetUint64(txPos)
+	fileNamePos := txPos + dbsize.Uint64Size
+	fileName := page.GetString(fileNamePos)
+	blockNumPos := fileNamePos + dbfile.MaxStringLengthOnPage(len(fileName))
+	blockNum := page.GetInt(blockNumPos)
+	offsetPos := blockNumPos + dbsize.IntSize
+	offset := page.GetInt(offsetPos)
+	imagePos := offsetPos + dbsize.IntSize
+	return &fullPageLogRecord{txNum: txNum, blockID: dbfile.NewBlockID(fileName, blockNum), offset: offset, image: page.GetBytes(imagePos)}
+}
+
+func (l *fullPageLogRecord) op() int {
+	return FULLPAGE
+}
+
+func (l *fullPageLogRecord) txNumber() uint64 {
+	return l.txNum
+}
+
+func (l *fullPageLogRecord) fileName() string {
+	return l.blockID.FileName()
+}
+
+// 内容は変えていないので取り消すものはない
+func (l *fullPageLogRecord) undo(ctx context.Context, tx *Transaction, lsn int) error {
+	return nil
+}
+
+func (l *fullPageLogRecord) redo(ctx context.Context, tx *Transaction, lsn int) error {
+	if err := tx.redoImage(ctx, l.blockID, lsn, l.offset, l.image); err != nil {
+		return fmt.Errorf("restore %d bytes at offset %d in block %s for redo: %w", len(l.image), l.offset, l.blockID, err)
+	}
+	return nil
+}
+
+func (l *fullPageLogRecord) String() string {
+	return fmt.Sprintf("{\"kind\": \"fullPage\", \"txNum\": %d, \"blockID\": %s, \"offset\": %d, \"length\": %d}", l.txNumber(), l.blockID.String(), l.offset, len(l.image))
+}
+
+// pの内容をfullPageChunks個のFULLPAGE recordに分けて書く
+func WriteFullPageToLog(lm *dblog.LogManager, txNum uint64, blockID dbfile.BlockID, p *dbfile.Page) error {
+	chunkSize := (p.Length() + fullPageChunks - 1) / fullPageChunks
+	for offset := 0; offset < p.Length(); offset += chunkSize {
+		image := p.RawBytes(offset, min(chunkSize, p.Length()-offset))
+		if _, err := writeFullPageChunkToLog(lm, txNum, blockID, offset, image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FULLPAGE,TXNUM,FILENAME,BLOCKNUM,OFFSET,IMAGE
+func writeFullPageChunkToLog(lm *dblog.LogManager, txNum uint64, blockID dbfile.BlockID, offset int, image []byte) (int, error) {
+	txPos := dbsize.IntSize
+	fileNamePos := txPos + dbsize.Uint64Size
+	blockPos := fileNamePos + dbfile.MaxStringLengthOnPage(len(blockID.FileName()))
+	offsetPos := blockPos + dbsize.IntSize
+	imagePos := offsetPos + dbsize.IntSize
+	recordLen := imagePos + dbsize.IntSize + len(image)
+	b := make([]byte, recordLen)
+	page := dbfile.NewPageFromBytes(b)
+	if err := page.SetInt(0, FULLPAGE); err != nil {
+		return 0, fmt.Errorf("set FULLPAGE operation code at offset 0: %w", err)
+	}
+	if err := page.SetUint64(txPos, txNum); err != nil {
+		return 0, fmt.Errorf("set transaction number %d at offset %d: %w", txNum, txPos, err)
+	}
+	if err := page.SetString(fileNamePos, blockID.FileName()); err != nil {
+		return 0, fmt.Errorf("set block file name %q at offset %d: %w", blockID.FileName(), fileNamePos, err)
+	}
+	if err := page.SetInt(blockPos, blockID.BlockNum()); err != nil {
+		return 0, fmt.Errorf("set block number %d at offset %d: %w", blockID.BlockNum(), blockPos, err)
+	}
+	if err := page.SetInt(offsetPos, offset); err != nil {
+		return 0, fmt.Errorf("set offset %d at offset %d: %w", offset, offsetPos, err)
+	}
+	if err := page.SetBytes(imagePos, image); err != nil {
+		return 0, fmt.Errorf("set %d bytes of page image at offset %d: %w", len(image), imagePos, err)
+	}
+	lsn, err := lm.Append(b)
+	if err != nil {
+		return 0, fmt.Errorf("append FULLPAGE log record for transaction %d: %w", txNum, err)
+	}
+	return lsn, nil
+}
@@ -3,6 +3,7 @@ package dbtx
 import (
 	"context"
 	"fmt"
+	"math"
 
 	"github.com/teru01/simpledb-go/dbbuffer"
 	"github.com/teru01/simpledb-go/dblog"
@@ -10,84 +11,148 @@ import (
 )
 
 // 個々のtxが独立したインスタンスを持つ
+// page LSNを使ったredo/undo logging(ARIES)でrecoveryする
 type RecoveryManager struct {
-	logManager     *dblog.LogManager
-	bufferManager  *dbbuffer.BufferManager
-	tx             *Transaction
-	txNum          uint64
+	logManager    *dblog.LogManager
+	bufferManager *dbbuffer.BufferManager
+	tx            *Transaction
+	txNum         uint64
+	// このtransactionのSTART recordのLSN
+	startLSN       int
 	pendingRecords []dbraft.WALRecord
 }
 
 func NewRecoveryManager(tx *Transaction, txNum uint64, logManager *dblog.LogManager, bufferManager *dbbuffer.BufferManager) (*RecoveryManager, error) {
 	rm := &RecoveryManager{tx: tx, txNum: txNum, logManager: logManager, bufferManager: bufferManager}
-	_, err := WriteStartToLog(logManager, txNum)
+	lsn, err := rm.logManager.StartTx(txNum, func() (int, error) {
+		return WriteStartToLog(logManager, txNum)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("write start record to log for transaction %d: %w", txNum, err)
 	}
+	rm.startLSN = lsn
 	return rm, nil
 }
 
-// バッファの変更が先にディスクに乗る(このwrite自体はWALが守られている）
-// undo only recovery専用のcommit実装になっている: logにcommitがあるけど実際にはディスクに乗ってない、ケースは発生しない
-func (rm *RecoveryManager) Commit() error {
-	if err := rm.bufferManager.FlushAll(rm.txNum); err != nil {
-		return fmt.Errorf("flush all buffers for transaction %d: %w", rm.txNum, err)
+// logだけをflushする. bufferの変更はディスクに乗っていなくてもrecovery時にlogからredoできる
+// logに残していない変更だけはredoできないので先に書き出す
+func (rm *RecoveryManager) Commit(droppedFiles []string) error {
+	if err := rm.bufferManager.FlushUnlogged(rm.txNum); err != nil {
+		return fmt.Errorf("flush unlogged buffers for transaction %d: %w", rm.txNum, err)
+	}
+	for _, fileName := range droppedFiles {
+		if _, err := WriteDropFileToLog(rm.logManager, rm.txNum, fileName); err != nil {
+			return fmt.Errorf("write drop file record to log for %q: %w", fileName, err)
+		}
 	}
-	lsn, err := WriteCommitToLog(rm.logManager, rm.txNum)
+	lsn, err := rm.logManager.FinishTx(rm.txNum, func() (int, error) {
+		return WriteCommitToLog(rm.logManager, rm.txNum)
+	})
 	if err != nil {
 		return fmt.Errorf("write commit record to log for transaction %d: %w", rm.txNum, err)
 	}
-	if err := rm.logManager.FlushWithLSN(lsn); err != nil {
+	// 同時にcommitする他のtransactionとまとめてflushする
+	if err := rm.logManager.GroupFlush(lsn); err != nil {
 		return fmt.Errorf("flush log with LSN %d: %w", lsn, err)
 	}
 	return nil
 }
 
+// 変更を取り消し, 取り消すごとにCLRを残す
+// logはflushしない. ROLLBACKがディスクに乗る前にcrashしてもrecoveryで残りが取り消される
 func (rm *RecoveryManager) Rollback(ctx context.Context) error {
-	if err := rm.doRollback(ctx); err != nil {
+	if err := rm.undo(ctx, map[uint64]int{rm.txNum: rm.startLSN}); err != nil {
 		return fmt.Errorf("rollback transaction %d: %w", rm.txNum, err)
 	}
-	if err := rm.bufferManager.FlushAll(rm.txNum); err != nil {
-		return fmt.Errorf("flush all buffers for transaction %d: %w", rm.txNum, err)
-	}
-	lsn, err := WriteRollbackToLog(rm.logManager, rm.txNum)
-	if err != nil {
-		return fmt.Errorf("write rollback record to log for transaction %d: %w", rm.txNum, err)
-	}
-	if err := rm.logManager.FlushWithLSN(lsn); err != nil {
-		return fmt.Errorf("flush log with LSN %d: %w", lsn, err)
-	}
 	return nil
 }
 
-func (rm *RecoveryManager) doRollback(ctx context.Context) error {
-	it, err := rm.logManager.Iterator()
+// losers(txNumからSTART recordのLSNへのmap)の変更を新しい順に取り消し, STARTまで戻ったらROLLBACKを書く
+// CLRで取り消し済みの変更は飛ばすので, 途中でcrashしたrollbackの続きから取り消せる
+func (rm *RecoveryManager) undo(ctx context.Context, losers map[uint64]int) error {
+	if len(losers) == 0 {
+		return nil
+	}
+	// transactionごとの, CLRで取り消し済みの最も古い変更のLSN
+	undone := make(map[uint64]int)
+	it, err := rm.logManager.RecordIterator()
 	if err != nil {
-		return fmt.Errorf("get log iterator for transaction %d: %w", rm.txNum, err)
+		return fmt.Errorf("get log iterator: %w", err)
 	}
-	for record, err := range it {
+	for rec, err := range it {
 		if err != nil {
 			return fmt.Errorf("get next log record: %w", err)
 		}
-		record := NewLogRecord(record)
-		if record.txNumber() == rm.txNum {
-			if record.op() == START {
+		record := NewLogRecord(rec.Data)
+		txNum := record.txNumber()
+		startLSN, ok := losers[txNum]
+		if !ok {
+			continue
+		}
+		if record.op() == START {
+			if rec.LSN != startLSN {
+				continue
+			}
+			if err := rm.writeRollback(txNum, startLSN); err != nil {
+				return fmt.Errorf("write rollback record to log for transaction %d: %w", txNum, err)
+			}
+			delete(losers, txNum)
+			if len(losers) == 0 {
 				return nil
 			}
-			if err := record.undo(ctx, rm.tx); err != nil {
-				return fmt.Errorf("undo log record for transaction %d: %w", rm.txNum, err)
+			continue
+		}
+		// 新しい順に読むので, 最初に見つけたCLRが最も古い変更を取り消している
+		if clr, ok := record.(compensationLogRecord); ok {
+			if _, ok := undone[txNum]; !ok {
+				undone[txNum] = clr.undoneLSN()
 			}
+			continue
+		}
+		if lsn, ok := undone[txNum]; ok && rec.LSN >= lsn {
+			continue
+		}
+		if err := record.undo(ctx, rm.tx, rec.LSN); err != nil {
+			return fmt.Errorf("undo log record for transaction %d: %w", txNum, err)
 		}
 	}
 	return nil
 }
 
+// recoveryで取り消す前回のtransactionはtxNumが同じでも実行中の表には載っていない
+func (rm *RecoveryManager) writeRollback(txNum uint64, startLSN int) error {
+	write := func() (int, error) {
+		return WriteRollbackToLog(rm.logManager, txNum)
+	}
+	var err error
+	if txNum == rm.txNum && startLSN == rm.startLSN {
+		_, err = rm.logManager.FinishTx(txNum, write)
+	} else {
+		_, err = write()
+	}
+	return err
+}
+
+// recovery中は他のtransactionがないので, 終わったら前回までのlogは全て捨てる
 func (rm *RecoveryManager) Recover(ctx context.Context) error {
-	if err := rm.doRecover(ctx); err != nil {
+	return rm.recover(ctx, math.MaxInt)
+}
+
+// backupのデータファイルに対してrecoveryを行う
+// データファイルはLSNがcheckpointLSNのcheckpointの時点のものなので, それより後のcheckpointは使わずにそこからredoする
+func (rm *RecoveryManager) RecoverFromBackup(ctx context.Context, checkpointLSN int) error {
+	return rm.recover(ctx, checkpointLSN)
+}
+
+func (rm *RecoveryManager) recover(ctx context.Context, checkpointLSN int) error {
+	if err := rm.doRecover(ctx, checkpointLSN); err != nil {
 		return fmt.Errorf("recover transaction %d: %w", rm.txNum, err)
 	}
-	if err := rm.bufferManager.FlushAll(rm.txNum); err != nil {
-		return fmt.Errorf("flush all buffers for transaction %d: %w", rm.txNum, err)
+	// 以降の変更で, recoveryで変更したpageのimageも残す
+	rm.logManager.BeginCheckpoint()
+	// redo中の置き換えで書き出したblockもfsyncしてからlogを捨てる
+	if err := rm.bufferManager.FlushModified(); err != nil {
+		return fmt.Errorf("flush modified buffers for transaction %d: %w", rm.txNum, err)
 	}
 	lsn, err := WriteCheckpointToLog(rm.logManager)
 	if err != nil {
@@ -96,33 +161,164 @@ func (rm *RecoveryManager) Recover(ctx context.Context) error {
 	if err := rm.logManager.FlushWithLSN(lsn); err != nil {
 		return fmt.Errorf("flush log with LSN %d: %w", lsn, err)
 	}
+	// recoveryを行うtransaction自身のrollbackに必要なSTARTは残す
+	if _, err := rm.logManager.Truncate(rm.startLSN); err != nil {
+		return fmt.Errorf("truncate log before LSN %d: %w", rm.startLSN, err)
+	}
 	return nil
 }
 
-func (rm *RecoveryManager) doRecover(ctx context.Context) error {
-	finishedTxNum := make(map[uint64]struct{}, 0)
-	it, err := rm.logManager.Iterator()
+// analysisの結果
+type recoveryState struct {
+	// redoを始めるLSN. checkpointがない場合は0
+	redoLSN int
+	// 完了していないtransactionのtxNumとSTART recordのLSN
+	losers map[uint64]int
+	// commitされたファイル削除の最後のLSN
+	droppedFiles map[string]int
+	// ファイルごとの最後の変更のLSN
+	lastWrites map[string]int
+}
+
+// analysis, redo, undoの順に行う
+// redoではcommitされたかどうかに関わらずcheckpoint以降の変更を全て反映し直し, undoで完了していないtransactionの変更を取り消す
+func (rm *RecoveryManager) doRecover(ctx context.Context, checkpointLSN int) error {
+	state, err := rm.analyze(checkpointLSN)
 	if err != nil {
-		return fmt.Errorf("get log iterator: %w", err)
+		return fmt.Errorf("analyze log: %w", err)
+	}
+	if err := rm.redo(ctx, state); err != nil {
+		return fmt.Errorf("redo log: %w", err)
+	}
+	if err := rm.undo(ctx, state.losers); err != nil {
+		return fmt.Errorf("undo log: %w", err)
 	}
-	for record, err := range it {
+	// commit後, ファイルを削除する前にcrashした場合
+	var files []string
+	for fileName, lsn := range state.droppedFiles {
+		if state.lastWrites[fileName] < lsn {
+			files = append(files, fileName)
+		}
+	}
+	if err := rm.tx.removeFiles(files); err != nil {
+		return fmt.Errorf("remove dropped files: %w", err)
+	}
+	return nil
+}
+
+// 最後のcheckpointまで遡り, 完了していないtransactionと削除されたファイルを調べる
+// NQCKPTの場合は, 記録された実行中のtransactionも完了していなければ取り消す対象にし, redoを始めるLSNまで遡る
+// LSNがcheckpointLSNより後のcheckpointは無視する
+func (rm *RecoveryManager) analyze(checkpointLSN int) (*recoveryState, error) {
+	state := &recoveryState{
+		losers:       make(map[uint64]int),
+		droppedFiles: make(map[string]int),
+		lastWrites:   make(map[string]int),
+	}
+	finished := make(map[uint64]struct{})
+	committed := make(map[uint64]struct{})
+	foundNQCheckpoint := false
+	it, err := rm.logManager.RecordIterator()
+	if err != nil {
+		return nil, fmt.Errorf("get log iterator: %w", err)
+	}
+	for rec, err := range it {
+		if err != nil {
+			return nil, fmt.Errorf("get next log record: %w", err)
+		}
+		if foundNQCheckpoint && rec.LSN < state.redoLSN {
+			return state, nil
+		}
+		if rec.LSN == rm.startLSN {
+			// txNumは起動ごとに振り直すので, recoveryを行うtransaction自身のSTARTはLSNで区別する
+			continue
+		}
+		record := NewLogRecord(rec.Data)
+		txNum := record.txNumber()
+		if op := record.op(); (op == CHECKPOINT || op == NQCHECKPOINT) && rec.LSN > checkpointLSN {
+			continue
+		}
+		switch record.op() {
+		case CHECKPOINT:
+			state.redoLSN = rec.LSN
+			return state, nil
+		case NQCHECKPOINT:
+			if foundNQCheckpoint {
+				continue
+			}
+			foundNQCheckpoint = true
+			ckpt := record.(*nqCheckpointLogRecord)
+			state.redoLSN = ckpt.redoLSN
+			for txNum, startLSN := range ckpt.activeTxs {
+				if _, ok := finished[txNum]; !ok {
+					state.losers[txNum] = startLSN
+				}
+			}
+		case COMMIT:
+			finished[txNum] = struct{}{}
+			committed[txNum] = struct{}{}
+		case ROLLBACK:
+			finished[txNum] = struct{}{}
+		case START:
+			if _, ok := finished[txNum]; !ok {
+				// 遡って最後に見つかったものが最初のSTART
+				state.losers[txNum] = rec.LSN
+			}
+		case DROPFILE:
+			fileName := record.(fileLogRecord).fileName()
+			if _, ok := committed[txNum]; !ok {
+				continue
+			}
+			if _, ok := state.droppedFiles[fileName]; !ok {
+				state.droppedFiles[fileName] = rec.LSN
+			}
+		default:
+			if r, ok := record.(fileLogRecord); ok {
+				if _, ok := state.lastWrites[r.fileName()]; !ok {
+					state.lastWrites[r.fileName()] = rec.LSN
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+// checkpoint以降の変更をLSNの順に反映し直す. 削除されたファイルへの削除前の変更は飛ばす
+func (rm *RecoveryManager) redo(ctx context.Context, state *recoveryState) error {
+	it, err := rm.logManager.ForwardIterator(state.redoLSN)
+	if err != nil {
+		return fmt.Errorf("get forward log iterator: %w", err)
+	}
+	for rec, err := range it {
 		if err != nil {
 			return fmt.Errorf("get next log record: %w", err)
 		}
-		record := NewLogRecord(record)
-		if record.op() == CHECKPOINT {
-			return nil
-		} else if record.op() == COMMIT || record.op() == ROLLBACK {
-			finishedTxNum[record.txNumber()] = struct{}{}
-		} else if _, ok := finishedTxNum[record.txNumber()]; !ok {
-			if err := record.undo(ctx, rm.tx); err != nil {
-				return fmt.Errorf("undo log record for transaction %d: %w", record.txNumber(), err)
+		record := NewLogRecord(rec.Data)
+		if r, ok := record.(fileLogRecord); ok {
+			if lsn, ok := state.droppedFiles[r.fileName()]; ok && rec.LSN < lsn {
+				continue
 			}
 		}
+		if err := record.redo(ctx, rm.tx, rec.LSN); err != nil {
+			return fmt.Errorf("redo log record for transaction %d: %w", record.txNumber(), err)
+		}
 	}
 	return nil
 }
 
+// bufの変更をlogでlogに書く. checkpoint以降初めての変更なら, 先に変更前のpageの内容をlogに残す
+// 変更を書き出している途中でcrashしても, redoでpageを復元できる
+func (rm *RecoveryManager) logPageChange(buf *dbbuffer.Buffer, log func() (int, error)) (int, error) {
+	return rm.logManager.LogPageChange(buf.Contents().LSN(), func(image bool) (int, error) {
+		if image {
+			if err := WriteFullPageToLog(rm.logManager, rm.txNum, buf.BlockID(), buf.Contents()); err != nil {
+				return 0, fmt.Errorf("write full page image of block %s: %w", buf.BlockID(), err)
+			}
+		}
+		return log()
+	})
+}
+
 func (rm *RecoveryManager) SetInt(buf *dbbuffer.Buffer, offset, val int) (int, error) {
 	oldVal := buf.Contents().GetInt(offset)
 	blk := buf.BlockID()
@@ -0,0 +1,96 @@
+package dbtx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teru01/simpledb-go/dblog"
+)
+
+// point-in-time recoveryの復元先. どちらも指定しない場合はarchiveされたlogを全て反映する
+type RecoveryTarget struct {
+	// LSNがこれ以下のlog recordまで反映する. 0なら指定しない
+	LSN int
+	// この時刻までにcommitしたtransactionまで反映する. ゼロ値なら指定しない
+	Time time.Time
+}
+
+func (t RecoveryTarget) String() string {
+	switch {
+	case t.LSN > 0:
+		return fmt.Sprintf("LSN %d", t.LSN)
+	case !t.Time.IsZero():
+		return fmt.Sprintf("TIMESTAMP %s", t.Time.Format(time.RFC3339))
+	default:
+		return "LATEST"
+	}
+}
+
+// 最後のcheckpointのLSN. checkpointがない場合は0
+func LastCheckpointLSN(lm *dblog.LogManager) (int, error) {
+	it, err := lm.RecordIterator()
+	if err != nil {
+		return 0, fmt.Errorf("get log iterator: %w", err)
+	}
+	for rec, err := range it {
+		if err != nil {
+			return 0, fmt.Errorf("get next log record: %w", err)
+		}
+		if op := NewLogRecord(rec.Data).op(); op == CHECKPOINT || op == NQCHECKPOINT {
+			return rec.LSN, nil
+		}
+	}
+	return 0, nil
+}
+
+// base backupのlogにarchiveDirのsegmentを繋げ, targetより後のlogを捨てる. 復元先のLSNを返す
+// この後RecoverFromBackupでbase backupのcheckpointからredoし, 復元先までにcommitしていないtransactionを取り消す
+func RestoreArchivedLog(lm *dblog.LogManager, archiveDir string, target RecoveryTarget) (int, error) {
+	// base backupのデータファイルにはこのLSNまでの変更が乗っている可能性がある
+	baseLSN := lm.LatestLSN()
+	if target.LSN > 0 && target.LSN < baseLSN {
+		return 0, fmt.Errorf("recovery target %s is before the end of the base backup (LSN %d)", target, baseLSN)
+	}
+	if _, err := lm.RestoreArchive(archiveDir); err != nil {
+		return 0, fmt.Errorf("restore archived log from %s: %w", archiveDir, err)
+	}
+	lsn, err := targetLSN(lm, target)
+	if err != nil {
+		return 0, err
+	}
+	if lsn < baseLSN {
+		return 0, fmt.Errorf("recovery target %s is before the end of the base backup (LSN %d)", target, baseLSN)
+	}
+	if err := lm.DiscardAfter(lsn); err != nil {
+		return 0, fmt.Errorf("discard log after LSN %d: %w", lsn, err)
+	}
+	return lsn, nil
+}
+
+// 復元先の最後のlog recordのLSN
+// 時刻の場合は, それより後にcommitした最初のtransactionのCOMMITの直前まで
+func targetLSN(lm *dblog.LogManager, target RecoveryTarget) (int, error) {
+	latest := lm.LatestLSN()
+	if target.LSN > 0 {
+		if target.LSN > latest {
+			return 0, fmt.Errorf("recovery target LSN %d is beyond the end of the archived log (LSN %d)", target.LSN, latest)
+		}
+		return target.LSN, nil
+	}
+	if target.Time.IsZero() {
+		return latest, nil
+	}
+	it, err := lm.ForwardIterator(0)
+	if err != nil {
+		return 0, fmt.Errorf("get forward log iterator: %w", err)
+	}
+	for rec, err := range it {
+		if err != nil {
+			return 0, fmt.Errorf("get next log record: %w", err)
+		}
+		if commit, ok := NewLogRecord(rec.Data).(*commitLogRecord); ok && commit.committedAt.After(target.Time) {
+			return rec.LSN - 1, nil
+		}
+	}
+	return latest, nil
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"sync/atomic"
+	"slices"
+	"strings"
 
 	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dberr"
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dblog"
 	"github.com/teru01/simpledb-go/dbraft"
@@ -15,6 +17,9 @@ import (
 
 const EndOfFile = -1
 
+// ファイル全体のlockに使うblock番号. ファイルを使うtransactionはSLockを, DROPはXLockをとる
+const wholeFile = -2
+
 var ErrNotLeader = errors.New("writes are only allowed on the leader node")
 
 func (t *Transaction) checkWritable() error {
@@ -24,8 +29,6 @@ func (t *Transaction) checkWritable() error {
 	return nil
 }
 
-var nextTxNum atomic.Uint64
-
 type Transaction struct {
 	recoveryManager    *RecoveryManager
 	concurrencyManager *ConcurrencyManager
@@ -37,7 +40,14 @@ type Transaction struct {
 }
 
 type transactionState struct {
-	txNum uint64
+	txNum          uint64
+	isolationLevel IsolationLevel
+	// snapshot isolationで実行する場合のみnilでない
+	snapshot *dbbuffer.Snapshot
+	// DROPされたファイル. rollbackで戻せるようにcommit後に削除する
+	droppedFiles []string
+	// このtransactionが作った一時ファイル. commitでもrollbackでも削除する
+	tempFiles []string
 }
 
 type TxOption func(*Transaction)
@@ -48,19 +58,37 @@ func WithRaftNode(rn *dbraft.RaftNode) TxOption {
 	}
 }
 
+// 指定しない場合はIsolationSerializable
+func WithIsolationLevel(level IsolationLevel) TxOption {
+	return func(tx *Transaction) {
+		tx.state.isolationLevel = level
+	}
+}
+
+// MVCCでtransactionを実行する.
+// 読み込みはlockを取らずに開始時点のsnapshotを読み, 書き込みの競合はfirst-committer-winsで検出する
+func WithSnapshotIsolation() TxOption {
+	return WithIsolationLevel(IsolationSnapshot)
+}
+
 func NewTransaction(fm *dbfile.FileManager, lm *dblog.LogManager, bm *dbbuffer.BufferManager, opts ...TxOption) (*Transaction, error) {
+	txNum := lm.NextTxNum()
 	tx := &Transaction{
-		concurrencyManager: NewConcurrencyManager(),
-		bufferManager:      bm,
-		fileManager:        fm,
-		myBufferList:       NewBufferList(bm),
+		bufferManager: bm,
+		fileManager:   fm,
+		myBufferList:  NewBufferList(bm),
 		state: transactionState{
-			txNum: NextTxNum(),
+			txNum: txNum,
 		},
 	}
 	for _, opt := range opts {
 		opt(tx)
 	}
+	tx.concurrencyManager = NewConcurrencyManager(bm.LockTable(), txNum, tx.state.isolationLevel)
+	if tx.state.isolationLevel == IsolationSnapshot {
+		snap := bm.Versions().Begin(txNum)
+		tx.state.snapshot = &snap
+	}
 	var err error
 	tx.recoveryManager, err = NewRecoveryManager(tx, tx.state.txNum, lm, bm)
 	if err != nil {
@@ -73,8 +101,13 @@ func (t *Transaction) TxNum() uint64 {
 	return t.state.txNum
 }
 
+func (t *Transaction) IsolationLevel() IsolationLevel {
+	return t.state.isolationLevel
+}
+
 func (t *Transaction) Commit() error {
 	defer t.concurrencyManager.Release()
+	defer t.bufferManager.Versions().Finish(t.state.txNum)
 	if t.raftNode != nil && len(t.recoveryManager.PendingRecords()) > 0 {
 		// tx内の全ての操作をまとめてencodeし、1つのraft logとする
 		cmd := &dbraft.Command{
@@ -88,25 +121,66 @@ func (t *Transaction) Commit() error {
 		if err := t.raftNode.Apply(data); err != nil {
 			if rbErr := t.recoveryManager.Rollback(context.Background()); rbErr != nil {
 				slog.Error("rollback after raft apply failure", "txNum", t.state.txNum, "err", rbErr)
+			} else {
+				t.bufferManager.Versions().Rollback(t.state.txNum)
 			}
 			t.myBufferList.UnpinAll()
 			return fmt.Errorf("raft apply for transaction %d: %w", t.state.txNum, err)
 		}
 	}
-	if err := t.recoveryManager.Commit(); err != nil {
+	if err := t.recoveryManager.Commit(t.state.droppedFiles); err != nil {
 		return fmt.Errorf("commit transaction %d: %w", t.state.txNum, err)
 	}
+	// lockを解放する前に, 後から書き込むtransactionが競合を検出できるようにする
+	t.bufferManager.Versions().Commit(t.state.txNum)
 	t.myBufferList.UnpinAll()
+	if err := t.removeDroppedFiles(); err != nil {
+		return fmt.Errorf("remove dropped files of transaction %d: %w", t.state.txNum, err)
+	}
+	if err := t.removeTempFiles(); err != nil {
+		return fmt.Errorf("remove temp files of transaction %d: %w", t.state.txNum, err)
+	}
 	slog.Debug("transaction committed", slog.Uint64("txnum", t.state.txNum))
 	return nil
 }
 
+// lockを解放する前に削除し、他のtransactionから削除途中のファイルが見えないようにする
+func (t *Transaction) removeDroppedFiles() error {
+	droppedFiles := t.state.droppedFiles
+	t.state.droppedFiles = nil
+	return t.removeFiles(droppedFiles)
+}
+
+func (t *Transaction) removeTempFiles() error {
+	tempFiles := t.state.tempFiles
+	t.state.tempFiles = nil
+	return t.removeFiles(tempFiles)
+}
+
+func (t *Transaction) removeFiles(fileNames []string) error {
+	for _, fileName := range fileNames {
+		if err := t.bufferManager.DiscardFile(fileName); err != nil {
+			return fmt.Errorf("discard buffers for %q: %w", fileName, err)
+		}
+		if err := t.fileManager.Remove(fileName); err != nil {
+			return fmt.Errorf("remove %q: %w", fileName, err)
+		}
+	}
+	return nil
+}
+
 func (t *Transaction) Rollback(ctx context.Context) error {
 	defer t.concurrencyManager.Release()
+	defer t.bufferManager.Versions().Finish(t.state.txNum)
+	t.state.droppedFiles = nil
 	if err := t.recoveryManager.Rollback(ctx); err != nil {
 		return fmt.Errorf("rollback transaction %d: %w", t.state.txNum, err)
 	}
+	t.bufferManager.Versions().Rollback(t.state.txNum)
 	t.myBufferList.UnpinAll()
+	if err := t.removeTempFiles(); err != nil {
+		return fmt.Errorf("remove temp files of transaction %d: %w", t.state.txNum, err)
+	}
 	slog.Debug("transaction rollback", slog.Uint64("txnum", t.state.txNum))
 	return nil
 }
@@ -121,6 +195,17 @@ func (t *Transaction) Recover(ctx context.Context) error {
 	return nil
 }
 
+// LSNがcheckpointLSNのcheckpointの時点のbackupに対してrecoveryを行う
+func (t *Transaction) RecoverFromBackup(ctx context.Context, checkpointLSN int) error {
+	if err := t.bufferManager.FlushAll(t.state.txNum); err != nil {
+		return fmt.Errorf("flush all buffers before recovery: %w", err)
+	}
+	if err := t.recoveryManager.RecoverFromBackup(ctx, checkpointLSN); err != nil {
+		return fmt.Errorf("recover transaction %d from backup at checkpoint LSN %d: %w", t.state.txNum, checkpointLSN, err)
+	}
+	return nil
+}
+
 func (t *Transaction) Pin(ctx context.Context, blk dbfile.BlockID) error {
 	return t.myBufferList.Pin(ctx, blk, false)
 }
@@ -129,61 +214,143 @@ func (t *Transaction) PinPermanent(ctx context.Context, blk dbfile.BlockID) erro
 	return t.myBufferList.Pin(ctx, blk, true)
 }
 
+// blockを読み込むbufferをsに従って選んでpinする. 順に読むscanで使う
+func (t *Transaction) PinWithStrategy(ctx context.Context, blk dbfile.BlockID, s *dbbuffer.AccessStrategy) error {
+	return t.myBufferList.PinWithStrategy(ctx, blk, s)
+}
+
+// blkから続くn個のblockをまとめてbuffer poolに読んでおく. pinはしないので, 読む時は改めてpinする
+func (t *Transaction) Prefetch(blk dbfile.BlockID, n int, s *dbbuffer.AccessStrategy) error {
+	if err := t.bufferManager.Prefetch(blk, n, s); err != nil {
+		return fmt.Errorf("prefetch %d blocks from %s: %w", n, blk, err)
+	}
+	return nil
+}
+
+// numBlocksのファイルを順に読むscanのためのstrategy
+func (t *Transaction) SequentialStrategy(numBlocks int) *dbbuffer.AccessStrategy {
+	return t.bufferManager.SequentialStrategy(numBlocks)
+}
+
 func (t *Transaction) UnPin(blk dbfile.BlockID) error {
 	return t.myBufferList.UnPin(blk)
 }
 
 func (t *Transaction) GetInt(ctx context.Context, blk dbfile.BlockID, offset int) (int, error) {
-	if err := t.concurrencyManager.SLock(ctx, blk); err != nil {
-		return 0, fmt.Errorf("acquire shared lock on block %s: %w", blk, err)
-	}
-	buf, err := t.myBufferList.Buffer(blk)
-	if err != nil {
-		return 0, fmt.Errorf("get buffer for block %s: %w", blk, err)
-	}
-	return buf.Contents().GetInt(offset), nil
+	var val int
+	err := t.read(ctx, blk, func(p *dbfile.Page) {
+		val = p.GetInt(offset)
+	})
+	return val, err
 }
 
 // valを指定のblock/offsetに書き込む
 // あくまでbuffer上でメモリに乗せるだけ。disk書き込みはまだ
 func (t *Transaction) SetInt(ctx context.Context, blk dbfile.BlockID, offset, val int, okToLog bool) error {
-	if err := t.checkWritable(); err != nil {
-		return err
-	}
-	if err := t.concurrencyManager.XLock(ctx, blk); err != nil {
-		return fmt.Errorf("acquire exclusive lock on block %s: %w", blk, err)
-	}
-	buf, err := t.myBufferList.Buffer(blk)
-	if err != nil {
-		return fmt.Errorf("get buffer for block %s (buffer may not be pinned): %w", blk, err)
-	}
-	lsn := -1
+	var log func(buf *dbbuffer.Buffer) (int, error)
 	if okToLog {
-		lsn, err = t.recoveryManager.SetInt(buf, offset, val)
+		log = func(buf *dbbuffer.Buffer) (int, error) {
+			return t.recoveryManager.SetInt(buf, offset, val)
+		}
 	}
-	page := buf.Contents()
-	if err := page.SetInt(offset, val); err != nil {
+	if err := t.write(ctx, blk, log, func(p *dbfile.Page) error {
+		return p.SetInt(offset, val)
+	}); err != nil {
 		return fmt.Errorf("set int value %d at offset %d in block %s: %w", val, offset, blk, err)
 	}
-	buf.SetModified(t.state.txNum, lsn)
 	return nil
 }
 
 func (t *Transaction) GetString(ctx context.Context, blk dbfile.BlockID, offset int) (string, error) {
-	if err := t.concurrencyManager.SLock(ctx, blk); err != nil {
-		return "", fmt.Errorf("acquire shared lock on block %s: %w", blk, err)
+	var val string
+	err := t.read(ctx, blk, func(p *dbfile.Page) {
+		val = p.GetString(offset)
+	})
+	return val, err
+}
+
+// snapshot isolationではlockを取らずにsnapshotから見えるversionを読む
+func (t *Transaction) read(ctx context.Context, blk dbfile.BlockID, read func(p *dbfile.Page)) error {
+	if err := t.concurrencyManager.ReadLock(ctx, blk); err != nil {
+		return fmt.Errorf("acquire shared lock on block %s: %w", blk, err)
 	}
+	defer t.concurrencyManager.ReadDone(blk)
 	buf, err := t.myBufferList.Buffer(blk)
 	if err != nil {
-		return "", fmt.Errorf("get buffer for block %s: %w", blk, err)
+		return fmt.Errorf("get buffer for block %s: %w", blk, err)
 	}
-	return buf.Contents().GetString(offset), nil
+	// READ UNCOMMITTEDやsnapshot isolationではlockを取らないので, 書き込み途中のpageを読まないようにlatchを取る
+	buf.Latch()
+	defer buf.Unlatch()
+	if t.state.snapshot == nil {
+		read(buf.Contents())
+		return nil
+	}
+	t.bufferManager.Versions().Read(*t.state.snapshot, blk, buf.Contents(), read)
+	return nil
 }
 
 func (t *Transaction) SetString(ctx context.Context, blk dbfile.BlockID, offset int, val string, okToLog bool) error {
-	if err := t.checkWritable(); err != nil {
+	var log func(buf *dbbuffer.Buffer) (int, error)
+	if okToLog {
+		log = func(buf *dbbuffer.Buffer) (int, error) {
+			return t.recoveryManager.SetString(buf, offset, val)
+		}
+	}
+	if err := t.write(ctx, blk, log, func(p *dbfile.Page) error {
+		return p.SetString(offset, val)
+	}); err != nil {
+		return fmt.Errorf("set string value %q at offset %d in block %s: %w", val, offset, blk, err)
+	}
+	return nil
+}
+
+// rollback/recoveryでtxNumの変更(LSNはundoneLSN)を取り消し, CLRを残す
+func (t *Transaction) compensateInt(txNum uint64, blk dbfile.BlockID, offset, val, undoneLSN int) error {
+	return t.compensate(blk, func() (int, error) {
+		return WriteCompensateIntToLog(t.recoveryManager.logManager, txNum, blk, offset, val, undoneLSN)
+	}, func(p *dbfile.Page) error {
+		return p.SetInt(offset, val)
+	})
+}
+
+func (t *Transaction) compensateString(txNum uint64, blk dbfile.BlockID, offset int, val string, undoneLSN int) error {
+	return t.compensate(blk, func() (int, error) {
+		return WriteCompensateStringToLog(t.recoveryManager.logManager, txNum, blk, offset, val, undoneLSN)
+	}, func(p *dbfile.Page) error {
+		return p.SetString(offset, val)
+	})
+}
+
+// rollbackでは既にXLockを持っており, recovery中は他のtransactionがないのでlockは取らない
+// 取り消した後の内容は書き込む前のversionと同じなのでversionも退避しない
+func (t *Transaction) compensate(blk dbfile.BlockID, log func() (int, error), apply func(p *dbfile.Page) error) error {
+	buf, err := t.myBufferList.Buffer(blk)
+	if err != nil {
+		return fmt.Errorf("get buffer for block %s (buffer may not be pinned): %w", blk, err)
+	}
+	buf.Latch()
+	defer buf.Unlatch()
+	lsn, err := t.recoveryManager.logPageChange(buf, log)
+	if err != nil {
+		return fmt.Errorf("write compensation log record for block %s: %w", blk, err)
+	}
+	if err := apply(buf.Contents()); err != nil {
 		return err
 	}
+	buf.SetModified(t.state.txNum, lsn)
+	return nil
+}
+
+// logでlog recordを書いてからapplyでbufferを書き換える. logがnilの場合はlogに残さない(write ahead log)
+// 一時ファイルはこのtransactionからしか見えず終了時に削除するので, followerでも書き込め, logにもversionにも残さない
+func (t *Transaction) write(ctx context.Context, blk dbfile.BlockID, log func(buf *dbbuffer.Buffer) (int, error), apply func(p *dbfile.Page) error) error {
+	temp := isTempFile(blk.FileName())
+	if !temp {
+		if err := t.checkWritable(); err != nil {
+			return err
+		}
+	}
 	if err := t.concurrencyManager.XLock(ctx, blk); err != nil {
 		return fmt.Errorf("acquire exclusive lock on block %s: %w", blk, err)
 	}
@@ -191,26 +358,96 @@ func (t *Transaction) SetString(ctx context.Context, blk dbfile.BlockID, offset
 	if err != nil {
 		return fmt.Errorf("get buffer for block %s (buffer may not be pinned): %w", blk, err)
 	}
+	if temp {
+		log = nil
+	} else if err := t.bufferManager.Versions().BeforeWrite(t.state.txNum, t.state.snapshot, blk, buf.Contents()); err != nil {
+		return fmt.Errorf("save version of block %s: %w", blk, err)
+	}
+	// checkpointがlog recordより前の変更だけをflushしたと判断できるよう, logを書いてから変更し終えるまでlatchを保持する
+	buf.Latch()
+	defer buf.Unlatch()
 	lsn := -1
-	if okToLog {
-		// write ahead log
-		lsn, err = t.recoveryManager.SetString(buf, offset, val)
+	if log != nil {
+		lsn, err = t.recoveryManager.logPageChange(buf, func() (int, error) { return log(buf) })
+		if err != nil {
+			return fmt.Errorf("write log record for block %s: %w", blk, err)
+		}
 	}
-	page := buf.Contents()
-	if err := page.SetString(offset, val); err != nil {
-		return fmt.Errorf("set string value %q at offset %d in block %s: %w", val, offset, blk, err)
+	if err := apply(buf.Contents()); err != nil {
+		return err
 	}
 	buf.SetModified(t.state.txNum, lsn)
 	return nil
 }
 
+// recoveryのredoでlsnのlog recordの変更をapplyでblockに反映する
+// pageのLSNがlsn以上なら反映済みなので何もしない. recovery中は他のtransactionがないのでlockは取らない
+func (t *Transaction) redo(ctx context.Context, blk dbfile.BlockID, lsn int, apply func(p *dbfile.Page) error) error {
+	size, err := t.fileManager.FileBlockLength(blk.FileName())
+	if err != nil {
+		return fmt.Errorf("get file block length for %q: %w", blk.FileName(), err)
+	}
+	if blk.BlockNum() >= size {
+		if isTempFile(blk.FileName()) {
+			// 一時ファイルは起動時に消えるので復元しない
+			return nil
+		}
+		// base backupより後に追加されたblock. 追加直後のblockは空なのでformatの内容も0で復元される
+		for ; size <= blk.BlockNum(); size++ {
+			if _, err := t.fileManager.Append(blk.FileName()); err != nil {
+				return fmt.Errorf("append block to %q for redo: %w", blk.FileName(), err)
+			}
+		}
+	}
+	if err := t.Pin(ctx, blk); err != nil {
+		return fmt.Errorf("pin block %s for redo: %w", blk, err)
+	}
+	buf, err := t.myBufferList.Buffer(blk)
+	if err != nil {
+		return fmt.Errorf("get buffer for block %s: %w", blk, err)
+	}
+	buf.Latch()
+	if buf.Contents().LSN() < lsn {
+		if err := apply(buf.Contents()); err != nil {
+			buf.Unlatch()
+			return err
+		}
+		buf.SetModified(t.state.txNum, lsn)
+	}
+	buf.Unlatch()
+	if err := t.UnPin(blk); err != nil {
+		return fmt.Errorf("unpin block %s after redo: %w", blk, err)
+	}
+	return nil
+}
+
+// recoveryのredoでfull page imageのoffsetからの部分imageをblockに書き戻す
+// ディスク上のpageが書きかけでchecksumが合わない場合は, 空のpageに置き換えてからimageで復元する
+// imageより後の変更は全てlogに残っているので, 続くlog recordのredoで最新の内容に戻る
+func (t *Transaction) redoImage(ctx context.Context, blk dbfile.BlockID, lsn, offset int, image []byte) error {
+	apply := func(p *dbfile.Page) error {
+		return p.SetRawBytes(offset, image)
+	}
+	err := t.redo(ctx, blk, lsn, apply)
+	if !dberr.IsCode(err, dberr.CodeChecksumMismatch) {
+		return err
+	}
+	slog.Warn("restoring torn page from full page image", "block", blk.String())
+	if err := t.fileManager.Write(blk, dbfile.NewPage(t.fileManager.BlockSize())); err != nil {
+		return fmt.Errorf("clear torn block %s: %w", blk, err)
+	}
+	return t.redo(ctx, blk, lsn, apply)
+}
+
 // fileNameのファイルが含むブロック数
-// ファントム対策にEOFマーカーに対してSLockをとる
+// ファントム対策にEOFマーカーに対してSLockをとる(SERIALIZABLEのみ)
+// snapshot isolationでは, snapshot以降に追加されたblockは空のblockとして見える
 func (t *Transaction) Size(ctx context.Context, fileName string) (int, error) {
 	blk := dbfile.NewBlockID(fileName, EndOfFile)
-	if err := t.concurrencyManager.SLock(ctx, blk); err != nil {
+	if err := t.concurrencyManager.ReadLock(ctx, blk); err != nil {
 		return 0, fmt.Errorf("acquire shared lock on EOF marker for file %q: %w", fileName, err)
 	}
+	defer t.concurrencyManager.ReadDone(blk)
 	length, err := t.fileManager.FileBlockLength(fileName)
 	if err != nil {
 		return 0, fmt.Errorf("get file block length for %q: %w", fileName, err)
@@ -221,6 +458,10 @@ func (t *Transaction) Size(ctx context.Context, fileName string) (int, error) {
 // fileNameのファイルに1ブロック追加する
 // ファントム対策にEOFマーカーにXLockをとる
 func (t *Transaction) Append(ctx context.Context, fileName string) (dbfile.BlockID, error) {
+	// commit時に消えてしまうので、同じtransaction内で再作成はできない
+	if slices.Contains(t.state.droppedFiles, fileName) {
+		return dbfile.BlockID{}, fmt.Errorf("file %q was dropped in this transaction", fileName)
+	}
 	blk := dbfile.NewBlockID(fileName, EndOfFile)
 	if err := t.concurrencyManager.XLock(ctx, blk); err != nil {
 		return dbfile.BlockID{}, fmt.Errorf("acquire exclusive lock on EOF marker for file %q: %w", fileName, err)
@@ -229,23 +470,51 @@ func (t *Transaction) Append(ctx context.Context, fileName string) (dbfile.Block
 	if err != nil {
 		return dbfile.BlockID{}, fmt.Errorf("append new block to file %q: %w", fileName, err)
 	}
+	if isTempFile(fileName) && !slices.Contains(t.state.tempFiles, fileName) {
+		t.state.tempFiles = append(t.state.tempFiles, fileName)
+	}
+	t.bufferManager.Versions().Appended(t.state.txNum, blk, t.fileManager.BlockSize())
 	return blk, nil
 }
 
-func (t *Transaction) BlockSize() int {
-	return t.fileManager.BlockSize()
+// fileNameのファイルを使うことを宣言する(intent lock)
+// isolation levelに関わらずcommitまでファイル全体のSLockを保持し, 使い終わるまでDROPを待たせる
+func (t *Transaction) LockFile(ctx context.Context, fileName string) error {
+	blk := dbfile.NewBlockID(fileName, wholeFile)
+	if err := t.concurrencyManager.SLock(ctx, blk); err != nil {
+		return fmt.Errorf("acquire intent lock on file %q: %w", fileName, err)
+	}
+	return nil
 }
 
-func (t *Transaction) AvailableBuffs() int {
-	return t.bufferManager.Available()
+// fileNameのファイルをcommit時に削除する
+// ファイルを使っているtransactionはLockFileでSLockを持っているので、XLockをとって終了を待つ
+// 一時ファイルはtransactionの終了時に必ず削除するので何もしない
+func (t *Transaction) DropFile(ctx context.Context, fileName string) error {
+	if isTempFile(fileName) {
+		return nil
+	}
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	blk := dbfile.NewBlockID(fileName, wholeFile)
+	if err := t.concurrencyManager.XLock(ctx, blk); err != nil {
+		return fmt.Errorf("acquire exclusive lock on file %q: %w", fileName, err)
+	}
+	if !slices.Contains(t.state.droppedFiles, fileName) {
+		t.state.droppedFiles = append(t.state.droppedFiles, fileName)
+	}
+	return nil
+}
+
+func isTempFile(fileName string) bool {
+	return strings.HasPrefix(fileName, dbfile.TempFilePrefix)
 }
 
-func NextTxNum() uint64 {
-	nextTxNum.Add(1)
-	slog.Debug("new transaction", slog.Any("nextTx", nextTxNum.Load()))
-	return nextTxNum.Load()
+func (t *Transaction) BlockSize() int {
+	return t.fileManager.BlockSize()
 }
 
-func ResetTxNum() {
-	nextTxNum.Store(0)
+func (t *Transaction) AvailableBuffs() int {
+	return t.bufferManager.Available()
 }
@@ -1,12 +1,20 @@
 package dbtx_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/teru01/simpledb-go/dbbuffer"
+	"github.com/teru01/simpledb-go/dberr"
 	"github.com/teru01/simpledb-go/dbfile"
 	"github.com/teru01/simpledb-go/dblog"
 	"github.com/teru01/simpledb-go/dbtx"
@@ -138,7 +146,6 @@ func TestTransactionPinSetStringGetString(t *testing.T) {
 }
 
 func TestTransactionGetStringParallel(t *testing.T) {
-	dbtx.ResetTxNum()
 	bm, fm, lm, cleanup := setupTestBufferManager(t, 8)
 	defer cleanup()
 	ctx := context.Background()
@@ -194,14 +201,13 @@ func TestTransactionGetStringParallel(t *testing.T) {
 		}(i)
 	}
 	wg.Wait()
-	next := dbtx.NextTxNum()
+	next := lm.NextTxNum()
 	if next != uint64(txCount+2) {
 		t.Fatalf("transaction number mismatch, expected %d actual %d", txCount+2, next)
 	}
 }
 
 func TestTransactionRollback(t *testing.T) {
-	dbtx.ResetTxNum()
 	bm, fm, lm, cleanup := setupTestBufferManager(t, 8)
 	defer cleanup()
 	ctx := context.Background()
@@ -556,3 +562,766 @@ func TestTransactionAvailableBuffs(t *testing.T) {
 		t.Fatalf("failed to commit: %v", err)
 	}
 }
+
+func TestTransactionDropFile(t *testing.T) {
+	bm, fm, lm, cleanup := setupTestBufferManager(t, 8)
+	defer cleanup()
+	ctx := context.Background()
+
+	fileName := "dropfile"
+	newTx := func() *dbtx.Transaction {
+		tx, err := dbtx.NewTransaction(fm, lm, bm)
+		if err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+		return tx
+	}
+
+	tx1 := newTx()
+	blk, err := tx1.Append(ctx, fileName)
+	if err != nil {
+		t.Fatalf("failed to append block: %v", err)
+	}
+	if err := tx1.Pin(ctx, blk); err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+	if err := tx1.SetInt(ctx, blk, 0, 123, true); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// rollbackすればファイルは残る
+	tx2 := newTx()
+	if err := tx2.DropFile(ctx, fileName); err != nil {
+		t.Fatalf("failed to drop file: %v", err)
+	}
+	if err := tx2.Rollback(ctx); err != nil {
+		t.Fatalf("failed to rollback: %v", err)
+	}
+	if n, err := fm.FileBlockLength(fileName); err != nil || n != 1 {
+		t.Fatalf("expected 1 block after rollback, got %d (err=%v)", n, err)
+	}
+
+	// 同じtransaction内ではdrop後に再作成できない
+	tx3 := newTx()
+	if err := tx3.DropFile(ctx, fileName); err != nil {
+		t.Fatalf("failed to drop file: %v", err)
+	}
+	if _, err := tx3.Append(ctx, fileName); err == nil {
+		t.Errorf("expected error when appending to dropped file")
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if n, err := fm.FileBlockLength(fileName); err != nil || n != 0 {
+		t.Fatalf("expected file to be removed after commit, got %d blocks (err=%v)", n, err)
+	}
+
+	// 削除前のbufferの内容が見えてはいけない
+	tx4 := newTx()
+	defer tx4.Commit()
+	blk, err = tx4.Append(ctx, fileName)
+	if err != nil {
+		t.Fatalf("failed to append block: %v", err)
+	}
+	if err := tx4.Pin(ctx, blk); err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+	v, err := tx4.GetInt(ctx, blk, 0)
+	if err != nil {
+		t.Fatalf("failed to get int: %v", err)
+	}
+	if v != 0 {
+		t.Errorf("expected 0 in recreated file, got %d", v)
+	}
+}
+
+func TestTransactionSnapshotIsolation(t *testing.T) {
+	bm, fm, lm, cleanup := setupTestBufferManager(t, 8)
+	defer cleanup()
+	ctx := context.Background()
+
+	newTx := func(opts ...dbtx.TxOption) *dbtx.Transaction {
+		tx, err := dbtx.NewTransaction(fm, lm, bm, opts...)
+		if err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+		return tx
+	}
+	setInt := func(tx *dbtx.Transaction, blk dbfile.BlockID, val int) error {
+		if err := tx.Pin(ctx, blk); err != nil {
+			t.Fatalf("failed to pin: %v", err)
+		}
+		return tx.SetInt(ctx, blk, 0, val, true)
+	}
+	assertInt := func(tx *dbtx.Transaction, blk dbfile.BlockID, want int) {
+		t.Helper()
+		if err := tx.Pin(ctx, blk); err != nil {
+			t.Fatalf("failed to pin: %v", err)
+		}
+		got, err := tx.GetInt(ctx, blk, 0)
+		if err != nil {
+			t.Fatalf("failed to get int: %v", err)
+		}
+		if got != want {
+			t.Errorf("expected %d, got %d", want, got)
+		}
+	}
+
+	tx1 := newTx()
+	blk, err := tx1.Append(ctx, "snapshotfile")
+	if err != nil {
+		t.Fatalf("failed to append block: %v", err)
+	}
+	if err := setInt(tx1, blk, 1); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+
+	// 未commitのblockは空のblockとして見える
+	reader1 := newTx(dbtx.WithSnapshotIsolation())
+	assertInt(reader1, blk, 0)
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	assertInt(reader1, blk, 0)
+
+	// writerが未commitのXLockを持っていてもblockせずにcommit済みの値を読む
+	reader2 := newTx(dbtx.WithSnapshotIsolation())
+	writer := newTx()
+	if err := setInt(writer, blk, 2); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	assertInt(reader2, blk, 1)
+	if err := writer.Rollback(ctx); err != nil {
+		t.Fatalf("failed to rollback: %v", err)
+	}
+	assertInt(reader2, blk, 1)
+
+	writer = newTx()
+	if err := setInt(writer, blk, 3); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	assertInt(reader1, blk, 0)
+	assertInt(reader2, blk, 1)
+
+	// snapshot以降にcommitされたblockへの書き込みは競合になる
+	err = setInt(reader2, blk, 4)
+	var dbErr *dberr.DBError
+	if !errors.As(err, &dbErr) || dbErr.Code != dberr.CodeTransactionWriteConflictAbort {
+		t.Fatalf("expected write conflict, got %v", err)
+	}
+	if err := reader2.Rollback(ctx); err != nil {
+		t.Fatalf("failed to rollback: %v", err)
+	}
+	if err := reader1.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// 新しいsnapshotからは最新の値が見え, 自分の書き込みも見える
+	tx3 := newTx(dbtx.WithSnapshotIsolation())
+	assertInt(tx3, blk, 3)
+	if err := setInt(tx3, blk, 5); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	assertInt(tx3, blk, 5)
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+}
+
+// 競合はblock単位で判定するので, 別々のrecordを書き換えても同じblockなら後からcommitする方が失敗する
+func TestTransactionSnapshotBlockConflict(t *testing.T) {
+	ctx := context.Background()
+	bm, fm, lm, cleanup := setupTestBufferManager(t, 8)
+	defer cleanup()
+	otherBM, otherFM, otherLM, otherCleanup := setupTestBufferManager(t, 8)
+	defer otherCleanup()
+
+	newTx := func(fm *dbfile.FileManager, lm *dblog.LogManager, bm *dbbuffer.BufferManager, opts ...dbtx.TxOption) *dbtx.Transaction {
+		tx, err := dbtx.NewTransaction(fm, lm, bm, opts...)
+		if err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+		return tx
+	}
+	setInt := func(tx *dbtx.Transaction, blk dbfile.BlockID, offset, val int) error {
+		if err := tx.Pin(ctx, blk); err != nil {
+			t.Fatalf("failed to pin: %v", err)
+		}
+		return tx.SetInt(ctx, blk, offset, val, true)
+	}
+	blk := appendBlocks(t, fm, "blockconflictfile", 1)[0]
+	otherBlk := appendBlocks(t, otherFM, "blockconflictfile", 1)[0]
+
+	tx1 := newTx(fm, lm, bm, dbtx.WithSnapshotIsolation())
+	tx2 := newTx(fm, lm, bm, dbtx.WithSnapshotIsolation())
+	if err := setInt(tx1, blk, 0, 1); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	err := setInt(tx2, blk, 100, 2)
+	var dbErr *dberr.DBError
+	if !errors.As(err, &dbErr) || dbErr.Code != dberr.CodeTransactionWriteConflictAbort {
+		t.Fatalf("expected write conflict on a different record in the same block, got %v", err)
+	}
+	if err := tx2.Rollback(ctx); err != nil {
+		t.Fatalf("failed to rollback: %v", err)
+	}
+
+	// 別のdatabaseで同じ名前のblockにcommitしても競合しない
+	tx3 := newTx(fm, lm, bm, dbtx.WithSnapshotIsolation())
+	other := newTx(otherFM, otherLM, otherBM)
+	if err := setInt(other, otherBlk, 0, 3); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	if err := other.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := setInt(tx3, blk, 0, 4); err != nil {
+		t.Fatalf("expected no conflict with another database, got %v", err)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+}
+
+// 再起動を模して, bufferの内容を書き出さずにmanagerを作り直す関数を返す
+// lockを取らずに読むisolation levelでも, 書き込み途中のpageは読まない. go test -raceで競合がないことを確かめる
+func TestTransactionReadWithoutLockDuringWrite(t *testing.T) {
+	for _, level := range []dbtx.IsolationLevel{dbtx.IsolationReadUncommitted, dbtx.IsolationSnapshot} {
+		t.Run(level.String(), func(t *testing.T) {
+			ctx := context.Background()
+			bm, fm, lm, cleanup := setupTestBufferManager(t, 8)
+			defer cleanup()
+			blk := appendBlocks(t, fm, "lockfreeread", 1)[0]
+			values := []string{"a", strings.Repeat("long value ", 5)}
+
+			writer, err := dbtx.NewTransaction(fm, lm, bm)
+			if err != nil {
+				t.Fatalf("failed to create transaction: %v", err)
+			}
+			if err := writer.Pin(ctx, blk); err != nil {
+				t.Fatalf("failed to pin: %v", err)
+			}
+			if err := writer.SetString(ctx, blk, 0, values[0], false); err != nil {
+				t.Fatalf("failed to set string: %v", err)
+			}
+			if err := writer.Commit(); err != nil {
+				t.Fatalf("failed to commit: %v", err)
+			}
+
+			reader, err := dbtx.NewTransaction(fm, lm, bm, dbtx.WithIsolationLevel(level))
+			if err != nil {
+				t.Fatalf("failed to create transaction: %v", err)
+			}
+			defer reader.Commit()
+			if err := reader.Pin(ctx, blk); err != nil {
+				t.Fatalf("failed to pin: %v", err)
+			}
+			writer, err = dbtx.NewTransaction(fm, lm, bm)
+			if err != nil {
+				t.Fatalf("failed to create transaction: %v", err)
+			}
+			if err := writer.Pin(ctx, blk); err != nil {
+				t.Fatalf("failed to pin: %v", err)
+			}
+
+			done := make(chan error)
+			go func() {
+				for i := range 500 {
+					if err := writer.SetString(ctx, blk, 0, values[i%2], true); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- writer.Rollback(ctx)
+			}()
+			for reading := true; reading; {
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatalf("failed to write: %v", err)
+					}
+					reading = false
+				default:
+				}
+				got, err := reader.GetString(ctx, blk, 0)
+				if err != nil {
+					t.Fatalf("failed to get string: %v", err)
+				}
+				if !slices.Contains(values, got) {
+					t.Fatalf("read a partially written value %q", got)
+				}
+			}
+		})
+	}
+}
+
+func setupRestartableDB(t *testing.T) func() (*dbfile.FileManager, *dblog.LogManager, *dbbuffer.BufferManager) {
+	t.Helper()
+	dir := t.TempDir()
+	return func() (*dbfile.FileManager, *dblog.LogManager, *dbbuffer.BufferManager) {
+		return openDB(t, dir)
+	}
+}
+
+func openDB(t *testing.T, dir string, opts ...dblog.LogOption) (*dbfile.FileManager, *dblog.LogManager, *dbbuffer.BufferManager) {
+	t.Helper()
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open dir: %v", err)
+	}
+	t.Cleanup(func() { dirFile.Close() })
+	fm, err := dbfile.NewFileManager(dirFile, 400)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm, err := dblog.NewLogManager(fm, "test.log", opts...)
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	return fm, lm, dbbuffer.NewBufferManager(fm, lm, 8)
+}
+
+func recoverDB(t *testing.T, fm *dbfile.FileManager, lm *dblog.LogManager, bm *dbbuffer.BufferManager) {
+	t.Helper()
+	tx, err := dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if err := tx.Recover(context.Background()); err != nil {
+		t.Fatalf("failed to recover: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+}
+
+// blkの先頭にintとstringを書き込む
+func setIntAndString(t *testing.T, tx *dbtx.Transaction, blk dbfile.BlockID, i int, s string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := tx.Pin(ctx, blk); err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+	if err := tx.SetInt(ctx, blk, 0, i, true); err != nil {
+		t.Fatalf("failed to set int: %v", err)
+	}
+	if err := tx.SetString(ctx, blk, 8, s, true); err != nil {
+		t.Fatalf("failed to set string: %v", err)
+	}
+}
+
+// bufferを経由せずにディスク上の内容を読む
+func readIntAndString(t *testing.T, fm *dbfile.FileManager, blk dbfile.BlockID) (int, string) {
+	t.Helper()
+	p := dbfile.NewPage(fm.BlockSize())
+	if err := fm.Read(blk, p); err != nil {
+		t.Fatalf("failed to read block: %v", err)
+	}
+	return p.GetInt(0), p.GetString(8)
+}
+
+func appendBlocks(t *testing.T, fm *dbfile.FileManager, fileName string, n int) []dbfile.BlockID {
+	t.Helper()
+	var blks []dbfile.BlockID
+	for range n {
+		blk, err := fm.Append(fileName)
+		if err != nil {
+			t.Fatalf("failed to append block: %v", err)
+		}
+		blks = append(blks, blk)
+	}
+	return blks
+}
+
+type intAndString struct {
+	i int
+	s string
+}
+
+func TestTransactionRecover(t *testing.T) {
+	ctx := context.Background()
+	restart := setupRestartableDB(t)
+	fm, lm, bm := restart()
+	newTx := func() *dbtx.Transaction {
+		tx, err := dbtx.NewTransaction(fm, lm, bm)
+		if err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+		return tx
+	}
+	// 他のテストとlockが衝突しないファイル名にする
+	blks := appendBlocks(t, fm, "recoverfile", 3)
+
+	// commitはlogだけをflushする
+	committed := newTx()
+	setIntAndString(t, committed, blks[0], 10, "committed")
+	if err := committed.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if i, s := readIntAndString(t, fm, blks[0]); i != 0 || s != "" {
+		t.Errorf("expected commit not to write the data page, got (%d, %q)", i, s)
+	}
+
+	// rollbackした変更はCLRのredoで元に戻る
+	rolledBack := newTx()
+	setIntAndString(t, rolledBack, blks[1], 20, "rolled back")
+	if err := rolledBack.Rollback(ctx); err != nil {
+		t.Fatalf("failed to rollback: %v", err)
+	}
+
+	// commitしていない変更がディスクに書き出された後にcrashする
+	uncommitted := newTx()
+	// 他のテストのためにlockを解放する
+	defer uncommitted.Rollback(ctx)
+	setIntAndString(t, uncommitted, blks[2], 30, "uncommitted")
+	if err := bm.FlushAll(uncommitted.TxNum()); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if i, s := readIntAndString(t, fm, blks[2]); i != 30 || s != "uncommitted" {
+		t.Fatalf("expected uncommitted data on disk, got (%d, %q)", i, s)
+	}
+
+	want := []intAndString{{10, "committed"}, {0, ""}, {0, ""}}
+	// 2回目はcheckpoint以降に何もないので結果が変わらないことを確かめる
+	for range 2 {
+		fm, lm, bm = restart()
+		recoverDB(t, fm, lm, bm)
+		for n, blk := range blks {
+			if i, s := readIntAndString(t, fm, blk); i != want[n].i || s != want[n].s {
+				t.Errorf("block %d: expected (%d, %q) after recovery, got (%d, %q)", n, want[n].i, want[n].s, i, s)
+			}
+		}
+	}
+}
+
+func TestTransactionRecoverFromNQCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	restart := setupRestartableDB(t)
+	fm, lm, bm := restart()
+	newTx := func() *dbtx.Transaction {
+		tx, err := dbtx.NewTransaction(fm, lm, bm)
+		if err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+		return tx
+	}
+	blks := appendBlocks(t, fm, "nqcheckpointfile", 4)
+
+	// checkpointより前にcommitした変更はcheckpointでディスクに乗る
+	before := newTx()
+	setIntAndString(t, before, blks[0], 10, "before checkpoint")
+	if err := before.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	// checkpointをまたいで実行中のtransaction
+	active := newTx()
+	defer active.Rollback(ctx)
+	setIntAndString(t, active, blks[1], 20, "active")
+
+	if err := dbtx.Checkpoint(lm, bm); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if i, s := readIntAndString(t, fm, blks[0]); i != 10 || s != "before checkpoint" {
+		t.Errorf("expected checkpoint to flush committed data, got (%d, %q)", i, s)
+	}
+
+	setIntAndString(t, active, blks[2], 30, "active")
+	after := newTx()
+	setIntAndString(t, after, blks[3], 40, "after checkpoint")
+	if err := after.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	fm, lm, bm = restart()
+	recoverDB(t, fm, lm, bm)
+	want := []intAndString{{10, "before checkpoint"}, {0, ""}, {0, ""}, {40, "after checkpoint"}}
+	for n, blk := range blks {
+		if i, s := readIntAndString(t, fm, blk); i != want[n].i || s != want[n].s {
+			t.Errorf("block %d: expected (%d, %q) after recovery, got (%d, %q)", n, want[n].i, want[n].s, i, s)
+		}
+	}
+}
+
+func TestCheckpointSyncsEvictedPages(t *testing.T) {
+	ctx := context.Background()
+	dirFile, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open dir: %v", err)
+	}
+	defer dirFile.Close()
+	fm, err := dbfile.NewFileManager(dirFile, 400, dbfile.WithSyncOnCommit())
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	lm, err := dblog.NewLogManager(fm, "test.log")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	bm := dbbuffer.NewBufferManager(fm, lm, 2)
+	blks := appendBlocks(t, fm, "syncfile", 3)
+
+	tx, err := dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	setIntAndString(t, tx, blks[0], 10, "evicted")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	// 置き換えで書き出した変更はfsyncしない
+	for _, blk := range blks[1:] {
+		if _, err := bm.Pin(ctx, blk); err != nil {
+			t.Fatalf("failed to pin: %v", err)
+		}
+	}
+	if i, s := readIntAndString(t, fm, blks[0]); i != 10 || s != "evicted" {
+		t.Fatalf("expected eviction to write the data page, got (%d, %q)", i, s)
+	}
+	if files := fm.UnsyncedFiles(); len(files) != 1 || files[0] != "syncfile" {
+		t.Fatalf("expected evicted file to be unsynced, got %v", files)
+	}
+
+	// logを捨てる前に, このcheckpointで書き出していないファイルもfsyncする
+	if err := dbtx.Checkpoint(lm, bm); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if files := fm.UnsyncedFiles(); len(files) != 0 {
+		t.Errorf("expected checkpoint to sync all files, got %v unsynced", files)
+	}
+}
+
+func TestTransactionPointInTimeRecovery(t *testing.T) {
+	ctx := context.Background()
+	dir, archiveDir := t.TempDir(), t.TempDir()
+	// 1 blockごとにsegmentを分けてarchiveする
+	fm, lm, bm := openDB(t, dir, dblog.WithSegmentBlocks(1), dblog.WithArchiveDir(archiveDir))
+	blk := appendBlocks(t, fm, "pitrfile", 1)[0]
+	commit := func(blk dbfile.BlockID, i int, s string) {
+		t.Helper()
+		tx, err := dbtx.NewTransaction(fm, lm, bm)
+		if err != nil {
+			t.Fatalf("failed to create transaction: %v", err)
+		}
+		setIntAndString(t, tx, blk, i, s)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+
+	commit(blk, 1, "base")
+	if err := dbtx.Checkpoint(lm, bm); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	baseDir, baseDir2 := t.TempDir(), t.TempDir()
+	copyDir(t, dir, baseDir)
+	copyDir(t, dir, baseDir2)
+
+	commit(blk, 2, "target")
+	target := time.Now()
+	time.Sleep(time.Millisecond)
+	commit(blk, 3, "after target")
+	// 最後のcommitを含むsegmentが書き終わってarchiveされるまでlogを進める
+	filler := appendBlocks(t, fm, "pitrfiller", 1)[0]
+	for i := range 20 {
+		commit(filler, i, "filler")
+	}
+
+	// base backupにarchiveされたlogを時刻targetまで反映する
+	fm, lm, bm = openDB(t, baseDir)
+	checkpointLSN, err := dbtx.LastCheckpointLSN(lm)
+	if err != nil {
+		t.Fatalf("failed to find checkpoint: %v", err)
+	}
+	if _, err := dbtx.RestoreArchivedLog(lm, archiveDir, dbtx.RecoveryTarget{Time: target}); err != nil {
+		t.Fatalf("failed to restore archived log: %v", err)
+	}
+	tx, err := dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if err := tx.RecoverFromBackup(ctx, checkpointLSN); err != nil {
+		t.Fatalf("failed to recover from backup: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if i, s := readIntAndString(t, fm, blk); i != 2 || s != "target" {
+		t.Errorf("expected (2, %q) after point-in-time recovery, got (%d, %q)", "target", i, s)
+	}
+
+	// base backupより前には戻せない
+	_, lm, _ = openDB(t, baseDir2)
+	if _, err := dbtx.RestoreArchivedLog(lm, archiveDir, dbtx.RecoveryTarget{LSN: 1}); err == nil {
+		t.Errorf("expected error for a recovery target before the base backup")
+	}
+}
+
+func copyDir(t *testing.T, src, dst string) {
+	t.Helper()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	for _, entry := range entries {
+		b, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, entry.Name()), b, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+}
+
+func TestTransactionRecoverTornPage(t *testing.T) {
+	dir := t.TempDir()
+	fm, lm, bm := openDB(t, dir)
+	blks := appendBlocks(t, fm, "tornfile", 1)
+
+	tx, err := dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	setIntAndString(t, tx, blks[0], 10, "before checkpoint")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := dbtx.Checkpoint(lm, bm); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+
+	// checkpoint後の最初の変更でpageの内容がlogに残る
+	tx, err = dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	setIntAndString(t, tx, blks[0], 20, "after checkpoint")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// pageの書き込み途中でcrashし, blockの後半だけが書き換わる
+	f, err := os.OpenFile(filepath.Join(dir, "tornfile"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open data file: %v", err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, fm.BlockSize()/2), int64(dbfile.PageHeaderSize+fm.BlockSize()/2)); err != nil {
+		t.Fatalf("failed to tear block: %v", err)
+	}
+	f.Close()
+
+	fm, lm, bm = openDB(t, dir)
+	recoverDB(t, fm, lm, bm)
+	if i, s := readIntAndString(t, fm, blks[0]); i != 20 || s != "after checkpoint" {
+		t.Errorf("expected torn page to be restored to (20, %q), got (%d, %q)", "after checkpoint", i, s)
+	}
+}
+
+func TestTransactionRecoverInterruptedRollback(t *testing.T) {
+	ctx := context.Background()
+	restart := setupRestartableDB(t)
+	fm, lm, bm := restart()
+	blks := appendBlocks(t, fm, "interruptedrollbackfile", 1)
+	blk := blks[0]
+
+	tx, err := dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	// 他のテストのためにlockを解放する
+	defer tx.Rollback(ctx)
+	if err := tx.Pin(ctx, blk); err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+	var lsns []int
+	for v := 1; v <= 3; v++ {
+		if err := tx.SetInt(ctx, blk, 0, v, true); err != nil {
+			t.Fatalf("failed to set int: %v", err)
+		}
+		lsns = append(lsns, lm.LatestLSN())
+	}
+	// rollbackが新しい2つの変更を取り消したところでcrashする
+	for i, v := range []int{2, 1} {
+		lsn, err := dbtx.WriteCompensateIntToLog(lm, tx.TxNum(), blk, 0, v, lsns[2-i])
+		if err != nil {
+			t.Fatalf("failed to write compensation record: %v", err)
+		}
+		if err := lm.FlushWithLSN(lsn); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+	}
+	before := lm.LatestLSN()
+
+	fm, lm, bm = restart()
+	recoverDB(t, fm, lm, bm)
+	if i, _ := readIntAndString(t, fm, blk); i != 0 {
+		t.Errorf("expected rollback to be completed by recovery, got %d", i)
+	}
+	// 取り消し済みの変更は取り消し直さない
+	it, err := lm.RecordIterator()
+	if err != nil {
+		t.Fatalf("failed to get log iterator: %v", err)
+	}
+	var clrs []string
+	for rec, err := range it {
+		if err != nil {
+			t.Fatalf("failed to read log: %v", err)
+		}
+		if rec.LSN <= before {
+			break
+		}
+		record := dbtx.NewLogRecord(rec.Data)
+		if s := record.(fmt.Stringer).String(); dbtx.LogRecordTxNumber(record) == tx.TxNum() && strings.Contains(s, "compensate") {
+			clrs = append(clrs, s)
+		}
+	}
+	if len(clrs) != 1 {
+		t.Errorf("expected recovery to undo only the oldest change, got compensation records %v", clrs)
+	}
+}
+
+// crashで失われた末尾のblockはredoで追加し直す. 一時ファイルは起動時に消えるので復元しない
+func TestTransactionRecoverAppendedBlocks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fm, lm, bm := openDB(t, dir)
+	// 一時ファイルと似た名前のtableも復元する
+	tableFile, tempFile := "temperatures.tbl", dbfile.TempFilePrefix+"recover.tbl"
+	tx, err := dbtx.NewTransaction(fm, lm, bm)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	for _, fileName := range []string{tableFile, tempFile} {
+		blk, err := tx.Append(ctx, fileName)
+		if err != nil {
+			t.Fatalf("failed to append block: %v", err)
+		}
+		setIntAndString(t, tx, blk, 10, fileName)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// 追加したblockがディスクに乗る前にcrashする
+	if err := os.Truncate(filepath.Join(dir, tableFile), 0); err != nil {
+		t.Fatalf("failed to truncate data file: %v", err)
+	}
+
+	fm, lm, bm = openDB(t, dir)
+	recoverDB(t, fm, lm, bm)
+	if i, s := readIntAndString(t, fm, dbfile.NewBlockID(tableFile, 0)); i != 10 || s != tableFile {
+		t.Errorf("expected appended block to be restored to (10, %q), got (%d, %q)", tableFile, i, s)
+	}
+	if n, err := fm.FileBlockLength(tempFile); err != nil || n != 0 {
+		t.Errorf("expected temp file not to be restored, got %d blocks (err=%v)", n, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,6 +16,7 @@ import (
 	"github.com/teru01/simpledb-go/dbbuffer"
 	"github.com/teru01/simpledb-go/dbexecutor"
 	"github.com/teru01/simpledb-go/dbfile"
+	"github.com/teru01/simpledb-go/dbparse"
 	"github.com/teru01/simpledb-go/dbraft"
 	"github.com/teru01/simpledb-go/dbserver"
 )
@@ -25,13 +27,46 @@ func main() {
 	dirName := getEnvOrDefault("BASE_DIR", filepath.Join(os.Getenv("PWD"), ".dbdata"))
 	blockSize := getEnvIntOrDefault("BLOCK_SIZE", 4000)
 	bufferSize := getEnvIntOrDefault("BUFFER_SIZE", 100)
+	groupCommitDelay := getEnvDurationOrDefault("GROUP_COMMIT_DELAY", 0)
+	archiveDir := os.Getenv("ARCHIVE_DIR")
+	bgWriterDelay := getEnvDurationOrDefault("BGWRITER_DELAY", 200*time.Millisecond)
+	bgWriterMaxBuffers := getEnvIntOrDefault("BGWRITER_MAX_BUFFERS", 100)
+	// write: 書き込みごとにO_SYNCで待つ, commit: commitやcheckpointでfsyncする
+	syncMode := getEnvOrDefault("SYNC_MODE", "write")
+	if syncMode != "write" && syncMode != "commit" {
+		slog.Error("invalid SYNC_MODE", "value", syncMode)
+		os.Exit(1)
+	}
+	// disk: BASE_DIRにファイルを置く, memory: メモリ上に置き, 終了すると消える
+	storageMode := getEnvOrDefault("STORAGE", "disk")
+	if storageMode != "disk" && storageMode != "memory" {
+		slog.Error("invalid STORAGE", "value", storageMode)
+		os.Exit(1)
+	}
+	bufferPolicy, err := dbbuffer.ParseReplacementPolicy(getEnvOrDefault("BUFFER_POLICY", string(dbbuffer.PolicyLRU)))
+	if err != nil {
+		slog.Error("invalid BUFFER_POLICY", "error", err)
+		os.Exit(1)
+	}
 
 	if err := os.MkdirAll(dirName, 0755); err != nil {
 		slog.Error("failed to create base dir", "dir", dirName, "error", err)
 		os.Exit(1)
 	}
 
-	db, cleanup, err := dbexecutor.NewSimpleDB(dirName, blockSize, bufferSize)
+	dbOpts := []dbexecutor.SimpleDBOption{
+		dbexecutor.WithGroupCommitDelay(groupCommitDelay),
+		dbexecutor.WithArchiveDir(archiveDir),
+		dbexecutor.WithBufferReplacementPolicy(bufferPolicy),
+		dbexecutor.WithBackgroundWriter(bgWriterDelay, bgWriterMaxBuffers),
+	}
+	if syncMode == "commit" {
+		dbOpts = append(dbOpts, dbexecutor.WithSyncOnCommit())
+	}
+	if storageMode == "memory" {
+		dbOpts = append(dbOpts, dbexecutor.WithStorage(dbfile.NewMemoryStorage()))
+	}
+	db, cleanup, err := dbexecutor.NewSimpleDB(dirName, blockSize, bufferSize, dbOpts...)
 	if err != nil {
 		slog.Error("failed to create simpledb", "error", err)
 		os.Exit(1)
@@ -63,6 +98,21 @@ func main() {
 				os.Exit(1)
 			}
 		}
+	} else if recoverTo := os.Getenv("RECOVER_TO"); recoverTo != "" {
+		// BASE_DIRのbase backupにARCHIVE_DIRのlogを反映する. 例: RECOVER_TO='TIMESTAMP "2026-01-02 15:04:05"'
+		if archiveDir == "" {
+			slog.Error("ARCHIVE_DIR is required for RECOVER_TO")
+			os.Exit(1)
+		}
+		data, err := dbparse.NewParser("RECOVER TO " + recoverTo).RecoverTo()
+		if err != nil {
+			slog.Error("invalid RECOVER_TO", "value", recoverTo, "error", err)
+			os.Exit(1)
+		}
+		if err := db.InitFromArchive(ctx, archiveDir, data.Target()); err != nil {
+			slog.Error("failed to restore simpledb", "error", err)
+			os.Exit(1)
+		}
 	} else {
 		if err := db.Init(ctx); err != nil {
 			slog.Error("failed to initialize simpledb", "error", err)
@@ -70,7 +120,7 @@ func main() {
 		}
 	}
 
-	slog.Info("simpledb started", "dir", dirName, "blockSize", blockSize, "bufferSize", bufferSize)
+	slog.Info("simpledb started", "dir", dirName, "blockSize", blockSize, "bufferSize", bufferSize, "bufferPolicy", bufferPolicy, "syncMode", syncMode, "storage", storageMode)
 
 	mode := getEnvOrDefault("MODE", "repl")
 	switch mode {
@@ -96,6 +146,13 @@ func runREPL(ctx context.Context, db *dbexecutor.SimpleDB, dirName string) {
 	}
 	defer rl.Close()
 
+	session := db.NewSession()
+	defer func() {
+		if err := session.Close(ctx); err != nil {
+			slog.Error("failed to close session", "error", err)
+		}
+	}()
+
 	for {
 		line, err := rl.Readline()
 		if err != nil {
@@ -110,7 +167,7 @@ func runREPL(ctx context.Context, db *dbexecutor.SimpleDB, dirName string) {
 			continue
 		}
 
-		result, err := db.Execute(ctx, line)
+		result, err := session.Execute(ctx, line)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			continue
@@ -131,8 +188,8 @@ func printResult(r *dbexecutor.ExecuteResult) {
 	}
 	for _, row := range r.Rows {
 		for i, v := range row {
-			if len(v) > widths[i] {
-				widths[i] = len(v)
+			if len(displayValue(v)) > widths[i] {
+				widths[i] = len(displayValue(v))
 			}
 		}
 	}
@@ -152,13 +209,20 @@ func printResult(r *dbexecutor.ExecuteResult) {
 	for _, row := range r.Rows {
 		cols := make([]string, len(r.Fields))
 		for i, v := range row {
-			cols[i] = padRight(v, widths[i])
+			cols[i] = padRight(displayValue(v), widths[i])
 		}
 		fmt.Println(strings.Join(cols, " | "))
 	}
 	fmt.Printf("(%d rows)\n", len(r.Rows))
 }
 
+func displayValue(v sql.NullString) string {
+	if !v.Valid {
+		return "NULL"
+	}
+	return v.String
+}
+
 func padRight(s string, width int) string {
 	if len(s) >= width {
 		return s
@@ -212,3 +276,16 @@ func getEnvIntOrDefault(key string, defaultVal int) int {
 	}
 	return n
 }
+
+func getEnvDurationOrDefault(key string, defaultVal time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Error("invalid env value", "key", key, "value", v, "error", err)
+		os.Exit(1)
+	}
+	return d
+}